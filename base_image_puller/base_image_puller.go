@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -20,21 +21,53 @@ import (
 const MetricsUnpackTimeName = "UnpackTime"
 const MetricsDownloadTimeName = "DownloadTime"
 
+// incompleteVolumeMarker is part of the name of volumes that are still being
+// unpacked. They are moved to their chain ID once complete.
+const incompleteVolumeMarker = "-incomplete-"
+
 //go:generate counterfeiter . Fetcher
 //go:generate counterfeiter . Unpacker
 //go:generate counterfeiter . DependencyRegisterer
 //go:generate counterfeiter . VolumeDriver
 
 type UnpackSpec struct {
-	Stream        io.ReadCloser `json:"-"`
-	TargetPath    string
-	UIDMappings   []groot.IDMappingSpec
-	GIDMappings   []groot.IDMappingSpec
+	Stream      io.ReadCloser `json:"-"`
+	TargetPath  string
+	UIDMappings []groot.IDMappingSpec
+	GIDMappings []groot.IDMappingSpec
+	// OverflowUID and OverflowGID own the entries whose IDs are not covered
+	// by the mappings.
+	OverflowUID   int
+	OverflowGID   int
 	BaseDirectory string
+	Limits        groot.UnpackLimits
+	// ImageBytesUnpacked is the logical size of the parent layers, whether
+	// they were unpacked during the same pull or were already in the store.
+	// It counts towards Limits.MaxImageBytes.
+	ImageBytesUnpacked int64
+}
+
+// UnpackLimitExceededError is returned when a layer would exceed one of the
+// configured unpack limits.
+type UnpackLimitExceededError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *UnpackLimitExceededError) Error() string {
+	return fmt.Sprintf("unpack limit exceeded: %s is limited to %d", e.Limit, e.Max)
 }
 
+// VolumeMeta is stored next to every chain volume. ContentDigest is a digest
+// of the unpacked volume tree, as the diff ID only covers the layer tarball.
+// Hashing the whole tree is too slow for every pull, fsck records it and
+// re-hashes volumes against it. Size is what the volume allocates on disk, LogicalSize the
+// size of its files as the unpack limits count them.
 type VolumeMeta struct {
-	Size int64
+	Size          int64
+	LogicalSize   int64  `json:",omitempty"`
+	DiffID        string `json:",omitempty"`
+	ContentDigest string `json:",omitempty"`
 }
 
 type Fetcher interface {
@@ -46,8 +79,11 @@ type DependencyRegisterer interface {
 	Register(id string, chainIDs []string) error
 }
 
+// UnpackOutput reports the bytes the layer allocates on disk, and the logical
+// size of its files, which can be larger when they are sparse.
 type UnpackOutput struct {
 	BytesWritten    int64
+	LogicalBytes    int64
 	OpaqueWhiteouts []string
 }
 
@@ -60,9 +96,13 @@ type VolumeDriver interface {
 	CreateVolume(logger lager.Logger, parentID, id string) (string, error)
 	DestroyVolume(logger lager.Logger, id string) error
 	Volumes(logger lager.Logger) ([]string, error)
+	VolumeSize(logger lager.Logger, id string) (int64, error)
+	VolumeMeta(logger lager.Logger, id string) (VolumeMeta, error)
 	MoveVolume(logger lager.Logger, from, to string) error
 	WriteVolumeMeta(logger lager.Logger, id string, data VolumeMeta) error
 	HandleOpaqueWhiteouts(logger lager.Logger, id string, opaqueWhiteouts []string) error
+	SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error
+	IsVolumeReadOnly(logger lager.Logger, id string) (bool, error)
 }
 
 type BaseImagePuller struct {
@@ -100,7 +140,59 @@ func (p *BaseImagePuller) Pull(logger lager.Logger, baseImageInfo groot.BaseImag
 		return err
 	}
 
-	return p.buildLayer(logger, len(baseImageInfo.LayerInfos)-1, baseImageInfo.LayerInfos, spec)
+	p.recoverIncompleteVolumes(logger, baseImageInfo.LayerInfos)
+
+	_, err := p.buildLayer(logger, len(baseImageInfo.LayerInfos)-1, baseImageInfo.LayerInfos, spec)
+	return err
+}
+
+// recoverIncompleteVolumes destroys the incomplete volumes that earlier pulls
+// of the layers left behind when they were killed. A pull holds the lock of
+// the chain ID for as long as it unpacks, so once the lock is acquired any
+// incomplete volume of that chain that still exists is abandoned. Failures
+// are only logged, the volumes are left for the garbage collector.
+func (p *BaseImagePuller) recoverIncompleteVolumes(logger lager.Logger, layerInfos []groot.LayerInfo) {
+	logger = logger.Session("recovering-incomplete-volumes")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	volumes, err := p.volumeDriver.Volumes(logger)
+	if err != nil {
+		logger.Error("listing-volumes-failed", err)
+		return
+	}
+
+	chainIDs := map[string]bool{}
+	for _, layerInfo := range layerInfos {
+		chainIDs[layerInfo.ChainID] = true
+	}
+
+	for _, volumeID := range volumes {
+		chainID, ok := IncompleteVolumeChainID(volumeID)
+		if !ok || !chainIDs[chainID] {
+			continue
+		}
+
+		if err := p.recoverIncompleteVolume(logger, chainID, volumeID); err != nil {
+			logger.Error("recovering-volume-failed", err, lager.Data{"volumeID": volumeID})
+		}
+	}
+}
+
+func (p *BaseImagePuller) recoverIncompleteVolume(logger lager.Logger, chainID, volumeID string) error {
+	lockFile, err := p.locksmith.Lock(chainID)
+	if err != nil {
+		return errorspkg.Wrap(err, "acquiring lock")
+	}
+	defer p.locksmith.Unlock(lockFile)
+
+	// the pull that held the lock before might have completed the volume
+	if !p.volumeExists(logger, volumeID) {
+		return nil
+	}
+
+	logger.Info("destroying-incomplete-volume", lager.Data{"volumeID": volumeID})
+	return p.volumeDriver.DestroyVolume(logger, volumeID)
 }
 
 func (p *BaseImagePuller) quotaExceeded(logger lager.Logger, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) error {
@@ -135,9 +227,9 @@ func (p *BaseImagePuller) volumeExists(logger lager.Logger, chainID string) bool
 	return false
 }
 
-func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) error {
+func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) (int64, error) {
 	if index < 0 {
-		return nil
+		return 0, nil
 	}
 
 	layerInfo := layerInfos[index]
@@ -147,29 +239,30 @@ func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos
 		"parentChainID": layerInfo.ParentChainID,
 	})
 	if p.volumeExists(logger, layerInfo.ChainID) {
-		return nil
+		return p.cachedLayersSize(logger, layerInfos[:index+1], spec)
 	}
 
 	lockFile, err := p.locksmith.Lock(layerInfo.ChainID)
 	if err != nil {
-		return errorspkg.Wrap(err, "acquiring lock")
+		return 0, errorspkg.Wrap(err, "acquiring lock")
 	}
 	defer p.locksmith.Unlock(lockFile)
 
 	if p.volumeExists(logger, layerInfo.ChainID) {
-		return nil
+		return p.cachedLayersSize(logger, layerInfos[:index+1], spec)
 	}
 
 	downloadChan := make(chan downloadReturn, 1)
 	go p.downloadLayer(logger, spec, layerInfo, downloadChan)
 
-	if err := p.buildLayer(logger, index-1, layerInfos, spec); err != nil {
-		return err
+	parentsSize, err := p.buildLayer(logger, index-1, layerInfos, spec)
+	if err != nil {
+		return 0, err
 	}
 
 	downloadResult := <-downloadChan
 	if downloadResult.Err != nil {
-		return downloadResult.Err
+		return 0, downloadResult.Err
 	}
 
 	defer downloadResult.Stream.Close()
@@ -178,7 +271,39 @@ func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos
 	if index > 0 {
 		parentLayerInfo = layerInfos[index-1]
 	}
-	return p.unpackLayer(logger, layerInfo, parentLayerInfo, spec, downloadResult.Stream)
+
+	logicalSize, err := p.unpackLayer(logger, layerInfo, parentLayerInfo, spec, parentsSize, downloadResult.Stream)
+	if err != nil {
+		return 0, err
+	}
+
+	return parentsSize + logicalSize, nil
+}
+
+// cachedLayersSize adds up the logical sizes recorded for layers that are
+// already in the store, so that cached parents count towards the image size
+// limit like the layers being unpacked.
+func (p *BaseImagePuller) cachedLayersSize(logger lager.Logger, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) (int64, error) {
+	if spec.UnpackLimits.MaxImageBytes == 0 {
+		return 0, nil
+	}
+
+	var size int64
+	for _, layerInfo := range layerInfos {
+		volumeMeta, err := p.volumeDriver.VolumeMeta(logger, layerInfo.ChainID)
+		if err != nil {
+			return 0, errorspkg.Wrapf(err, "reading size of cached layer `%s`", layerInfo.ChainID)
+		}
+
+		// volumes pulled before the logical size was recorded only have the
+		// allocated one
+		if volumeMeta.LogicalSize == 0 {
+			volumeMeta.LogicalSize = volumeMeta.Size
+		}
+		size += volumeMeta.LogicalSize
+	}
+
+	return size, nil
 }
 
 type downloadReturn struct {
@@ -206,34 +331,38 @@ func (p *BaseImagePuller) downloadLayer(logger lager.Logger, spec groot.BaseImag
 	downloadChan <- downloadReturn{Stream: stream, Err: err}
 }
 
-func (p *BaseImagePuller) unpackLayer(logger lager.Logger, layerInfo, parentLayerInfo groot.LayerInfo, spec groot.BaseImageSpec, stream io.ReadCloser) error {
+func (p *BaseImagePuller) unpackLayer(logger lager.Logger, layerInfo, parentLayerInfo groot.LayerInfo, spec groot.BaseImageSpec, parentsSize int64, stream io.ReadCloser) (int64, error) {
 	logger = logger.Session("unpacking-layer", lager.Data{"LayerInfo": layerInfo})
 	logger.Debug("starting")
 	defer logger.Debug("ending")
 
 	tempVolumeName, volumePath, err := p.createTemporaryVolumeDirectory(logger, layerInfo, spec)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	unpackSpec := UnpackSpec{
-		TargetPath:    volumePath,
-		Stream:        stream,
-		UIDMappings:   spec.UIDMappings,
-		GIDMappings:   spec.GIDMappings,
-		BaseDirectory: layerInfo.BaseDirectory,
+		TargetPath:         volumePath,
+		Stream:             stream,
+		UIDMappings:        spec.UIDMappings,
+		GIDMappings:        spec.GIDMappings,
+		OverflowUID:        spec.OverflowUID,
+		OverflowGID:        spec.OverflowGID,
+		BaseDirectory:      layerInfo.BaseDirectory,
+		Limits:             spec.UnpackLimits,
+		ImageBytesUnpacked: parentsSize,
 	}
 
-	volSize, err := p.unpackLayerToTemporaryDirectory(logger, unpackSpec, layerInfo, parentLayerInfo)
+	unpackOutput, err := p.unpackLayerToTemporaryDirectory(logger, unpackSpec, layerInfo, parentLayerInfo)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return p.finalizeVolume(logger, tempVolumeName, volumePath, layerInfo.ChainID, volSize)
+	return unpackOutput.LogicalBytes, p.finalizeVolume(logger, tempVolumeName, volumePath, layerInfo, unpackOutput)
 }
 
 func (p *BaseImagePuller) createTemporaryVolumeDirectory(logger lager.Logger, layerInfo groot.LayerInfo, spec groot.BaseImageSpec) (string, string, error) {
-	tempVolumeName := fmt.Sprintf("%s-incomplete-%d-%d", layerInfo.ChainID, time.Now().UnixNano(), rand.Int())
+	tempVolumeName := fmt.Sprintf("%s%s%d-%d", layerInfo.ChainID, incompleteVolumeMarker, time.Now().UnixNano(), rand.Int())
 	volumePath, err := p.volumeDriver.CreateVolume(logger,
 		layerInfo.ParentChainID,
 		tempVolumeName,
@@ -253,39 +382,44 @@ func (p *BaseImagePuller) createTemporaryVolumeDirectory(logger lager.Logger, la
 	return tempVolumeName, volumePath, nil
 }
 
-func (p *BaseImagePuller) unpackLayerToTemporaryDirectory(logger lager.Logger, unpackSpec UnpackSpec, layerInfo, parentLayerInfo groot.LayerInfo) (volSize int64, err error) {
+func (p *BaseImagePuller) unpackLayerToTemporaryDirectory(logger lager.Logger, unpackSpec UnpackSpec, layerInfo, parentLayerInfo groot.LayerInfo) (unpackOutput UnpackOutput, err error) {
 	defer p.metricsEmitter.TryEmitDurationFrom(logger, MetricsUnpackTimeName, time.Now())
 
 	if unpackSpec.BaseDirectory != "" {
 		parentPath, err := p.volumeDriver.VolumePath(logger, parentLayerInfo.ChainID)
 		if err != nil {
-			return 0, err
+			return UnpackOutput{}, err
 		}
 
 		if err := ensureBaseDirectoryExists(unpackSpec.BaseDirectory, unpackSpec.TargetPath, parentPath); err != nil {
-			return 0, err
+			return UnpackOutput{}, err
 		}
 	}
 
-	var unpackOutput UnpackOutput
 	if unpackOutput, err = p.unpacker.Unpack(logger, unpackSpec); err != nil {
-		if errD := p.volumeDriver.DestroyVolume(logger, layerInfo.ChainID); errD != nil {
+		if errD := p.volumeDriver.DestroyVolume(logger, path.Base(unpackSpec.TargetPath)); errD != nil {
 			logger.Error("volume-cleanup-failed", errD)
 		}
-		return 0, errorspkg.Wrapf(err, "unpacking layer `%s`", layerInfo.BlobID)
+		return UnpackOutput{}, errorspkg.Wrapf(err, "unpacking layer `%s`", layerInfo.BlobID)
 	}
 
 	if err := p.volumeDriver.HandleOpaqueWhiteouts(logger, path.Base(unpackSpec.TargetPath), unpackOutput.OpaqueWhiteouts); err != nil {
 		logger.Error("handling-opaque-whiteouts", err)
-		return 0, errorspkg.Wrap(err, "handling opaque whiteouts")
+		return UnpackOutput{}, errorspkg.Wrap(err, "handling opaque whiteouts")
 	}
 
 	logger.Debug("layer-unpacked")
-	return unpackOutput.BytesWritten, nil
+	return unpackOutput, nil
 }
 
-func (p *BaseImagePuller) finalizeVolume(logger lager.Logger, tempVolumeName, volumePath, chainID string, volSize int64) error {
-	if err := p.volumeDriver.WriteVolumeMeta(logger, chainID, VolumeMeta{Size: volSize}); err != nil {
+func (p *BaseImagePuller) finalizeVolume(logger lager.Logger, tempVolumeName, volumePath string, layerInfo groot.LayerInfo, unpackOutput UnpackOutput) error {
+	chainID := layerInfo.ChainID
+	volumeMeta := VolumeMeta{
+		Size:        unpackOutput.BytesWritten,
+		LogicalSize: unpackOutput.LogicalBytes,
+		DiffID:      layerInfo.DiffID,
+	}
+	if err := p.volumeDriver.WriteVolumeMeta(logger, chainID, volumeMeta); err != nil {
 		return errorspkg.Wrapf(err, "writing volume `%s` metadata", chainID)
 	}
 
@@ -294,9 +428,50 @@ func (p *BaseImagePuller) finalizeVolume(logger lager.Logger, tempVolumeName, vo
 		return errorspkg.Wrapf(err, "failed to move volume to its final location")
 	}
 
+	// chain volumes are shared by every image built on top of them, so they
+	// must not change once they are complete
+	if err := p.volumeDriver.SetVolumeReadOnly(logger, chainID, true); err != nil {
+		return errorspkg.Wrapf(err, "making volume `%s` read-only", chainID)
+	}
+
 	return nil
 }
 
+// IsIncompleteVolume reports whether the volume is still being unpacked, or
+// was left behind by a pull that failed.
+func IsIncompleteVolume(id string) bool {
+	return strings.Contains(id, incompleteVolumeMarker)
+}
+
+// IncompleteVolumeStartTime returns when the pull that created the incomplete
+// volume started unpacking it.
+func IncompleteVolumeStartTime(id string) (time.Time, bool) {
+	if _, ok := IncompleteVolumeChainID(id); !ok {
+		return time.Time{}, false
+	}
+
+	suffix := id[strings.Index(id, incompleteVolumeMarker)+len(incompleteVolumeMarker):]
+	fields := strings.SplitN(suffix, "-", 2)
+	nanos, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+// IncompleteVolumeChainID returns the chain ID of the layer the incomplete
+// volume is being unpacked for. The pull holds the chain ID lock while it
+// unpacks it.
+func IncompleteVolumeChainID(id string) (string, bool) {
+	index := strings.Index(id, incompleteVolumeMarker)
+	if index <= 0 {
+		return "", false
+	}
+
+	return id[:index], true
+}
+
 func (p *BaseImagePuller) layersSize(layerInfos []groot.LayerInfo) int64 {
 	var totalSize int64
 	for _, layerInfo := range layerInfos {
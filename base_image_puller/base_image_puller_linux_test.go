@@ -24,6 +24,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	errorspkg "github.com/pkg/errors"
 )
 
 var _ = Describe("Base Image Puller", func() {
@@ -52,9 +53,9 @@ var _ = Describe("Base Image Puller", func() {
 		fakeFetcher = new(base_image_pullerfakes.FakeFetcher)
 		expectedImgDesc = specsv1.Image{Author: "Groot"}
 		layerInfos = []groot.LayerInfo{
-			{BlobID: "i-am-a-layer", ChainID: "layer-111", ParentChainID: ""},
-			{BlobID: "i-am-another-layer", ChainID: "chain-222", ParentChainID: "layer-111"},
-			{BlobID: "i-am-the-last-layer", ChainID: "chain-333", ParentChainID: "chain-222"},
+			{BlobID: "i-am-a-layer", ChainID: "layer-111", ParentChainID: "", DiffID: "diff-111"},
+			{BlobID: "i-am-another-layer", ChainID: "chain-222", ParentChainID: "layer-111", DiffID: "diff-222"},
+			{BlobID: "i-am-the-last-layer", ChainID: "chain-333", ParentChainID: "chain-222", DiffID: "diff-333"},
 		}
 		baseImageInfo = groot.BaseImageInfo{
 			LayerInfos: layerInfos,
@@ -374,7 +375,7 @@ var _ = Describe("Base Image Puller", func() {
 			var unpackCall int
 			fakeUnpacker.UnpackStub = func(_ lager.Logger, _ base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
 				unpackCall++
-				return base_image_puller.UnpackOutput{BytesWritten: int64(unpackCall * 100)}, nil
+				return base_image_puller.UnpackOutput{BytesWritten: int64(unpackCall * 100), LogicalBytes: int64(unpackCall * 150)}, nil
 			}
 
 			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
@@ -385,15 +386,63 @@ var _ = Describe("Base Image Puller", func() {
 			Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(Equal(3))
 			_, id, metadata := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
 			Expect(id).To(Equal("layer-111"))
-			Expect(metadata).To(Equal(base_image_puller.VolumeMeta{Size: 100}))
+			Expect(metadata.Size).To(Equal(int64(100)))
+			Expect(metadata.LogicalSize).To(Equal(int64(150)))
+			Expect(metadata.DiffID).To(Equal("diff-111"))
 
 			_, id, metadata = fakeVolumeDriver.WriteVolumeMetaArgsForCall(1)
 			Expect(id).To(Equal("chain-222"))
-			Expect(metadata).To(Equal(base_image_puller.VolumeMeta{Size: 200}))
+			Expect(metadata.Size).To(Equal(int64(200)))
+			Expect(metadata.LogicalSize).To(Equal(int64(300)))
+			Expect(metadata.DiffID).To(Equal("diff-222"))
 
 			_, id, metadata = fakeVolumeDriver.WriteVolumeMetaArgsForCall(2)
 			Expect(id).To(Equal("chain-333"))
-			Expect(metadata).To(Equal(base_image_puller.VolumeMeta{Size: 300}))
+			Expect(metadata.Size).To(Equal(int64(300)))
+			Expect(metadata.LogicalSize).To(Equal(int64(450)))
+			Expect(metadata.DiffID).To(Equal("diff-333"))
+		})
+
+		It("does not hash the unpacked contents", func() {
+			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+				BaseImageSrc: baseImageSrcURL,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, metadata := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
+			Expect(metadata.ContentDigest).To(BeEmpty())
+		})
+
+		It("makes each volume read-only once it is in its final location", func() {
+			fakeVolumeDriver.SetVolumeReadOnlyStub = func(_ lager.Logger, id string, _ bool) error {
+				Expect(filepath.Join(tmpVolumesDir, id)).To(BeADirectory())
+				return nil
+			}
+
+			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+				BaseImageSrc: baseImageSrcURL,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeVolumeDriver.SetVolumeReadOnlyCallCount()).To(Equal(3))
+			for i, chainID := range []string{"layer-111", "chain-222", "chain-333"} {
+				_, id, readOnly := fakeVolumeDriver.SetVolumeReadOnlyArgsForCall(i)
+				Expect(id).To(Equal(chainID))
+				Expect(readOnly).To(BeTrue())
+			}
+		})
+
+		Context("when making a volume read-only fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.SetVolumeReadOnlyReturns(errors.New("read-only failed"))
+			})
+
+			It("returns an error", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+					BaseImageSrc: baseImageSrcURL,
+				})
+				Expect(err).To(MatchError(ContainSubstring("read-only failed")))
+			})
 		})
 
 		It("emits a metric with the unpack and download time for each layer", func() {
@@ -486,6 +535,108 @@ var _ = Describe("Base Image Puller", func() {
 			})
 		})
 
+		Context("when unpack limits are provided", func() {
+			var limits groot.UnpackLimits
+
+			BeforeEach(func() {
+				limits = groot.UnpackLimits{MaxLayerBytes: 100, MaxImageBytes: 250}
+				fakeUnpacker.UnpackReturns(base_image_puller.UnpackOutput{BytesWritten: 40, LogicalBytes: 100}, nil)
+			})
+
+			It("forwards them to the unpacker with the logical size of the parent layers", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+					BaseImageSrc: baseImageSrcURL,
+					UnpackLimits: limits,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeUnpacker.UnpackCallCount()).To(Equal(3))
+				for i := 0; i < 3; i++ {
+					_, unpackSpec := fakeUnpacker.UnpackArgsForCall(i)
+					Expect(unpackSpec.Limits).To(Equal(limits))
+					Expect(unpackSpec.ImageBytesUnpacked).To(Equal(int64(i * 100)))
+				}
+			})
+
+			Context("when the parent layers are already in the store", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "layer-111"), 0777)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "chain-222"), 0777)).To(Succeed())
+					fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 20, LogicalSize: 80}, nil)
+				})
+
+				It("counts their recorded logical sizes towards the image bytes", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+						BaseImageSrc: baseImageSrcURL,
+						UnpackLimits: limits,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeVolumeDriver.VolumeMetaCallCount()).To(Equal(2))
+					Expect(fakeUnpacker.UnpackCallCount()).To(Equal(1))
+					_, unpackSpec := fakeUnpacker.UnpackArgsForCall(0)
+					Expect(unpackSpec.ImageBytesUnpacked).To(Equal(int64(160)))
+				})
+
+				Context("when a cached layer has no logical size recorded", func() {
+					BeforeEach(func() {
+						fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 70}, nil)
+					})
+
+					It("counts its allocated size instead", func() {
+						err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+							BaseImageSrc: baseImageSrcURL,
+							UnpackLimits: limits,
+						})
+						Expect(err).NotTo(HaveOccurred())
+
+						_, unpackSpec := fakeUnpacker.UnpackArgsForCall(0)
+						Expect(unpackSpec.ImageBytesUnpacked).To(Equal(int64(140)))
+					})
+				})
+
+				Context("when reading the size of a cached layer fails", func() {
+					BeforeEach(func() {
+						fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{}, errors.New("no metadata"))
+					})
+
+					It("returns an error", func() {
+						err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+							BaseImageSrc: baseImageSrcURL,
+							UnpackLimits: limits,
+						})
+						Expect(err).To(MatchError(ContainSubstring("no metadata")))
+						Expect(fakeUnpacker.UnpackCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			Context("when the unpacker exceeds a limit", func() {
+				BeforeEach(func() {
+					fakeUnpacker.UnpackReturns(base_image_puller.UnpackOutput{}, &base_image_puller.UnpackLimitExceededError{Limit: "layer-bytes", Max: 100})
+				})
+
+				It("returns the typed error", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+						BaseImageSrc: baseImageSrcURL,
+						UnpackLimits: limits,
+					})
+					Expect(errorspkg.Cause(err)).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "layer-bytes", Max: 100}))
+				})
+
+				It("destroys the incomplete volume", func() {
+					_ = baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+						BaseImageSrc: baseImageSrcURL,
+						UnpackLimits: limits,
+					})
+
+					Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
+					_, id := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
+					Expect(id).To(MatchRegexp("layer-111-incomplete-\\d*-\\d*"))
+				})
+			})
+		})
+
 		Context("when UID and GID mappings are provided", func() {
 			var spec groot.BaseImageSpec
 
@@ -506,6 +657,8 @@ var _ = Describe("Base Image Puller", func() {
 							Size:        100,
 						},
 					},
+					OverflowUID: 65534,
+					OverflowGID: 65533,
 				}
 			})
 
@@ -526,6 +679,15 @@ var _ = Describe("Base Image Puller", func() {
 				Expect(unpackSpec.UIDMappings).To(Equal(spec.UIDMappings))
 				Expect(unpackSpec.GIDMappings).To(Equal(spec.GIDMappings))
 			})
+
+			It("forwards the overflow ids to the unpacker", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, spec)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, unpackSpec := fakeUnpacker.UnpackArgsForCall(0)
+				Expect(unpackSpec.OverflowUID).To(Equal(65534))
+				Expect(unpackSpec.OverflowGID).To(Equal(65533))
+			})
 		})
 
 		Describe("volumes ownership", func() {
@@ -663,6 +825,47 @@ var _ = Describe("Base Image Puller", func() {
 			})
 		})
 
+		Context("when earlier pulls left incomplete volumes behind", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "chain-222-incomplete-1-2"), 0777)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "other-chain-incomplete-1-2"), 0777)).To(Succeed())
+
+				fakeVolumeDriver.VolumesReturns([]string{
+					"chain-222-incomplete-1-2",
+					"chain-333-incomplete-3-4",
+					"other-chain-incomplete-1-2",
+				}, nil)
+			})
+
+			It("destroys the ones of the image layers under the lock of their chain", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+					BaseImageSrc: baseImageSrcURL,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal("chain-222"))
+				Expect(fakeLocksmith.LockArgsForCall(1)).To(Equal("chain-333"))
+
+				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
+				_, volID := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
+				Expect(volID).To(Equal("chain-222-incomplete-1-2"))
+			})
+
+			Context("when destroying them fails", func() {
+				BeforeEach(func() {
+					fakeVolumeDriver.DestroyVolumeReturns(errors.New("failed to destroy"))
+				})
+
+				It("still pulls the image", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{
+						BaseImageSrc: baseImageSrcURL,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeVolumeDriver.CreateVolumeCallCount()).To(Equal(3))
+				})
+			})
+		})
+
 		Context("when creating a volume fails", func() {
 			BeforeEach(func() {
 				fakeVolumeDriver.CreateVolumeReturns("", errors.New("failed to create volume"))
@@ -711,7 +914,7 @@ var _ = Describe("Base Image Puller", func() {
 
 				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
 				_, path := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
-				Expect(path).To(Equal("chain-333"))
+				Expect(path).To(MatchRegexp("chain-333-incomplete-\\d*-\\d*"))
 			})
 
 			It("emits a metric with the unpack and download time for each layer", func() {
@@ -777,11 +980,32 @@ var _ = Describe("Base Image Puller", func() {
 
 					Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
 					_, path := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
-					Expect(path).To(Equal("chain-333"))
+					Expect(path).To(MatchRegexp("chain-333-incomplete-\\d*-\\d*"))
 				})
 			})
 		})
 	})
+
+	Describe("IncompleteVolumeStartTime", func() {
+		It("returns when the volume started to be unpacked", func() {
+			startedAt := time.Now()
+			id := fmt.Sprintf("sha256:abc-incomplete-%d-123", startedAt.UnixNano())
+
+			volumeStartedAt, ok := base_image_puller.IncompleteVolumeStartTime(id)
+			Expect(ok).To(BeTrue())
+			Expect(volumeStartedAt.Equal(startedAt)).To(BeTrue())
+		})
+
+		It("rejects volumes that are not incomplete", func() {
+			_, ok := base_image_puller.IncompleteVolumeStartTime("sha256:abc")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("rejects malformed names", func() {
+			_, ok := base_image_puller.IncompleteVolumeStartTime("sha256:abc-incomplete-soon-123")
+			Expect(ok).To(BeFalse())
+		})
+	})
 })
 
 func chainIDs(layerInfos []groot.LayerInfo) []string {
@@ -0,0 +1,67 @@
+package base_image_puller // import "github.com/SUSE/groot-btrfs/base_image_puller"
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+// ContentDigest hashes the tree at path: the names, modes, sizes, link
+// targets and contents of its entries, walked in lexical order. Ownership is
+// left out, as remapping the store shifts it.
+func ContentDigest(path string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(path, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(path, entryPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%o\x00", relPath, info.Mode())
+
+		switch {
+		case info.Mode().IsRegular():
+			fmt.Fprintf(hash, "%d\x00", info.Size())
+			return hashFile(hash, entryPath)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(entryPath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00", target)
+
+		case info.Mode()&(os.ModeDevice|os.ModeCharDevice) != 0:
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				fmt.Fprintf(hash, "%d\x00", stat.Rdev)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", errorspkg.Wrapf(err, "hashing `%s`", path)
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
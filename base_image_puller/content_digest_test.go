@@ -0,0 +1,50 @@
+package base_image_puller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ContentDigest", func() {
+	var treePath string
+
+	BeforeEach(func() {
+		var err error
+		treePath, err = ioutil.TempDir("", "tree")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(treePath, "file"), []byte("hello"), 0644)).To(Succeed())
+		Expect(os.Symlink("file", filepath.Join(treePath, "link"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(treePath)).To(Succeed())
+	})
+
+	It("changes with the contents", func() {
+		before, err := base_image_puller.ContentDigest(treePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(before).To(HavePrefix("sha256:"))
+
+		Expect(ioutil.WriteFile(filepath.Join(treePath, "file"), []byte("world"), 0644)).To(Succeed())
+		after, err := base_image_puller.ContentDigest(treePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+	})
+
+	It("changes with the link targets", func() {
+		before, err := base_image_puller.ContentDigest(treePath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.Remove(filepath.Join(treePath, "link"))).To(Succeed())
+		Expect(os.Symlink("other", filepath.Join(treePath, "link"))).To(Succeed())
+		after, err := base_image_puller.ContentDigest(treePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+	})
+})
@@ -25,6 +25,21 @@ type IDMapper interface {
 	MapGIDs(logger lager.Logger, pid int, mappings []groot.IDMappingSpec) error
 }
 
+type unpackLimits struct {
+	Limits             groot.UnpackLimits
+	ImageBytesUnpacked int64
+}
+
+// namespaceIDs is how the unpack child, which runs inside the user namespace,
+// translates the IDs of the entries. The IDs the mappings cover are kept as
+// they are, the rest are given the overflow IDs as seen from the namespace.
+type namespaceIDs struct {
+	UIDMappings []groot.IDMappingSpec
+	GIDMappings []groot.IDMappingSpec
+	OverflowUID int
+	OverflowGID int
+}
+
 type NSIdMapperUnpacker struct {
 	commandRunner  commandrunner.CommandRunner
 	idMapper       IDMapper
@@ -43,7 +58,7 @@ func init() {
 		logger := lager.NewLogger("unpack")
 		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
 
-		if len(os.Args) != 4 {
+		if len(os.Args) != 6 {
 			fail(logger, "parsing-command", errorspkg.New("destination directory or filesystem were not specified"))
 		}
 
@@ -59,12 +74,24 @@ func init() {
 		targetDir := os.Args[1]
 		baseDirectory := os.Args[2]
 		unpackStrategyJSON := os.Args[3]
+		unpackLimitsJSON := os.Args[4]
+		namespaceIDsJSON := os.Args[5]
 
 		var unpackStrategy UnpackStrategy
 		if err = json.Unmarshal([]byte(unpackStrategyJSON), &unpackStrategy); err != nil {
 			fail(logger, "unmarshal-unpack-strategy-failed", err)
 		}
 
+		var unpackLimits unpackLimits
+		if err = json.Unmarshal([]byte(unpackLimitsJSON), &unpackLimits); err != nil {
+			fail(logger, "unmarshal-unpack-limits-failed", err)
+		}
+
+		var namespaceIDs namespaceIDs
+		if err = json.Unmarshal([]byte(namespaceIDsJSON), &namespaceIDs); err != nil {
+			fail(logger, "unmarshal-namespace-ids-failed", err)
+		}
+
 		unpacker, err := NewTarUnpacker(unpackStrategy)
 		if err != nil {
 			fail(logger, "creating-tar-unpacker", err)
@@ -72,11 +99,17 @@ func init() {
 
 		var unpackOutput base_image_puller.UnpackOutput
 		if unpackOutput, err = unpacker.Unpack(logger, base_image_puller.UnpackSpec{
-			Stream:        os.Stdin,
-			TargetPath:    targetDir,
-			BaseDirectory: baseDirectory,
+			Stream:             os.Stdin,
+			TargetPath:         targetDir,
+			BaseDirectory:      baseDirectory,
+			UIDMappings:        namespaceIDs.UIDMappings,
+			GIDMappings:        namespaceIDs.GIDMappings,
+			OverflowUID:        namespaceIDs.OverflowUID,
+			OverflowGID:        namespaceIDs.OverflowGID,
+			Limits:             unpackLimits.Limits,
+			ImageBytesUnpacked: unpackLimits.ImageBytesUnpacked,
 		}); err != nil {
-			fail(logger, "unpacking-failed", err)
+			failUnpack(logger, err)
 		}
 
 		json.NewEncoder(os.Stdout).Encode(unpackOutput)
@@ -109,7 +142,27 @@ func (u *NSIdMapperUnpacker) Unpack(logger lager.Logger, spec base_image_puller.
 		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "unmarshal unpack strategy")
 	}
 
-	unpackCmd := reexec.Command("unpack", spec.TargetPath, spec.BaseDirectory, string(unpackStrategyJSON))
+	unpackLimitsJSON, err := json.Marshal(&unpackLimits{
+		Limits:             spec.Limits,
+		ImageBytesUnpacked: spec.ImageBytesUnpacked,
+	})
+	if err != nil {
+		logger.Error("marshal-unpack-limits-failed", err)
+		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "marshal unpack limits")
+	}
+
+	namespaceIDsJSON, err := json.Marshal(&namespaceIDs{
+		UIDMappings: identityMappings(spec.UIDMappings),
+		GIDMappings: identityMappings(spec.GIDMappings),
+		OverflowUID: namespaceOverflowID(spec.UIDMappings, spec.OverflowUID),
+		OverflowGID: namespaceOverflowID(spec.GIDMappings, spec.OverflowGID),
+	})
+	if err != nil {
+		logger.Error("marshal-namespace-ids-failed", err)
+		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "marshal namespace ids")
+	}
+
+	unpackCmd := reexec.Command("unpack", spec.TargetPath, spec.BaseDirectory, string(unpackStrategyJSON), string(unpackLimitsJSON), string(namespaceIDsJSON))
 	unpackCmd.Stdin = spec.Stream
 	if len(spec.UIDMappings) > 0 || len(spec.GIDMappings) > 0 {
 		unpackCmd.SysProcAttr = &syscall.SysProcAttr{
@@ -143,7 +196,7 @@ func (u *NSIdMapperUnpacker) Unpack(logger lager.Logger, spec base_image_puller.
 
 	logger.Debug("waiting-for-unpack-command")
 	if err := u.commandRunner.Wait(unpackCmd); err != nil {
-		return base_image_puller.UnpackOutput{}, errorspkg.Errorf(outBuffer.String())
+		return base_image_puller.UnpackOutput{}, parseUnpackError(outBuffer.String())
 	}
 	logger.Debug("unpack-command-done")
 
@@ -173,3 +226,29 @@ func (u *NSIdMapperUnpacker) setIDMappings(logger lager.Logger, spec base_image_
 
 	return nil
 }
+
+// identityMappings are the namespace side of the mappings, inside the user
+// namespace the IDs they cover need no translation.
+func identityMappings(mappings []groot.IDMappingSpec) []groot.IDMappingSpec {
+	identity := []groot.IDMappingSpec{}
+	for _, mapping := range mappings {
+		identity = append(identity, groot.IDMappingSpec{
+			NamespaceID: mapping.NamespaceID,
+			HostID:      mapping.NamespaceID,
+			Size:        mapping.Size,
+		})
+	}
+
+	return identity
+}
+
+// namespaceOverflowID returns the namespace ID of the host overflow ID. An
+// overflow ID the mappings don't cover is passed on as it is, and chowning to
+// it fails inside the namespace.
+func namespaceOverflowID(mappings []groot.IDMappingSpec, overflowID int) int {
+	if namespaceID, ok := groot.NewIDMappingTable(mappings, overflowID).NamespaceID(overflowID); ok {
+		return namespaceID
+	}
+
+	return overflowID
+}
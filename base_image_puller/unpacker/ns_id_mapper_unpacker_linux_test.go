@@ -89,10 +89,33 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		Expect(commands).To(HaveLen(1))
 		Expect(commands[0].Path).To(Equal("/proc/self/exe"))
 		Expect(commands[0].Args).To(Equal([]string{
-			"unpack", targetPath, "/base-folder/", string(unpackStrategyJson),
+			"unpack", targetPath, "/base-folder/", string(unpackStrategyJson), `{"Limits":{"MaxLayerBytes":0,"MaxImageBytes":0,"MaxEntries":0,"MaxPathLength":0,"MaxFileBytes":0},"ImageBytesUnpacked":0}`,
+			`{"UIDMappings":[],"GIDMappings":[],"OverflowUID":0,"OverflowGID":0}`,
 		}))
 	})
 
+	It("passes the namespace side of the mappings and overflow ids to the unpack command", func() {
+		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+			TargetPath: targetPath,
+			UIDMappings: []groot.IDMappingSpec{
+				{NamespaceID: 0, HostID: 1000, Size: 1},
+				{NamespaceID: 1, HostID: 100000, Size: 65536},
+			},
+			GIDMappings: []groot.IDMappingSpec{
+				{NamespaceID: 0, HostID: 1000, Size: 1},
+			},
+			OverflowUID: 165533,
+			OverflowGID: 65534,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := fakeCommandRunner.StartedCommands()
+		Expect(commands).To(HaveLen(1))
+		Expect(commands[0].Args[5]).To(Equal(
+			`{"UIDMappings":[{"HostID":0,"NamespaceID":0,"Size":1},{"HostID":1,"NamespaceID":1,"Size":65536}],"GIDMappings":[{"HostID":0,"NamespaceID":0,"Size":1}],"OverflowUID":65534,"OverflowGID":65534}`,
+		))
+	})
+
 	It("returns the total bytes written based on the unpack output", func() {
 		totalBytes, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 			TargetPath: targetPath,
@@ -0,0 +1,94 @@
+package unpacker // import "github.com/SUSE/groot-btrfs/base_image_puller/unpacker"
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sparseBlockSize  = 4096
+	sparseBufferSize = 64 * sparseBlockSize
+	statBlockSize    = 512
+)
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// copySparse copies the contents of reader into file, leaving holes in place
+// of zero-filled blocks instead of writing them. The tar reader expands the
+// GNU and PAX sparse maps into zeros, so sparse entries end up as sparse files
+// as well. It returns the number of bytes actually allocated for the file.
+func copySparse(file *os.File, reader io.Reader) (int64, error) {
+	buffer := make([]byte, sparseBufferSize)
+	var offset int64
+
+	for {
+		n, readErr := io.ReadFull(reader, buffer)
+		if n > 0 {
+			if err := writeNonZeroBlocks(file, buffer[:n], offset); err != nil {
+				return 0, err
+			}
+			offset += int64(n)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return 0, readErr
+		}
+	}
+
+	// holes at the end of the file are not written, so the size must be set
+	// explicitly
+	if err := file.Truncate(offset); err != nil {
+		return 0, errors.Wrap(err, "setting the file size")
+	}
+
+	return allocatedSize(file)
+}
+
+func writeNonZeroBlocks(file *os.File, data []byte, offset int64) error {
+	dataStart := -1
+
+	for blockStart := 0; blockStart < len(data); blockStart += sparseBlockSize {
+		blockEnd := blockStart + sparseBlockSize
+		if blockEnd > len(data) {
+			blockEnd = len(data)
+		}
+
+		if !bytes.Equal(data[blockStart:blockEnd], zeroBlock[:blockEnd-blockStart]) {
+			if dataStart < 0 {
+				dataStart = blockStart
+			}
+			continue
+		}
+
+		if dataStart >= 0 {
+			if _, err := file.WriteAt(data[dataStart:blockStart], offset+int64(dataStart)); err != nil {
+				return err
+			}
+			dataStart = -1
+		}
+	}
+
+	if dataStart >= 0 {
+		if _, err := file.WriteAt(data[dataStart:], offset+int64(dataStart)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func allocatedSize(file *os.File) (int64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(file.Fd()), &stat); err != nil {
+		return 0, errors.Wrap(err, "measuring allocated size")
+	}
+
+	return stat.Blocks * statBlockSize, nil
+}
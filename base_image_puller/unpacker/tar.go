@@ -60,7 +60,7 @@ func init() {
 		logger.Info("unpacking")
 		var unpackOutput base_image_puller.UnpackOutput
 		if unpackOutput, err = unpacker.unpack(logger, unpackSpec); err != nil {
-			fail(logger, "unpacking-failed", err)
+			failUnpack(logger, err)
 		}
 
 		_ = json.NewEncoder(os.Stdout).Encode(unpackOutput)
@@ -122,7 +122,7 @@ func (u *TarUnpacker) Unpack(logger lager.Logger, spec base_image_puller.UnpackS
 
 	if err := cmd.Run(); err != nil {
 		logger.Error("chroot-unpack-failed", err, lager.Data{"output": outputBuffer.String()})
-		return base_image_puller.UnpackOutput{}, errors.New(strings.TrimSpace(outputBuffer.String()))
+		return base_image_puller.UnpackOutput{}, parseUnpackError(outputBuffer.String())
 	}
 
 	var unpackOutput base_image_puller.UnpackOutput
@@ -150,7 +150,9 @@ func (u *TarUnpacker) unpack(logger lager.Logger, spec base_image_puller.UnpackS
 	}
 
 	tarReader := tar.NewReader(spec.Stream)
-	var totalBytesUnpacked int64
+	// limits are enforced on the logical size of the entries, since the
+	// allocated size is only known once a file has been written
+	var totalBytesUnpacked, totalBytesRead, totalEntries int64
 	for {
 		tarHeader, err := tarReader.Next()
 		if err == io.EOF {
@@ -159,6 +161,12 @@ func (u *TarUnpacker) unpack(logger lager.Logger, spec base_image_puller.UnpackS
 			return base_image_puller.UnpackOutput{}, err
 		}
 
+		totalEntries++
+		if err := checkUnpackLimits(spec, tarHeader, totalEntries, totalBytesRead); err != nil {
+			logger.Error("unpack-limit-exceeded", err, lager.Data{"entry": tarHeader.Name})
+			return base_image_puller.UnpackOutput{}, err
+		}
+
 		entryPath := filepath.Join(spec.BaseDirectory, tarHeader.Name)
 
 		if strings.Contains(tarHeader.Name, ".wh..wh..opq") {
@@ -182,10 +190,14 @@ func (u *TarUnpacker) unpack(logger lager.Logger, spec base_image_puller.UnpackS
 		}
 
 		totalBytesUnpacked += entrySize
+		if isRegularFile(tarHeader) {
+			totalBytesRead += tarHeader.Size
+		}
 	}
 
 	return base_image_puller.UnpackOutput{
-		BytesWritten:    totalBytesUnpacked,
+		BytesWritten: totalBytesUnpacked,
+		LogicalBytes: totalBytesRead,
 	}, nil
 }
 
@@ -210,7 +222,7 @@ func (u *TarUnpacker) handleEntry(entryPath string, tarReader *tar.Reader, tarHe
 			return 0, err
 		}
 
-	case tar.TypeReg, tar.TypeRegA:
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
 		if entrySize, err = u.createRegularFile(entryPath, tarHeader, tarReader, spec); err != nil {
 			return 0, err
 		}
@@ -234,8 +246,8 @@ func (u *TarUnpacker) createDirectory(path string, tarHeader *tar.Header, spec b
 	}
 
 	if os.Getuid() == 0 {
-		uid := u.translateID(tarHeader.Uid, spec.UIDMappings)
-		gid := u.translateID(tarHeader.Gid, spec.GIDMappings)
+		uid := groot.NewIDMappingTable(spec.UIDMappings, spec.OverflowUID).HostID(tarHeader.Uid)
+		gid := groot.NewIDMappingTable(spec.GIDMappings, spec.OverflowGID).HostID(tarHeader.Gid)
 		if err := os.Chown(path, uid, gid); err != nil {
 			return errors.Wrapf(err, "chowning directory %d:%d `%s`", uid, gid, path)
 		}
@@ -247,7 +259,7 @@ func (u *TarUnpacker) createDirectory(path string, tarHeader *tar.Header, spec b
 	}
 
 	if err := changeModTime(path, tarHeader.ModTime); err != nil {
-		return errors.Wrapf(err, "setting the modtime for directory `%s`: %s", path)
+		return errors.Wrapf(err, "setting the modtime for directory `%s`", path)
 	}
 
 	return nil
@@ -269,8 +281,8 @@ func (u *TarUnpacker) createSymlink(path string, tarHeader *tar.Header, spec bas
 	}
 
 	if os.Getuid() == 0 {
-		uid := u.translateID(tarHeader.Uid, spec.UIDMappings)
-		gid := u.translateID(tarHeader.Gid, spec.GIDMappings)
+		uid := groot.NewIDMappingTable(spec.UIDMappings, spec.OverflowUID).HostID(tarHeader.Uid)
+		gid := groot.NewIDMappingTable(spec.GIDMappings, spec.OverflowGID).HostID(tarHeader.Gid)
 
 		if err := os.Lchown(path, uid, gid); err != nil {
 			return errors.Wrapf(err, "chowning link %d:%d `%s`", uid, gid, path)
@@ -297,7 +309,7 @@ func (u *TarUnpacker) createRegularFile(path string, tarHeader *tar.Header, tarR
 		return 0, newErr
 	}
 
-	fileSize, err := io.Copy(file, tarReader)
+	fileSize, err := copySparse(file, tarReader)
 	if err != nil {
 		_ = file.Close()
 		return 0, errors.Wrapf(err, "writing to file `%s`", path)
@@ -308,8 +320,8 @@ func (u *TarUnpacker) createRegularFile(path string, tarHeader *tar.Header, tarR
 	}
 
 	if os.Getuid() == 0 {
-		uid := u.translateID(tarHeader.Uid, spec.UIDMappings)
-		gid := u.translateID(tarHeader.Gid, spec.GIDMappings)
+		uid := groot.NewIDMappingTable(spec.UIDMappings, spec.OverflowUID).HostID(tarHeader.Uid)
+		gid := groot.NewIDMappingTable(spec.GIDMappings, spec.OverflowGID).HostID(tarHeader.Gid)
 		if err := os.Chown(path, uid, gid); err != nil {
 			return 0, errors.Wrapf(err, "chowning file %d:%d `%s`", uid, gid, path)
 		}
@@ -327,47 +339,83 @@ func (u *TarUnpacker) createRegularFile(path string, tarHeader *tar.Header, tarR
 	return fileSize, nil
 }
 
-func cleanWhiteoutDir(path string) error {
-	contents, err := ioutil.ReadDir(path)
-	if err != nil {
-		return errors.Wrap(err, "reading whiteout directory")
+func isRegularFile(tarHeader *tar.Header) bool {
+	switch tarHeader.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		return true
 	}
 
-	for _, content := range contents {
-		if err := os.RemoveAll(filepath.Join(path, content.Name())); err != nil {
-			return errors.Wrap(err, "cleaning up whiteout directory")
-		}
+	return false
+}
+
+func checkUnpackLimits(spec base_image_puller.UnpackSpec, tarHeader *tar.Header, totalEntries, totalBytesRead int64) error {
+	limits := spec.Limits
+
+	if limits.MaxEntries > 0 && totalEntries > limits.MaxEntries {
+		return &base_image_puller.UnpackLimitExceededError{Limit: "entries", Max: limits.MaxEntries}
+	}
+
+	if limits.MaxPathLength > 0 && len(tarHeader.Name) > limits.MaxPathLength {
+		return &base_image_puller.UnpackLimitExceededError{Limit: "path-length", Max: int64(limits.MaxPathLength)}
+	}
+
+	if !isRegularFile(tarHeader) {
+		return nil
+	}
+
+	if limits.MaxFileBytes > 0 && tarHeader.Size > limits.MaxFileBytes {
+		return &base_image_puller.UnpackLimitExceededError{Limit: "file-bytes", Max: limits.MaxFileBytes}
+	}
+
+	if limits.MaxLayerBytes > 0 && totalBytesRead+tarHeader.Size > limits.MaxLayerBytes {
+		return &base_image_puller.UnpackLimitExceededError{Limit: "layer-bytes", Max: limits.MaxLayerBytes}
+	}
+
+	if limits.MaxImageBytes > 0 && spec.ImageBytesUnpacked+totalBytesRead+tarHeader.Size > limits.MaxImageBytes {
+		return &base_image_puller.UnpackLimitExceededError{Limit: "image-bytes", Max: limits.MaxImageBytes}
 	}
 
 	return nil
 }
 
-func (u *TarUnpacker) translateID(id int, mappings []groot.IDMappingSpec) int {
-	if id == 0 {
-		return u.translateRootID(mappings)
+// failUnpack is used by the reexeced unpack commands. Limit errors are written
+// as JSON so that parseUnpackError can rebuild them in the parent process.
+func failUnpack(logger lager.Logger, err error) {
+	logger.Error("unpacking-failed", err)
+
+	if limitErr, ok := errors.Cause(err).(*base_image_puller.UnpackLimitExceededError); ok {
+		_ = json.NewEncoder(os.Stdout).Encode(limitErr)
+	} else {
+		fmt.Println(err.Error())
 	}
 
-	for _, mapping := range mappings {
-		if mapping.Size == 1 {
-			continue
-		}
+	os.Exit(1)
+}
 
-		if id >= mapping.NamespaceID && id < mapping.NamespaceID+mapping.Size {
-			return mapping.HostID + id - 1
-		}
+func parseUnpackError(output string) error {
+	output = strings.TrimSpace(output)
+
+	var limitErr base_image_puller.UnpackLimitExceededError
+	if err := json.Unmarshal([]byte(output), &limitErr); err == nil && limitErr.Limit != "" {
+		return &limitErr
 	}
 
-	return id
+	return errors.New(output)
 }
 
-func (u *TarUnpacker) translateRootID(mappings []groot.IDMappingSpec) int {
-	for _, mapping := range mappings {
-		if mapping.Size == 1 {
-			return mapping.HostID
+func cleanWhiteoutDir(path string) error {
+	contents, err := ioutil.ReadDir(path)
+	if err != nil {
+		return errors.Wrap(err, "reading whiteout directory")
+	}
+
+	for _, content := range contents {
+		if err := os.RemoveAll(filepath.Join(path, content.Name())); err != nil {
+			return errors.Wrap(err, "cleaning up whiteout directory")
 		}
 	}
 
-	return 0
+	return nil
 }
 
 func chroot(path string) error {
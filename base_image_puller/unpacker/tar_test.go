@@ -94,30 +94,95 @@ var _ = Describe("Tar unpacker", func() {
 
 		Describe("unpacked bytes count", func() {
 			BeforeEach(func() {
-				cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "1mb")), "count=1", "bs=1M")
+				cmd := exec.Command("dd", "if=/dev/urandom", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "1mb")), "count=1", "bs=1M")
 				sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 				Eventually(sess).Should(gexec.Exit(0))
 
-				cmd = exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "3mb")), "count=3", "bs=1M")
+				cmd = exec.Command("dd", "if=/dev/urandom", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "3mb")), "count=3", "bs=1M")
 				sess, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 				Eventually(sess).Should(gexec.Exit(0))
 
-				cmd = exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "1k")), "count=1", "bs=1K")
+				cmd = exec.Command("dd", "if=/dev/urandom", fmt.Sprintf("of=%s", filepath.Join(baseImagePath, "1k")), "count=1", "bs=1K")
 				sess, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 				Eventually(sess).Should(gexec.Exit(0))
 			})
 
-			It("returns the total size that was unpacked", func() {
+			It("returns the total size that was allocated on disk", func() {
 				totalUnpacked, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
 					Stream:     stream,
 					TargetPath: targetPath,
 				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var allocated int64
+				for _, name := range []string{"1mb", "3mb", "1k", "a_file"} {
+					allocated += allocatedBytes(filepath.Join(targetPath, name))
+				}
+				Expect(totalUnpacked.BytesWritten).To(Equal(allocated))
+				Expect(totalUnpacked.BytesWritten).To(BeNumerically(">=", 1024*1024+1024*1024*3+1024+11))
+			})
+		})
+
+		Describe("sparse files", func() {
+			BeforeEach(func() {
+				sparseFile, err := os.Create(filepath.Join(baseImagePath, "sparse"))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = sparseFile.WriteAt([]byte("beginning"), 0)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = sparseFile.WriteAt([]byte("end"), 64*1024*1024-3)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sparseFile.Close()).To(Succeed())
+
+				zeroFile, err := os.Create(filepath.Join(baseImagePath, "zeros"))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = zeroFile.Write(make([]byte, 1024*1024))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(zeroFile.Close()).To(Succeed())
+			})
+
+			JustBeforeEach(func() {
+				stream = gbytes.NewBuffer()
+				sess, err := gexec.Start(exec.Command("tar", "-c", "--sparse", "-C", baseImagePath, "."), stream, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(sess, 5*time.Second).Should(gexec.Exit(0))
+			})
+
+			It("keeps the holes in the unpacked files", func() {
+				_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+					Stream:     stream,
+					TargetPath: targetPath,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				sparsePath := filepath.Join(targetPath, "sparse")
+				stat, err := os.Stat(sparsePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stat.Size()).To(Equal(int64(64 * 1024 * 1024)))
+				Expect(allocatedBytes(sparsePath)).To(BeNumerically("<", 1024*1024))
+
+				contents, err := ioutil.ReadFile(sparsePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents[:9])).To(Equal("beginning"))
+				Expect(string(contents[len(contents)-3:])).To(Equal("end"))
 
+				zerosPath := filepath.Join(targetPath, "zeros")
+				stat, err = os.Stat(zerosPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stat.Size()).To(Equal(int64(1024 * 1024)))
+				Expect(allocatedBytes(zerosPath)).To(BeZero())
+			})
+
+			It("reports the allocated size and the logical size apart", func() {
+				totalUnpacked, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+					Stream:     stream,
+					TargetPath: targetPath,
+				})
 				Expect(err).NotTo(HaveOccurred())
-				Expect(totalUnpacked).To(Equal(base_image_puller.UnpackOutput{BytesWritten: 1024*1024 + 1024*1024*3 + 1024 + 11, OpaqueWhiteouts: []string{}}))
+				Expect(totalUnpacked.BytesWritten).To(BeNumerically("<", 1024*1024))
+				Expect(totalUnpacked.LogicalBytes).To(Equal(int64(64*1024*1024 + 1024*1024)))
 			})
 		})
 
@@ -191,6 +256,8 @@ var _ = Describe("Tar unpacker", func() {
 							groot.IDMappingSpec{HostID: 11, NamespaceID: 1, Size: 900},
 							groot.IDMappingSpec{HostID: 2001, NamespaceID: 1001, Size: 900},
 						},
+						OverflowUID: 3000,
+						OverflowGID: 3000,
 					})
 					Expect(err).NotTo(HaveOccurred())
 
@@ -212,15 +279,51 @@ var _ = Describe("Tar unpacker", func() {
 					Expect(filePath).To(BeARegularFile())
 					stat, err = os.Stat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2000 + 1200)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2000 + 1200)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2001 + 199)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2001 + 199)))
 
 					filePath = path.Join(targetPath, "groot_file")
 					Expect(filePath).To(BeARegularFile())
 					stat, err = os.Stat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1000)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(1000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(3000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(3000)))
+				})
+
+				It("maps ranges that do not start at namespace id 1", func() {
+					_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+						Stream:     stream,
+						TargetPath: targetPath,
+						UIDMappings: []groot.IDMappingSpec{
+							groot.IDMappingSpec{HostID: 100000, NamespaceID: 0, Size: 1100},
+						},
+						GIDMappings: []groot.IDMappingSpec{
+							groot.IDMappingSpec{HostID: 200000, NamespaceID: 0, Size: 1100},
+						},
+						OverflowUID: 3000,
+						OverflowGID: 4000,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					stat, err := os.Stat(path.Join(targetPath, "a_file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(100000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(200000)))
+
+					stat, err = os.Stat(path.Join(targetPath, "200_file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(100200)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(200200)))
+
+					stat, err = os.Stat(path.Join(targetPath, "groot_file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(101000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(201000)))
+
+					stat, err = os.Stat(path.Join(targetPath, "1200_file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(3000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(4000)))
 				})
 			})
 		})
@@ -291,6 +394,8 @@ var _ = Describe("Tar unpacker", func() {
 							groot.IDMappingSpec{HostID: 11, NamespaceID: 1, Size: 900},
 							groot.IDMappingSpec{HostID: 2001, NamespaceID: 1001, Size: 900},
 						},
+						OverflowUID: 3000,
+						OverflowGID: 3000,
 					})
 					Expect(err).NotTo(HaveOccurred())
 
@@ -305,15 +410,15 @@ var _ = Describe("Tar unpacker", func() {
 					Expect(filePath).To(BeADirectory())
 					stat, err = os.Stat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2000 + 1200)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2000 + 1200)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2001 + 199)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2001 + 199)))
 
 					filePath = path.Join(targetPath, "groot_dir")
 					Expect(filePath).To(BeADirectory())
 					stat, err = os.Stat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1000)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(1000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(3000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(3000)))
 				})
 			})
 		})
@@ -475,6 +580,8 @@ var _ = Describe("Tar unpacker", func() {
 							groot.IDMappingSpec{HostID: 11, NamespaceID: 1, Size: 900},
 							groot.IDMappingSpec{HostID: 2001, NamespaceID: 1001, Size: 900},
 						},
+						OverflowUID: 3000,
+						OverflowGID: 3000,
 					})
 					Expect(err).NotTo(HaveOccurred())
 
@@ -489,15 +596,15 @@ var _ = Describe("Tar unpacker", func() {
 					Expect(filePath).To(BeAnExistingFile())
 					stat, err = os.Lstat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2000 + 1200)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2000 + 1200)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(2001 + 199)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(2001 + 199)))
 
 					filePath = path.Join(targetPath, "groot_link")
 					Expect(filePath).To(BeAnExistingFile())
 					stat, err = os.Lstat(filePath)
 					Expect(err).NotTo(HaveOccurred())
-					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1000)))
-					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(1000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(3000)))
+					Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(3000)))
 				})
 			})
 		})
@@ -682,6 +789,59 @@ var _ = Describe("Tar unpacker", func() {
 		})
 	})
 
+	Describe("unpack limits", func() {
+		BeforeEach(func() {
+			Expect(os.Mkdir(path.Join(baseImagePath, "a_directory"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(baseImagePath, "a_directory", "a_file"), []byte("hello-world"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(baseImagePath, "another_file"), []byte("hello-world"), 0600)).To(Succeed())
+		})
+
+		unpackWithLimits := func(limits groot.UnpackLimits, imageBytesUnpacked int64) error {
+			_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				Stream:             stream,
+				TargetPath:         targetPath,
+				Limits:             limits,
+				ImageBytesUnpacked: imageBytesUnpacked,
+			})
+			return err
+		}
+
+		It("succeeds when the layer is within the limits", func() {
+			Expect(unpackWithLimits(groot.UnpackLimits{
+				MaxLayerBytes: 22,
+				MaxImageBytes: 22,
+				MaxEntries:    4,
+				MaxPathLength: 100,
+				MaxFileBytes:  11,
+			}, 0)).To(Succeed())
+		})
+
+		It("fails when the layer exceeds the max layer bytes", func() {
+			err := unpackWithLimits(groot.UnpackLimits{MaxLayerBytes: 21}, 0)
+			Expect(err).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "layer-bytes", Max: 21}))
+		})
+
+		It("fails when the parent layers and this layer exceed the max image bytes", func() {
+			err := unpackWithLimits(groot.UnpackLimits{MaxImageBytes: 30}, 10)
+			Expect(err).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "image-bytes", Max: 30}))
+		})
+
+		It("fails when the layer has too many entries", func() {
+			err := unpackWithLimits(groot.UnpackLimits{MaxEntries: 3}, 0)
+			Expect(err).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "entries", Max: 3}))
+		})
+
+		It("fails when an entry path is too long", func() {
+			err := unpackWithLimits(groot.UnpackLimits{MaxPathLength: 10}, 0)
+			Expect(err).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "path-length", Max: 10}))
+		})
+
+		It("fails when a file is too big", func() {
+			err := unpackWithLimits(groot.UnpackLimits{MaxFileBytes: 10}, 0)
+			Expect(err).To(Equal(&base_image_puller.UnpackLimitExceededError{Limit: "file-bytes", Max: 10}))
+		})
+	})
+
 	Context("when the tar has files that point to a parent directory", func() {
 		JustBeforeEach(func() {
 			workDir, err := os.Getwd()
@@ -734,3 +894,9 @@ var _ = Describe("Tar unpacker", func() {
 		})
 	})
 })
+
+func allocatedBytes(path string) int64 {
+	var stat syscall.Stat_t
+	Expect(syscall.Stat(path, &stat)).To(Succeed())
+	return stat.Blocks * 512
+}
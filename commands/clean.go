@@ -1,9 +1,10 @@
 package commands // import "github.com/SUSE/groot-btrfs/commands"
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
 
 	"code.cloudfoundry.org/commandrunner/linux_command_runner"
 	"code.cloudfoundry.org/lager"
@@ -12,20 +13,31 @@ import (
 	"github.com/SUSE/groot-btrfs/commands/config"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
-	storepkg "github.com/SUSE/groot-btrfs/store"
-	"github.com/SUSE/groot-btrfs/store/dependency_manager"
 	"github.com/SUSE/groot-btrfs/store/filesystems/namespaced"
 	"github.com/SUSE/groot-btrfs/store/garbage_collector"
 	imageClonerpkg "github.com/SUSE/groot-btrfs/store/image_cloner"
-	locksmithpkg "github.com/SUSE/groot-btrfs/store/locksmith"
 	errorspkg "github.com/pkg/errors"
 
 	"github.com/urfave/cli"
 )
 
+// cleanCandidate is a layer clean would remove.
+type cleanCandidate struct {
+	ChainID   string     `json:"chain_id"`
+	SizeBytes int64      `json:"size_bytes"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	BaseImage string     `json:"base_image,omitempty"`
+}
+
+type cleanReport struct {
+	ThresholdReached bool             `json:"threshold_reached"`
+	TotalBytes       int64            `json:"total_bytes"`
+	Layers           []cleanCandidate `json:"layers"`
+}
+
 var CleanCommand = cli.Command{
 	Name:        "clean",
-	Usage:       "clean",
+	Usage:       "clean [--dry-run]",
 	Description: "Cleans up unused layers",
 
 	Flags: []cli.Flag{
@@ -33,6 +45,26 @@ var CleanCommand = cli.Command{
 			Name:  "threshold-bytes",
 			Usage: "Disk usage of the store directory at which cleanup should trigger",
 		},
+		cli.StringFlag{
+			Name:  "measurer",
+			Usage: "How to measure the store usage for the threshold: `statfs` or `qgroup`",
+		},
+		cli.Int64Flag{
+			Name:  "low-watermark-bytes",
+			Usage: "Only collect the least recently used layers until the store usage is below this",
+		},
+		cli.DurationFlag{
+			Name:  "keep-for",
+			Usage: "Keep the layers used more recently than this",
+		},
+		cli.IntFlag{
+			Name:  "keep-base-images",
+			Usage: "Keep the layers of this many of the most recently used base images",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print the layers that would be removed as JSON, without removing them",
+		},
 	},
 
 	Action: func(ctx *cli.Context) error {
@@ -42,7 +74,11 @@ var CleanCommand = cli.Command{
 
 		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
 		configBuilder.WithCleanThresholdBytes(ctx.Int64("threshold-bytes"),
-			ctx.IsSet("threshold-bytes"))
+			ctx.IsSet("threshold-bytes")).
+			WithCleanMeasurer(ctx.String("measurer"), ctx.IsSet("measurer")).
+			WithCleanLowWatermarkBytes(ctx.Int64("low-watermark-bytes"), ctx.IsSet("low-watermark-bytes")).
+			WithCleanKeepFor(ctx.Duration("keep-for"), ctx.IsSet("keep-for")).
+			WithCleanKeepBaseImages(ctx.Int("keep-base-images"), ctx.IsSet("keep-base-images"))
 
 		cfg, err := configBuilder.Build()
 		logger.Debug("clean-config", lager.Data{"currentConfig": cfg})
@@ -67,10 +103,14 @@ var CleanCommand = cli.Command{
 		imageCloner := imageClonerpkg.NewImageCloner(fsDriver, storePath)
 		metricsEmitter := metrics.NewEmitter()
 
-		locksmith := locksmithpkg.NewExclusiveFileSystem(storePath, metricsEmitter)
-		dependencyManager := dependency_manager.NewDependencyManager(
-			filepath.Join(storePath, storepkg.MetaDirName, "dependencies"),
-		)
+		sharedLocksmith := newSharedLocksmith(cfg, metricsEmitter)
+		exclusiveLocksmith := newExclusiveLocksmith(cfg, metricsEmitter)
+		metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+		if err != nil {
+			logger.Error("opening-metadata-db-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
 
 		storeNamespacer := groot.NewStoreNamespacer(storePath)
 		idMappings, err := storeNamespacer.Read()
@@ -82,10 +122,23 @@ var CleanCommand = cli.Command{
 		runner := linux_command_runner.New()
 		idMapper := unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
 		nsFsDriver := namespaced.New(fsDriver, idMappings, idMapper, runner)
-		sm := storepkg.NewStoreMeasurer(storePath, fsDriver)
-		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, dependencyManager)
+		sm := createStoreMeasurer(cfg, storePath, fsDriver)
+		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, metadataDB, exclusiveLocksmith).
+			WithRetentionPolicy(metadataDB, retentionPolicy(cfg.Clean))
 
-		cleaner := groot.IamCleaner(locksmith, sm, gc, metricsEmitter)
+		cleaner := groot.IamCleaner(sharedLocksmith, exclusiveLocksmith, sm, gc, metricsEmitter).
+			WithLowWatermark(cfg.Clean.LowWatermarkBytes)
+
+		if ctx.Bool("dry-run") {
+			noop, unusedVolumes, err := cleaner.DryRun(logger, cfg.Clean.ThresholdBytes, nil)
+			if err != nil {
+				logger.Error("finding-unused-resources", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			_ = json.NewEncoder(os.Stdout).Encode(newCleanReport(logger, !noop, unusedVolumes, sm, metadataDB))
+			return nil
+		}
 
 		defer func() {
 			unusedVols, err := gc.UnusedVolumes(logger, nil)
@@ -120,3 +173,35 @@ var CleanCommand = cli.Command{
 		return nil
 	},
 }
+
+func retentionPolicy(cleanCfg config.Clean) garbage_collector.RetentionPolicy {
+	return garbage_collector.RetentionPolicy{
+		KeepFor:        cleanCfg.KeepFor,
+		KeepBaseImages: cleanCfg.KeepBaseImages,
+	}
+}
+
+func newCleanReport(logger lager.Logger, thresholdReached bool, unusedVolumes []string, sm groot.StoreMeasurer, usageTracker garbage_collector.UsageTracker) cleanReport {
+	usage, err := usageTracker.Usage(logger)
+	if err != nil {
+		logger.Error("reading-volume-usage-failed", err)
+	}
+
+	report := cleanReport{ThresholdReached: thresholdReached, Layers: []cleanCandidate{}}
+	for _, volumeID := range unusedVolumes {
+		candidate := cleanCandidate{
+			ChainID:   volumeID,
+			SizeBytes: sm.CacheUsage(logger, []string{volumeID}),
+		}
+		if volumeUsage, ok := usage[volumeID]; ok {
+			lastUsed := volumeUsage.LastUsed
+			candidate.LastUsed = &lastUsed
+			candidate.BaseImage = volumeUsage.BaseImage
+		}
+
+		report.TotalBytes += candidate.SizeBytes
+		report.Layers = append(report.Layers, candidate)
+	}
+
+	return report
+}
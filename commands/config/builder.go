@@ -2,6 +2,8 @@ package config
 
 import (
 	"io/ioutil"
+	"regexp"
+	"time"
 
 	errorspkg "github.com/pkg/errors"
 
@@ -17,31 +19,77 @@ type Config struct {
 	MetronEndpoint string `yaml:"metron_endpoint"`
 	LogLevel       string `yaml:"log_level"`
 	LogFile        string `yaml:"log_file"`
+	Compression    string `yaml:"compression"`
 	Create         Create `yaml:"create"`
 	Clean          Clean  `yaml:"clean"`
 	Init           Init   `yaml:"-"`
+	Stats          Stats  `yaml:"-"`
+	// LockTimeout bounds how long commands wait for a store lock, zero waits
+	// forever
+	LockTimeout time.Duration `yaml:"lock_timeout"`
 }
 
 type Create struct {
-	ExcludeImageFromQuota             bool     `yaml:"exclude_image_from_quota"`
-	SkipLayerValidation               bool     `yaml:"skip_layer_validation"`
-	WithClean                         bool     `yaml:"with_clean"`
-	WithoutMount                      bool     `yaml:"without_mount"`
-	DiskLimitSizeBytes                int64    `yaml:"disk_limit_size_bytes"`
-	InsecureRegistries                []string `yaml:"insecure_registries"`
-	RemoteLayerClientCertificatesPath string   `yaml:"remote_layer_client_certificates_path"`
+	ExcludeImageFromQuota             bool         `yaml:"exclude_image_from_quota"`
+	SkipLayerValidation               bool         `yaml:"skip_layer_validation"`
+	WithClean                         bool         `yaml:"with_clean"`
+	WithoutMount                      bool         `yaml:"without_mount"`
+	DiskLimitSizeBytes                int64        `yaml:"disk_limit_size_bytes"`
+	InsecureRegistries                []string     `yaml:"insecure_registries"`
+	RemoteLayerClientCertificatesPath string       `yaml:"remote_layer_client_certificates_path"`
+	UnpackLimits                      UnpackLimits `yaml:"unpack_limits"`
+	Compression                       string       `yaml:"compression"`
+	// AutoMigrateStore runs the pending store migrations before creating,
+	// instead of failing until migrate-store is run
+	AutoMigrateStore bool `yaml:"auto_migrate_store"`
+}
+
+type UnpackLimits struct {
+	MaxLayerSizeBytes int64 `yaml:"max_layer_size_bytes"`
+	MaxImageSizeBytes int64 `yaml:"max_image_size_bytes"`
+	MaxEntries        int64 `yaml:"max_entries"`
+	MaxPathLength     int   `yaml:"max_path_length"`
+	MaxFileSizeBytes  int64 `yaml:"max_file_size_bytes"`
 }
 
 type Clean struct {
-	ThresholdBytes int64 `yaml:"threshold_bytes"`
+	// ThresholdBytes is the high watermark, the store usage clean starts at
+	ThresholdBytes         int64  `yaml:"threshold_bytes"`
+	Measurer               string `yaml:"measurer"`
+	EmptyTrashInBackground bool   `yaml:"empty_trash_in_background"`
+	// LowWatermarkBytes stops clean once the least recently used volumes it
+	// collected bring the store usage below it
+	LowWatermarkBytes int64         `yaml:"low_watermark_bytes"`
+	KeepFor           time.Duration `yaml:"keep_for"`
+	KeepBaseImages    int           `yaml:"keep_base_images"`
 }
 
+const (
+	// StatfsMeasurer measures the used bytes of the whole store filesystem
+	StatfsMeasurer = "statfs"
+	// QgroupMeasurer measures the bytes referenced by the store qgroups
+	QgroupMeasurer = "qgroup"
+)
+
 type Init struct {
-	StoreSizeBytes int64
-	OwnerUser      string
-	OwnerGroup     string
+	StoreSizeBytes  int64
+	CacheLimitBytes int64
+	OwnerUser       string
+	OwnerGroup      string
+	OverflowUID     int
+	OverflowGID     int
+}
+
+type Stats struct {
+	// CompressionStats walks every extent of the image to report its
+	// compressed usage
+	CompressionStats bool
 }
 
+// compressionRegexp matches the compression algorithms btrfs supports, with
+// the levels each of them accepts
+var compressionRegexp = regexp.MustCompile(`^(none|lzo|zlib(:[1-9])?|zstd(:([1-9]|1[0-5]))?)$`)
+
 type Builder struct {
 	config *Config
 }
@@ -73,6 +121,46 @@ func (b *Builder) Build() (Config, error) {
 		return *b.config, errorspkg.New("invalid argument: clean threshold cannot be negative")
 	}
 
+	if b.config.Init.OverflowUID < 0 || b.config.Init.OverflowGID < 0 {
+		return *b.config, errorspkg.New("invalid argument: overflow ids cannot be negative")
+	}
+
+	if b.config.Clean.LowWatermarkBytes < 0 || b.config.Clean.KeepFor < 0 || b.config.Clean.KeepBaseImages < 0 {
+		return *b.config, errorspkg.New("invalid argument: clean policies cannot be negative")
+	}
+
+	if b.config.Clean.ThresholdBytes > 0 && b.config.Clean.LowWatermarkBytes >= b.config.Clean.ThresholdBytes {
+		return *b.config, errorspkg.New("invalid argument: clean low watermark must be below the threshold")
+	}
+
+	switch b.config.Clean.Measurer {
+	case "", StatfsMeasurer, QgroupMeasurer:
+	default:
+		return *b.config, errorspkg.Errorf("invalid argument: unsupported measurer `%s`", b.config.Clean.Measurer)
+	}
+
+	if b.config.LockTimeout < 0 {
+		return *b.config, errorspkg.New("invalid argument: lock timeout cannot be negative")
+	}
+
+	if b.config.Init.CacheLimitBytes < 0 {
+		return *b.config, errorspkg.New("invalid argument: cache limit cannot be negative")
+	}
+
+	if b.config.Compression != "" && !compressionRegexp.MatchString(b.config.Compression) {
+		return *b.config, errorspkg.Errorf("invalid argument: unsupported compression `%s`", b.config.Compression)
+	}
+
+	if b.config.Create.Compression != "" && !compressionRegexp.MatchString(b.config.Create.Compression) {
+		return *b.config, errorspkg.Errorf("invalid argument: unsupported compression `%s`", b.config.Create.Compression)
+	}
+
+	limits := b.config.Create.UnpackLimits
+	if limits.MaxLayerSizeBytes < 0 || limits.MaxImageSizeBytes < 0 || limits.MaxEntries < 0 ||
+		limits.MaxPathLength < 0 || limits.MaxFileSizeBytes < 0 {
+		return *b.config, errorspkg.New("invalid argument: unpack limits cannot be negative")
+	}
+
 	return *b.config, nil
 }
 
@@ -130,6 +218,13 @@ func (b *Builder) WithMetronEndpoint(metronEndpoint string) *Builder {
 	return b
 }
 
+func (b *Builder) WithLockTimeout(timeout time.Duration, isSet bool) *Builder {
+	if isSet {
+		b.config.LockTimeout = timeout
+	}
+	return b
+}
+
 func (b *Builder) WithDiskLimitSizeBytes(limit int64, isSet bool) *Builder {
 	if isSet {
 		b.config.Create.DiskLimitSizeBytes = limit
@@ -151,6 +246,27 @@ func (b *Builder) WithSkipLayerValidation(skip, isSet bool) *Builder {
 	return b
 }
 
+func (b *Builder) WithAutoMigrateStore(autoMigrate, isSet bool) *Builder {
+	if isSet {
+		b.config.Create.AutoMigrateStore = autoMigrate
+	}
+	return b
+}
+
+func (b *Builder) WithCompression(compression string, isSet bool) *Builder {
+	if isSet {
+		b.config.Compression = compression
+	}
+	return b
+}
+
+func (b *Builder) WithImageCompression(compression string, isSet bool) *Builder {
+	if isSet {
+		b.config.Create.Compression = compression
+	}
+	return b
+}
+
 func (b *Builder) WithCleanThresholdBytes(threshold int64, isSet bool) *Builder {
 	if isSet {
 		b.config.Clean.ThresholdBytes = threshold
@@ -158,6 +274,34 @@ func (b *Builder) WithCleanThresholdBytes(threshold int64, isSet bool) *Builder
 	return b
 }
 
+func (b *Builder) WithCleanMeasurer(measurer string, isSet bool) *Builder {
+	if isSet {
+		b.config.Clean.Measurer = measurer
+	}
+	return b
+}
+
+func (b *Builder) WithCleanLowWatermarkBytes(lowWatermark int64, isSet bool) *Builder {
+	if isSet {
+		b.config.Clean.LowWatermarkBytes = lowWatermark
+	}
+	return b
+}
+
+func (b *Builder) WithCleanKeepFor(keepFor time.Duration, isSet bool) *Builder {
+	if isSet {
+		b.config.Clean.KeepFor = keepFor
+	}
+	return b
+}
+
+func (b *Builder) WithCleanKeepBaseImages(keepBaseImages int, isSet bool) *Builder {
+	if isSet {
+		b.config.Clean.KeepBaseImages = keepBaseImages
+	}
+	return b
+}
+
 func (b *Builder) WithLogLevel(level string, isSet bool) *Builder {
 	if isSet {
 		b.config.LogLevel = level
@@ -201,6 +345,36 @@ func (b *Builder) WithStoreSizeBytes(size int64) *Builder {
 	return b
 }
 
+func (b *Builder) WithCacheLimitBytes(limit int64) *Builder {
+	b.config.Init.CacheLimitBytes = limit
+	return b
+}
+
+func (b *Builder) WithOverflowUID(overflowUID int) *Builder {
+	b.config.Init.OverflowUID = overflowUID
+	return b
+}
+
+func (b *Builder) WithOverflowGID(overflowGID int) *Builder {
+	b.config.Init.OverflowGID = overflowGID
+	return b
+}
+
+func (b *Builder) WithOwnerUser(ownerUser string) *Builder {
+	b.config.Init.OwnerUser = ownerUser
+	return b
+}
+
+func (b *Builder) WithOwnerGroup(ownerGroup string) *Builder {
+	b.config.Init.OwnerGroup = ownerGroup
+	return b
+}
+
+func (b *Builder) WithCompressionStats(compressionStats bool) *Builder {
+	b.config.Stats.CompressionStats = compressionStats
+	return b
+}
+
 func load(configPath string) (Config, error) {
 	configContent, err := ioutil.ReadFile(configPath)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/SUSE/groot-btrfs/commands/config"
 	yaml "gopkg.in/yaml.v2"
@@ -47,6 +48,7 @@ var _ = Describe("Builder", func() {
 			MetronEndpoint: "config_endpoint:1111",
 			LogLevel:       "info",
 			LogFile:        "/path/to/a/file",
+			LockTimeout:    time.Minute,
 		}
 	})
 
@@ -98,6 +100,85 @@ var _ = Describe("Builder", func() {
 			})
 		})
 
+		Context("when a clean policy is negative", func() {
+			BeforeEach(func() {
+				cfg.Clean.KeepBaseImages = -1
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: clean policies cannot be negative"))
+			})
+		})
+
+		Context("when the lock timeout is negative", func() {
+			BeforeEach(func() {
+				cfg.LockTimeout = -time.Second
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: lock timeout cannot be negative"))
+			})
+		})
+
+		Context("when the clean low watermark is not below the threshold", func() {
+			BeforeEach(func() {
+				cfg.Clean.ThresholdBytes = 1000
+				cfg.Clean.LowWatermarkBytes = 1000
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: clean low watermark must be below the threshold"))
+			})
+		})
+
+		Context("when the clean keep-for duration is given", func() {
+			BeforeEach(func() {
+				cfg.Clean.KeepFor = 36 * time.Hour
+			})
+
+			It("reads it back", func() {
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Clean.KeepFor).To(Equal(36 * time.Hour))
+			})
+		})
+
+		Context("when an unpack limit is invalid", func() {
+			BeforeEach(func() {
+				cfg.Create.UnpackLimits.MaxEntries = int64(-1)
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: unpack limits cannot be negative"))
+			})
+		})
+
+		Context("when the compression is unsupported", func() {
+			BeforeEach(func() {
+				cfg.Compression = "zstd:16"
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: unsupported compression `zstd:16`"))
+			})
+		})
+
+		Context("when the image compression is unsupported", func() {
+			BeforeEach(func() {
+				cfg.Create.Compression = "lzo:3"
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: unsupported compression `lzo:3`"))
+			})
+		})
+
 		Context("when config is invalid", func() {
 			JustBeforeEach(func() {
 				configFilePath = path.Join(configDir, "invalid_config.yaml")
@@ -312,6 +393,24 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Describe("WithLockTimeout", func() {
+		It("overrides the config's LockTimeout entry when the flag is set", func() {
+			builder = builder.WithLockTimeout(time.Second, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.LockTimeout).To(Equal(time.Second))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithLockTimeout(time.Second, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.LockTimeout).To(Equal(cfg.LockTimeout))
+			})
+		})
+	})
+
 	Describe("WithDiskLimitSizeBytes", func() {
 		It("overrides the config's DiskLimitSizeBytes entry when flag is set", func() {
 			diskLimit := int64(3000)
@@ -376,6 +475,28 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Describe("WithAutoMigrateStore", func() {
+		BeforeEach(func() {
+			cfg.Create.AutoMigrateStore = true
+		})
+
+		It("overrides the config's AutoMigrateStore when the flag is set", func() {
+			builder = builder.WithAutoMigrateStore(false, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Create.AutoMigrateStore).To(BeFalse())
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithAutoMigrateStore(false, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.AutoMigrateStore).To(BeTrue())
+			})
+		})
+	})
+
 	Describe("WithCleanThresholdBytes", func() {
 		It("overrides the config's CleanThresholdBytes entry when the flag is set", func() {
 			builder = builder.WithCleanThresholdBytes(1024, true)
@@ -394,6 +515,134 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Describe("WithCleanLowWatermarkBytes", func() {
+		It("overrides the config's LowWatermarkBytes entry when the flag is set", func() {
+			builder = builder.WithCleanLowWatermarkBytes(512, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Clean.LowWatermarkBytes).To(Equal(int64(512)))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithCleanLowWatermarkBytes(512, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Clean.LowWatermarkBytes).To(Equal(cfg.Clean.LowWatermarkBytes))
+			})
+		})
+	})
+
+	Describe("WithCleanKeepFor", func() {
+		It("overrides the config's KeepFor entry when the flag is set", func() {
+			builder = builder.WithCleanKeepFor(time.Hour, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Clean.KeepFor).To(Equal(time.Hour))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithCleanKeepFor(time.Hour, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Clean.KeepFor).To(Equal(cfg.Clean.KeepFor))
+			})
+		})
+	})
+
+	Describe("WithCleanKeepBaseImages", func() {
+		It("overrides the config's KeepBaseImages entry when the flag is set", func() {
+			builder = builder.WithCleanKeepBaseImages(3, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Clean.KeepBaseImages).To(Equal(3))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithCleanKeepBaseImages(3, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Clean.KeepBaseImages).To(Equal(cfg.Clean.KeepBaseImages))
+			})
+		})
+	})
+
+	Describe("WithCleanMeasurer", func() {
+		BeforeEach(func() {
+			cfg.Clean.Measurer = "statfs"
+		})
+
+		It("overrides the config's Measurer entry when the flag is set", func() {
+			builder = builder.WithCleanMeasurer("qgroup", true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Clean.Measurer).To(Equal("qgroup"))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithCleanMeasurer("qgroup", false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Clean.Measurer).To(Equal("statfs"))
+			})
+		})
+
+		Context("when the measurer is not supported", func() {
+			It("returns an error", func() {
+				builder = builder.WithCleanMeasurer("du", true)
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: unsupported measurer `du`"))
+			})
+		})
+	})
+
+	Describe("WithCompression", func() {
+		BeforeEach(func() {
+			cfg.Compression = "zlib"
+		})
+
+		It("overrides the config's Compression entry when the flag is set", func() {
+			builder = builder.WithCompression("zstd:3", true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Compression).To(Equal("zstd:3"))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithCompression("zstd:3", false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Compression).To(Equal("zlib"))
+			})
+		})
+	})
+
+	Describe("WithImageCompression", func() {
+		BeforeEach(func() {
+			cfg.Create.Compression = "lzo"
+		})
+
+		It("overrides the config's create Compression entry when the flag is set", func() {
+			builder = builder.WithImageCompression("none", true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Create.Compression).To(Equal("none"))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithImageCompression("none", false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.Compression).To(Equal("lzo"))
+			})
+		})
+	})
+
 	Describe("WithLogLevel", func() {
 		It("overrides the config's Log Level entry", func() {
 			builder = builder.WithLogLevel("debug", true)
@@ -520,5 +769,83 @@ var _ = Describe("Builder", func() {
 				Expect(config.Init.StoreSizeBytes).To(Equal(int64(1024)))
 			})
 		})
+
+		Describe("WithCacheLimitBytes", func() {
+			It("sets the correct config value", func() {
+				builder = builder.WithCacheLimitBytes(2048)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Init.CacheLimitBytes).To(Equal(int64(2048)))
+			})
+
+			Context("when negative", func() {
+				It("returns an error", func() {
+					builder = builder.WithCacheLimitBytes(-1)
+					_, err := builder.Build()
+					Expect(err).To(MatchError("invalid argument: cache limit cannot be negative"))
+				})
+			})
+		})
+
+		Describe("WithOverflowUID", func() {
+			It("sets the init overflow uid", func() {
+				builder = builder.WithOverflowUID(0)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Init.OverflowUID).To(Equal(0))
+			})
+
+			Context("when negative", func() {
+				It("returns an error", func() {
+					builder = builder.WithOverflowUID(-1)
+					_, err := builder.Build()
+					Expect(err).To(MatchError("invalid argument: overflow ids cannot be negative"))
+				})
+			})
+		})
+
+		Describe("WithCompressionStats", func() {
+			It("sets the stats compression stats", func() {
+				builder = builder.WithCompressionStats(true)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Stats.CompressionStats).To(BeTrue())
+			})
+		})
+
+		Describe("WithOverflowGID", func() {
+			It("sets the init overflow gid", func() {
+				builder = builder.WithOverflowGID(1010)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Init.OverflowGID).To(Equal(1010))
+			})
+
+			Context("when negative", func() {
+				It("returns an error", func() {
+					builder = builder.WithOverflowGID(-1)
+					_, err := builder.Build()
+					Expect(err).To(MatchError("invalid argument: overflow ids cannot be negative"))
+				})
+			})
+		})
+
+		Describe("WithOwnerUser", func() {
+			It("sets the init owner user", func() {
+				builder = builder.WithOwnerUser("vcap")
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Init.OwnerUser).To(Equal("vcap"))
+			})
+		})
+
+		Describe("WithOwnerGroup", func() {
+			It("sets the init owner group", func() {
+				builder = builder.WithOwnerGroup("vcap")
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Init.OwnerGroup).To(Equal("vcap"))
+			})
+		})
 	})
 })
@@ -20,13 +20,11 @@ import (
 	"github.com/SUSE/groot-btrfs/fetcher/tar_fetcher"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
-	storepkg "github.com/SUSE/groot-btrfs/store"
-	"github.com/SUSE/groot-btrfs/store/dependency_manager"
 	"github.com/SUSE/groot-btrfs/store/filesystems/namespaced"
 	"github.com/SUSE/groot-btrfs/store/garbage_collector"
 	"github.com/SUSE/groot-btrfs/store/image_cloner"
-	locksmithpkg "github.com/SUSE/groot-btrfs/store/locksmith"
 	"github.com/SUSE/groot-btrfs/store/manager"
+	"github.com/SUSE/groot-btrfs/store/migrator"
 
 	"github.com/containers/image/types"
 	"github.com/docker/distribution/registry/api/errcode"
@@ -57,6 +55,10 @@ var CreateCommand = cli.Command{
 			Name:  "skip-layer-validation",
 			Usage: "Do not validate checksums of image layers. (Can only be used with oci:/// protocol images.)",
 		},
+		cli.BoolFlag{
+			Name:  "auto-migrate-store",
+			Usage: "Run the pending store migrations instead of failing",
+		},
 		cli.BoolFlag{
 			Name:  "with-clean",
 			Usage: "Clean up unused layers before creating rootfs",
@@ -69,6 +71,10 @@ var CreateCommand = cli.Command{
 			Name:  "threshold-bytes",
 			Usage: "Disk usage of the store directory at which cleanup should trigger",
 		},
+		cli.StringFlag{
+			Name:  "measurer",
+			Usage: "How to measure the store usage for the threshold: `statfs` or `qgroup`",
+		},
 		cli.BoolFlag{
 			Name:  "with-mount",
 			Usage: "Mount the root filesystem after creation. This may require root privileges.",
@@ -77,6 +83,10 @@ var CreateCommand = cli.Command{
 			Name:  "without-mount",
 			Usage: "Do not mount the root filesystem.",
 		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "Compression of the files written to the image: none, zlib, lzo or zstd",
+		},
 		cli.StringFlag{
 			Name:  "username",
 			Usage: "Username to authenticate in image registry",
@@ -105,9 +115,13 @@ var CreateCommand = cli.Command{
 				ctx.IsSet("exclude-image-from-quota")).
 			WithSkipLayerValidation(ctx.Bool("skip-layer-validation"),
 				ctx.IsSet("skip-layer-validation")).
+			WithAutoMigrateStore(ctx.Bool("auto-migrate-store"),
+				ctx.IsSet("auto-migrate-store")).
 			WithCleanThresholdBytes(ctx.Int64("threshold-bytes"), ctx.IsSet("threshold-bytes")).
+			WithCleanMeasurer(ctx.String("measurer"), ctx.IsSet("measurer")).
 			WithClean(ctx.IsSet("with-clean"), ctx.IsSet("without-clean")).
-			WithMount(ctx.IsSet("with-mount"), ctx.IsSet("without-mount"))
+			WithMount(ctx.IsSet("with-mount"), ctx.IsSet("without-mount")).
+			WithImageCompression(ctx.String("compression"), ctx.IsSet("compression"))
 
 		cfg, err := configBuilder.Build()
 		logger.Debug("create-config", lager.Data{"currentConfig": cfg})
@@ -135,8 +149,8 @@ var CreateCommand = cli.Command{
 		}
 
 		metricsEmitter := metrics.NewEmitter()
-		sharedLocksmith := locksmithpkg.NewSharedFileSystem(storePath, metricsEmitter)
-		exclusiveLocksmith := locksmithpkg.NewExclusiveFileSystem(storePath, metricsEmitter)
+		sharedLocksmith := newSharedLocksmith(cfg, metricsEmitter)
+		exclusiveLocksmith := newExclusiveLocksmith(cfg, metricsEmitter)
 		imageCloner := image_cloner.NewImageCloner(fsDriver, storePath)
 
 		storeNamespacer := groot.NewStoreNamespacer(storePath)
@@ -146,6 +160,11 @@ var CreateCommand = cli.Command{
 			return newExitError("Store path is not initialized. Please run init-store.", 1)
 		}
 
+		if err = migrateStore(logger, cfg, exclusiveLocksmith, fsDriver); err != nil {
+			logger.Error("store-migration-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
 		idMappings, err := storeNamespacer.Read()
 		if err != nil {
 			logger.Error("reading-namespace-file", err)
@@ -170,9 +189,12 @@ var CreateCommand = cli.Command{
 			unpacker = unpackerpkg.NewNSIdMapperUnpacker(runner, idMapper, unpackerStrategy)
 		}
 
-		dependencyManager := dependency_manager.NewDependencyManager(
-			filepath.Join(storePath, storepkg.MetaDirName, "dependencies"),
-		)
+		metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+		if err != nil {
+			logger.Error("opening-metadata-db-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
 
 		nsFsDriver := namespaced.New(fsDriver, idMappings, idMapper, runner)
 
@@ -186,9 +208,11 @@ var CreateCommand = cli.Command{
 			exclusiveLocksmith,
 		)
 
-		sm := storepkg.NewStoreMeasurer(storePath, fsDriver)
-		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, dependencyManager)
-		cleaner := groot.IamCleaner(exclusiveLocksmith, sm, gc, metricsEmitter)
+		sm := createStoreMeasurer(cfg, storePath, fsDriver)
+		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, metadataDB, exclusiveLocksmith).
+			WithRetentionPolicy(metadataDB, retentionPolicy(cfg.Clean))
+		cleaner := groot.IamCleaner(sharedLocksmith, exclusiveLocksmith, sm, gc, metricsEmitter).
+			WithLowWatermark(cfg.Clean.LowWatermarkBytes)
 
 		defer func() {
 			unusedVols, err := gc.UnusedVolumes(logger, nil)
@@ -200,8 +224,8 @@ var CreateCommand = cli.Command{
 		}()
 
 		creator := groot.IamCreator(
-			imageCloner, baseImagePuller, sharedLocksmith,
-			dependencyManager, metricsEmitter, cleaner,
+			imageCloner, metadataReleasingPuller{baseImagePuller, metadataDB}, sharedLocksmith,
+			metadataDB, metricsEmitter, cleaner,
 		)
 
 		createSpec := groot.CreateSpec{
@@ -212,8 +236,18 @@ var CreateCommand = cli.Command{
 			ExcludeBaseImageFromQuota:   cfg.Create.ExcludeImageFromQuota,
 			UIDMappings:                 idMappings.UIDMappings,
 			GIDMappings:                 idMappings.GIDMappings,
+			OverflowUID:                 idMappings.OverflowUID,
+			OverflowGID:                 idMappings.OverflowGID,
 			CleanOnCreate:               cfg.Create.WithClean,
 			CleanOnCreateThresholdBytes: cfg.Clean.ThresholdBytes,
+			Compression:                 cfg.Create.Compression,
+			UnpackLimits: groot.UnpackLimits{
+				MaxLayerBytes: cfg.Create.UnpackLimits.MaxLayerSizeBytes,
+				MaxImageBytes: cfg.Create.UnpackLimits.MaxImageSizeBytes,
+				MaxEntries:    cfg.Create.UnpackLimits.MaxEntries,
+				MaxPathLength: cfg.Create.UnpackLimits.MaxPathLength,
+				MaxFileBytes:  cfg.Create.UnpackLimits.MaxFileSizeBytes,
+			},
 		}
 		image, err := creator.Create(logger, createSpec)
 		if err != nil {
@@ -356,3 +390,15 @@ func validateOptions(ctx *cli.Context, cfg config.Config) error {
 
 	return nil
 }
+
+// migrateStore makes sure the store is at the version this build uses,
+// running the pending migrations only when asked to.
+func migrateStore(logger lager.Logger, cfg config.Config, locksmith groot.Locksmith, volumeDriver migrator.VolumeDriver) error {
+	storeMigrator := migrator.NewMigrator(cfg.StorePath, locksmith, migrator.Migrations(volumeDriver))
+	if cfg.Create.AutoMigrateStore {
+		_, err := storeMigrator.Migrate(logger)
+		return err
+	}
+
+	return requireMigratedStore(logger, storeMigrator)
+}
@@ -2,15 +2,12 @@ package commands // import "github.com/SUSE/groot-btrfs/commands"
 
 import (
 	"fmt"
-	"path/filepath"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/SUSE/groot-btrfs/commands/config"
 	"github.com/SUSE/groot-btrfs/commands/idfinder"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
-	"github.com/SUSE/groot-btrfs/store"
-	"github.com/SUSE/groot-btrfs/store/dependency_manager"
 	"github.com/SUSE/groot-btrfs/store/garbage_collector"
 	"github.com/SUSE/groot-btrfs/store/image_cloner"
 	errorspkg "github.com/pkg/errors"
@@ -62,14 +59,17 @@ var DeleteCommand = cli.Command{
 		}
 
 		imageCloner := image_cloner.NewImageCloner(imageDriver, storePath)
-		dependencyManager := dependency_manager.NewDependencyManager(
-			filepath.Join(storePath, store.MetaDirName, "dependencies"),
-		)
+		metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+		if err != nil {
+			logger.Error("opening-metadata-db-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
 		metricsEmitter := metrics.NewEmitter()
-		deleter := groot.IamDeleter(imageCloner, dependencyManager, metricsEmitter)
+		deleter := groot.IamDeleter(imageCloner, metadataDB, metricsEmitter)
 
-		sm := store.NewStoreMeasurer(storePath, fsDriver)
-		gc := garbage_collector.NewGC(fsDriver, imageCloner, dependencyManager)
+		sm := createStoreMeasurer(cfg, storePath, fsDriver)
+		gc := garbage_collector.NewGC(fsDriver, imageCloner, metadataDB, newExclusiveLocksmith(cfg, metricsEmitter))
 
 		defer func() {
 			unusedVols, err := gc.UnusedVolumes(logger, nil)
@@ -86,6 +86,10 @@ var DeleteCommand = cli.Command{
 			return newExitError(err.Error(), 1)
 		}
 
+		if cfg.Clean.EmptyTrashInBackground {
+			startTrashWorker(ctx, logger)
+		}
+
 		fmt.Printf("Image %s deleted\n", id)
 		metricsEmitter.TryIncrementRunCount("delete", nil)
 		return nil
@@ -1,10 +1,15 @@
 package commands // import "github.com/SUSE/groot-btrfs/commands"
 
 import (
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
 	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	unpackerpkg "github.com/SUSE/groot-btrfs/base_image_puller/unpacker"
 	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
-	"github.com/SUSE/groot-btrfs/store/locksmith"
+	"github.com/SUSE/groot-btrfs/store/filesystems/namespaced"
+	"github.com/SUSE/groot-btrfs/store/image_cloner"
 	"github.com/SUSE/groot-btrfs/store/manager"
 	"github.com/urfave/cli"
 )
@@ -33,10 +38,28 @@ var DeleteStoreCommand = cli.Command{
 		}
 
 		storePath := cfg.StorePath
-		locksmith := locksmith.NewSharedFileSystem(storePath, metrics.NewEmitter())
-		manager := manager.New(storePath, nil, fsDriver, fsDriver, fsDriver)
+		locksmith := newSharedLocksmith(cfg, metrics.NewEmitter())
 
-		if err := manager.DeleteStore(logger, locksmith); err != nil {
+		var (
+			volumeDriver base_image_puller.VolumeDriver = fsDriver
+			imageDriver  image_cloner.ImageDriver       = fsDriver
+			storeDriver  manager.StoreDriver            = fsDriver
+		)
+		// a store that cannot tell its mappings is deleted as it is, it may
+		// not even have been initialized completely
+		if idMappings, err := groot.NewStoreNamespacer(storePath).Read(); err != nil {
+			logger.Debug("reading-namespace-file-failed", lager.Data{"error": err.Error()})
+		} else {
+			runner := linux_command_runner.New()
+			idMapper := unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
+			nsFsDriver := namespaced.New(fsDriver, idMappings, idMapper, runner)
+			volumeDriver, imageDriver = nsFsDriver, nsFsDriver
+			storeDriver = namespacedStoreDriver{fileSystemDriver: fsDriver, nsFsDriver: nsFsDriver}
+		}
+		manager := manager.New(storePath, nil, volumeDriver, imageDriver, storeDriver)
+
+		trashLocksmith := newExclusiveLocksmith(cfg, metrics.NewEmitter())
+		if err := manager.DeleteStore(logger, locksmith, trashLocksmith); err != nil {
 			logger.Error("cleaning-up-store-failed", err)
 			return cli.NewExitError(err.Error(), 1)
 		}
@@ -0,0 +1,92 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/lager"
+
+	unpackerpkg "github.com/SUSE/groot-btrfs/base_image_puller/unpacker"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/filesystems/namespaced"
+	errorspkg "github.com/pkg/errors"
+
+	"github.com/urfave/cli"
+)
+
+var EmptyTrashCommand = cli.Command{
+	Name:        "empty-trash",
+	Usage:       "empty-trash",
+	Description: "Destroys the images and volumes that were deleted but still take space",
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("empty-trash")
+		newExitError := newErrorHandler(logger, "empty-trash")
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("empty-trash-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if _, err = os.Stat(storePath); os.IsNotExist(err) {
+			err = errorspkg.Errorf("no store found at %s", storePath)
+			logger.Error("store-path-failed", err, nil)
+			return newExitError(err.Error(), 0)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		storeNamespacer := groot.NewStoreNamespacer(storePath)
+		idMappings, err := storeNamespacer.Read()
+		if err != nil {
+			logger.Error("reading-namespace-file", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		runner := linux_command_runner.New()
+		idMapper := unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
+		nsFsDriver := namespaced.New(fsDriver, idMappings, idMapper, runner)
+
+		locksmith := newExclusiveLocksmith(cfg, metrics.NewEmitter())
+		lockFile, err := locksmith.Lock(groot.TrashLockKey)
+		if err != nil {
+			logger.Error("locking-trash-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer locksmith.Unlock(lockFile)
+
+		if err := nsFsDriver.EmptyTrash(logger); err != nil {
+			logger.Error("emptying-trash-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		fmt.Println("trash emptied")
+		metrics.NewEmitter().TryIncrementRunCount("empty-trash", nil)
+		return nil
+	},
+}
+
+// startTrashWorker empties the trash in a detached grootfs process, so that
+// the command that filled it does not wait for btrfs to destroy the
+// subvolumes.
+func startTrashWorker(ctx *cli.Context, logger lager.Logger) {
+	logger = logger.Session("starting-trash-worker")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if _, err := startDetached(ctx, EmptyTrashCommand.Name); err != nil {
+		logger.Error("starting-worker-failed", err)
+	}
+}
@@ -0,0 +1,114 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/fsck"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var FsckCommand = cli.Command{
+	Name:  "fsck",
+	Usage: "fsck [--repair] [--rehash] [--json]",
+	Description: "Cross-checks the volumes, images, dependencies and volume metadata of the store and reports what is inconsistent, " +
+		"including volumes that are no longer read-only. " +
+		"With --rehash the contents of every volume are compared with the digest recorded by an earlier fsck --rehash --repair.",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "repair",
+			Usage: "Apply the repair action of every finding",
+		},
+		cli.BoolFlag{
+			Name:  "rehash",
+			Usage: "Re-hash the contents of every volume",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the findings as JSON",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("fsck")
+		newExitError := newErrorHandler(logger, "fsck")
+
+		if ctx.NArg() != 0 {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return newExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("fsck-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if _, err = os.Stat(storePath); os.IsNotExist(err) {
+			err = errorspkg.Errorf("no store found at %s", storePath)
+			logger.Error("store-path-failed", err, nil)
+			return newExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter := metrics.NewEmitter()
+		locksmith := newExclusiveLocksmith(cfg, metricsEmitter)
+		metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+		if err != nil {
+			logger.Error("opening-metadata-db-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
+		checker := fsck.NewChecker(storePath, fsDriver, fsDriver, metadataDB, locksmith)
+
+		report, err := checker.Check(logger, fsck.Options{
+			Repair: ctx.Bool("repair"),
+			Rehash: ctx.Bool("rehash"),
+		})
+		if err != nil {
+			logger.Error("checking-store-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		if ctx.Bool("json") {
+			_ = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			for _, finding := range report.Findings {
+				fmt.Println(formatFinding(finding))
+			}
+		}
+
+		if report.HasErrors() {
+			return newExitError("store has inconsistencies", 1)
+		}
+
+		metricsEmitter.TryIncrementRunCount("fsck", nil)
+		return nil
+	},
+}
+
+func formatFinding(finding fsck.Finding) string {
+	outcome := fmt.Sprintf("repair: %s", finding.Repair)
+	if finding.Repaired {
+		outcome = fmt.Sprintf("repaired: %s", finding.Repair)
+	} else if finding.RepairError != "" {
+		outcome = fmt.Sprintf("repair failed: %s: %s", finding.Repair, finding.RepairError)
+	}
+
+	return fmt.Sprintf("%s %s %s: %s (%s)", finding.Severity, finding.Kind, finding.Subject, finding.Message, outcome)
+}
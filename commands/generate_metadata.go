@@ -2,17 +2,17 @@ package commands // import "github.com/SUSE/groot-btrfs/commands"
 
 import (
 	"fmt"
-	"os"
 
 	"code.cloudfoundry.org/lager"
-	"github.com/SUSE/groot-btrfs/base_image_puller"
 	"github.com/SUSE/groot-btrfs/commands/config"
-	"github.com/SUSE/groot-btrfs/store/filesystems"
+	"github.com/SUSE/groot-btrfs/store/migrator"
 
 	errorspkg "github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
+// GenerateVolumeSizeMetadata is kept for the scripts that call it, migrate-store
+// runs the same migration.
 var GenerateVolumeSizeMetadata = cli.Command{
 	Name:   "generate-volume-size-metadata",
 	Hidden: true,
@@ -37,33 +37,6 @@ var GenerateVolumeSizeMetadata = cli.Command{
 			return err
 		}
 
-		volumes, err := driver.Volumes(logger)
-		if err != nil {
-			return err
-		}
-
-		for _, volumeID := range volumes {
-			_, err := driver.VolumeSize(logger, volumeID)
-			if os.IsNotExist(errorspkg.Cause(err)) {
-				logger.Info("volume-meta-missing", lager.Data{"volumeID": volumeID})
-
-				volumePath, err := driver.VolumePath(logger, volumeID)
-				if err != nil {
-					return err
-				}
-
-				size, err := filesystems.CalculatePathSize(logger, volumePath)
-				if err != nil {
-					return err
-				}
-
-				err = driver.WriteVolumeMeta(logger, volumeID, base_image_puller.VolumeMeta{Size: size})
-				if err != nil {
-					return err
-				}
-			}
-		}
-
-		return nil
+		return migrator.GenerateVolumeSizeMetadata(logger, driver)
 	},
 }
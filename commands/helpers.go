@@ -3,19 +3,30 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/SUSE/groot-btrfs/base_image_puller"
 	"github.com/SUSE/groot-btrfs/commands/config"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
+	storepkg "github.com/SUSE/groot-btrfs/store"
 	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs"
+	"github.com/SUSE/groot-btrfs/store/filesystems/namespaced"
 	"github.com/SUSE/groot-btrfs/store/image_cloner"
+	"github.com/SUSE/groot-btrfs/store/locksmith"
+	"github.com/SUSE/groot-btrfs/store/metadata_db"
+	"github.com/SUSE/groot-btrfs/store/migrator"
 	"github.com/opencontainers/runc/libcontainer/user"
+	errorspkg "github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
@@ -26,20 +37,90 @@ type fileSystemDriver interface {
 	ConfigureStore(logger lager.Logger, storePath string, ownerUID, ownerGID int) error
 	ValidateFileSystem(logger lager.Logger, path string) error
 	InitFilesystem(logger lager.Logger, filesystemPath, storePath string) error
+	InitQuotaGroups(logger lager.Logger, cacheLimitBytes int64) error
+	AssignStoreQgroups(logger lager.Logger) error
+	ResizeFilesystem(logger lager.Logger, sizeBytes int64) error
+	AllocatedBytes(logger lager.Logger) (int64, error)
+	StoreStats(logger lager.Logger) (groot.StoreStats, error)
+	VolumeUsage(logger lager.Logger, id string) (groot.DiskUsage, error)
 	VolumePath(logger lager.Logger, id string) (string, error)
 	Volumes(logger lager.Logger) ([]string, error)
 	VolumeSize(lager.Logger, string) (int64, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+	ImageHasSubvolume(logger lager.Logger, imagePath string) (bool, error)
 	CreateVolume(logger lager.Logger, parentID, id string) (string, error)
 	DestroyVolume(logger lager.Logger, id string) error
+	EmptyTrash(logger lager.Logger) error
+	SendVolume(logger lager.Logger, id, parentID string, stream io.Writer) error
+	ReceiveVolume(logger lager.Logger, id string, stream io.Reader) error
+	VolumeUUIDs(logger lager.Logger, id string) (string, string, error)
+	TrashStats(logger lager.Logger) (groot.TrashStats, error)
+	Scrub(logger lager.Logger) (groot.ScrubResult, error)
+	Balance(logger lager.Logger, usageFilter int) (groot.BalanceResult, error)
+	QuotaRescan(logger lager.Logger) (groot.QuotaRescanResult, error)
+	MaintenanceStatus(logger lager.Logger) (groot.MaintenanceReport, error)
 	MoveVolume(logger lager.Logger, from, to string) error
 	WriteVolumeMeta(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error
 	HandleOpaqueWhiteouts(logger lager.Logger, id string, opaqueWhiteouts []string) error
+	SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error
+	IsVolumeReadOnly(logger lager.Logger, id string) (bool, error)
 	Marshal(logger lager.Logger) ([]byte, error)
 }
 
+// namespacedStoreDriver empties the trash from the user namespace of the
+// store, which rootless stores need to destroy their subvolumes.
+type namespacedStoreDriver struct {
+	fileSystemDriver
+	nsFsDriver *namespaced.Driver
+}
+
+func (d namespacedStoreDriver) EmptyTrash(logger lager.Logger) error {
+	return d.nsFsDriver.EmptyTrash(logger)
+}
+
 func createFileSystemDriver(cfg config.Config) (fileSystemDriver, error) {
 	return btrfs.NewDriver(filepath.Join(cfg.BtrfsProgsPath, "btrfs"),
-		filepath.Join(cfg.BtrfsProgsPath, "mkfs.btrfs"), cfg.DraxBin, cfg.StorePath), nil
+		filepath.Join(cfg.BtrfsProgsPath, "mkfs.btrfs"), cfg.DraxBin, cfg.StorePath).
+		WithCompression(cfg.Compression).
+		WithCompressionStats(cfg.Stats.CompressionStats), nil
+}
+
+func openMetadataDB(logger lager.Logger, storePath string, fsDriver fileSystemDriver) (*metadata_db.MetadataDB, error) {
+	return metadata_db.Open(logger, filepath.Join(storePath, storepkg.MetaDirName), fsDriver)
+}
+
+func closeMetadataDB(logger lager.Logger, metadataDB *metadata_db.MetadataDB) {
+	if err := metadataDB.Close(); err != nil {
+		logger.Error("closing-metadata-db-failed", err)
+	}
+}
+
+// metadataReleasingPuller closes the metadata database before pulling, so
+// that other creates can register their images while the layers download.
+type metadataReleasingPuller struct {
+	groot.BaseImagePuller
+	metadataDB *metadata_db.MetadataDB
+}
+
+func (p metadataReleasingPuller) Pull(logger lager.Logger, imageInfo groot.BaseImageInfo, spec groot.BaseImageSpec) error {
+	closeMetadataDB(logger, p.metadataDB)
+	return p.BaseImagePuller.Pull(logger, imageInfo, spec)
+}
+
+func newSharedLocksmith(cfg config.Config, metricsEmitter groot.MetricsEmitter) *locksmith.FileSystem {
+	return locksmith.NewSharedFileSystem(cfg.StorePath, metricsEmitter).WithTimeout(cfg.LockTimeout)
+}
+
+func newExclusiveLocksmith(cfg config.Config, metricsEmitter groot.MetricsEmitter) *locksmith.FileSystem {
+	return locksmith.NewExclusiveFileSystem(cfg.StorePath, metricsEmitter).WithTimeout(cfg.LockTimeout)
+}
+
+func createStoreMeasurer(cfg config.Config, storePath string, fsDriver fileSystemDriver) groot.StoreMeasurer {
+	if cfg.Clean.Measurer == config.QgroupMeasurer {
+		return groot.NewQgroupMeasurer(fsDriver)
+	}
+
+	return storepkg.NewStoreMeasurer(storePath, fsDriver)
 }
 
 func createImageDriver(cfg config.Config, fsDriver fileSystemDriver) (image_cloner.ImageDriver, error) {
@@ -79,33 +160,55 @@ func readSubGIDMapping(groupname string) ([]groot.IDMappingSpec, error) {
 	return readSubIDMapping(groupname, group.Gid, "/etc/subgid")
 }
 
+// readSubIDMapping maps root to the given id and lays out every subordinate
+// range of name (or of its numeric id) one after the other in the namespace,
+// starting at 1. The ranges are laid out in host ID order, so the mappings
+// don't depend on the order of the entries in the file.
 func readSubIDMapping(name string, id int, subidPath string) ([]groot.IDMappingSpec, error) {
-	mappings := []groot.IDMappingSpec{{
-		HostID: id, NamespaceID: 0, Size: 1,
-	}}
-
 	contents, err := ioutil.ReadFile(subidPath)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, line := range strings.Fields(string(contents)) {
+	ranges := []groot.IDMappingSpec{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
 		entry := strings.Split(line, ":")
-		if entry[0] == name {
-			hostID, err := strconv.Atoi(entry[1])
-			if err != nil {
-				return nil, err
-			}
-			size, err := strconv.Atoi(entry[2])
-			if err != nil {
-				return nil, err
-			}
-			mappings = append(mappings, groot.IDMappingSpec{
-				HostID:      hostID,
-				NamespaceID: 1,
-				Size:        size,
-			})
+		if len(entry) != 3 {
+			return nil, fmt.Errorf("invalid entry `%s` in %s", line, subidPath)
+		}
+
+		if entry[0] != name && entry[0] != strconv.Itoa(id) {
+			continue
+		}
+
+		hostID, err := strconv.Atoi(entry[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry `%s` in %s: %s", line, subidPath, err)
 		}
+		size, err := strconv.Atoi(entry[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry `%s` in %s: %s", line, subidPath, err)
+		}
+		ranges = append(ranges, groot.IDMappingSpec{HostID: hostID, Size: size})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].HostID < ranges[j].HostID
+	})
+
+	mappings := []groot.IDMappingSpec{{
+		HostID: id, NamespaceID: 0, Size: 1,
+	}}
+	namespaceID := 1
+	for _, subIDRange := range ranges {
+		subIDRange.NamespaceID = namespaceID
+		mappings = append(mappings, subIDRange)
+		namespaceID += subIDRange.Size
 	}
 
 	return mappings, nil
@@ -123,3 +226,43 @@ func newErrorHandler(logger lager.Logger, action string) exitErrorFunc {
 		return cli.NewExitError(message, exitCode)
 	}
 }
+
+// startDetached runs a grootfs command with the global flags of ctx in a new
+// session, so that it outlives the current process, and returns its PID.
+func startDetached(ctx *cli.Context, commandArgs ...string) (int, error) {
+	grootfsBin, err := os.Executable()
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "finding executable")
+	}
+
+	args := []string{}
+	for _, name := range ctx.GlobalFlagNames() {
+		if ctx.GlobalIsSet(name) {
+			args = append(args, fmt.Sprintf("--%s", name), ctx.GlobalString(name))
+		}
+	}
+	args = append(args, commandArgs...)
+
+	cmd := exec.Command(grootfsBin, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, errorspkg.Wrapf(err, "starting `%s`", strings.Join(cmd.Args, " "))
+	}
+
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// requireMigratedStore fails when the store, including one from before the
+// layout was versioned, still needs migrations.
+func requireMigratedStore(logger lager.Logger, storeMigrator *migrator.Migrator) error {
+	pending, err := storeMigrator.Pending(logger)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return errorspkg.Errorf("store needs %d migration(s), please run migrate-store", len(pending))
+	}
+
+	return nil
+}
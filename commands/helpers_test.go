@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SUSE/groot-btrfs/groot"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readSubIDMapping", func() {
+	var subidPath string
+
+	BeforeEach(func() {
+		tmpDir, err := ioutil.TempDir("", "subid")
+		Expect(err).NotTo(HaveOccurred())
+		subidPath = filepath.Join(tmpDir, "subuid")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filepath.Dir(subidPath))).To(Succeed())
+	})
+
+	entries := []struct {
+		description string
+		contents    string
+		mappings    []groot.IDMappingSpec
+		err         string
+	}{
+		{
+			description: "lays out several ranges of the user one after the other",
+			contents:    "vcap:100000:65536\nother:300000:65536\nvcap:500000:1000\n",
+			mappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65536},
+				{HostID: 500000, NamespaceID: 65537, Size: 1000},
+			},
+		},
+		{
+			description: "picks the ranges of a user that only appears by id",
+			contents:    "1000:100000:65536\n",
+			mappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65536},
+			},
+		},
+		{
+			description: "lays out the ranges of an unsorted file in host id order",
+			contents:    "vcap:500000:1000\nvcap:100000:65536\n",
+			mappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65536},
+				{HostID: 500000, NamespaceID: 65537, Size: 1000},
+			},
+		},
+		{
+			description: "skips comments and empty lines",
+			contents:    "# subordinate ids\n\nvcap:100000:65536\n",
+			mappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65536},
+			},
+		},
+		{
+			description: "only maps root when the user has no ranges",
+			contents:    "other:100000:65536\n",
+			mappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 0, Size: 1},
+			},
+		},
+		{
+			description: "fails on a line without three fields",
+			contents:    "vcap:100000:65536\nvcap:200000\n",
+			err:         "invalid entry `vcap:200000`",
+		},
+		{
+			description: "fails on a line with a non numeric field",
+			contents:    "vcap:lots:65536\n",
+			err:         "invalid entry `vcap:lots:65536`",
+		},
+	}
+
+	for _, entry := range entries {
+		entry := entry
+
+		It(entry.description, func() {
+			Expect(ioutil.WriteFile(subidPath, []byte(entry.contents), 0644)).To(Succeed())
+
+			mappings, err := readSubIDMapping("vcap", 1000, subidPath)
+			if entry.err != "" {
+				Expect(err).To(MatchError(ContainSubstring(entry.err)))
+				return
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mappings).To(Equal(entry.mappings))
+		})
+	}
+
+	Context("when the file doesn't exist", func() {
+		It("returns an error", func() {
+			_, err := readSubIDMapping("vcap", 1000, subidPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
 	"code.cloudfoundry.org/lager"
+	unpackerpkg "github.com/SUSE/groot-btrfs/base_image_puller/unpacker"
 	"github.com/SUSE/groot-btrfs/commands/config"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/store/manager"
@@ -27,9 +29,35 @@ var InitStoreCommand = cli.Command{
 			Name:  "gid-mapping",
 			Usage: "GID mapping for image translation, e.g.: <Namespace GID>:<Host GID>:<Size>",
 		},
+		cli.StringFlag{
+			Name:  "owner-user",
+			Usage: "Builds the UID mappings from the user and its ranges in /etc/subuid",
+		},
+		cli.StringFlag{
+			Name:  "owner-group",
+			Usage: "Builds the GID mappings from the group and its ranges in /etc/subgid",
+		},
+		cli.IntFlag{
+			Name:  "overflow-uid",
+			Usage: "Owner of the files whose uid is not covered by the uid mappings",
+			Value: groot.DefaultOverflowID,
+		},
+		cli.IntFlag{
+			Name:  "overflow-gid",
+			Usage: "Group of the files whose gid is not covered by the gid mappings",
+			Value: groot.DefaultOverflowID,
+		},
 		cli.Int64Flag{
 			Name:  "store-size-bytes",
-			Usage: "Creates a new filesystem of the given size and mounts it to the given Store Directory",
+			Usage: "Creates a new filesystem of the given size and mounts it to the given Store Directory. Requires root.",
+		},
+		cli.Int64Flag{
+			Name:  "cache-limit-bytes",
+			Usage: "Limits the space the cached layers can take. Requires quotas to be enabled on the store filesystem.",
+		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "Compression the filesystem created with --store-size-bytes is mounted with: none, zlib, lzo or zstd, optionally followed by :<level>",
 		},
 	},
 
@@ -43,7 +71,13 @@ var InitStoreCommand = cli.Command{
 		}
 
 		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder).
-			WithStoreSizeBytes(ctx.Int64("store-size-bytes"))
+			WithStoreSizeBytes(ctx.Int64("store-size-bytes")).
+			WithCacheLimitBytes(ctx.Int64("cache-limit-bytes")).
+			WithOwnerUser(ctx.String("owner-user")).
+			WithOwnerGroup(ctx.String("owner-group")).
+			WithOverflowUID(ctx.Int("overflow-uid")).
+			WithOverflowGID(ctx.Int("overflow-gid")).
+			WithCompression(ctx.String("compression"), ctx.IsSet("compression"))
 		cfg, err := configBuilder.Build()
 		logger.Debug("init-store", lager.Data{"currentConfig": cfg})
 		if err != nil {
@@ -54,7 +88,7 @@ var InitStoreCommand = cli.Command{
 		storePath := cfg.StorePath
 		storeSizeBytes := cfg.Init.StoreSizeBytes
 
-		if os.Getuid() != 0 {
+		if os.Getuid() != 0 && storeSizeBytes > 0 {
 			err := errorspkg.Errorf("store %s can only be initialized by Root user", storePath)
 			logger.Error("init-store-failed", err)
 			return cli.NewExitError(err.Error(), 1)
@@ -66,13 +100,13 @@ var InitStoreCommand = cli.Command{
 			return cli.NewExitError(err.Error(), 1)
 		}
 
-		uidMappings, err := parseIDMappings(ctx.StringSlice("uid-mapping"))
+		uidMappings, err := initStoreMappings(ctx.StringSlice("uid-mapping"), cfg.Init.OwnerUser, readSubUIDMapping)
 		if err != nil {
 			err = errorspkg.Errorf("parsing uid-mapping: %s", err)
 			logger.Error("parsing-command", err)
 			return cli.NewExitError(err.Error(), 1)
 		}
-		gidMappings, err := parseIDMappings(ctx.StringSlice("gid-mapping"))
+		gidMappings, err := initStoreMappings(ctx.StringSlice("gid-mapping"), cfg.Init.OwnerGroup, readSubGIDMapping)
 		if err != nil {
 			err = errorspkg.Errorf("parsing gid-mapping: %s", err)
 			logger.Error("parsing-command", err)
@@ -81,13 +115,24 @@ var InitStoreCommand = cli.Command{
 
 		namespacer := groot.NewStoreNamespacer(storePath)
 		spec := manager.InitSpec{
-			UIDMappings:    uidMappings,
-			GIDMappings:    gidMappings,
-			StoreSizeBytes: storeSizeBytes,
+			UIDMappings:     uidMappings,
+			GIDMappings:     gidMappings,
+			OverflowUID:     cfg.Init.OverflowUID,
+			OverflowGID:     cfg.Init.OverflowGID,
+			StoreSizeBytes:  storeSizeBytes,
+			CacheLimitBytes: cfg.Init.CacheLimitBytes,
 		}
 
 		manager := manager.New(storePath, namespacer, fsDriver, fsDriver, fsDriver)
-		if err := manager.InitStore(logger, spec); err != nil {
+		if os.Getuid() == 0 {
+			err = manager.InitStore(logger, spec)
+		} else {
+			runner := linux_command_runner.New()
+			idMapper := unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
+			err = manager.InitStoreInUserNamespace(logger, spec, idMapper, runner)
+		}
+
+		if err != nil {
 			logger.Error("cleaning-up-store-failed", err)
 			return cli.NewExitError(errorspkg.Cause(err).Error(), 1)
 		}
@@ -95,3 +140,15 @@ var InitStoreCommand = cli.Command{
 		return nil
 	},
 }
+
+func initStoreMappings(mappingArgs []string, owner string, readSubIDs func(string) ([]groot.IDMappingSpec, error)) ([]groot.IDMappingSpec, error) {
+	if owner == "" {
+		return parseIDMappings(mappingArgs)
+	}
+
+	if len(mappingArgs) > 0 {
+		return nil, errorspkg.Errorf("owner `%s` cannot be combined with explicit mappings", owner)
+	}
+
+	return readSubIDs(owner)
+}
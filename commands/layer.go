@@ -0,0 +1,135 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/layer_transfer"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var LayerCommand = cli.Command{
+	Name:        "layer",
+	Usage:       "layer <export|import>",
+	Description: "Copies layers between stores with btrfs send and receive",
+
+	Subcommands: []cli.Command{
+		LayerExportCommand,
+		LayerImportCommand,
+	},
+}
+
+var LayerExportCommand = cli.Command{
+	Name:        "export",
+	Usage:       "export [options] <chain id> > <stream>",
+	Description: "Writes a layer to stdout, only the difference to the parent layer when one is given",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "parent",
+			Usage: "Chain ID of a layer the importing store already has",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("layer-export")
+		newExitError := newErrorHandler(logger, "layer-export")
+
+		if ctx.NArg() != 1 {
+			logger.Error("parsing-command", errorspkg.New("chain id was not specified"))
+			return newExitError("chain id was not specified", 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("layer-export-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter := metrics.NewEmitter()
+		locksmith := newSharedLocksmith(cfg, metricsEmitter)
+		lockFile, err := locksmith.Lock(groot.GlobalLockKey)
+		if err != nil {
+			logger.Error("locking-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer func() {
+			if err := locksmith.Unlock(lockFile); err != nil {
+				logger.Error("unlocking-failed", err)
+			}
+		}()
+
+		exporter := layer_transfer.NewExporter(fsDriver)
+		if err := exporter.Export(logger, ctx.Args().First(), ctx.String("parent"), os.Stdout); err != nil {
+			logger.Error("exporting-layer-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter.TryIncrementRunCount("layer-export", nil)
+		return nil
+	},
+}
+
+var LayerImportCommand = cli.Command{
+	Name:        "import",
+	Usage:       "import < <stream>",
+	Description: "Reads a layer written by `layer export` from stdin. The parent layer it was exported against has to have been imported first",
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("layer-import")
+		newExitError := newErrorHandler(logger, "layer-import")
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("layer-import-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter := metrics.NewEmitter()
+		locksmith := newSharedLocksmith(cfg, metricsEmitter)
+		lockFile, err := locksmith.Lock(groot.GlobalLockKey)
+		if err != nil {
+			logger.Error("locking-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+		defer func() {
+			if err := locksmith.Unlock(lockFile); err != nil {
+				logger.Error("unlocking-failed", err)
+			}
+		}()
+
+		importer := layer_transfer.NewImporter(fsDriver)
+		chainID, err := importer.Import(logger, os.Stdin)
+		if err != nil {
+			logger.Error("importing-layer-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		fmt.Println(chainID)
+		metricsEmitter.TryIncrementRunCount("layer-import", nil)
+		return nil
+	},
+}
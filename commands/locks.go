@@ -0,0 +1,68 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/store/locksmith"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var LocksCommand = cli.Command{
+	Name:        "locks",
+	Usage:       "locks [--json]",
+	Description: "Lists the processes holding store locks",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the holders as json",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("locks")
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("locks-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		if _, err := os.Stat(cfg.StorePath); os.IsNotExist(err) {
+			err := errorspkg.Errorf("no store found at %s", cfg.StorePath)
+			logger.Error("store-path-failed", err, nil)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		holders, err := locksmith.Holders(cfg.StorePath)
+		if err != nil {
+			logger.Error("listing-lock-holders-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		if ctx.Bool("json") {
+			_ = json.NewEncoder(os.Stdout).Encode(holders)
+			return nil
+		}
+
+		for _, holder := range holders {
+			mode := "shared"
+			if holder.Exclusive {
+				mode = "exclusive"
+			}
+			fmt.Printf("%s %s %d %s %s\n", holder.Key, mode, holder.PID,
+				holder.LockedAt.Format(time.RFC3339), holder.Command)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,224 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/metrics"
+	storepkg "github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const maintenanceReportFileName = "maintenance.json"
+
+// balanceFlag is a flag that can be given without a value, `--balance`, to
+// balance every chunk, or with a usage filter, `--balance=50`.
+type balanceFlag struct {
+	set         bool
+	usageFilter int
+}
+
+func (f *balanceFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.set, f.usageFilter = true, groot.BalanceAllChunks
+	case "false":
+		f.set = false
+	default:
+		usageFilter, err := strconv.Atoi(value)
+		if err != nil || usageFilter < 0 || usageFilter > 100 {
+			return errorspkg.Errorf("invalid usage filter `%s`, it must be a percentage", value)
+		}
+		f.set, f.usageFilter = true, usageFilter
+	}
+
+	return nil
+}
+
+func (f *balanceFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	if f.usageFilter == groot.BalanceAllChunks {
+		return "true"
+	}
+	return strconv.Itoa(f.usageFilter)
+}
+
+func (f *balanceFlag) IsBoolFlag() bool {
+	return true
+}
+
+type maintenanceOutcome struct {
+	groot.MaintenanceReport
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type maintenanceStatus struct {
+	Running groot.MaintenanceReport `json:"running"`
+	Last    *maintenanceOutcome     `json:"last,omitempty"`
+}
+
+var MaintainCommand = cli.Command{
+	Name:  "maintain",
+	Usage: "maintain [--scrub] [--balance[=<usage>]] [--quota-rescan] [--background] | maintain --status",
+	Description: "Scrubs the store, balances its chunks and rescans its quotas, in this order. " +
+		"Without a usage filter every chunk is balanced, with one only the chunks that are at most <usage> percent full. " +
+		"The results are printed as JSON once done, or can be read with --status when running in the background.",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "scrub",
+			Usage: "Verify the checksums of all data and metadata",
+		},
+		cli.GenericFlag{
+			Name:  "balance",
+			Usage: "Relocate chunks to reclaim unused space, optionally only the ones at most <usage> percent full",
+			Value: &balanceFlag{},
+		},
+		cli.BoolFlag{
+			Name:  "quota-rescan",
+			Usage: "Recompute the quota group numbers",
+		},
+		cli.BoolFlag{
+			Name:  "background",
+			Usage: "Run in a detached process and return straight away",
+		},
+		cli.BoolFlag{
+			Name:  "status",
+			Usage: "Show the progress of the running operations and the outcome of the last maintenance",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("maintain")
+		newExitError := newErrorHandler(logger, "maintain")
+
+		balance := ctx.Generic("balance").(*balanceFlag)
+		spec := groot.MaintenanceSpec{
+			Scrub:              ctx.Bool("scrub"),
+			Balance:            balance.set,
+			BalanceUsageFilter: balance.usageFilter,
+			QuotaRescan:        ctx.Bool("quota-rescan"),
+		}
+
+		hasOperation := spec.Scrub || spec.Balance || spec.QuotaRescan
+		if ctx.NArg() != 0 || hasOperation == ctx.Bool("status") {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return newExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("maintain-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if os.Getuid() != 0 {
+			err := errorspkg.Errorf("store %s can only be maintained by Root user", storePath)
+			logger.Error("maintain-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		if _, err = os.Stat(storePath); os.IsNotExist(err) {
+			err = errorspkg.Errorf("no store found at %s", storePath)
+			logger.Error("store-path-failed", err, nil)
+			return newExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter := metrics.NewEmitter()
+		sharedLocksmith := newSharedLocksmith(cfg, metricsEmitter)
+		exclusiveLocksmith := newExclusiveLocksmith(cfg, metricsEmitter)
+		maintainer := groot.IamMaintainer(fsDriver, sharedLocksmith, exclusiveLocksmith, metricsEmitter)
+		reportPath := filepath.Join(storePath, storepkg.MetaDirName, maintenanceReportFileName)
+
+		if ctx.Bool("status") {
+			running, err := maintainer.Status(logger)
+			if err != nil {
+				logger.Error("reading-status-failed", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			status := maintenanceStatus{Running: running}
+			if contents, err := ioutil.ReadFile(reportPath); err == nil {
+				status.Last = &maintenanceOutcome{}
+				if err := json.Unmarshal(contents, status.Last); err != nil {
+					logger.Error("parsing-last-report-failed", storepkg.QuarantineCorruptFile(reportPath, err))
+					status.Last = nil
+				}
+			}
+
+			_ = json.NewEncoder(os.Stdout).Encode(status)
+			return nil
+		}
+
+		if ctx.Bool("background") {
+			args := []string{ctx.Command.Name}
+			if spec.Scrub {
+				args = append(args, "--scrub")
+			}
+			if spec.Balance {
+				args = append(args, fmt.Sprintf("--balance=%s", balance))
+			}
+			if spec.QuotaRescan {
+				args = append(args, "--quota-rescan")
+			}
+
+			pid, err := startDetached(ctx, args...)
+			if err != nil {
+				logger.Error("starting-background-maintenance-failed", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			fmt.Printf("maintenance running in the background with pid %d\n", pid)
+			return nil
+		}
+
+		report, err := maintainer.Maintain(logger, spec)
+		outcome := maintenanceOutcome{MaintenanceReport: report, FinishedAt: time.Now()}
+		if err != nil {
+			outcome.Error = err.Error()
+		}
+		if err := writeMaintenanceOutcome(reportPath, outcome); err != nil {
+			logger.Error("writing-report-failed", err)
+		}
+
+		_ = json.NewEncoder(os.Stdout).Encode(outcome)
+		if err != nil {
+			logger.Error("maintenance-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter.TryIncrementRunCount("maintain", nil)
+		return nil
+	},
+}
+
+func writeMaintenanceOutcome(path string, outcome maintenanceOutcome) error {
+	contents, err := json.Marshal(outcome)
+	if err != nil {
+		return errorspkg.Wrap(err, "encoding maintenance report")
+	}
+
+	return storepkg.WriteFileAtomically(path, contents, 0644)
+}
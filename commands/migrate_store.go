@@ -0,0 +1,88 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/migrator"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var MigrateStoreCommand = cli.Command{
+	Name:  "migrate-store",
+	Usage: "migrate-store [--dry-run]",
+	Description: "Brings the on-disk layout of the store up to the version this build uses, running the pending migrations in order. " +
+		"Stores initialized before the layout was versioned run every migration.",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only list the pending migrations",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("migrate-store")
+		newExitError := newErrorHandler(logger, "migrate-store")
+
+		if ctx.NArg() != 0 {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return newExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("migrate-store-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if _, err = os.Stat(storePath); os.IsNotExist(err) {
+			err = errorspkg.Errorf("no store found at %s", storePath)
+			logger.Error("store-path-failed", err, nil)
+			return newExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter := metrics.NewEmitter()
+		locksmith := newExclusiveLocksmith(cfg, metricsEmitter)
+		storeMigrator := migrator.NewMigrator(storePath, locksmith, migrator.Migrations(fsDriver))
+
+		if ctx.Bool("dry-run") {
+			pending, err := storeMigrator.Pending(logger)
+			if err != nil {
+				logger.Error("listing-pending-migrations-failed", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			for _, migration := range pending {
+				fmt.Printf("pending migration to version %d: %s\n", migration.Version, migration.Description)
+			}
+			return nil
+		}
+
+		applied, err := storeMigrator.Migrate(logger)
+		for _, migration := range applied {
+			fmt.Printf("migrated store to version %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			logger.Error("migrating-store-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter.TryIncrementRunCount("migrate-store", nil)
+		return nil
+	},
+}
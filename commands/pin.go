@@ -0,0 +1,216 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/metadata_db"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var PinCommand = cli.Command{
+	Name:        "pin",
+	Usage:       "pin [options] <image url>",
+	Description: "Keeps the layers of a base image from ever being cleaned up. Pinning it again follows a moved tag",
+
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "insecure-registry",
+			Usage: "Whitelist a private registry",
+		},
+		cli.StringFlag{
+			Name:  "username",
+			Usage: "Username to authenticate in image registry",
+		},
+		cli.StringFlag{
+			Name:  "password",
+			Usage: "Password to authenticate in image registry",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("pin")
+		newExitError := newErrorHandler(logger, "pin")
+
+		if ctx.NArg() != 1 {
+			logger.Error("parsing-command", errorspkg.New("image url was not specified"))
+			return newExitError("image url was not specified", 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		configBuilder.WithInsecureRegistries(ctx.StringSlice("insecure-registry"))
+		cfg, err := configBuilder.Build()
+		logger.Debug("pin-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		baseImageURL, err := url.Parse(ctx.Args().First())
+		if err != nil {
+			logger.Error("base-image-url-parsing-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		pinner, metadataDB, metricsEmitter, err := createPinner(logger, cfg, baseImageURL, ctx.String("username"), ctx.String("password"))
+		if err != nil {
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
+
+		// resolving the image can take a while, the pin reopens the database
+		// when it registers
+		closeMetadataDB(logger, metadataDB)
+
+		pin, err := pinner.Pin(logger, baseImageURL)
+		if err != nil {
+			logger.Error("pinning-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		fmt.Printf("pinned %s: %s\n", pin.BaseImage, strings.Join(pin.ChainIDs, " "))
+		metricsEmitter.TryIncrementRunCount("pin", nil)
+		return nil
+	},
+}
+
+var UnpinCommand = cli.Command{
+	Name:        "unpin",
+	Usage:       "unpin <image url>",
+	Description: "Lets the layers of a pinned base image be cleaned up again",
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("unpin")
+		newExitError := newErrorHandler(logger, "unpin")
+
+		if ctx.NArg() != 1 {
+			logger.Error("parsing-command", errorspkg.New("image url was not specified"))
+			return newExitError("image url was not specified", 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("unpin-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		baseImageURL, err := url.Parse(ctx.Args().First())
+		if err != nil {
+			logger.Error("base-image-url-parsing-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		pinner, metadataDB, metricsEmitter, err := createPinner(logger, cfg, baseImageURL, "", "")
+		if err != nil {
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
+
+		if err := pinner.Unpin(logger, baseImageURL); err != nil {
+			logger.Error("unpinning-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		metricsEmitter.TryIncrementRunCount("unpin", nil)
+		return nil
+	},
+}
+
+var PinsCommand = cli.Command{
+	Name:        "pins",
+	Usage:       "pins <list>",
+	Description: "Shows the pinned base images",
+
+	Subcommands: []cli.Command{
+		PinsListCommand,
+	},
+}
+
+var PinsListCommand = cli.Command{
+	Name:        "list",
+	Usage:       "list [--json]",
+	Description: "Lists the pinned base images and the chain IDs of their layers",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the pins as JSON",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("pins-list")
+		newExitError := newErrorHandler(logger, "pins-list")
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("pins-list-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		pinner, metadataDB, _, err := createPinner(logger, cfg, &url.URL{}, "", "")
+		if err != nil {
+			return newExitError(err.Error(), 1)
+		}
+		defer closeMetadataDB(logger, metadataDB)
+
+		pins, err := pinner.Pins(logger)
+		if err != nil {
+			logger.Error("listing-pins-failed", err)
+			return newExitError(err.Error(), 1)
+		}
+
+		if ctx.Bool("json") {
+			_ = json.NewEncoder(os.Stdout).Encode(pins)
+			return nil
+		}
+
+		for _, pin := range pins {
+			fmt.Printf("%s %s\n", pin.BaseImage, strings.Join(pin.ChainIDs, " "))
+		}
+		return nil
+	},
+}
+
+func createPinner(logger lager.Logger, cfg config.Config, baseImageURL *url.URL, username, password string) (*groot.Pinner, *metadata_db.MetadataDB, *metrics.Emitter, error) {
+	storePath := cfg.StorePath
+	if _, err := os.Stat(storePath); os.IsNotExist(err) {
+		err = errorspkg.Errorf("no store found at %s", storePath)
+		logger.Error("store-path-failed", err, nil)
+		return nil, nil, nil, err
+	}
+
+	metricsEmitter := metrics.NewEmitter()
+	locksmith := newSharedLocksmith(cfg, metricsEmitter)
+	fsDriver, err := createFileSystemDriver(cfg)
+	if err != nil {
+		logger.Error("failed-to-initialise-filesystem-driver", err)
+		return nil, nil, nil, err
+	}
+
+	metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+	if err != nil {
+		logger.Error("opening-metadata-db-failed", err)
+		return nil, nil, nil, err
+	}
+
+	systemContext := createSystemContext(baseImageURL, cfg.Create, username, password)
+	fetcher := createFetcher(baseImageURL, systemContext, cfg.Create)
+
+	return groot.IamPinner(fetcher, metadataDB, locksmith), metadataDB, metricsEmitter, nil
+}
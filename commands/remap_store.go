@@ -0,0 +1,104 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/manager"
+	"github.com/SUSE/groot-btrfs/store/migrator"
+
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var RemapStoreCommand = cli.Command{
+	Name:        "remap-store",
+	Usage:       "remap-store --store <path> --uid-mapping <mapping> --gid-mapping <mapping>",
+	Description: "Shifts the ownership of the store volumes to new ID mappings. An interrupted remap resumes when run again with the same mappings.",
+
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "uid-mapping",
+			Usage: "New UID mapping for the store, e.g.: <Namespace UID>:<Host UID>:<Size>",
+		},
+		cli.StringSliceFlag{
+			Name:  "gid-mapping",
+			Usage: "New GID mapping for the store, e.g.: <Namespace GID>:<Host GID>:<Size>",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("remap-store")
+
+		if ctx.NArg() != 0 {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return cli.NewExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("remap-store", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if os.Getuid() != 0 {
+			err := errorspkg.Errorf("store %s can only be remapped by Root user", storePath)
+			logger.Error("remap-store-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		uidMappings, err := parseIDMappings(ctx.StringSlice("uid-mapping"))
+		if err != nil {
+			err = errorspkg.Errorf("parsing uid-mapping: %s", err)
+			logger.Error("parsing-command", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		gidMappings, err := parseIDMappings(ctx.StringSlice("gid-mapping"))
+		if err != nil {
+			err = errorspkg.Errorf("parsing gid-mapping: %s", err)
+			logger.Error("parsing-command", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		namespacer := groot.NewStoreNamespacer(storePath)
+		spec := manager.RemapSpec{
+			UIDMappings: uidMappings,
+			GIDMappings: gidMappings,
+		}
+
+		manager := manager.New(storePath, namespacer, fsDriver, fsDriver, fsDriver)
+		if !manager.IsStoreInitialized(logger) {
+			err := errorspkg.Errorf("store %s is not initialized", storePath)
+			logger.Error("remap-store-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		locksmith := newExclusiveLocksmith(cfg, metrics.NewEmitter())
+		storeMigrator := migrator.NewMigrator(storePath, locksmith, migrator.Migrations(fsDriver))
+		if err := requireMigratedStore(logger, storeMigrator); err != nil {
+			logger.Error("store-migration-check-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		if err := manager.RemapStore(logger, spec, locksmith); err != nil {
+			logger.Error("remapping-store-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
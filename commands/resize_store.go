@@ -0,0 +1,68 @@
+package commands // import "github.com/SUSE/groot-btrfs/commands"
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/commands/config"
+	"github.com/SUSE/groot-btrfs/metrics"
+	"github.com/SUSE/groot-btrfs/store/manager"
+
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var ResizeStoreCommand = cli.Command{
+	Name:        "resize-store",
+	Usage:       "resize-store --store <path> --store-size-bytes <size>",
+	Description: "Grows or shrinks the file backing a store created with --store-size-bytes, together with its mounted filesystem. Shrinking fails when the store does not fit in the new size.",
+
+	Flags: []cli.Flag{
+		cli.Int64Flag{
+			Name:  "store-size-bytes",
+			Usage: "New size of the store filesystem in bytes",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("resize-store")
+
+		if ctx.NArg() != 0 || !ctx.IsSet("store-size-bytes") {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return cli.NewExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		cfg, err := configBuilder.Build()
+		logger.Debug("resize-store", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		storePath := cfg.StorePath
+		if os.Getuid() != 0 {
+			err := errorspkg.Errorf("store %s can only be resized by Root user", storePath)
+			logger.Error("resize-store-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		fsDriver, err := createFileSystemDriver(cfg)
+		if err != nil {
+			logger.Error("failed-to-initialise-filesystem-driver", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		locksmith := newExclusiveLocksmith(cfg, metrics.NewEmitter())
+		manager := manager.New(storePath, nil, fsDriver, fsDriver, fsDriver)
+
+		if err := manager.ResizeStore(logger, locksmith, ctx.Int64("store-size-bytes")); err != nil {
+			logger.Error("resizing-store-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
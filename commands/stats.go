@@ -10,6 +10,8 @@ import (
 	"github.com/SUSE/groot-btrfs/commands/idfinder"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/metrics"
+	storepkg "github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/garbage_collector"
 	imageClonerpkg "github.com/SUSE/groot-btrfs/store/image_cloner"
 	errorspkg "github.com/pkg/errors"
 	"github.com/urfave/cli"
@@ -17,20 +19,33 @@ import (
 
 var StatsCommand = cli.Command{
 	Name:        "stats",
-	Usage:       "stats [options] <id|image path>",
-	Description: "Return filesystem stats",
+	Usage:       "stats [options] [<id|image path>]",
+	Description: "Return filesystem stats of an image, or of the layer cache, the images, the unused volumes and the trash of the store when no image is given",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "measurer",
+			Usage: "How to measure the store when no image is given: `statfs` or `qgroup`",
+		},
+		cli.BoolFlag{
+			Name:  "compression",
+			Usage: "Also report the logical and compressed usage of the image, which reads every extent of it",
+		},
+	},
 
 	Action: func(ctx *cli.Context) error {
 		logger := ctx.App.Metadata["logger"].(lager.Logger)
 		logger = logger.Session("stats")
 		newExitError := newErrorHandler(logger, "stats")
 
-		if ctx.NArg() != 1 {
+		if ctx.NArg() > 1 {
 			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
 			return newExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
 		}
 
 		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		configBuilder.WithCleanMeasurer(ctx.String("measurer"), ctx.IsSet("measurer")).
+			WithCompressionStats(ctx.Bool("compression"))
 		cfg, err := configBuilder.Build()
 		logger.Debug("stats-config", lager.Data{"currentConfig": cfg})
 		if err != nil {
@@ -39,18 +54,48 @@ var StatsCommand = cli.Command{
 		}
 
 		storePath := cfg.StorePath
-		idOrPath := ctx.Args().First()
-		id, err := idfinder.FindID(storePath, idOrPath)
+		fsDriver, err := createFileSystemDriver(cfg)
 		if err != nil {
-			logger.Error("find-id-failed", err, lager.Data{"id": idOrPath, "storePath": storePath})
 			return newExitError(err.Error(), 1)
 		}
 
-		fsDriver, err := createFileSystemDriver(cfg)
+		imageCloner := imageClonerpkg.NewImageCloner(fsDriver, storePath)
+
+		if ctx.NArg() == 0 {
+			metadataDB, err := openMetadataDB(logger, storePath, fsDriver)
+			if err != nil {
+				logger.Error("opening-metadata-db-failed", err)
+				return newExitError(err.Error(), 1)
+			}
+			defer closeMetadataDB(logger, metadataDB)
+			gc := garbage_collector.NewGC(fsDriver, imageCloner, metadataDB, newExclusiveLocksmith(cfg, metrics.NewEmitter()))
+			unusedVolumes, err := gc.UnusedVolumes(logger, nil)
+			if err != nil {
+				logger.Error("getting-unused-layers-failed", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			storeStats, err := fetchStoreStats(logger, cfg, fsDriver, unusedVolumes)
+			if err != nil {
+				logger.Error("fetching-store-stats", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			if storeStats.Trash, err = fsDriver.TrashStats(logger); err != nil {
+				logger.Error("fetching-trash-stats", err)
+				return newExitError(err.Error(), 1)
+			}
+
+			_ = json.NewEncoder(os.Stdout).Encode(storeStats)
+			return nil
+		}
+
+		idOrPath := ctx.Args().First()
+		id, err := idfinder.FindID(storePath, idOrPath)
 		if err != nil {
+			logger.Error("find-id-failed", err, lager.Data{"id": idOrPath, "storePath": storePath})
 			return newExitError(err.Error(), 1)
 		}
-		imageCloner := imageClonerpkg.NewImageCloner(fsDriver, storePath)
 
 		metricsEmitter := metrics.NewEmitter()
 		statser := groot.IamStatser(imageCloner, metricsEmitter)
@@ -65,3 +110,24 @@ var StatsCommand = cli.Command{
 		return nil
 	},
 }
+
+// fetchStoreStats measures the store the way clean is configured to. Without
+// qgroups only the size the volumes recorded when they were pulled is known,
+// which ignores the extents they share and cannot tell the images apart from
+// the rest of the filesystem.
+func fetchStoreStats(logger lager.Logger, cfg config.Config, fsDriver fileSystemDriver, unusedVolumes []string) (groot.StoreStats, error) {
+	if cfg.Clean.Measurer == config.QgroupMeasurer {
+		return groot.NewQgroupMeasurer(fsDriver).Stats(logger, unusedVolumes)
+	}
+
+	volumes, err := fsDriver.Volumes(logger)
+	if err != nil {
+		return groot.StoreStats{}, errorspkg.Wrap(err, "listing volumes")
+	}
+
+	measurer := storepkg.NewStoreMeasurer(cfg.StorePath, fsDriver)
+	return groot.StoreStats{
+		Layers: groot.DiskUsage{TotalBytesUsed: measurer.CacheUsage(logger, volumes)},
+		Unused: groot.DiskUsage{TotalBytesUsed: measurer.CacheUsage(logger, unusedVolumes)},
+	}, nil
+}
@@ -1,6 +1,7 @@
 package groot
 
 import (
+	"os"
 	"time"
 
 	"code.cloudfoundry.org/lager"
@@ -13,23 +14,36 @@ type Cleaner interface {
 }
 
 type cleaner struct {
-	storeMeasurer    StoreMeasurer
-	garbageCollector GarbageCollector
-	locksmith        Locksmith
-	metricsEmitter   MetricsEmitter
+	storeMeasurer      StoreMeasurer
+	garbageCollector   GarbageCollector
+	sharedLocksmith    Locksmith
+	exclusiveLocksmith Locksmith
+	metricsEmitter     MetricsEmitter
+	lowWatermark       int64
 }
 
-func IamCleaner(locksmith Locksmith, sm StoreMeasurer,
+// IamCleaner takes the global lock shared, so that cleaning runs alongside
+// creates, and the reference lock of each volume it marks exclusively.
+func IamCleaner(sharedLocksmith, exclusiveLocksmith Locksmith, sm StoreMeasurer,
 	gc GarbageCollector, metricsEmitter MetricsEmitter,
 ) *cleaner {
 	return &cleaner{
-		locksmith:        locksmith,
-		storeMeasurer:    sm,
-		garbageCollector: gc,
-		metricsEmitter:   metricsEmitter,
+		sharedLocksmith:    sharedLocksmith,
+		exclusiveLocksmith: exclusiveLocksmith,
+		storeMeasurer:      sm,
+		garbageCollector:   gc,
+		metricsEmitter:     metricsEmitter,
 	}
 }
 
+// WithLowWatermark makes Clean stop collecting unused volumes, least recently
+// used first, once the store usage would be below lowWatermark. The threshold
+// Clean is given acts as the high watermark.
+func (c *cleaner) WithLowWatermark(lowWatermark int64) *cleaner {
+	c.lowWatermark = lowWatermark
+	return c
+}
+
 func (c *cleaner) Clean(logger lager.Logger, threshold int64, chainIDsToPreserve []string) (bool, error) {
 	logger = logger.Session("groot-cleaning")
 	logger.Info("starting")
@@ -37,35 +51,160 @@ func (c *cleaner) Clean(logger lager.Logger, threshold int64, chainIDsToPreserve
 	defer c.metricsEmitter.TryEmitDurationFrom(logger, MetricImageCleanTime, time.Now())
 	defer logger.Info("ending")
 
-	if threshold > 0 {
-		storeUsage, err := c.storeMeasurer.Usage(logger)
+	noop, _, err := c.selectUnused(logger, threshold, chainIDsToPreserve, false)
+	if noop || err != nil {
+		return noop, err
+	}
+
+	return false, c.garbageCollector.Collect(logger)
+}
+
+// DryRun returns the volumes Clean would collect, without marking or
+// collecting them.
+func (c *cleaner) DryRun(logger lager.Logger, threshold int64, chainIDsToPreserve []string) (bool, []string, error) {
+	logger = logger.Session("groot-cleaning-dry-run")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	return c.selectUnused(logger, threshold, chainIDsToPreserve, true)
+}
+
+// selectUnused finds the volumes to collect and, unless dryRun is set, marks
+// them as unused. Volumes whose reference lock is held are in use by a create
+// and are left alone.
+func (c *cleaner) selectUnused(logger lager.Logger, threshold int64, chainIDsToPreserve []string, dryRun bool) (bool, []string, error) {
+	if threshold < 0 {
+		return true, nil, errorspkg.New("Threshold must be greater than 0")
+	}
+
+	var storeUsage int64
+	if threshold > 0 || c.lowWatermark > 0 {
+		var err error
+		storeUsage, err = c.storeMeasurer.Usage(logger)
 		if err != nil {
-			return true, errorspkg.Wrap(err, "measuring store usage")
-		}
-		if storeUsage < threshold {
-			return true, nil
+			return true, nil, errorspkg.Wrap(err, "measuring store usage")
 		}
-	} else if threshold < 0 {
-		return true, errorspkg.New("Threshold must be greater than 0")
 	}
 
-	lockFile, err := c.locksmith.Lock(GlobalLockKey)
+	if threshold > 0 && storeUsage < threshold {
+		return true, nil, nil
+	}
+
+	lockFile, err := c.sharedLocksmith.Lock(GlobalLockKey)
 	if err != nil {
-		return false, errorspkg.Wrap(err, "garbage collector acquiring lock")
+		return false, nil, errorspkg.Wrap(err, "garbage collector acquiring lock")
 	}
+	defer func() {
+		if err := c.sharedLocksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-failed", err)
+		}
+	}()
 
 	unusedVolumes, err := c.garbageCollector.UnusedVolumes(logger, chainIDsToPreserve)
 	if err != nil {
+		if dryRun {
+			return false, nil, errorspkg.Wrap(err, "finding unused volumes")
+		}
 		logger.Error("finding-unused-failed", err)
 	}
 
+	if !dryRun {
+		referenceLockFiles, lockedVolumes := c.lockReferences(logger, unusedVolumes)
+		defer c.unlockReferences(logger, referenceLockFiles)
+
+		unusedVolumes = c.stillUnused(logger, chainIDsToPreserve, lockedVolumes)
+	}
+
+	if c.lowWatermark > 0 {
+		unusedVolumes = c.volumesAboveLowWatermark(logger, storeUsage, unusedVolumes)
+	}
+
+	if dryRun {
+		return false, unusedVolumes, nil
+	}
+
 	if err := c.garbageCollector.MarkUnused(logger, unusedVolumes); err != nil {
 		logger.Error("marking-unused-failed", err)
 	}
 
-	if err := c.locksmith.Unlock(lockFile); err != nil {
-		logger.Error("unlocking-failed", err)
+	return false, unusedVolumes, nil
+}
+
+// lockReferences takes the reference locks of the volumes that no create is
+// using, and returns the volumes it locked.
+func (c *cleaner) lockReferences(logger lager.Logger, volumes []string) ([]*os.File, map[string]bool) {
+	lockFiles := []*os.File{}
+	lockedVolumes := map[string]bool{}
+
+	for _, volumeID := range volumes {
+		lockFile, err := c.exclusiveLocksmith.TryLock(ReferenceLockKey(volumeID))
+		if err != nil {
+			logger.Error("locking-reference-failed", err, lager.Data{"volumeID": volumeID})
+			continue
+		}
+		if lockFile == nil {
+			logger.Debug("volume-in-use", lager.Data{"volumeID": volumeID})
+			continue
+		}
+
+		lockFiles = append(lockFiles, lockFile)
+		lockedVolumes[volumeID] = true
 	}
 
-	return false, c.garbageCollector.Collect(logger)
+	return lockFiles, lockedVolumes
+}
+
+func (c *cleaner) unlockReferences(logger lager.Logger, lockFiles []*os.File) {
+	for _, lockFile := range lockFiles {
+		if err := c.exclusiveLocksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-reference-failed", err)
+		}
+	}
+}
+
+// stillUnused returns the locked volumes that are still unused, in the order
+// the garbage collector returns them. Creates that registered an image since
+// the volumes were first listed have released their reference locks, so they
+// show up here. Nothing is returned when that can't be told.
+func (c *cleaner) stillUnused(logger lager.Logger, chainIDsToPreserve []string, lockedVolumes map[string]bool) []string {
+	if len(lockedVolumes) == 0 {
+		return []string{}
+	}
+
+	unusedVolumes, err := c.garbageCollector.UnusedVolumes(logger, chainIDsToPreserve)
+	if err != nil {
+		logger.Error("finding-unused-failed", err)
+		return []string{}
+	}
+
+	stillUnused := []string{}
+	for _, volumeID := range unusedVolumes {
+		if lockedVolumes[volumeID] {
+			stillUnused = append(stillUnused, volumeID)
+		}
+	}
+
+	return stillUnused
+}
+
+// volumesAboveLowWatermark returns the first of the unused volumes whose sizes
+// add up to what the store uses above the low watermark.
+func (c *cleaner) volumesAboveLowWatermark(logger lager.Logger, storeUsage int64, unusedVolumes []string) []string {
+	toReclaim := storeUsage - c.lowWatermark
+	var reclaimed int64
+
+	for i, volumeID := range unusedVolumes {
+		if reclaimed >= toReclaim {
+			logger.Info("low-watermark-reached", lager.Data{
+				"lowWatermark": c.lowWatermark,
+				"storeUsage":   storeUsage,
+				"reclaimed":    reclaimed,
+			})
+			return unusedVolumes[:i]
+		}
+
+		reclaimed += c.storeMeasurer.CacheUsage(logger, []string{volumeID})
+	}
+
+	return unusedVolumes
 }
@@ -18,6 +18,7 @@ import (
 var _ = Describe("Cleaner", func() {
 	var (
 		fakeLocksmith        *grootfakes.FakeLocksmith
+		fakeRefLocksmith     *grootfakes.FakeLocksmith
 		fakeStoreMeasurer    *grootfakes.FakeStoreMeasurer
 		fakeGarbageCollector *grootfakes.FakeGarbageCollector
 		fakeMetricsEmitter   *grootfakes.FakeMetricsEmitter
@@ -33,12 +34,14 @@ var _ = Describe("Cleaner", func() {
 		lockFile, err = ioutil.TempFile("", "")
 		Expect(err).NotTo(HaveOccurred())
 		fakeLocksmith.LockReturns(lockFile, nil)
+		fakeRefLocksmith = new(grootfakes.FakeLocksmith)
+		fakeRefLocksmith.TryLockReturns(lockFile, nil)
 
 		fakeStoreMeasurer = new(grootfakes.FakeStoreMeasurer)
 		fakeGarbageCollector = new(grootfakes.FakeGarbageCollector)
 		fakeMetricsEmitter = new(grootfakes.FakeMetricsEmitter)
 
-		cleaner = groot.IamCleaner(fakeLocksmith, fakeStoreMeasurer,
+		cleaner = groot.IamCleaner(fakeLocksmith, fakeRefLocksmith, fakeStoreMeasurer,
 			fakeGarbageCollector, fakeMetricsEmitter)
 		logger = lagertest.NewTestLogger("cleaner")
 	})
@@ -129,6 +132,81 @@ var _ = Describe("Cleaner", func() {
 			Expect(unLockTime.UnixNano()).To(BeNumerically("<", collectTime.UnixNano()))
 		})
 
+		Context("when there are unused volumes", func() {
+			BeforeEach(func() {
+				fakeGarbageCollector.UnusedVolumesReturns([]string{"vol-a", "vol-b"}, nil)
+			})
+
+			It("marks them while holding their reference locks", func() {
+				fakeGarbageCollector.MarkUnusedStub = func(_ lager.Logger, _ []string) error {
+					Expect(fakeRefLocksmith.UnlockCallCount()).To(Equal(0))
+					return nil
+				}
+
+				_, err := cleaner.Clean(logger, 0, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeRefLocksmith.TryLockCallCount()).To(Equal(2))
+				Expect(fakeRefLocksmith.TryLockArgsForCall(0)).To(Equal(groot.ReferenceLockKey("vol-a")))
+				Expect(fakeRefLocksmith.TryLockArgsForCall(1)).To(Equal(groot.ReferenceLockKey("vol-b")))
+				Expect(fakeRefLocksmith.UnlockCallCount()).To(Equal(2))
+
+				_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+				Expect(unusedVolumes).To(Equal([]string{"vol-a", "vol-b"}))
+			})
+
+			It("doesn't take the global lock exclusively", func() {
+				_, err := cleaner.Clean(logger, 0, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+				Expect(fakeRefLocksmith.LockCallCount()).To(Equal(0))
+			})
+
+			Context("when a create holds the reference lock of a volume", func() {
+				BeforeEach(func() {
+					fakeRefLocksmith.TryLockReturnsOnCall(0, nil, nil)
+				})
+
+				It("leaves it alone", func() {
+					_, err := cleaner.Clean(logger, 0, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+					Expect(unusedVolumes).To(Equal([]string{"vol-b"}))
+					Expect(fakeRefLocksmith.UnlockCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when an image started using a volume before its reference lock was taken", func() {
+				BeforeEach(func() {
+					fakeGarbageCollector.UnusedVolumesReturnsOnCall(1, []string{"vol-b"}, nil)
+				})
+
+				It("leaves it alone", func() {
+					_, err := cleaner.Clean(logger, 0, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+					Expect(unusedVolumes).To(Equal([]string{"vol-b"}))
+				})
+			})
+
+			Context("when checking the locked volumes again fails", func() {
+				BeforeEach(func() {
+					fakeGarbageCollector.UnusedVolumesReturnsOnCall(1, nil, errors.New("failed to list volumes"))
+				})
+
+				It("doesn't mark any volume", func() {
+					_, err := cleaner.Clean(logger, 0, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+					Expect(unusedVolumes).To(BeEmpty())
+				})
+			})
+		})
+
 		Context("when marking unused volumes fails", func() {
 			BeforeEach(func() {
 				fakeGarbageCollector.MarkUnusedReturns(errors.New("Failed to mark!"))
@@ -222,5 +300,110 @@ var _ = Describe("Cleaner", func() {
 				})
 			})
 		})
+
+		Context("when a low watermark is set", func() {
+			BeforeEach(func() {
+				cleaner = groot.IamCleaner(fakeLocksmith, fakeRefLocksmith, fakeStoreMeasurer,
+					fakeGarbageCollector, fakeMetricsEmitter).WithLowWatermark(600)
+
+				fakeStoreMeasurer.UsageReturns(1000, nil)
+				fakeStoreMeasurer.CacheUsageReturns(150)
+				fakeGarbageCollector.UnusedVolumesReturns([]string{"vol-a", "vol-b", "vol-c", "vol-d"}, nil)
+			})
+
+			It("only marks the least recently used volumes needed to get below it", func() {
+				_, err := cleaner.Clean(logger, 800, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeGarbageCollector.MarkUnusedCallCount()).To(Equal(1))
+				_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+				Expect(unusedVolumes).To(Equal([]string{"vol-a", "vol-b", "vol-c"}))
+			})
+
+			Context("when the store is already below it", func() {
+				BeforeEach(func() {
+					fakeStoreMeasurer.UsageReturns(500, nil)
+				})
+
+				It("doesn't mark any volume", func() {
+					_, err := cleaner.Clean(logger, 0, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeGarbageCollector.MarkUnusedCallCount()).To(Equal(1))
+					_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+					Expect(unusedVolumes).To(BeEmpty())
+				})
+			})
+
+			Context("when the unused volumes are not enough", func() {
+				BeforeEach(func() {
+					fakeStoreMeasurer.UsageReturns(2000, nil)
+				})
+
+				It("marks all of them", func() {
+					_, err := cleaner.Clean(logger, 800, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, unusedVolumes := fakeGarbageCollector.MarkUnusedArgsForCall(0)
+					Expect(unusedVolumes).To(HaveLen(4))
+				})
+			})
+		})
+	})
+
+	Describe("DryRun", func() {
+		BeforeEach(func() {
+			fakeGarbageCollector.UnusedVolumesReturns([]string{"vol-a", "vol-b"}, nil)
+		})
+
+		dryRun := func(threshold int64) (bool, []string, error) {
+			return groot.IamCleaner(fakeLocksmith, fakeRefLocksmith, fakeStoreMeasurer,
+				fakeGarbageCollector, fakeMetricsEmitter).DryRun(logger, threshold, []string{"preserve"})
+		}
+
+		It("returns the unused volumes under the global lock", func() {
+			noop, unusedVolumes, err := dryRun(0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(noop).To(BeFalse())
+			Expect(unusedVolumes).To(Equal([]string{"vol-a", "vol-b"}))
+
+			_, chainIDsToPreserve := fakeGarbageCollector.UnusedVolumesArgsForCall(0)
+			Expect(chainIDsToPreserve).To(ConsistOf("preserve"))
+			Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+		})
+
+		It("neither marks nor collects anything", func() {
+			_, _, err := dryRun(0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeGarbageCollector.MarkUnusedCallCount()).To(Equal(0))
+			Expect(fakeGarbageCollector.CollectCallCount()).To(Equal(0))
+		})
+
+		Context("when the store is under the threshold", func() {
+			BeforeEach(func() {
+				fakeStoreMeasurer.UsageReturns(100, nil)
+			})
+
+			It("indicates a no-op", func() {
+				noop, unusedVolumes, err := dryRun(1000)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(noop).To(BeTrue())
+				Expect(unusedVolumes).To(BeEmpty())
+				Expect(fakeGarbageCollector.UnusedVolumesCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when finding the unused volumes fails", func() {
+			BeforeEach(func() {
+				fakeGarbageCollector.UnusedVolumesReturns(nil, errors.New("failed to list volumes"))
+			})
+
+			It("returns the error", func() {
+				_, _, err := dryRun(0)
+				Expect(err).To(MatchError(ContainSubstring("failed to list volumes")))
+			})
+		})
 	})
 })
@@ -23,28 +23,32 @@ type CreateSpec struct {
 	CleanOnCreateThresholdBytes int64
 	UIDMappings                 []IDMappingSpec
 	GIDMappings                 []IDMappingSpec
+	OverflowUID                 int
+	OverflowGID                 int
+	UnpackLimits                UnpackLimits
+	Compression                 string
 }
 
 type Creator struct {
-	cleaner           Cleaner
-	imageCloner       ImageCloner
-	baseImagePuller   BaseImagePuller
-	locksmith         Locksmith
-	dependencyManager DependencyManager
-	metricsEmitter    MetricsEmitter
+	cleaner         Cleaner
+	imageCloner     ImageCloner
+	baseImagePuller BaseImagePuller
+	locksmith       Locksmith
+	imageRegistry   ImageRegistry
+	metricsEmitter  MetricsEmitter
 }
 
 func IamCreator(
 	imageCloner ImageCloner, baseImagePuller BaseImagePuller,
-	locksmith Locksmith, dependencyManager DependencyManager,
+	locksmith Locksmith, imageRegistry ImageRegistry,
 	metricsEmitter MetricsEmitter, cleaner Cleaner) *Creator {
 	return &Creator{
-		imageCloner:       imageCloner,
-		baseImagePuller:   baseImagePuller,
-		locksmith:         locksmith,
-		dependencyManager: dependencyManager,
-		metricsEmitter:    metricsEmitter,
-		cleaner:           cleaner,
+		imageCloner:     imageCloner,
+		baseImagePuller: baseImagePuller,
+		locksmith:       locksmith,
+		imageRegistry:   imageRegistry,
+		metricsEmitter:  metricsEmitter,
+		cleaner:         cleaner,
 	}
 }
 
@@ -74,8 +78,11 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (ImageInfo, error
 		ExcludeBaseImageFromQuota: spec.ExcludeBaseImageFromQuota,
 		UIDMappings:               spec.UIDMappings,
 		GIDMappings:               spec.GIDMappings,
+		OverflowUID:               spec.OverflowUID,
+		OverflowGID:               spec.OverflowGID,
 		OwnerUID:                  ownerUid,
 		OwnerGID:                  ownerGid,
+		UnpackLimits:              spec.UnpackLimits,
 	}
 
 	baseImageInfo, err := c.baseImagePuller.FetchBaseImageInfo(logger, baseImageSpec)
@@ -100,6 +107,15 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (ImageInfo, error
 		}
 	}()
 
+	// the reference locks keep clean from marking the volumes of the base
+	// image until the image is registered as using them, without waiting
+	// for creates of other images
+	referenceLockFiles, err := lockReferences(c.locksmith, baseImageChainIDs)
+	defer unlockReferences(logger, c.locksmith, referenceLockFiles)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+
 	if err := c.baseImagePuller.Pull(logger, baseImageInfo, baseImageSpec); err != nil {
 		return ImageInfo{}, errorspkg.Wrap(err, "pulling the image")
 	}
@@ -113,6 +129,7 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (ImageInfo, error
 		BaseImage:                 baseImageInfo.Config,
 		OwnerUID:                  ownerUid,
 		OwnerGID:                  ownerGid,
+		Compression:               spec.Compression,
 	}
 
 	image, err := c.imageCloner.Create(logger, imageSpec)
@@ -120,8 +137,13 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (ImageInfo, error
 		return ImageInfo{}, errorspkg.Wrap(err, "making image")
 	}
 
+	baseImage := ""
+	if spec.BaseImageURL != nil {
+		baseImage = spec.BaseImageURL.String()
+	}
+
 	imageRefName := fmt.Sprintf(ImageReferenceFormat, spec.ID)
-	if err := c.dependencyManager.Register(imageRefName, baseImageChainIDs); err != nil {
+	if err := c.imageRegistry.RegisterImage(logger, imageRefName, baseImageChainIDs, baseImage); err != nil {
 		if destroyErr := c.imageCloner.Destroy(logger, spec.ID); destroyErr != nil {
 			logger.Error("failed-to-destroy-image", destroyErr)
 		}
@@ -132,6 +154,27 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (ImageInfo, error
 	return image, nil
 }
 
+func lockReferences(locksmith Locksmith, chainIDs []string) ([]*os.File, error) {
+	lockFiles := []*os.File{}
+	for _, chainID := range chainIDs {
+		lockFile, err := locksmith.Lock(ReferenceLockKey(chainID))
+		if err != nil {
+			return lockFiles, errorspkg.Wrapf(err, "locking reference to `%s`", chainID)
+		}
+		lockFiles = append(lockFiles, lockFile)
+	}
+
+	return lockFiles, nil
+}
+
+func unlockReferences(logger lager.Logger, locksmith Locksmith, lockFiles []*os.File) {
+	for _, lockFile := range lockFiles {
+		if err := locksmith.Unlock(lockFile); err != nil {
+			logger.Error("failed-to-unlock-reference", err)
+		}
+	}
+}
+
 func chainIDs(layerInfos []LayerInfo) []string {
 	chainIDs := []string{}
 	for _, layerInfo := range layerInfos {
@@ -144,18 +187,14 @@ func (c *Creator) parseOwner(uidMappings, gidMappings []IDMappingSpec) (int, int
 	uid := os.Getuid()
 	gid := os.Getgid()
 
-	for _, mapping := range uidMappings {
-		if mapping.Size == 1 && mapping.NamespaceID == 0 {
-			uid = mapping.HostID
-			break
-		}
+	uidTable := NewIDMappingTable(uidMappings, DefaultOverflowID)
+	if hostID, ok := uidTable.Lookup(0); ok && !uidTable.Empty() {
+		uid = hostID
 	}
 
-	for _, mapping := range gidMappings {
-		if mapping.Size == 1 && mapping.NamespaceID == 0 {
-			gid = mapping.HostID
-			break
-		}
+	gidTable := NewIDMappingTable(gidMappings, DefaultOverflowID)
+	if hostID, ok := gidTable.Lookup(0); ok && !gidTable.Empty() {
+		gid = hostID
 	}
 
 	return uid, gid
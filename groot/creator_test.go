@@ -18,14 +18,14 @@ import (
 
 var _ = Describe("Creator", func() {
 	var (
-		baseImageUrl          *url.URL
-		fakeImageCloner       *grootfakes.FakeImageCloner
-		fakeBaseImagePuller   *grootfakes.FakeBaseImagePuller
-		fakeLocksmith         *grootfakes.FakeLocksmith
-		fakeDependencyManager *grootfakes.FakeDependencyManager
-		fakeMetricsEmitter    *grootfakes.FakeMetricsEmitter
-		fakeCleaner           *grootfakes.FakeCleaner
-		lockFile              *os.File
+		baseImageUrl        *url.URL
+		fakeImageCloner     *grootfakes.FakeImageCloner
+		fakeBaseImagePuller *grootfakes.FakeBaseImagePuller
+		fakeLocksmith       *grootfakes.FakeLocksmith
+		fakeImageRegistry   *grootfakes.FakeImageRegistry
+		fakeMetricsEmitter  *grootfakes.FakeMetricsEmitter
+		fakeCleaner         *grootfakes.FakeCleaner
+		lockFile            *os.File
 
 		creator *groot.Creator
 		logger  lager.Logger
@@ -41,7 +41,7 @@ var _ = Describe("Creator", func() {
 		fakeImageCloner = new(grootfakes.FakeImageCloner)
 		fakeBaseImagePuller = new(grootfakes.FakeBaseImagePuller)
 		fakeLocksmith = new(grootfakes.FakeLocksmith)
-		fakeDependencyManager = new(grootfakes.FakeDependencyManager)
+		fakeImageRegistry = new(grootfakes.FakeImageRegistry)
 		fakeMetricsEmitter = new(grootfakes.FakeMetricsEmitter)
 		fakeCleaner = new(grootfakes.FakeCleaner)
 
@@ -73,8 +73,7 @@ var _ = Describe("Creator", func() {
 
 		creator = groot.IamCreator(
 			fakeImageCloner, fakeBaseImagePuller, fakeLocksmith,
-			fakeDependencyManager, fakeMetricsEmitter,
-			fakeCleaner)
+			fakeImageRegistry, fakeMetricsEmitter, fakeCleaner)
 	})
 
 	JustBeforeEach(func() {
@@ -93,10 +92,31 @@ var _ = Describe("Creator", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.LockCallCount()).To(BeNumerically(">=", 1))
 			Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
 		})
 
+		It("locks the references to the volumes of the base image while pulling and registering", func() {
+			fakeBaseImagePuller.PullStub = func(_ lager.Logger, _ groot.BaseImageInfo, _ groot.BaseImageSpec) error {
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(3))
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(0))
+				return nil
+			}
+			fakeImageRegistry.RegisterImageStub = func(_ lager.Logger, _ string, _ []string, _ string) error {
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(0))
+				return nil
+			}
+
+			_, err := creator.Create(logger, groot.CreateSpec{
+				BaseImageURL: baseImageUrl,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeLocksmith.LockArgsForCall(1)).To(Equal(groot.ReferenceLockKey("id-1")))
+			Expect(fakeLocksmith.LockArgsForCall(2)).To(Equal(groot.ReferenceLockKey("id-2")))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(3))
+		})
+
 		Context("when clean up store is requested", func() {
 			It("cleans the store", func() {
 				_, err := creator.Create(logger, groot.CreateSpec{
@@ -130,10 +150,15 @@ var _ = Describe("Creator", func() {
 			uidMappings := []groot.IDMappingSpec{groot.IDMappingSpec{HostID: 2, NamespaceID: 0, Size: 1}}
 			gidMappings := []groot.IDMappingSpec{groot.IDMappingSpec{HostID: 3, NamespaceID: 0, Size: 1}}
 
+			unpackLimits := groot.UnpackLimits{MaxLayerBytes: 1024, MaxEntries: 10}
+
 			_, err := creator.Create(logger, groot.CreateSpec{
 				BaseImageURL: baseImageUrl,
 				UIDMappings:  uidMappings,
 				GIDMappings:  gidMappings,
+				OverflowUID:  65534,
+				OverflowGID:  65533,
+				UnpackLimits: unpackLimits,
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -146,6 +171,9 @@ var _ = Describe("Creator", func() {
 			Expect(imageSpec.GIDMappings).To(Equal(gidMappings))
 			Expect(imageSpec.OwnerUID).To(Equal(2))
 			Expect(imageSpec.OwnerGID).To(Equal(3))
+			Expect(imageSpec.OverflowUID).To(Equal(65534))
+			Expect(imageSpec.OverflowGID).To(Equal(65533))
+			Expect(imageSpec.UnpackLimits).To(Equal(unpackLimits))
 		})
 
 		It("makes an image", func() {
@@ -173,14 +201,26 @@ var _ = Describe("Creator", func() {
 			}))
 		})
 
+		It("makes the image with the given compression", func() {
+			_, err := creator.Create(logger, groot.CreateSpec{
+				ID:           "some-id",
+				BaseImageURL: baseImageUrl,
+				Compression:  "zlib",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, createImagerSpec := fakeImageCloner.CreateArgsForCall(0)
+			Expect(createImagerSpec.Compression).To(Equal("zlib"))
+		})
+
 		It("releases the global lock", func() {
 			_, err := creator.Create(logger, groot.CreateSpec{
 				BaseImageURL: baseImageUrl,
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
-			Expect(fakeLocksmith.UnlockArgsForCall(0)).To(Equal(lockFile))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(3))
+			Expect(fakeLocksmith.UnlockArgsForCall(2)).To(Equal(lockFile))
 		})
 
 		It("returns the image", func() {
@@ -236,6 +276,21 @@ var _ = Describe("Creator", func() {
 				Expect(createImagerSpec.OwnerGID).To(Equal(60))
 			})
 
+			Context("when root is mapped as part of a range", func() {
+				It("uses the start of the range", func() {
+					_, err := creator.Create(logger, groot.CreateSpec{
+						BaseImageURL: baseImageUrl,
+						UIDMappings:  []groot.IDMappingSpec{{HostID: 100000, NamespaceID: 0, Size: 65536}},
+						GIDMappings:  []groot.IDMappingSpec{{HostID: 200000, NamespaceID: 0, Size: 65536}},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					_, _, imageSpec := fakeBaseImagePuller.PullArgsForCall(0)
+					Expect(imageSpec.OwnerUID).To(Equal(100000))
+					Expect(imageSpec.OwnerGID).To(Equal(200000))
+				})
+			})
+
 			Context("when there's no root mapping", func() {
 				It("sets the current user as the store owner", func() {
 					_, err := creator.Create(logger, groot.CreateSpec{
@@ -345,6 +400,28 @@ var _ = Describe("Creator", func() {
 			})
 		})
 
+		Context("when locking a reference fails", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockReturnsOnCall(2, nil, errors.New("failed to lock"))
+			})
+
+			It("returns the error without pulling the image", func() {
+				_, err := creator.Create(logger, groot.CreateSpec{
+					BaseImageURL: baseImageUrl,
+				})
+				Expect(err).To(MatchError(ContainSubstring("locking reference to `id-2`: failed to lock")))
+				Expect(fakeBaseImagePuller.PullCallCount()).To(BeZero())
+			})
+
+			It("releases the locks it took", func() {
+				_, err := creator.Create(logger, groot.CreateSpec{
+					BaseImageURL: baseImageUrl,
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(2))
+			})
+		})
+
 		Context("when pulling the image fails", func() {
 			BeforeEach(func() {
 				pullError = errors.New("failed to pull image")
@@ -380,7 +457,7 @@ var _ = Describe("Creator", func() {
 
 		Context("when registering dependencies fails", func() {
 			BeforeEach(func() {
-				fakeDependencyManager.RegisterReturns(errors.New("failed to register dependencies"))
+				fakeImageRegistry.RegisterImageReturns(errors.New("failed to register dependencies"))
 			})
 
 			It("returns an errors", func() {
@@ -403,6 +480,20 @@ var _ = Describe("Creator", func() {
 			})
 		})
 
+		It("registers the image with the volumes and the base image it uses", func() {
+			_, err := creator.Create(logger, groot.CreateSpec{
+				ID:           "my-image",
+				BaseImageURL: baseImageUrl,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeImageRegistry.RegisterImageCallCount()).To(Equal(1))
+			_, id, chainIDs, baseImage := fakeImageRegistry.RegisterImageArgsForCall(0)
+			Expect(id).To(Equal("image:my-image"))
+			Expect(chainIDs).To(Equal([]string{"id-1", "id-2"}))
+			Expect(baseImage).To(Equal("/path/to/image"))
+		})
+
 		Context("when disk limit is given", func() {
 			It("passes the disk limit to the imageCloner", func() {
 				_, err := creator.Create(logger, groot.CreateSpec{
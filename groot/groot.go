@@ -11,6 +11,8 @@ import (
 
 const (
 	GlobalLockKey                      = "global-groot-lock"
+	ReferenceLockKeyPrefix             = "reference-"
+	TrashLockKey                       = "trash"
 	MetricImageCreationTime            = "ImageCreationTime"
 	MetricImageDeletionTime            = "ImageDeletionTime"
 	MetricImageStatsTime               = "ImageStatsTime"
@@ -28,6 +30,7 @@ const (
 //go:generate counterfeiter . StoreMeasurer
 //go:generate counterfeiter . RootFSConfigurer
 //go:generate counterfeiter . MetricsEmitter
+//go:generate counterfeiter . ImageRegistry
 
 type ImageInfo struct {
 	Rootfs string        `json:"rootfs"`
@@ -46,6 +49,10 @@ type MountInfo struct {
 type IDMappings struct {
 	UIDMappings []IDMappingSpec
 	GIDMappings []IDMappingSpec
+	// OverflowUID and OverflowGID are the host IDs given to the files whose
+	// IDs are not covered by the mappings.
+	OverflowUID int
+	OverflowGID int
 }
 
 type IDMappingSpec struct {
@@ -54,14 +61,31 @@ type IDMappingSpec struct {
 	Size        int
 }
 
+// UnpackLimits caps the resources a single pull may consume while layers are
+// being unpacked. A zero value disables the corresponding check. The byte
+// limits count the logical size of regular files, holes included, so that
+// they don't depend on how sparse the files end up on disk.
+type UnpackLimits struct {
+	MaxLayerBytes int64
+	// MaxImageBytes also counts the layers of the image that are already in
+	// the store.
+	MaxImageBytes int64
+	MaxEntries    int64
+	MaxPathLength int
+	MaxFileBytes  int64
+}
+
 type BaseImageSpec struct {
 	DiskLimit                 int64
 	ExcludeBaseImageFromQuota bool
 	BaseImageSrc              *url.URL
 	UIDMappings               []IDMappingSpec
 	GIDMappings               []IDMappingSpec
+	OverflowUID               int
+	OverflowGID               int
 	OwnerUID                  int
 	OwnerGID                  int
+	UnpackLimits              UnpackLimits
 }
 
 type LayerInfo struct {
@@ -94,6 +118,7 @@ type ImageSpec struct {
 	BaseImage                 specsv1.Image
 	OwnerUID                  int
 	OwnerGID                  int
+	Compression               string
 }
 
 type ImageCloner interface {
@@ -112,7 +137,22 @@ type DependencyManager interface {
 	Deregister(id string) error
 }
 
+// ImageRegistry registers the volumes an image depends on and records their
+// usage in one go, so that no image is left without either.
+type ImageRegistry interface {
+	RegisterImage(logger lager.Logger, id string, chainIDs []string, baseImage string) error
+}
+
+// VolumeUsage is when a volume was last used to create an image, and the
+// base image that image was created from.
+type VolumeUsage struct {
+	LastUsed  time.Time `json:"last_used"`
+	BaseImage string    `json:"base_image"`
+}
+
 type GarbageCollector interface {
+	// UnusedVolumes returns the least recently used volumes first when their
+	// usage is known.
 	UnusedVolumes(logger lager.Logger, chainIDsToPreserve []string) ([]string, error)
 	MarkUnused(logger lager.Logger, unusedVolumes []string) error
 	Collect(logger lager.Logger) error
@@ -125,19 +165,41 @@ type StoreMeasurer interface {
 
 type Locksmith interface {
 	Lock(key string) (*os.File, error)
+	// TryLock returns a nil file when the lock is held by someone else
+	TryLock(key string) (*os.File, error)
 	Unlock(lockFile *os.File) error
 }
 
+// ReferenceLockKey is the key of the lock creates hold, shared, on the volumes
+// of their base image, and clean takes exclusively to mark a volume unused.
+func ReferenceLockKey(chainID string) string {
+	return ReferenceLockKeyPrefix + chainID
+}
+
 type MetricsEmitter interface {
 	TryEmitUsage(logger lager.Logger, name string, usage int64, units string)
 	TryEmitDurationFrom(logger lager.Logger, name string, from time.Time)
 }
 
 type DiskUsage struct {
-	TotalBytesUsed     int64 `json:"total_bytes_used"`
-	ExclusiveBytesUsed int64 `json:"exclusive_bytes_used"`
+	TotalBytesUsed      int64 `json:"total_bytes_used"`
+	ExclusiveBytesUsed  int64 `json:"exclusive_bytes_used"`
+	LogicalBytesUsed    int64 `json:"logical_bytes_used"`
+	CompressedBytesUsed int64 `json:"compressed_bytes_used"`
 }
 
 type VolumeStats struct {
 	DiskUsage DiskUsage `json:"disk_usage"`
 }
+
+type StoreStats struct {
+	Layers DiskUsage  `json:"layers"`
+	Images DiskUsage  `json:"images"`
+	Unused DiskUsage  `json:"unused"`
+	Trash  TrashStats `json:"trash"`
+}
+
+type TrashStats struct {
+	Volumes            int   `json:"volumes"`
+	ExclusiveBytesUsed int64 `json:"exclusive_bytes_used"`
+}
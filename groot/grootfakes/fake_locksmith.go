@@ -22,6 +22,19 @@ type FakeLocksmith struct {
 		result1 *os.File
 		result2 error
 	}
+	TryLockStub        func(key string) (*os.File, error)
+	tryLockMutex       sync.RWMutex
+	tryLockArgsForCall []struct {
+		key string
+	}
+	tryLockReturns struct {
+		result1 *os.File
+		result2 error
+	}
+	tryLockReturnsOnCall map[int]struct {
+		result1 *os.File
+		result2 error
+	}
 	UnlockStub        func(lockFile *os.File) error
 	unlockMutex       sync.RWMutex
 	unlockArgsForCall []struct {
@@ -88,6 +101,57 @@ func (fake *FakeLocksmith) LockReturnsOnCall(i int, result1 *os.File, result2 er
 	}{result1, result2}
 }
 
+func (fake *FakeLocksmith) TryLock(key string) (*os.File, error) {
+	fake.tryLockMutex.Lock()
+	ret, specificReturn := fake.tryLockReturnsOnCall[len(fake.tryLockArgsForCall)]
+	fake.tryLockArgsForCall = append(fake.tryLockArgsForCall, struct {
+		key string
+	}{key})
+	fake.recordInvocation("TryLock", []interface{}{key})
+	fake.tryLockMutex.Unlock()
+	if fake.TryLockStub != nil {
+		return fake.TryLockStub(key)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.tryLockReturns.result1, fake.tryLockReturns.result2
+}
+
+func (fake *FakeLocksmith) TryLockCallCount() int {
+	fake.tryLockMutex.RLock()
+	defer fake.tryLockMutex.RUnlock()
+	return len(fake.tryLockArgsForCall)
+}
+
+func (fake *FakeLocksmith) TryLockArgsForCall(i int) string {
+	fake.tryLockMutex.RLock()
+	defer fake.tryLockMutex.RUnlock()
+	return fake.tryLockArgsForCall[i].key
+}
+
+func (fake *FakeLocksmith) TryLockReturns(result1 *os.File, result2 error) {
+	fake.TryLockStub = nil
+	fake.tryLockReturns = struct {
+		result1 *os.File
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeLocksmith) TryLockReturnsOnCall(i int, result1 *os.File, result2 error) {
+	fake.TryLockStub = nil
+	if fake.tryLockReturnsOnCall == nil {
+		fake.tryLockReturnsOnCall = make(map[int]struct {
+			result1 *os.File
+			result2 error
+		})
+	}
+	fake.tryLockReturnsOnCall[i] = struct {
+		result1 *os.File
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeLocksmith) Unlock(lockFile *os.File) error {
 	fake.unlockMutex.Lock()
 	ret, specificReturn := fake.unlockReturnsOnCall[len(fake.unlockArgsForCall)]
@@ -141,6 +205,8 @@ func (fake *FakeLocksmith) Invocations() map[string][][]interface{} {
 	defer fake.invocationsMutex.RUnlock()
 	fake.lockMutex.RLock()
 	defer fake.lockMutex.RUnlock()
+	fake.tryLockMutex.RLock()
+	defer fake.tryLockMutex.RUnlock()
 	fake.unlockMutex.RLock()
 	defer fake.unlockMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
@@ -0,0 +1,97 @@
+package groot // import "github.com/SUSE/groot-btrfs/groot"
+
+import (
+	"fmt"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+// DefaultOverflowID is the ID given to files owned by an ID that is not
+// covered by any mapping. It matches the kernel's default overflowuid and
+// overflowgid.
+const DefaultOverflowID = 65534
+
+// IDMappingTable translates IDs inside a user namespace to IDs on the host.
+// Each mapping covers the namespace IDs [NamespaceID, NamespaceID+Size) and
+// maps them onto [HostID, HostID+Size). An empty table is the identity.
+type IDMappingTable struct {
+	mappings   []IDMappingSpec
+	overflowID int
+}
+
+func NewIDMappingTable(mappings []IDMappingSpec, overflowID int) IDMappingTable {
+	return IDMappingTable{
+		mappings:   mappings,
+		overflowID: overflowID,
+	}
+}
+
+// Lookup returns the host ID for the given namespace ID and whether the ID is
+// covered by the table. IDs outside of every mapping translate to the overflow
+// ID.
+func (t IDMappingTable) Lookup(namespaceID int) (int, bool) {
+	if len(t.mappings) == 0 {
+		return namespaceID, true
+	}
+
+	for _, mapping := range t.mappings {
+		if namespaceID >= mapping.NamespaceID && namespaceID < mapping.NamespaceID+mapping.Size {
+			return mapping.HostID + namespaceID - mapping.NamespaceID, true
+		}
+	}
+
+	return t.overflowID, false
+}
+
+// HostID is like Lookup, without reporting whether the ID was mapped.
+func (t IDMappingTable) HostID(namespaceID int) int {
+	hostID, _ := t.Lookup(namespaceID)
+	return hostID
+}
+
+// NamespaceID is the reverse of Lookup. It returns the namespace ID that maps to
+// the given host ID and whether there is one.
+func (t IDMappingTable) NamespaceID(hostID int) (int, bool) {
+	if len(t.mappings) == 0 {
+		return hostID, true
+	}
+
+	for _, mapping := range t.mappings {
+		if hostID >= mapping.HostID && hostID < mapping.HostID+mapping.Size {
+			return mapping.NamespaceID + hostID - mapping.HostID, true
+		}
+	}
+
+	return 0, false
+}
+
+func (t IDMappingTable) Empty() bool {
+	return len(t.mappings) == 0
+}
+
+// ValidateIDMappings checks that every mapping has a positive size and that no two
+// of them overlap, neither inside the namespace nor on the host.
+func ValidateIDMappings(mappings []IDMappingSpec) error {
+	for i, mapping := range mappings {
+		if mapping.Size <= 0 {
+			return errorspkg.Errorf("mapping %s must have a positive size", formatIDMapping(mapping))
+		}
+
+		for _, other := range mappings[i+1:] {
+			if rangesOverlap(mapping.NamespaceID, other.NamespaceID, mapping.Size, other.Size) ||
+				rangesOverlap(mapping.HostID, other.HostID, mapping.Size, other.Size) {
+				return errorspkg.Errorf("mappings %s and %s overlap", formatIDMapping(mapping), formatIDMapping(other))
+			}
+		}
+	}
+
+	return nil
+}
+
+func rangesOverlap(start, otherStart, size, otherSize int) bool {
+	return start < otherStart+otherSize && otherStart < start+size
+}
+
+func formatIDMapping(mapping IDMappingSpec) string {
+	return fmt.Sprintf("%d:%d:%d", mapping.NamespaceID, mapping.HostID, mapping.Size)
+}
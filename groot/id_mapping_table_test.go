@@ -0,0 +1,113 @@
+package groot_test
+
+import (
+	"github.com/SUSE/groot-btrfs/groot"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IDMappingTable", func() {
+	var table groot.IDMappingTable
+
+	BeforeEach(func() {
+		table = groot.NewIDMappingTable([]groot.IDMappingSpec{
+			{HostID: 1000, NamespaceID: 0, Size: 1},
+			{HostID: 100000, NamespaceID: 1, Size: 999},
+			{HostID: 300000, NamespaceID: 5000, Size: 10},
+		}, 65534)
+	})
+
+	It("maps single id mappings", func() {
+		hostID, ok := table.Lookup(0)
+		Expect(ok).To(BeTrue())
+		Expect(hostID).To(Equal(1000))
+	})
+
+	It("maps ids relative to the start of their range", func() {
+		Expect(table.HostID(1)).To(Equal(100000))
+		Expect(table.HostID(999)).To(Equal(100998))
+		Expect(table.HostID(5000)).To(Equal(300000))
+		Expect(table.HostID(5009)).To(Equal(300009))
+	})
+
+	It("translates ids outside of every range to the overflow id", func() {
+		hostID, ok := table.Lookup(1000)
+		Expect(ok).To(BeFalse())
+		Expect(hostID).To(Equal(65534))
+		Expect(table.HostID(5010)).To(Equal(65534))
+	})
+
+	It("maps host ids back to the namespace", func() {
+		namespaceID, ok := table.NamespaceID(1000)
+		Expect(ok).To(BeTrue())
+		Expect(namespaceID).To(Equal(0))
+
+		namespaceID, ok = table.NamespaceID(100998)
+		Expect(ok).To(BeTrue())
+		Expect(namespaceID).To(Equal(999))
+
+		_, ok = table.NamespaceID(200000)
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("when a range covers root", func() {
+		BeforeEach(func() {
+			table = groot.NewIDMappingTable([]groot.IDMappingSpec{
+				{HostID: 100000, NamespaceID: 0, Size: 65536},
+			}, 65534)
+		})
+
+		It("maps root to the start of the range", func() {
+			Expect(table.HostID(0)).To(Equal(100000))
+			Expect(table.HostID(65535)).To(Equal(165535))
+			Expect(table.HostID(65536)).To(Equal(65534))
+		})
+	})
+
+	Context("when there are no mappings", func() {
+		BeforeEach(func() {
+			table = groot.NewIDMappingTable(nil, 65534)
+		})
+
+		It("returns the same ids", func() {
+			Expect(table.Empty()).To(BeTrue())
+			hostID, ok := table.Lookup(1234)
+			Expect(ok).To(BeTrue())
+			Expect(hostID).To(Equal(1234))
+		})
+	})
+})
+
+var _ = Describe("ValidateIDMappings", func() {
+	It("accepts disjoint mappings", func() {
+		Expect(groot.ValidateIDMappings([]groot.IDMappingSpec{
+			{HostID: 1000, NamespaceID: 0, Size: 1},
+			{HostID: 100000, NamespaceID: 1, Size: 65536},
+			{HostID: 300000, NamespaceID: 65537, Size: 1000},
+		})).To(Succeed())
+	})
+
+	It("rejects mappings that overlap in the namespace", func() {
+		err := groot.ValidateIDMappings([]groot.IDMappingSpec{
+			{HostID: 100000, NamespaceID: 1, Size: 100},
+			{HostID: 300000, NamespaceID: 100, Size: 100},
+		})
+		Expect(err).To(MatchError("mappings 1:100000:100 and 100:300000:100 overlap"))
+	})
+
+	It("rejects mappings that overlap on the host", func() {
+		err := groot.ValidateIDMappings([]groot.IDMappingSpec{
+			{HostID: 100000, NamespaceID: 0, Size: 1},
+			{HostID: 99000, NamespaceID: 1, Size: 1001},
+		})
+		Expect(err).To(MatchError("mappings 0:100000:1 and 1:99000:1001 overlap"))
+	})
+
+	It("rejects empty mappings", func() {
+		err := groot.ValidateIDMappings([]groot.IDMappingSpec{
+			{HostID: 100000, NamespaceID: 0, Size: 0},
+		})
+		Expect(err).To(MatchError("mapping 0:100000:0 must have a positive size"))
+	})
+})
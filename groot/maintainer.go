@@ -0,0 +1,210 @@
+package groot
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	MetricStoreScrubTime              = "StoreScrubTime"
+	MetricStoreScrubErrors            = "StoreScrubErrors"
+	MetricStoreBalanceTime            = "StoreBalanceTime"
+	MetricStoreBalanceRelocatedChunks = "StoreBalanceRelocatedChunks"
+	MetricStoreQuotaRescanTime        = "StoreQuotaRescanTime"
+
+	// BalanceAllChunks balances every chunk instead of only the ones below a
+	// usage filter
+	BalanceAllChunks = -1
+)
+
+//go:generate counterfeiter . MaintenanceDriver
+type MaintenanceDriver interface {
+	Scrub(logger lager.Logger) (ScrubResult, error)
+	Balance(logger lager.Logger, usageFilter int) (BalanceResult, error)
+	QuotaRescan(logger lager.Logger) (QuotaRescanResult, error)
+	MaintenanceStatus(logger lager.Logger) (MaintenanceReport, error)
+}
+
+type MaintenanceSpec struct {
+	Scrub              bool
+	Balance            bool
+	BalanceUsageFilter int
+	QuotaRescan        bool
+}
+
+type ScrubResult struct {
+	DataBytesScrubbed   uint64  `json:"data_bytes_scrubbed"`
+	TreeBytesScrubbed   uint64  `json:"tree_bytes_scrubbed"`
+	ReadErrors          uint64  `json:"read_errors"`
+	CsumErrors          uint64  `json:"csum_errors"`
+	VerifyErrors        uint64  `json:"verify_errors"`
+	SuperErrors         uint64  `json:"super_errors"`
+	CorrectedErrors     uint64  `json:"corrected_errors"`
+	UncorrectableErrors uint64  `json:"uncorrectable_errors"`
+	DurationSeconds     float64 `json:"duration_seconds,omitempty"`
+}
+
+type BalanceResult struct {
+	UsageFilter      int     `json:"usage_filter"`
+	ExpectedChunks   uint64  `json:"expected_chunks"`
+	ConsideredChunks uint64  `json:"considered_chunks"`
+	RelocatedChunks  uint64  `json:"relocated_chunks"`
+	DurationSeconds  float64 `json:"duration_seconds,omitempty"`
+}
+
+type QuotaRescanResult struct {
+	// Progress is the last object a running rescan has accounted
+	Progress        uint64  `json:"progress,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// MaintenanceReport holds the results of the operations that were run, or the
+// progress of the ones that are running.
+type MaintenanceReport struct {
+	Scrub       *ScrubResult       `json:"scrub,omitempty"`
+	Balance     *BalanceResult     `json:"balance,omitempty"`
+	QuotaRescan *QuotaRescanResult `json:"quota_rescan,omitempty"`
+}
+
+func (r ScrubResult) Errors() uint64 {
+	return r.ReadErrors + r.CsumErrors + r.VerifyErrors + r.SuperErrors
+}
+
+type Maintainer struct {
+	driver             MaintenanceDriver
+	sharedLocksmith    Locksmith
+	exclusiveLocksmith Locksmith
+	metricsEmitter     MetricsEmitter
+}
+
+// IamMaintainer builds a Maintainer. Scrubbing only reads the store and takes
+// no lock. Balancing holds the shared lock, so that images keep being created
+// but the store is not cleaned, resized or deleted meanwhile. Quota rescans
+// hold the exclusive lock, since new volumes would skew the numbers.
+func IamMaintainer(driver MaintenanceDriver, sharedLocksmith, exclusiveLocksmith Locksmith,
+	metricsEmitter MetricsEmitter,
+) *Maintainer {
+	return &Maintainer{
+		driver:             driver,
+		sharedLocksmith:    sharedLocksmith,
+		exclusiveLocksmith: exclusiveLocksmith,
+		metricsEmitter:     metricsEmitter,
+	}
+}
+
+// Maintain runs the operations of the spec one after the other and stops at
+// the first one that fails. A scrub that finds uncorrectable errors fails.
+func (m *Maintainer) Maintain(logger lager.Logger, spec MaintenanceSpec) (MaintenanceReport, error) {
+	logger = logger.Session("groot-maintaining", lager.Data{"spec": spec})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	report := MaintenanceReport{}
+
+	if spec.Scrub {
+		result, err := m.scrub(logger)
+		if err != nil {
+			return report, err
+		}
+		report.Scrub = &result
+
+		if result.UncorrectableErrors > 0 {
+			return report, errorspkg.Errorf("scrub found %d uncorrectable errors", result.UncorrectableErrors)
+		}
+	}
+
+	if spec.Balance {
+		result, err := m.balance(logger, spec.BalanceUsageFilter)
+		if err != nil {
+			return report, err
+		}
+		report.Balance = &result
+	}
+
+	if spec.QuotaRescan {
+		result, err := m.quotaRescan(logger)
+		if err != nil {
+			return report, err
+		}
+		report.QuotaRescan = &result
+	}
+
+	return report, nil
+}
+
+// Status reports the progress of the operations running on the store.
+func (m *Maintainer) Status(logger lager.Logger) (MaintenanceReport, error) {
+	logger = logger.Session("groot-maintenance-status")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	report, err := m.driver.MaintenanceStatus(logger)
+	if err != nil {
+		return MaintenanceReport{}, errorspkg.Wrap(err, "reading maintenance status")
+	}
+
+	return report, nil
+}
+
+func (m *Maintainer) scrub(logger lager.Logger) (ScrubResult, error) {
+	defer m.metricsEmitter.TryEmitDurationFrom(logger, MetricStoreScrubTime, time.Now())
+	start := time.Now()
+
+	result, err := m.driver.Scrub(logger)
+	if err != nil {
+		return ScrubResult{}, errorspkg.Wrap(err, "scrubbing store")
+	}
+	result.DurationSeconds = time.Since(start).Seconds()
+
+	m.metricsEmitter.TryEmitUsage(logger, MetricStoreScrubErrors, int64(result.Errors()), "errors")
+	return result, nil
+}
+
+func (m *Maintainer) balance(logger lager.Logger, usageFilter int) (BalanceResult, error) {
+	lockFile, err := m.sharedLocksmith.Lock(GlobalLockKey)
+	if err != nil {
+		return BalanceResult{}, errorspkg.Wrap(err, "acquiring lock")
+	}
+	defer func() {
+		if err := m.sharedLocksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-failed", err)
+		}
+	}()
+
+	defer m.metricsEmitter.TryEmitDurationFrom(logger, MetricStoreBalanceTime, time.Now())
+	start := time.Now()
+
+	result, err := m.driver.Balance(logger, usageFilter)
+	if err != nil {
+		return BalanceResult{}, errorspkg.Wrap(err, "balancing store")
+	}
+	result.DurationSeconds = time.Since(start).Seconds()
+
+	m.metricsEmitter.TryEmitUsage(logger, MetricStoreBalanceRelocatedChunks, int64(result.RelocatedChunks), "chunks")
+	return result, nil
+}
+
+func (m *Maintainer) quotaRescan(logger lager.Logger) (QuotaRescanResult, error) {
+	lockFile, err := m.exclusiveLocksmith.Lock(GlobalLockKey)
+	if err != nil {
+		return QuotaRescanResult{}, errorspkg.Wrap(err, "acquiring lock")
+	}
+	defer func() {
+		if err := m.exclusiveLocksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-failed", err)
+		}
+	}()
+
+	defer m.metricsEmitter.TryEmitDurationFrom(logger, MetricStoreQuotaRescanTime, time.Now())
+	start := time.Now()
+
+	result, err := m.driver.QuotaRescan(logger)
+	if err != nil {
+		return QuotaRescanResult{}, errorspkg.Wrap(err, "rescanning quotas")
+	}
+	result.DurationSeconds = time.Since(start).Seconds()
+
+	return result, nil
+}
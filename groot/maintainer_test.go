@@ -0,0 +1,236 @@
+package groot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Maintainer", func() {
+	var (
+		fakeDriver             *grootfakes.FakeMaintenanceDriver
+		fakeSharedLocksmith    *grootfakes.FakeLocksmith
+		fakeExclusiveLocksmith *grootfakes.FakeLocksmith
+		fakeMetricsEmitter     *grootfakes.FakeMetricsEmitter
+		lockFile               *os.File
+
+		maintainer *groot.Maintainer
+		logger     lager.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		lockFile, err = ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeDriver = new(grootfakes.FakeMaintenanceDriver)
+		fakeSharedLocksmith = new(grootfakes.FakeLocksmith)
+		fakeSharedLocksmith.LockReturns(lockFile, nil)
+		fakeExclusiveLocksmith = new(grootfakes.FakeLocksmith)
+		fakeExclusiveLocksmith.LockReturns(lockFile, nil)
+		fakeMetricsEmitter = new(grootfakes.FakeMetricsEmitter)
+
+		maintainer = groot.IamMaintainer(fakeDriver, fakeSharedLocksmith, fakeExclusiveLocksmith, fakeMetricsEmitter)
+		logger = lagertest.NewTestLogger("maintainer")
+	})
+
+	AfterEach(func() {
+		Expect(os.Remove(lockFile.Name())).To(Succeed())
+	})
+
+	Describe("Maintain", func() {
+		It("only runs the requested operations", func() {
+			report, err := maintainer.Maintain(logger, groot.MaintenanceSpec{QuotaRescan: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDriver.ScrubCallCount()).To(BeZero())
+			Expect(fakeDriver.BalanceCallCount()).To(BeZero())
+			Expect(fakeDriver.QuotaRescanCallCount()).To(Equal(1))
+			Expect(report.Scrub).To(BeNil())
+			Expect(report.Balance).To(BeNil())
+			Expect(report.QuotaRescan).NotTo(BeNil())
+		})
+
+		Describe("scrubbing", func() {
+			BeforeEach(func() {
+				fakeDriver.ScrubReturns(groot.ScrubResult{DataBytesScrubbed: 4096, CsumErrors: 2, CorrectedErrors: 2}, nil)
+			})
+
+			It("reports the result", func() {
+				report, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Scrub: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Scrub.DataBytesScrubbed).To(Equal(uint64(4096)))
+				Expect(report.Scrub.CorrectedErrors).To(Equal(uint64(2)))
+			})
+
+			It("does not take any lock", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Scrub: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeSharedLocksmith.LockCallCount()).To(BeZero())
+				Expect(fakeExclusiveLocksmith.LockCallCount()).To(BeZero())
+			})
+
+			It("emits the duration and the errors found", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Scrub: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeMetricsEmitter.TryEmitDurationFromCallCount()).To(Equal(1))
+				_, name, _ := fakeMetricsEmitter.TryEmitDurationFromArgsForCall(0)
+				Expect(name).To(Equal(groot.MetricStoreScrubTime))
+
+				Expect(fakeMetricsEmitter.TryEmitUsageCallCount()).To(Equal(1))
+				_, name, usage, units := fakeMetricsEmitter.TryEmitUsageArgsForCall(0)
+				Expect(name).To(Equal(groot.MetricStoreScrubErrors))
+				Expect(usage).To(Equal(int64(2)))
+				Expect(units).To(Equal("errors"))
+			})
+
+			Context("when the scrub finds uncorrectable errors", func() {
+				BeforeEach(func() {
+					fakeDriver.ScrubReturns(groot.ScrubResult{CsumErrors: 1, UncorrectableErrors: 1}, nil)
+				})
+
+				It("reports them and stops", func() {
+					report, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Scrub: true, Balance: true})
+					Expect(err).To(MatchError("scrub found 1 uncorrectable errors"))
+					Expect(report.Scrub.UncorrectableErrors).To(Equal(uint64(1)))
+					Expect(fakeDriver.BalanceCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when scrubbing fails", func() {
+				BeforeEach(func() {
+					fakeDriver.ScrubReturns(groot.ScrubResult{}, errors.New("no scrub"))
+				})
+
+				It("returns an error", func() {
+					_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Scrub: true})
+					Expect(err).To(MatchError(ContainSubstring("no scrub")))
+				})
+			})
+		})
+
+		Describe("balancing", func() {
+			BeforeEach(func() {
+				fakeDriver.BalanceReturns(groot.BalanceResult{UsageFilter: 50, ExpectedChunks: 3, RelocatedChunks: 3}, nil)
+			})
+
+			It("balances with the usage filter", func() {
+				report, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Balance: true, BalanceUsageFilter: 50})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeDriver.BalanceCallCount()).To(Equal(1))
+				_, usageFilter := fakeDriver.BalanceArgsForCall(0)
+				Expect(usageFilter).To(Equal(50))
+				Expect(report.Balance.RelocatedChunks).To(Equal(uint64(3)))
+			})
+
+			It("holds the shared lock", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Balance: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeSharedLocksmith.LockCallCount()).To(Equal(1))
+				Expect(fakeSharedLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+				Expect(fakeSharedLocksmith.UnlockCallCount()).To(Equal(1))
+				Expect(fakeExclusiveLocksmith.LockCallCount()).To(BeZero())
+			})
+
+			It("emits the relocated chunks", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Balance: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, name, usage, _ := fakeMetricsEmitter.TryEmitUsageArgsForCall(0)
+				Expect(name).To(Equal(groot.MetricStoreBalanceRelocatedChunks))
+				Expect(usage).To(Equal(int64(3)))
+			})
+
+			Context("when locking fails", func() {
+				BeforeEach(func() {
+					fakeSharedLocksmith.LockReturns(nil, errors.New("locked"))
+				})
+
+				It("does not balance", func() {
+					_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Balance: true})
+					Expect(err).To(MatchError(ContainSubstring("locked")))
+					Expect(fakeDriver.BalanceCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when balancing fails", func() {
+				BeforeEach(func() {
+					fakeDriver.BalanceReturns(groot.BalanceResult{}, errors.New("no space left"))
+				})
+
+				It("releases the lock and returns an error", func() {
+					_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{Balance: true, QuotaRescan: true})
+					Expect(err).To(MatchError(ContainSubstring("no space left")))
+					Expect(fakeSharedLocksmith.UnlockCallCount()).To(Equal(1))
+					Expect(fakeDriver.QuotaRescanCallCount()).To(BeZero())
+				})
+			})
+		})
+
+		Describe("rescanning quotas", func() {
+			It("holds the exclusive lock", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{QuotaRescan: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeExclusiveLocksmith.LockCallCount()).To(Equal(1))
+				Expect(fakeExclusiveLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+				Expect(fakeExclusiveLocksmith.UnlockCallCount()).To(Equal(1))
+				Expect(fakeSharedLocksmith.LockCallCount()).To(BeZero())
+			})
+
+			It("emits the duration", func() {
+				_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{QuotaRescan: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, name, _ := fakeMetricsEmitter.TryEmitDurationFromArgsForCall(0)
+				Expect(name).To(Equal(groot.MetricStoreQuotaRescanTime))
+			})
+
+			Context("when the rescan fails", func() {
+				BeforeEach(func() {
+					fakeDriver.QuotaRescanReturns(groot.QuotaRescanResult{}, errors.New("quotas disabled"))
+				})
+
+				It("returns an error", func() {
+					_, err := maintainer.Maintain(logger, groot.MaintenanceSpec{QuotaRescan: true})
+					Expect(err).To(MatchError(ContainSubstring("quotas disabled")))
+				})
+			})
+		})
+	})
+
+	Describe("Status", func() {
+		It("reports the progress of the running operations", func() {
+			fakeDriver.MaintenanceStatusReturns(groot.MaintenanceReport{
+				Balance: &groot.BalanceResult{ExpectedChunks: 10, RelocatedChunks: 4},
+			}, nil)
+
+			report, err := maintainer.Status(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Balance.RelocatedChunks).To(Equal(uint64(4)))
+			Expect(report.Scrub).To(BeNil())
+		})
+
+		Context("when the driver fails", func() {
+			BeforeEach(func() {
+				fakeDriver.MaintenanceStatusReturns(groot.MaintenanceReport{}, errors.New("failed"))
+			})
+
+			It("returns an error", func() {
+				_, err := maintainer.Status(logger)
+				Expect(err).To(MatchError(ContainSubstring("failed")))
+			})
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package groot
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+// PinReferencePrefix is the prefix of the dependencies of pinned base images.
+const PinReferencePrefix = "pin:"
+
+//go:generate counterfeiter . BaseImageInfoFetcher
+//go:generate counterfeiter . PinRegistry
+
+type BaseImageInfoFetcher interface {
+	BaseImageInfo(logger lager.Logger, baseImageURL *url.URL) (BaseImageInfo, error)
+}
+
+type PinRegistry interface {
+	Register(id string, chainIDs []string) error
+	Deregister(id string) error
+	Dependencies(id string) ([]string, error)
+	IDs() ([]string, error)
+}
+
+// Pin is a base image whose layers are never garbage collected.
+type Pin struct {
+	BaseImage string   `json:"base_image"`
+	ChainIDs  []string `json:"chain_ids"`
+}
+
+type Pinner struct {
+	fetcher     BaseImageInfoFetcher
+	pinRegistry PinRegistry
+	locksmith   Locksmith
+}
+
+func IamPinner(fetcher BaseImageInfoFetcher, pinRegistry PinRegistry, locksmith Locksmith) *Pinner {
+	return &Pinner{
+		fetcher:     fetcher,
+		pinRegistry: pinRegistry,
+		locksmith:   locksmith,
+	}
+}
+
+// Pin resolves the base image to the chain IDs of its layers and keeps them
+// from being collected. Pinning the same base image again resolves it again,
+// which is how a moved tag is followed.
+func (p *Pinner) Pin(logger lager.Logger, baseImageURL *url.URL) (Pin, error) {
+	logger = logger.Session("groot-pinning", lager.Data{"baseImageURL": baseImageURL.String()})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	baseImageInfo, err := p.fetcher.BaseImageInfo(logger, baseImageURL)
+	if err != nil {
+		return Pin{}, errorspkg.Wrap(err, "resolving base image")
+	}
+	pin := Pin{BaseImage: baseImageURL.String(), ChainIDs: chainIDs(baseImageInfo.LayerInfos)}
+
+	lockFile, err := p.locksmith.Lock(GlobalLockKey)
+	if err != nil {
+		return Pin{}, err
+	}
+	defer func() {
+		if err := p.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("failed-to-unlock", err)
+		}
+	}()
+
+	// like for creates, the reference locks keep clean from marking the
+	// volumes of the base image until the pin is registered
+	referenceLockFiles, err := lockReferences(p.locksmith, pin.ChainIDs)
+	defer unlockReferences(logger, p.locksmith, referenceLockFiles)
+	if err != nil {
+		return Pin{}, err
+	}
+
+	if err := p.pinRegistry.Register(PinReferencePrefix+pin.BaseImage, pin.ChainIDs); err != nil {
+		return Pin{}, errorspkg.Wrap(err, "registering pin")
+	}
+
+	return pin, nil
+}
+
+func (p *Pinner) Unpin(logger lager.Logger, baseImageURL *url.URL) error {
+	logger = logger.Session("groot-unpinning", lager.Data{"baseImageURL": baseImageURL.String()})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	err := p.pinRegistry.Deregister(PinReferencePrefix + baseImageURL.String())
+	if os.IsNotExist(errorspkg.Cause(err)) {
+		return errorspkg.Errorf("base image `%s` is not pinned", baseImageURL.String())
+	}
+	if err != nil {
+		return errorspkg.Wrap(err, "deregistering pin")
+	}
+
+	return nil
+}
+
+// Pins lists the pinned base images, sorted by name.
+func (p *Pinner) Pins(logger lager.Logger) ([]Pin, error) {
+	logger = logger.Session("groot-listing-pins")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	ids, err := p.pinRegistry.IDs()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing pins")
+	}
+
+	pins := []Pin{}
+	for _, id := range ids {
+		if !strings.HasPrefix(id, PinReferencePrefix) {
+			continue
+		}
+
+		chainIDs, err := p.pinRegistry.Dependencies(id)
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "reading pin `%s`", id)
+		}
+
+		pins = append(pins, Pin{BaseImage: strings.TrimPrefix(id, PinReferencePrefix), ChainIDs: chainIDs})
+	}
+
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].BaseImage < pins[j].BaseImage
+	})
+
+	return pins, nil
+}
@@ -0,0 +1,178 @@
+package groot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pinner", func() {
+	var (
+		fakeFetcher     *grootfakes.FakeBaseImageInfoFetcher
+		fakePinRegistry *grootfakes.FakePinRegistry
+		fakeLocksmith   *grootfakes.FakeLocksmith
+		lockFile        *os.File
+		baseImageURL    *url.URL
+
+		pinner *groot.Pinner
+		logger lager.Logger
+	)
+
+	BeforeEach(func() {
+		fakeFetcher = new(grootfakes.FakeBaseImageInfoFetcher)
+		fakePinRegistry = new(grootfakes.FakePinRegistry)
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+
+		var err error
+		lockFile, err = ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+		fakeLocksmith.LockReturns(lockFile, nil)
+
+		fakeFetcher.BaseImageInfoReturns(groot.BaseImageInfo{
+			LayerInfos: []groot.LayerInfo{{ChainID: "chain-1"}, {ChainID: "chain-2"}},
+		}, nil)
+
+		baseImageURL, err = url.Parse("docker:///cloudfoundry/cflinuxfs3")
+		Expect(err).NotTo(HaveOccurred())
+
+		pinner = groot.IamPinner(fakeFetcher, fakePinRegistry, fakeLocksmith)
+		logger = lagertest.NewTestLogger("pinner")
+	})
+
+	AfterEach(func() {
+		Expect(os.Remove(lockFile.Name())).To(Succeed())
+	})
+
+	Describe("Pin", func() {
+		It("registers the chain ids of the base image under a pin reference", func() {
+			pin, err := pinner.Pin(logger, baseImageURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pin).To(Equal(groot.Pin{
+				BaseImage: "docker:///cloudfoundry/cflinuxfs3",
+				ChainIDs:  []string{"chain-1", "chain-2"},
+			}))
+
+			Expect(fakePinRegistry.RegisterCallCount()).To(Equal(1))
+			id, chainIDs := fakePinRegistry.RegisterArgsForCall(0)
+			Expect(id).To(Equal("pin:docker:///cloudfoundry/cflinuxfs3"))
+			Expect(chainIDs).To(Equal([]string{"chain-1", "chain-2"}))
+		})
+
+		It("registers under the global lock and the reference locks of the chain ids", func() {
+			fakePinRegistry.RegisterStub = func(_ string, _ []string) error {
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(3))
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(0))
+				return nil
+			}
+
+			_, err := pinner.Pin(logger, baseImageURL)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(fakeLocksmith.LockArgsForCall(1)).To(Equal(groot.ReferenceLockKey("chain-1")))
+			Expect(fakeLocksmith.LockArgsForCall(2)).To(Equal(groot.ReferenceLockKey("chain-2")))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(3))
+		})
+
+		Context("when locking a reference fails", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockStub = func(key string) (*os.File, error) {
+					if key == groot.ReferenceLockKey("chain-2") {
+						return nil, errors.New("lock timed out")
+					}
+					return lockFile, nil
+				}
+			})
+
+			It("returns the error without registering anything", func() {
+				_, err := pinner.Pin(logger, baseImageURL)
+				Expect(err).To(MatchError(ContainSubstring("lock timed out")))
+				Expect(fakePinRegistry.RegisterCallCount()).To(Equal(0))
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(2))
+			})
+		})
+
+		Context("when resolving the base image fails", func() {
+			BeforeEach(func() {
+				fakeFetcher.BaseImageInfoReturns(groot.BaseImageInfo{}, errors.New("no such image"))
+			})
+
+			It("returns the error without registering anything", func() {
+				_, err := pinner.Pin(logger, baseImageURL)
+				Expect(err).To(MatchError(ContainSubstring("no such image")))
+				Expect(fakePinRegistry.RegisterCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when registering fails", func() {
+			BeforeEach(func() {
+				fakePinRegistry.RegisterReturns(errors.New("disk full"))
+			})
+
+			It("returns the error", func() {
+				_, err := pinner.Pin(logger, baseImageURL)
+				Expect(err).To(MatchError(ContainSubstring("disk full")))
+			})
+		})
+	})
+
+	Describe("Unpin", func() {
+		It("deregisters the pin", func() {
+			Expect(pinner.Unpin(logger, baseImageURL)).To(Succeed())
+
+			Expect(fakePinRegistry.DeregisterCallCount()).To(Equal(1))
+			Expect(fakePinRegistry.DeregisterArgsForCall(0)).To(Equal("pin:docker:///cloudfoundry/cflinuxfs3"))
+		})
+
+		Context("when the base image is not pinned", func() {
+			BeforeEach(func() {
+				fakePinRegistry.DeregisterReturns(&os.PathError{Op: "remove", Path: "pin", Err: os.ErrNotExist})
+			})
+
+			It("returns an error", func() {
+				Expect(pinner.Unpin(logger, baseImageURL)).To(MatchError("base image `docker:///cloudfoundry/cflinuxfs3` is not pinned"))
+			})
+		})
+	})
+
+	Describe("Pins", func() {
+		BeforeEach(func() {
+			fakePinRegistry.IDsReturns([]string{"pin:docker:///ubuntu", "image:my-image", "pin:docker:///alpine"}, nil)
+			fakePinRegistry.DependenciesStub = func(id string) ([]string, error) {
+				return map[string][]string{
+					"pin:docker:///ubuntu": {"chain-u"},
+					"pin:docker:///alpine": {"chain-a"},
+				}[id], nil
+			}
+		})
+
+		It("lists the pinned base images only", func() {
+			pins, err := pinner.Pins(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pins).To(Equal([]groot.Pin{
+				{BaseImage: "docker:///alpine", ChainIDs: []string{"chain-a"}},
+				{BaseImage: "docker:///ubuntu", ChainIDs: []string{"chain-u"}},
+			}))
+		})
+
+		Context("when listing fails", func() {
+			BeforeEach(func() {
+				fakePinRegistry.IDsReturns(nil, errors.New("permission denied"))
+			})
+
+			It("returns the error", func() {
+				_, err := pinner.Pins(logger)
+				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			})
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package groot
+
+import (
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+//go:generate counterfeiter . QuotaStatser
+type QuotaStatser interface {
+	StoreStats(logger lager.Logger) (StoreStats, error)
+	VolumeUsage(logger lager.Logger, id string) (DiskUsage, error)
+}
+
+// QgroupMeasurer measures the store through its btrfs quota groups. Unlike
+// statfs it only accounts for the data of the store, and unlike the volume
+// metadata it knows which extents volumes share.
+type QgroupMeasurer struct {
+	quotaStatser QuotaStatser
+}
+
+func NewQgroupMeasurer(quotaStatser QuotaStatser) *QgroupMeasurer {
+	return &QgroupMeasurer{
+		quotaStatser: quotaStatser,
+	}
+}
+
+// Usage is every byte referenced by the layer cache, plus the bytes only the
+// images reference.
+func (m *QgroupMeasurer) Usage(logger lager.Logger) (int64, error) {
+	logger = logger.Session("measuring-store-qgroups")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	stats, err := m.quotaStatser.StoreStats(logger)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "fetching store qgroup stats")
+	}
+
+	used := stats.Layers.TotalBytesUsed + stats.Images.ExclusiveBytesUsed
+	logger.Debug("store-usage", lager.Data{"bytes": used})
+	return used, nil
+}
+
+// CacheUsage is the space deleting the unused volumes would free at least.
+func (m *QgroupMeasurer) CacheUsage(logger lager.Logger, unusedVolumes []string) int64 {
+	return m.unusedUsage(logger, unusedVolumes).ExclusiveBytesUsed
+}
+
+// Stats reports the referenced and exclusive bytes of the layer cache, of the
+// images and of the unused volumes.
+func (m *QgroupMeasurer) Stats(logger lager.Logger, unusedVolumes []string) (StoreStats, error) {
+	logger = logger.Session("fetching-store-qgroup-stats")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	stats, err := m.quotaStatser.StoreStats(logger)
+	if err != nil {
+		return StoreStats{}, errorspkg.Wrap(err, "fetching store qgroup stats")
+	}
+	stats.Unused = m.unusedUsage(logger, unusedVolumes)
+
+	return stats, nil
+}
+
+// unusedUsage adds up the qgroups of the unused volumes. Extents only shared
+// between unused volumes are exclusive to none of them, which makes the
+// exclusive bytes a lower bound of what collecting them reclaims.
+func (m *QgroupMeasurer) unusedUsage(logger lager.Logger, unusedVolumes []string) DiskUsage {
+	var usage DiskUsage
+	for _, volume := range unusedVolumes {
+		volumeUsage, err := m.quotaStatser.VolumeUsage(logger, volume)
+		if err != nil {
+			logger.Error("fetching-volume-usage-failed", err, lager.Data{"volume": volume})
+			continue
+		}
+		usage.TotalBytesUsed += volumeUsage.TotalBytesUsed
+		usage.ExclusiveBytesUsed += volumeUsage.ExclusiveBytesUsed
+	}
+
+	return usage
+}
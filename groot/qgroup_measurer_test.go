@@ -0,0 +1,93 @@
+package groot_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QgroupMeasurer", func() {
+	var (
+		fakeQuotaStatser *grootfakes.FakeQuotaStatser
+		measurer         *groot.QgroupMeasurer
+		logger           lager.Logger
+	)
+
+	BeforeEach(func() {
+		fakeQuotaStatser = new(grootfakes.FakeQuotaStatser)
+		fakeQuotaStatser.StoreStatsReturns(groot.StoreStats{
+			Layers: groot.DiskUsage{TotalBytesUsed: 3000, ExclusiveBytesUsed: 1000},
+			Images: groot.DiskUsage{TotalBytesUsed: 2500, ExclusiveBytesUsed: 500},
+		}, nil)
+		fakeQuotaStatser.VolumeUsageStub = func(_ lager.Logger, id string) (groot.DiskUsage, error) {
+			switch id {
+			case "volume-1":
+				return groot.DiskUsage{TotalBytesUsed: 200, ExclusiveBytesUsed: 100}, nil
+			case "volume-2":
+				return groot.DiskUsage{TotalBytesUsed: 400, ExclusiveBytesUsed: 50}, nil
+			}
+			return groot.DiskUsage{}, errors.New("no such volume")
+		}
+
+		measurer = groot.NewQgroupMeasurer(fakeQuotaStatser)
+		logger = lagertest.NewTestLogger("qgroup-measurer")
+	})
+
+	Describe("Usage", func() {
+		It("returns the bytes referenced by the layers and the bytes exclusive to the images", func() {
+			usage, err := measurer.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(Equal(int64(3500)))
+		})
+
+		Context("when fetching the store stats fails", func() {
+			BeforeEach(func() {
+				fakeQuotaStatser.StoreStatsReturns(groot.StoreStats{}, errors.New("quotas are not enabled"))
+			})
+
+			It("returns an error", func() {
+				_, err := measurer.Usage(logger)
+				Expect(err).To(MatchError(ContainSubstring("quotas are not enabled")))
+			})
+		})
+	})
+
+	Describe("CacheUsage", func() {
+		It("returns the exclusive bytes of the unused volumes", func() {
+			Expect(measurer.CacheUsage(logger, []string{"volume-1", "volume-2"})).To(Equal(int64(150)))
+		})
+
+		It("skips volumes it fails to measure", func() {
+			Expect(measurer.CacheUsage(logger, []string{"volume-1", "volume-3"})).To(Equal(int64(100)))
+		})
+	})
+
+	Describe("Stats", func() {
+		It("reports the layers, the images and the unused volumes", func() {
+			stats, err := measurer.Stats(logger, []string{"volume-1", "volume-2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(Equal(groot.StoreStats{
+				Layers: groot.DiskUsage{TotalBytesUsed: 3000, ExclusiveBytesUsed: 1000},
+				Images: groot.DiskUsage{TotalBytesUsed: 2500, ExclusiveBytesUsed: 500},
+				Unused: groot.DiskUsage{TotalBytesUsed: 600, ExclusiveBytesUsed: 150},
+			}))
+		})
+
+		Context("when fetching the store stats fails", func() {
+			BeforeEach(func() {
+				fakeQuotaStatser.StoreStatsReturns(groot.StoreStats{}, errors.New("quotas are not enabled"))
+			})
+
+			It("returns an error", func() {
+				_, err := measurer.Stats(logger, nil)
+				Expect(err).To(MatchError(ContainSubstring("quotas are not enabled")))
+			})
+		})
+	})
+})
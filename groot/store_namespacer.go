@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"syscall"
 
 	"github.com/SUSE/groot-btrfs/store"
 
@@ -23,6 +24,10 @@ type StoreNamespacer struct {
 type mappings struct {
 	UIDMappings []string `json:"uid-mappings"`
 	GIDMappings []string `json:"gid-mappings"`
+	// the overflow IDs are missing from the files of older stores, which
+	// used DefaultOverflowID
+	OverflowUID *int `json:"overflow-uid,omitempty"`
+	OverflowGID *int `json:"overflow-gid,omitempty"`
 }
 
 func NewStoreNamespacer(storePath string) *StoreNamespacer {
@@ -31,19 +36,73 @@ func NewStoreNamespacer(storePath string) *StoreNamespacer {
 	}
 }
 
-func (n *StoreNamespacer) ApplyMappings(uidMappings, gidMappings []IDMappingSpec) error {
+func (n *StoreNamespacer) ApplyMappings(idMappings IDMappings) error {
+	if err := validateMappings(idMappings); err != nil {
+		return err
+	}
+
 	namespaceFilePath := n.namespaceFilePath()
 
 	_, err := os.Stat(namespaceFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return n.write(uidMappings, gidMappings)
+			return n.write(idMappings)
 		}
 	}
 
-	return n.validateNamespace(namespaceFilePath, uidMappings, gidMappings)
+	return n.validateNamespace(namespaceFilePath, idMappings)
+}
+
+// Remap replaces the mappings of the store, the overflow IDs are kept. The
+// namespace file is rewritten atomically, so readers either see the old or
+// the new mappings.
+func (n *StoreNamespacer) Remap(uidMappings, gidMappings []IDMappingSpec) error {
+	if err := ValidateIDMappings(uidMappings); err != nil {
+		return errorspkg.Errorf("invalid uid mappings: %s", err)
+	}
+
+	if err := ValidateIDMappings(gidMappings); err != nil {
+		return errorspkg.Errorf("invalid gid mappings: %s", err)
+	}
+
+	namespaceFilePath := n.namespaceFilePath()
+	stat, err := os.Stat(namespaceFilePath)
+	if err != nil {
+		return errorspkg.Wrap(err, "reading namespace file")
+	}
+
+	current, err := n.Read()
+	if err != nil {
+		return err
+	}
+
+	namespace := n.namespace(IDMappings{
+		UIDMappings: uidMappings,
+		GIDMappings: gidMappings,
+		OverflowUID: current.OverflowUID,
+		OverflowGID: current.OverflowGID,
+	})
+	return store.WriteAtomically(namespaceFilePath, func(tempFile *os.File) error {
+		if err := json.NewEncoder(tempFile).Encode(namespace); err != nil {
+			return errorspkg.Wrap(err, "writing namespace file")
+		}
+
+		if err := tempFile.Chmod(0755); err != nil {
+			return errorspkg.Wrap(err, "failed to chmod namespace file")
+		}
+
+		sysStat := stat.Sys().(*syscall.Stat_t)
+		if err := tempFile.Chown(int(sysStat.Uid), int(sysStat.Gid)); err != nil {
+			return errorspkg.Wrap(err, "failed to chown namespace file")
+		}
+
+		return nil
+	})
 }
 
+// Read returns the mappings of the store. A namespace file that can't be
+// parsed is moved to quarantine, and init-store has to be run again with the
+// mappings of the store.
 func (n *StoreNamespacer) Read() (IDMappings, error) {
 	mappingsFromFile := mappings{}
 	jsonBytes, err := ioutil.ReadFile(n.namespaceFilePath())
@@ -51,7 +110,7 @@ func (n *StoreNamespacer) Read() (IDMappings, error) {
 		return IDMappings{}, errorspkg.Wrap(err, "reading namespace file")
 	}
 	if err := json.Unmarshal(jsonBytes, &mappingsFromFile); err != nil {
-		return IDMappings{}, errorspkg.Wrap(err, "invalid namespace file")
+		return IDMappings{}, errorspkg.Wrap(store.QuarantineCorruptFile(n.namespaceFilePath(), err), "invalid namespace file")
 	}
 
 	uidMappings, err := n.parseIDMappings(mappingsFromFile.UIDMappings)
@@ -67,49 +126,88 @@ func (n *StoreNamespacer) Read() (IDMappings, error) {
 	return IDMappings{
 		UIDMappings: uidMappings,
 		GIDMappings: gidMappings,
+		OverflowUID: overflowID(mappingsFromFile.OverflowUID),
+		OverflowGID: overflowID(mappingsFromFile.OverflowGID),
 	}, nil
 }
 
-func (n *StoreNamespacer) write(uidMappings, gidMappings []IDMappingSpec) error {
-	namespaceStore, err := os.Create(n.namespaceFilePath())
+func (n *StoreNamespacer) write(idMappings IDMappings) error {
+	contents, err := json.Marshal(n.namespace(idMappings))
 	if err != nil {
-		return errorspkg.Wrap(err, "creating namespace file")
+		return errorspkg.Wrap(err, "encoding namespace file")
 	}
-	defer namespaceStore.Close()
 
-	namespace := mappings{
-		UIDMappings: n.normalizeMappings(uidMappings),
-		GIDMappings: n.normalizeMappings(gidMappings),
+	if err := store.WriteFileAtomically(n.namespaceFilePath(), contents, 0755); err != nil {
+		return errorspkg.Wrap(err, "creating namespace file")
 	}
 
-	if err := os.Chmod(namespaceStore.Name(), 0755); err != nil {
-		return errorspkg.Wrap(err, "failed to chmod namespace file")
-	}
-	return json.NewEncoder(namespaceStore).Encode(namespace)
+	return nil
 }
 
-func (n *StoreNamespacer) validateNamespace(namespaceFilePath string, uidMappings, gidMappings []IDMappingSpec) error {
-	namespaceStore, err := os.Open(namespaceFilePath)
+func (n *StoreNamespacer) validateNamespace(namespaceFilePath string, idMappings IDMappings) error {
+	contents, err := ioutil.ReadFile(namespaceFilePath)
 	if err != nil {
-		return errorspkg.Wrap(err, "opening namespace file")
+		return errorspkg.Wrapf(err, "reading namespace file %s", namespaceFilePath)
 	}
-	defer namespaceStore.Close()
+
 	var namespace mappings
-	if err := json.NewDecoder(namespaceStore).Decode(&namespace); err != nil {
-		return errorspkg.Wrapf(err, "reading namespace file %s", namespaceStore.Name())
+	if err := json.Unmarshal(contents, &namespace); err != nil {
+		// the mappings being applied are the best guess there is, init-store
+		// is the only caller
+		if quarantineErr := store.QuarantineCorruptFile(namespaceFilePath, err); !store.IsCorruptMetadata(quarantineErr) {
+			return errorspkg.Wrapf(quarantineErr, "reading namespace file %s", namespaceFilePath)
+		}
+		return n.write(idMappings)
 	}
 
-	if !reflect.DeepEqual(namespace.UIDMappings, n.normalizeMappings(uidMappings)) {
+	if !reflect.DeepEqual(namespace.UIDMappings, n.normalizeMappings(idMappings.UIDMappings)) {
 		return errorspkg.New("provided UID mappings do not match those already configured in the store")
 	}
 
-	if !reflect.DeepEqual(namespace.GIDMappings, n.normalizeMappings(gidMappings)) {
+	if !reflect.DeepEqual(namespace.GIDMappings, n.normalizeMappings(idMappings.GIDMappings)) {
 		return errorspkg.New("provided GID mappings do not match those already configured in the store")
 	}
 
+	if overflowID(namespace.OverflowUID) != idMappings.OverflowUID || overflowID(namespace.OverflowGID) != idMappings.OverflowGID {
+		return errorspkg.New("provided overflow IDs do not match those already configured in the store")
+	}
+
 	return nil
 }
 
+func (n *StoreNamespacer) namespace(idMappings IDMappings) mappings {
+	return mappings{
+		UIDMappings: n.normalizeMappings(idMappings.UIDMappings),
+		GIDMappings: n.normalizeMappings(idMappings.GIDMappings),
+		OverflowUID: &idMappings.OverflowUID,
+		OverflowGID: &idMappings.OverflowGID,
+	}
+}
+
+func validateMappings(idMappings IDMappings) error {
+	if err := ValidateIDMappings(idMappings.UIDMappings); err != nil {
+		return errorspkg.Errorf("invalid uid mappings: %s", err)
+	}
+
+	if err := ValidateIDMappings(idMappings.GIDMappings); err != nil {
+		return errorspkg.Errorf("invalid gid mappings: %s", err)
+	}
+
+	if idMappings.OverflowUID < 0 || idMappings.OverflowGID < 0 {
+		return errorspkg.New("invalid overflow ids: they cannot be negative")
+	}
+
+	return nil
+}
+
+func overflowID(id *int) int {
+	if id == nil {
+		return DefaultOverflowID
+	}
+
+	return *id
+}
+
 func (n *StoreNamespacer) namespaceFilePath() string {
 	return filepath.Join(n.storePath, store.MetaDirName, NamespaceFilename)
 }
@@ -117,7 +215,7 @@ func (n *StoreNamespacer) namespaceFilePath() string {
 func (n *StoreNamespacer) normalizeMappings(mappings []IDMappingSpec) []string {
 	stringMappings := []string{}
 	for _, mapping := range mappings {
-		stringMappings = append(stringMappings, fmt.Sprintf("%d:%d:%d", mapping.NamespaceID, mapping.HostID, mapping.Size))
+		stringMappings = append(stringMappings, formatIDMapping(mapping))
 	}
 
 	sort.Strings(stringMappings)
@@ -18,6 +18,8 @@ var _ = Describe("StoreNamespacer", func() {
 		storeNamespacer *groot.StoreNamespacer
 		uidMappings     []groot.IDMappingSpec
 		gidMappings     []groot.IDMappingSpec
+		overflowUID     int
+		overflowGID     int
 	)
 
 	BeforeEach(func() {
@@ -66,6 +68,8 @@ var _ = Describe("StoreNamespacer", func() {
 						Size:        10,
 					},
 				},
+				OverflowUID: 65534,
+				OverflowGID: 65534,
 			}
 		})
 
@@ -76,6 +80,21 @@ var _ = Describe("StoreNamespacer", func() {
 			Expect(mappingsFromFile).To(Equal(expectedMappings))
 		})
 
+		Context("when the namespace file has overflow ids", func() {
+			BeforeEach(func() {
+				mappings := []byte(`{"uid-mappings":["0:1000:1","1:100000:10"],"gid-mappings":["0:2000:1","1:200000:10"],"overflow-uid":0,"overflow-gid":1010}`)
+				Expect(ioutil.WriteFile(namespaceFile, mappings, 0700)).To(Succeed())
+			})
+
+			It("reads them", func() {
+				mappingsFromFile, err := storeNamespacer.Read()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mappingsFromFile.OverflowUID).To(Equal(0))
+				Expect(mappingsFromFile.OverflowGID).To(Equal(1010))
+			})
+		})
+
 		Context("when it fails to read the namespace file", func() {
 			BeforeEach(func() {
 				storePath = "invalid-path"
@@ -96,6 +115,16 @@ var _ = Describe("StoreNamespacer", func() {
 				_, err := storeNamespacer.Read()
 				Expect(err).To(MatchError(ContainSubstring("invalid namespace file")))
 			})
+
+			It("moves it to quarantine", func() {
+				_, err := storeNamespacer.Read()
+				Expect(store.IsCorruptMetadata(err)).To(BeTrue())
+
+				Expect(namespaceFile).NotTo(BeAnExistingFile())
+				quarantined, err := filepath.Glob(filepath.Join(storePath, store.MetaDirName, store.QuarantineDirName, "namespace.json.*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quarantined).To(HaveLen(1))
+			})
 		})
 
 		Context("when the mappings file contains", func() {
@@ -136,11 +165,23 @@ var _ = Describe("StoreNamespacer", func() {
 				groot.IDMappingSpec{HostID: 200000, NamespaceID: 1, Size: 10},
 				groot.IDMappingSpec{HostID: 2000, NamespaceID: 0, Size: 1},
 			}
+
+			overflowUID = 65534
+			overflowGID = 65534
 		})
 
+		applyMappings := func() error {
+			return storeNamespacer.ApplyMappings(groot.IDMappings{
+				UIDMappings: uidMappings,
+				GIDMappings: gidMappings,
+				OverflowUID: overflowUID,
+				OverflowGID: overflowGID,
+			})
+		}
+
 		Context("when there is no namespace file", func() {
 			It("creates the correct namespace file", func() {
-				err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+				err := applyMappings()
 				Expect(err).NotTo(HaveOccurred())
 
 				namespaceFile := filepath.Join(storePath, store.MetaDirName, "namespace.json")
@@ -149,11 +190,34 @@ var _ = Describe("StoreNamespacer", func() {
 				contents, err := ioutil.ReadFile(namespaceFile)
 				Expect(err).NotTo(HaveOccurred())
 
-				var namespaces map[string][]string
+				var namespaces struct {
+					UIDMappings []string `json:"uid-mappings"`
+					GIDMappings []string `json:"gid-mappings"`
+					OverflowUID int      `json:"overflow-uid"`
+					OverflowGID int      `json:"overflow-gid"`
+				}
 				Expect(json.Unmarshal(contents, &namespaces)).To(Succeed())
 
-				Expect(namespaces["uid-mappings"]).To(Equal([]string{"0:1000:1", "1:100000:10"}))
-				Expect(namespaces["gid-mappings"]).To(Equal([]string{"0:2000:1", "1:200000:10"}))
+				Expect(namespaces.UIDMappings).To(Equal([]string{"0:1000:1", "1:100000:10"}))
+				Expect(namespaces.GIDMappings).To(Equal([]string{"0:2000:1", "1:200000:10"}))
+				Expect(namespaces.OverflowUID).To(Equal(65534))
+				Expect(namespaces.OverflowGID).To(Equal(65534))
+			})
+
+			Context("when the overflow ids are 0", func() {
+				BeforeEach(func() {
+					overflowUID = 0
+					overflowGID = 0
+				})
+
+				It("keeps them", func() {
+					Expect(applyMappings()).To(Succeed())
+
+					mappings, err := storeNamespacer.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(mappings.OverflowUID).To(Equal(0))
+					Expect(mappings.OverflowGID).To(Equal(0))
+				})
 			})
 
 			Context("when it fails to create the namespace file", func() {
@@ -162,12 +226,48 @@ var _ = Describe("StoreNamespacer", func() {
 				})
 
 				It("returns an error", func() {
-					err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+					err := applyMappings()
 					Expect(err).To(MatchError(ContainSubstring("creating namespace file")))
 				})
 			})
 		})
 
+		Context("when an overflow id is negative", func() {
+			BeforeEach(func() {
+				overflowGID = -1
+			})
+
+			It("returns an error without writing the namespace file", func() {
+				err := applyMappings()
+				Expect(err).To(MatchError("invalid overflow ids: they cannot be negative"))
+				Expect(filepath.Join(storePath, store.MetaDirName, "namespace.json")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the uid mappings overlap", func() {
+			BeforeEach(func() {
+				uidMappings = append(uidMappings, groot.IDMappingSpec{HostID: 100005, NamespaceID: 20, Size: 10})
+			})
+
+			It("returns an error without writing the namespace file", func() {
+				err := applyMappings()
+				Expect(err).To(MatchError("invalid uid mappings: mappings 1:100000:10 and 20:100005:10 overlap"))
+				Expect(filepath.Join(storePath, store.MetaDirName, "namespace.json")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the gid mappings overlap", func() {
+			BeforeEach(func() {
+				gidMappings = append(gidMappings, groot.IDMappingSpec{HostID: 300000, NamespaceID: 5, Size: 10})
+			})
+
+			It("returns an error without writing the namespace file", func() {
+				err := applyMappings()
+				Expect(err).To(MatchError("invalid gid mappings: mappings 1:200000:10 and 5:300000:10 overlap"))
+				Expect(filepath.Join(storePath, store.MetaDirName, "namespace.json")).NotTo(BeAnExistingFile())
+			})
+		})
+
 		Context("when there's a namespace file", func() {
 			BeforeEach(func() {
 				mappings := []byte(`{"uid-mappings":["0:1000:1","1:100000:10"],"gid-mappings":["0:2000:1","1:200000:10"]}`)
@@ -175,7 +275,7 @@ var _ = Describe("StoreNamespacer", func() {
 			})
 
 			It("succeeds when the namespaces are the same", func() {
-				Expect(storeNamespacer.ApplyMappings(uidMappings, gidMappings)).To(Succeed())
+				Expect(applyMappings()).To(Succeed())
 			})
 
 			Context("when uid mapping doesn't match", func() {
@@ -184,7 +284,7 @@ var _ = Describe("StoreNamespacer", func() {
 				})
 
 				It("returns an error", func() {
-					err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+					err := applyMappings()
 					Expect(err).To(MatchError(ContainSubstring("provided UID mappings do not match those already configured in the store")))
 				})
 			})
@@ -195,11 +295,38 @@ var _ = Describe("StoreNamespacer", func() {
 				})
 
 				It("returns an error", func() {
-					err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+					err := applyMappings()
 					Expect(err).To(MatchError(ContainSubstring("provided GID mappings do not match those already configured in the store")))
 				})
 			})
 
+			Context("when the overflow ids don't match", func() {
+				BeforeEach(func() {
+					overflowUID = 1010
+				})
+
+				It("returns an error", func() {
+					err := applyMappings()
+					Expect(err).To(MatchError(ContainSubstring("provided overflow IDs do not match those already configured in the store")))
+				})
+			})
+
+			Context("when the namespace file is corrupt", func() {
+				BeforeEach(func() {
+					namespaceFile := filepath.Join(storePath, store.MetaDirName, "namespace.json")
+					Expect(ioutil.WriteFile(namespaceFile, []byte{}, 0755)).To(Succeed())
+				})
+
+				It("replaces it with the mappings being applied", func() {
+					Expect(applyMappings()).To(Succeed())
+
+					mappings, err := storeNamespacer.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(mappings.UIDMappings).To(ConsistOf(uidMappings))
+					Expect(mappings.GIDMappings).To(ConsistOf(gidMappings))
+				})
+			})
+
 			Context("when it fails to read the namespace file", func() {
 				BeforeEach(func() {
 					imageJsonPath := filepath.Join(storePath, store.MetaDirName, "namespace.json")
@@ -208,7 +335,7 @@ var _ = Describe("StoreNamespacer", func() {
 				})
 
 				It("returns an error", func() {
-					err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+					err := applyMappings()
 					Expect(err).To(MatchError(ContainSubstring("reading namespace file")))
 				})
 			})
@@ -220,9 +347,82 @@ var _ = Describe("StoreNamespacer", func() {
 			})
 
 			It("returns an error", func() {
-				err := storeNamespacer.ApplyMappings(uidMappings, gidMappings)
+				err := applyMappings()
 				Expect(err).To(MatchError(ContainSubstring("creating namespace file")))
 			})
 		})
 	})
+
+	Describe("Remap", func() {
+		BeforeEach(func() {
+			uidMappings = []groot.IDMappingSpec{
+				groot.IDMappingSpec{HostID: 300000, NamespaceID: 1, Size: 10},
+				groot.IDMappingSpec{HostID: 3000, NamespaceID: 0, Size: 1},
+			}
+
+			gidMappings = []groot.IDMappingSpec{
+				groot.IDMappingSpec{HostID: 400000, NamespaceID: 1, Size: 10},
+				groot.IDMappingSpec{HostID: 4000, NamespaceID: 0, Size: 1},
+			}
+		})
+
+		Context("when there's a namespace file", func() {
+			JustBeforeEach(func() {
+				Expect(storeNamespacer.ApplyMappings(groot.IDMappings{
+					UIDMappings: []groot.IDMappingSpec{groot.IDMappingSpec{HostID: 1000, NamespaceID: 0, Size: 1}},
+					GIDMappings: []groot.IDMappingSpec{groot.IDMappingSpec{HostID: 2000, NamespaceID: 0, Size: 1}},
+					OverflowUID: 1010,
+					OverflowGID: 2020,
+				})).To(Succeed())
+			})
+
+			It("replaces the mappings in the namespace file", func() {
+				Expect(storeNamespacer.Remap(uidMappings, gidMappings)).To(Succeed())
+
+				mappings, err := storeNamespacer.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mappings.UIDMappings).To(ConsistOf(uidMappings))
+				Expect(mappings.GIDMappings).To(ConsistOf(gidMappings))
+			})
+
+			It("keeps the overflow ids", func() {
+				Expect(storeNamespacer.Remap(uidMappings, gidMappings)).To(Succeed())
+
+				mappings, err := storeNamespacer.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mappings.OverflowUID).To(Equal(1010))
+				Expect(mappings.OverflowGID).To(Equal(2020))
+			})
+
+			It("leaves no temporary files behind", func() {
+				Expect(storeNamespacer.Remap(uidMappings, gidMappings)).To(Succeed())
+
+				files, err := ioutil.ReadDir(filepath.Join(storePath, store.MetaDirName))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(HaveLen(1))
+			})
+
+			Context("when the mappings overlap", func() {
+				BeforeEach(func() {
+					uidMappings = append(uidMappings, groot.IDMappingSpec{HostID: 300005, NamespaceID: 20, Size: 10})
+				})
+
+				It("returns an error without changing the namespace file", func() {
+					err := storeNamespacer.Remap(uidMappings, gidMappings)
+					Expect(err).To(MatchError(ContainSubstring("invalid uid mappings")))
+
+					mappings, err := storeNamespacer.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(mappings.UIDMappings).To(ConsistOf(groot.IDMappingSpec{HostID: 1000, NamespaceID: 0, Size: 1}))
+				})
+			})
+		})
+
+		Context("when there is no namespace file", func() {
+			It("returns an error", func() {
+				err := storeNamespacer.Remap(uidMappings, gidMappings)
+				Expect(err).To(MatchError(ContainSubstring("reading namespace file")))
+			})
+		})
+	})
 })
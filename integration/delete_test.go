@@ -8,9 +8,12 @@ import (
 	"path/filepath"
 	"syscall"
 
+	"code.cloudfoundry.org/lager/lagertest"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/integration"
 	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/metadata_db"
+	"github.com/SUSE/groot-btrfs/store/metadata_db/metadata_dbfakes"
 	"github.com/SUSE/groot-btrfs/testhelpers"
 
 	. "github.com/onsi/ginkgo"
@@ -140,11 +143,14 @@ var _ = Describe("Delete", func() {
 			Expect(syscall.Unmount(mntPoint, syscall.MNT_DETACH)).To(Succeed())
 		})
 
-		It("doesn't remove the metadata file", func() {
-			metadataPath := filepath.Join(StorePath, store.MetaDirName, "dependencies", fmt.Sprintf("image:%s.json", randomImageID))
-			Expect(metadataPath).To(BeAnExistingFile())
+		It("doesn't deregister the dependencies", func() {
+			metadataDB, err := metadata_db.Open(lagertest.NewTestLogger("delete"), filepath.Join(StorePath, store.MetaDirName), new(metadata_dbfakes.FakeVolumeDriver))
+			Expect(err).NotTo(HaveOccurred())
+
+			imageRefName := fmt.Sprintf(groot.ImageReferenceFormat, randomImageID)
+			Expect(metadataDB.Dependencies(imageRefName)).NotTo(BeEmpty())
 			Expect(Runner.Delete(filepath.Dir(containerSpec.Root.Path))).To(MatchError(ContainSubstring("deleting image path")))
-			Expect(metadataPath).To(BeAnExistingFile())
+			Expect(metadataDB.Dependencies(imageRefName)).NotTo(BeEmpty())
 		})
 	})
 
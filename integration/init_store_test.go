@@ -13,6 +13,7 @@ import (
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/integration"
 	grootfsRunner "github.com/SUSE/groot-btrfs/integration/runner"
+	"github.com/SUSE/groot-btrfs/store"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -174,10 +175,66 @@ var _ = Describe("Init Store", func() {
 	})
 
 	Context("when the user is not root", func() {
-		It("returns an error", func() {
-			err := runner.RunningAsUser(GrootUID, GrootUID).InitStore(spec)
-			Expect(err).To(HaveOccurred())
-			Expect(err).To(MatchError(fmt.Sprintf("store %s can only be initialized by Root user", runner.StorePath)))
+		BeforeEach(func() {
+			spec.UIDMappings = []groot.IDMappingSpec{
+				{HostID: GrootUID, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65000},
+			}
+			spec.GIDMappings = []groot.IDMappingSpec{
+				{HostID: GrootGID, NamespaceID: 0, Size: 1},
+				{HostID: 100000, NamespaceID: 1, Size: 65000},
+			}
+		})
+
+		It("initializes the store inside a user namespace", func() {
+			Expect(runner.RunningAsUser(GrootUID, GrootGID).InitStore(spec)).To(Succeed())
+
+			stat, err := os.Stat(runner.StorePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(GrootUID)))
+			Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(GrootGID)))
+
+			stat, err = os.Stat(filepath.Join(runner.StorePath, store.VolumesDirName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(GrootUID)))
+			Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(GrootGID)))
+		})
+
+		Context("when the root mapping is not the caller", func() {
+			BeforeEach(func() {
+				spec.UIDMappings[0].HostID = 100000 + 65000
+			})
+
+			It("returns an error", func() {
+				err := runner.RunningAsUser(GrootUID, GrootGID).InitStore(spec)
+				Expect(err).To(MatchError(ContainSubstring("must be the current user")))
+			})
+		})
+
+		Context("when --cache-limit-bytes is passed", func() {
+			BeforeEach(func() {
+				spec.CacheLimitBytes = 10 * 1024 * 1024
+			})
+
+			It("limits the layer cache through drax", func() {
+				Expect(runner.RunningAsUser(GrootUID, GrootGID).InitStore(spec)).To(Succeed())
+
+				output, err := exec.Command("btrfs", "qgroup", "show", "--raw", "-r", runner.StorePath).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(MatchRegexp(`(?m)^1/1\s+\d+\s+\d+\s+10485760\s*$`))
+			})
+		})
+
+		Context("when --store-size-bytes is passed", func() {
+			BeforeEach(func() {
+				spec.StoreSizeBytes = 500 * 1024 * 1024
+			})
+
+			It("returns an error", func() {
+				err := runner.RunningAsUser(GrootUID, GrootGID).InitStore(spec)
+				Expect(err).To(HaveOccurred())
+				Expect(err).To(MatchError(fmt.Sprintf("store %s can only be initialized by Root user", runner.StorePath)))
+			})
 		})
 	})
 })
@@ -0,0 +1,80 @@
+package integration_test
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/integration"
+	grootfsRunner "github.com/SUSE/groot-btrfs/integration/runner"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/testhelpers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrate Store", func() {
+	var (
+		runner          grootfsRunner.Runner
+		storePath       string
+		sourceImagePath string
+		baseImagePath   string
+	)
+
+	BeforeEach(func() {
+		integration.SkipIfNonRoot(GrootfsTestUid)
+
+		storePath = filepath.Join(StorePath, strconv.Itoa(rand.Int()))
+		Expect(os.MkdirAll(storePath, 0777)).To(Succeed())
+		runner = Runner.WithStore(storePath).SkipInitStore()
+		Expect(runner.InitStore(grootfsRunner.InitSpec{})).To(Succeed())
+
+		sourceImagePath = integration.CreateBaseImage(rootUID, rootGID, GrootUID, GrootGID)
+		baseImagePath = integration.CreateBaseImageTar(sourceImagePath).Name()
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(sourceImagePath)).To(Succeed())
+		Expect(os.RemoveAll(baseImagePath)).To(Succeed())
+	})
+
+	Context("when the store predates the version", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(storePath, store.MetaDirName, store.VersionFileName))).To(Succeed())
+		})
+
+		It("asks for migrate-store rather than init-store before creating", func() {
+			_, err := runner.Create(groot.CreateSpec{
+				BaseImageURL: integration.String2URL(baseImagePath),
+				ID:           testhelpers.NewRandomID(),
+			})
+			Expect(err).To(MatchError(ContainSubstring("please run migrate-store")))
+			Expect(err).NotTo(MatchError(ContainSubstring("init-store")))
+		})
+
+		It("brings the store up to the current version", func() {
+			output, err := runner.MigrateStore()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainSubstring("migrated store to version %d", store.CurrentVersion))
+			Expect(store.ReadVersion(storePath)).To(Equal(store.CurrentVersion))
+
+			_, err = runner.Create(groot.CreateSpec{
+				BaseImageURL: integration.String2URL(baseImagePath),
+				ID:           testhelpers.NewRandomID(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when the store is up to date", func() {
+		It("doesn't migrate anything", func() {
+			output, err := runner.MigrateStore()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).NotTo(ContainSubstring("migrated store"))
+			Expect(store.ReadVersion(storePath)).To(Equal(store.CurrentVersion))
+		})
+	})
+})
@@ -7,9 +7,10 @@ import (
 )
 
 type InitSpec struct {
-	UIDMappings    []groot.IDMappingSpec
-	GIDMappings    []groot.IDMappingSpec
-	StoreSizeBytes int64
+	UIDMappings     []groot.IDMappingSpec
+	GIDMappings     []groot.IDMappingSpec
+	StoreSizeBytes  int64
+	CacheLimitBytes int64
 }
 
 func (r Runner) InitStore(spec InitSpec) error {
@@ -31,6 +32,10 @@ func (r Runner) InitStore(spec InitSpec) error {
 		args = append(args, "--store-size-bytes", fmt.Sprintf("%d", spec.StoreSizeBytes))
 	}
 
+	if spec.CacheLimitBytes > 0 {
+		args = append(args, "--cache-limit-bytes", fmt.Sprintf("%d", spec.CacheLimitBytes))
+	}
+
 	_, err := r.RunSubcommand("init-store", args...)
 	return err
 }
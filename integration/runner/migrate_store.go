@@ -0,0 +1,5 @@
+package runner
+
+func (r Runner) MigrateStore() (string, error) {
+	return r.RunSubcommand("migrate-store")
+}
@@ -26,3 +26,19 @@ func (r Runner) Stats(id string) (groot.VolumeStats, error) {
 	err = json.Unmarshal([]byte(stats), &volumeStats)
 	return volumeStats, err
 }
+
+func (r Runner) StoreStats(measurer string) (groot.StoreStats, error) {
+	args := []string{}
+	if measurer != "" {
+		args = append(args, "--measurer", measurer)
+	}
+
+	stats, err := r.RunSubcommand("stats", args...)
+	if err != nil {
+		return groot.StoreStats{}, err
+	}
+
+	var storeStats groot.StoreStats
+	err = json.Unmarshal([]byte(stats), &storeStats)
+	return storeStats, err
+}
@@ -197,9 +197,41 @@ var _ = Describe("Stats", func() {
 	})
 
 	Context("when the image id is not provided", func() {
-		It("returns an error", func() {
-			_, err := Runner.Stats("")
-			Expect(err).To(MatchError(ContainSubstring("invalid arguments")))
+		BeforeEach(func() {
+			cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(sourceImagePath, "fatfile")), "bs=1048576", "count=5")
+			sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+
+		JustBeforeEach(func() {
+			_, err := Runner.Create(groot.CreateSpec{
+				BaseImageURL: integration.String2URL(baseImagePath),
+				ID:           imageID,
+				Mount:        true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("measures the layer cache from the volume sizes", func() {
+			stats, err := Runner.StoreStats("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Layers.TotalBytesUsed).To(BeNumerically(">=", 5*1024*1024))
+		})
+
+		Context("when the qgroup measurer is asked for", func() {
+			It("measures the store qgroups", func() {
+				stats, err := Runner.StoreStats("qgroup")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stats.Layers.TotalBytesUsed).To(BeNumerically(">=", 5*1024*1024))
+			})
+		})
+
+		Context("when more than one image is given", func() {
+			It("returns an error", func() {
+				_, err := Runner.RunSubcommand("stats", "one", "two")
+				Expect(err).To(MatchError(ContainSubstring("invalid arguments")))
+			})
 		})
 	})
 })
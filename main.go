@@ -11,6 +11,7 @@ import (
 	"github.com/SUSE/groot-btrfs/commands"
 	"github.com/SUSE/groot-btrfs/commands/config"
 	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/locksmith"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/containers/storage/pkg/reexec"
@@ -82,17 +83,32 @@ func main() {
 			Usage: "Metron endpoint used to send metrics",
 			Value: "",
 		},
+		cli.DurationFlag{
+			Name:  "lock-timeout",
+			Usage: "How long to wait for a store lock before failing. (Waits forever if not provided.)",
+		},
 	}
 
 	grootfs.Commands = []cli.Command{
 		commands.InitStoreCommand,
 		commands.DeleteStoreCommand,
+		commands.RemapStoreCommand,
+		commands.ResizeStoreCommand,
+		commands.MigrateStoreCommand,
+		commands.FsckCommand,
 		commands.GenerateVolumeSizeMetadata,
 		commands.CreateCommand,
 		commands.DeleteCommand,
 		commands.StatsCommand,
 		commands.CleanCommand,
+		commands.PinCommand,
+		commands.UnpinCommand,
+		commands.PinsCommand,
+		commands.MaintainCommand,
+		commands.EmptyTrashCommand,
 		commands.ListCommand,
+		commands.LocksCommand,
+		commands.LayerCommand,
 	}
 
 	grootfs.Before = func(ctx *cli.Context) error {
@@ -110,6 +126,7 @@ func main() {
 			WithBtrfsProgsPath(ctx.GlobalString("btrfs-progs-path"), ctx.IsSet("btrfs-progs-path")).
 			WithNewuidmapBin(ctx.GlobalString("newuidmap-bin"), ctx.IsSet("newuidmap-bin")).
 			WithNewgidmapBin(ctx.GlobalString("newgidmap-bin"), ctx.IsSet("newgidmap-bin")).
+			WithLockTimeout(ctx.GlobalDuration("lock-timeout"), ctx.IsSet("lock-timeout")).
 			Build()
 		if err != nil {
 			return cli.NewExitError(err.Error(), 1)
@@ -122,6 +139,11 @@ func main() {
 		}
 		ctx.App.Metadata["logger"] = logger
 
+		// lock owners only record the command, the flags can hold passwords
+		if command := ctx.App.Command(ctx.Args().First()); command != nil {
+			locksmith.OwnerCommand = filepath.Base(os.Args[0]) + " " + command.Name
+		}
+
 		// Sadness. We need to do that becuase we use stderr for logs so user
 		// errors need to end up in stdout.
 		cli.ErrWriter = os.Stdout
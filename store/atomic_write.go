@@ -0,0 +1,110 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+// QuarantineDirName is where corrupt metadata files are moved, next to where
+// they were found.
+const QuarantineDirName = "quarantine"
+
+// CorruptMetadataError is returned by readers that found a metadata file they
+// can't parse and moved it to quarantine.
+type CorruptMetadataError struct {
+	Path           string
+	QuarantinePath string
+	Err            error
+}
+
+func (e *CorruptMetadataError) Error() string {
+	return fmt.Sprintf("corrupt metadata file %s was moved to %s: %s", e.Path, e.QuarantinePath, e.Err)
+}
+
+// IsCorruptMetadata reports whether err, or the error it wraps, is a
+// CorruptMetadataError.
+func IsCorruptMetadata(err error) bool {
+	_, ok := errorspkg.Cause(err).(*CorruptMetadataError)
+	return ok
+}
+
+// WriteFileAtomically replaces the file at path with data, see
+// WriteAtomically.
+func WriteFileAtomically(path string, data []byte, perm os.FileMode) error {
+	return WriteAtomically(path, func(file *os.File) error {
+		if _, err := file.Write(data); err != nil {
+			return err
+		}
+
+		return file.Chmod(perm)
+	})
+}
+
+// WriteAtomically replaces the file at path with what write puts in a
+// temporary file next to it. The temporary file is synced before it is
+// renamed over path, and the directory after, so that readers and a store
+// that lost power see either the old or the new contents, never a partial
+// file.
+func WriteAtomically(path string, write func(*os.File) error) error {
+	dir := filepath.Dir(path)
+	tempFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return errorspkg.Wrapf(err, "creating temporary file for %s", path)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if err := write(tempFile); err != nil {
+		return errorspkg.Wrapf(err, "writing %s", path)
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		return errorspkg.Wrapf(err, "syncing %s", path)
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return errorspkg.Wrapf(err, "closing %s", path)
+	}
+
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		return errorspkg.Wrapf(err, "replacing %s", path)
+	}
+
+	return syncDir(dir)
+}
+
+// QuarantineCorruptFile moves the file at path, which failed to parse with
+// cause, to the quarantine directory next to it, where it no longer breaks
+// readers but can still be looked at. The returned error describes both.
+func QuarantineCorruptFile(path string, cause error) error {
+	quarantineDir := filepath.Join(filepath.Dir(path), QuarantineDirName)
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return errorspkg.Wrapf(err, "quarantining corrupt file %s: %s", path, cause)
+	}
+
+	quarantinePath := filepath.Join(quarantineDir, fmt.Sprintf("%s.%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := os.Rename(path, quarantinePath); err != nil {
+		return errorspkg.Wrapf(err, "quarantining corrupt file %s: %s", path, cause)
+	}
+
+	return &CorruptMetadataError{Path: path, QuarantinePath: quarantinePath, Err: cause}
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return errorspkg.Wrapf(err, "opening %s", path)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return errorspkg.Wrapf(err, "syncing %s", path)
+	}
+
+	return nil
+}
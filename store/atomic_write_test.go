@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Atomic writes", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "atomic-write")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "meta.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("WriteFileAtomically", func() {
+		It("writes the file with the given permissions", func() {
+			Expect(store.WriteFileAtomically(path, []byte("{}"), 0640)).To(Succeed())
+
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte("{}")))
+			stat, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		})
+
+		It("replaces an existing file", func() {
+			Expect(ioutil.WriteFile(path, []byte("old contents"), 0644)).To(Succeed())
+			Expect(store.WriteFileAtomically(path, []byte("new"), 0644)).To(Succeed())
+
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte("new")))
+		})
+
+		It("leaves no temporary files behind", func() {
+			Expect(store.WriteFileAtomically(path, []byte("{}"), 0644)).To(Succeed())
+
+			entries, err := ioutil.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		Context("when the directory does not exist", func() {
+			It("returns an error", func() {
+				err := store.WriteFileAtomically(filepath.Join(dir, "missing", "meta.json"), []byte("{}"), 0644)
+				Expect(err).To(MatchError(ContainSubstring("creating temporary file")))
+			})
+		})
+	})
+
+	Describe("WriteAtomically", func() {
+		Context("when writing fails", func() {
+			It("keeps the old file and cleans up", func() {
+				Expect(ioutil.WriteFile(path, []byte("old contents"), 0644)).To(Succeed())
+
+				err := store.WriteAtomically(path, func(*os.File) error {
+					return errors.New("disk full")
+				})
+				Expect(err).To(MatchError(ContainSubstring("disk full")))
+
+				Expect(ioutil.ReadFile(path)).To(Equal([]byte("old contents")))
+				entries, err := ioutil.ReadDir(dir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("QuarantineCorruptFile", func() {
+		It("moves the file to the quarantine directory next to it", func() {
+			Expect(ioutil.WriteFile(path, []byte(""), 0644)).To(Succeed())
+
+			err := store.QuarantineCorruptFile(path, errors.New("unexpected end of JSON input"))
+			Expect(err).To(MatchError(ContainSubstring("unexpected end of JSON input")))
+			Expect(store.IsCorruptMetadata(errorspkg.Wrap(err, "reading"))).To(BeTrue())
+
+			Expect(path).NotTo(BeAnExistingFile())
+			quarantined, err := filepath.Glob(filepath.Join(dir, store.QuarantineDirName, "meta.json.*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quarantined).To(HaveLen(1))
+		})
+
+		Context("when the file can't be moved", func() {
+			It("returns an error that is not about corrupt metadata", func() {
+				err := store.QuarantineCorruptFile(path, errors.New("unexpected end of JSON input"))
+				Expect(err).To(MatchError(ContainSubstring("quarantining corrupt file")))
+				Expect(store.IsCorruptMetadata(err)).To(BeFalse())
+			})
+		})
+	})
+})
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/SUSE/groot-btrfs/store"
 	errorspkg "github.com/pkg/errors"
 )
 
@@ -27,15 +28,18 @@ func (d *DependencyManager) Register(id string, chainIDs []string) error {
 		return err
 	}
 
-	return ioutil.WriteFile(d.filePath(id), data, 0666)
+	return store.WriteFileAtomically(d.filePath(id), data, 0644)
 }
 
 func (d *DependencyManager) Deregister(id string) error {
 	return os.Remove(d.filePath(id))
 }
 
+// Dependencies returns the chain IDs registered for id. Files that can't be
+// parsed are moved to quarantine and reported with a
+// store.CorruptMetadataError.
 func (d *DependencyManager) Dependencies(id string) ([]string, error) {
-	f, err := os.Open(d.filePath(id))
+	contents, err := ioutil.ReadFile(d.filePath(id))
 	if err != nil && os.IsNotExist(err) {
 		return nil, errorspkg.Errorf("image `%s` not found", id)
 	}
@@ -44,13 +48,33 @@ func (d *DependencyManager) Dependencies(id string) ([]string, error) {
 	}
 
 	var chainIDs []string
-	if err := json.NewDecoder(f).Decode(&chainIDs); err != nil {
-		return nil, err
+	if err := json.Unmarshal(contents, &chainIDs); err != nil {
+		return nil, store.QuarantineCorruptFile(d.filePath(id), err)
 	}
 
 	return chainIDs, nil
 }
 
+// IDs lists the ids that have dependencies registered.
+func (d *DependencyManager) IDs() ([]string, error) {
+	entries, err := ioutil.ReadDir(d.dependenciesPath)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading dependencies")
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		escapedID := strings.TrimSuffix(entry.Name(), ".json")
+		ids = append(ids, strings.Replace(escapedID, "__", "/", -1))
+	}
+
+	return ids, nil
+}
+
 func (d *DependencyManager) filePath(id string) string {
 	escapedId := strings.Replace(id, "/", "__", -1)
 	return filepath.Join(d.dependenciesPath, fmt.Sprintf("%s.json", escapedId))
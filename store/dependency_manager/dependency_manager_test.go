@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 
+	"github.com/SUSE/groot-btrfs/store"
 	"github.com/SUSE/groot-btrfs/store/dependency_manager"
 
 	. "github.com/onsi/ginkgo"
@@ -61,6 +62,24 @@ var _ = Describe("DependencyManager", func() {
 		})
 	})
 
+	Describe("Dependencies", func() {
+		Context("when the dependencies file is corrupt", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(path.Join(depsPath, "my-image.json"), []byte{}, 0644)).To(Succeed())
+			})
+
+			It("moves it to quarantine and returns an error", func() {
+				_, err := manager.Dependencies("my-image")
+				Expect(store.IsCorruptMetadata(err)).To(BeTrue())
+
+				Expect(path.Join(depsPath, "my-image.json")).NotTo(BeAnExistingFile())
+				ids, err := manager.IDs()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("Deregister", func() {
 		It("deregisters the dependencies for a given image", func() {
 			imageID := "my-image"
@@ -88,4 +107,27 @@ var _ = Describe("DependencyManager", func() {
 			})
 		})
 	})
+
+	Describe("IDs", func() {
+		It("lists the registered ids", func() {
+			Expect(manager.Register("image:my/image", []string{"sha256:vol-1"})).To(Succeed())
+			Expect(manager.Register("image:other", []string{"sha256:vol-2"})).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(depsPath, "not-a-dependency"), []byte{}, 0600)).To(Succeed())
+
+			ids, err := manager.IDs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(ConsistOf("image:my/image", "image:other"))
+		})
+
+		Context("when the base path does not exist", func() {
+			BeforeEach(func() {
+				manager = dependency_manager.NewDependencyManager("/path/to/non/existent/dir")
+			})
+
+			It("returns an error", func() {
+				_, err := manager.IDs()
+				Expect(err).To(MatchError(ContainSubstring("reading dependencies")))
+			})
+		})
+	})
 })
@@ -0,0 +1,42 @@
+package commands // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/commands"
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/lager"
+	limiterpkg "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/limiter"
+	"github.com/urfave/cli"
+)
+
+var AssignQgroupCommand = cli.Command{
+	Name:        "assign-qgroup",
+	Usage:       "assign-qgroup --volume-path <path> --qgroup <level>/<id>",
+	Description: "Adds the volume to the given qgroup.",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "volume-path",
+			Usage: "Path to the volume",
+		},
+		cli.StringFlag{
+			Name:  "qgroup",
+			Usage: "Qgroup to add the volume to, e.g.: 1/1",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := lager.NewLogger("drax")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
+
+		commandRunner := linux_command_runner.New()
+		limiter := limiterpkg.NewBtrfsLimiter(ctx.GlobalString("btrfs-bin"), commandRunner)
+		err := limiter.AssignQuotaGroup(logger, ctx.String("volume-path"), ctx.String("qgroup"))
+		if err != nil {
+			logger.Error("assigning-qgroup", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,51 @@
+package commands // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/commands"
+
+import (
+	"encoding/json"
+	"os"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/metrix"
+	"github.com/urfave/cli"
+)
+
+var CompressionStatsCommand = cli.Command{
+	Name:        "compression-stats",
+	Usage:       "compression-stats --volume-path <path>",
+	Description: "Get the logical and compressed usage of a volume",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "volume-path",
+			Usage: "Path to the volume",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := lager.NewLogger("drax")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
+
+		commandRunner := linux_command_runner.New()
+		btrfsStats := metrix.NewBtrfsStats(ctx.GlobalString("btrfs-bin"), commandRunner)
+		usage, err := btrfsStats.CompressionStats(logger, ctx.String("volume-path"))
+		if err != nil {
+			if metrix.IsNotVolume(err) {
+				logger.Debug("fetching-compression-stats-not-a-volume", lager.Data{"error": err.Error()})
+			} else {
+				logger.Error("fetching-compression-stats", err)
+			}
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(map[string]int64{
+			"logical_bytes":    usage.LogicalBytes,
+			"compressed_bytes": usage.CompressedBytes,
+		}); err != nil {
+			logger.Error("encoding-compression-stats", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,42 @@
+package commands // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/commands"
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/lager"
+	limiterpkg "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/limiter"
+	"github.com/urfave/cli"
+)
+
+var CreateQgroupsCommand = cli.Command{
+	Name:        "create-qgroups",
+	Usage:       "create-qgroups --path <path> --qgroup <level>/<id> [--qgroup ...]",
+	Description: "Creates the given qgroups in the filesystem containing the path.",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "Path in the filesystem",
+		},
+		cli.StringSliceFlag{
+			Name:  "qgroup",
+			Usage: "Qgroup to create, e.g.: 1/1",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := lager.NewLogger("drax")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
+
+		commandRunner := linux_command_runner.New()
+		limiter := limiterpkg.NewBtrfsLimiter(ctx.GlobalString("btrfs-bin"), commandRunner)
+		err := limiter.CreateQuotaGroups(logger, ctx.String("path"), ctx.StringSlice("qgroup"))
+		if err != nil {
+			logger.Error("creating-qgroups", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
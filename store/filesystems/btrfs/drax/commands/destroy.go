@@ -11,13 +11,13 @@ import (
 
 var DestroyCommand = cli.Command{
 	Name:        "destroy",
-	Usage:       "destroy --volume-path <path>",
-	Description: "Destroys the qgroup for the given path.",
+	Usage:       "destroy --volume-path <path> [--volume-path <path>...]",
+	Description: "Destroys the qgroups for the given paths.",
 
 	Flags: []cli.Flag{
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "volume-path",
-			Usage: "Path to the volume",
+			Usage: "Path to the volume, can be given multiple times",
 		},
 	},
 
@@ -27,10 +27,16 @@ var DestroyCommand = cli.Command{
 
 		commandRunner := linux_command_runner.New()
 		limiter := limiterpkg.NewBtrfsLimiter(ctx.GlobalString("btrfs-bin"), commandRunner)
-		err := limiter.DestroyQuotaGroup(logger, ctx.String("volume-path"))
-		if err != nil {
-			logger.Error("destroying-qgroup", err)
-			return cli.NewExitError(err.Error(), 1)
+		var destroyErr error
+		for _, volumePath := range ctx.StringSlice("volume-path") {
+			if err := limiter.DestroyQuotaGroup(logger, volumePath); err != nil {
+				logger.Error("destroying-qgroup", err, lager.Data{"volumePath": volumePath})
+				destroyErr = err
+			}
+		}
+
+		if destroyErr != nil {
+			return cli.NewExitError(destroyErr.Error(), 1)
 		}
 
 		return nil
@@ -11,7 +11,7 @@ import (
 
 var LimitCommand = cli.Command{
 	Name:        "limit",
-	Usage:       "limit --disk-limit-bytes 102400 --volume-path <path>",
+	Usage:       "limit --disk-limit-bytes 102400 --volume-path <path> [--qgroup <level>/<id>]",
 	Description: "Add disk limits to the volume.",
 
 	Flags: []cli.Flag{
@@ -27,6 +27,10 @@ var LimitCommand = cli.Command{
 			Name:  "exclude-image-from-quota",
 			Usage: "Exclude base image from disk quota",
 		},
+		cli.StringFlag{
+			Name:  "qgroup",
+			Usage: "Limit this qgroup of the filesystem containing the volume instead of the volume itself",
+		},
 	},
 
 	Action: func(ctx *cli.Context) error {
@@ -35,12 +39,23 @@ var LimitCommand = cli.Command{
 
 		commandRunner := linux_command_runner.New()
 		limiter := limiterpkg.NewBtrfsLimiter(ctx.GlobalString("btrfs-bin"), commandRunner)
-		err := limiter.ApplyDiskLimit(
-			logger,
-			ctx.String("volume-path"),
-			ctx.Int64("disk-limit-bytes"),
-			ctx.Bool("exclude-image-from-quota"),
-		)
+
+		var err error
+		if ctx.IsSet("qgroup") {
+			err = limiter.LimitQuotaGroup(
+				logger,
+				ctx.String("volume-path"),
+				ctx.String("qgroup"),
+				ctx.Int64("disk-limit-bytes"),
+			)
+		} else {
+			err = limiter.ApplyDiskLimit(
+				logger,
+				ctx.String("volume-path"),
+				ctx.Int64("disk-limit-bytes"),
+				ctx.Bool("exclude-image-from-quota"),
+			)
+		}
 		if err != nil {
 			logger.Error("applying-limit-failed", err)
 			return cli.NewExitError(err.Error(), 1)
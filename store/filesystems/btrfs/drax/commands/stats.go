@@ -1,6 +1,7 @@
 package commands // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/drax/commands"
 
 import (
+	"encoding/json"
 	"os"
 
 	"code.cloudfoundry.org/commandrunner/linux_command_runner"
@@ -11,7 +12,7 @@ import (
 
 var StatsCommand = cli.Command{
 	Name:        "stats",
-	Usage:       "stats --volume-path <path> [--force-sync]",
+	Usage:       "stats --volume-path <path> [--qgroup <level>/<id>] [--force-sync]",
 	Description: "Get stats for a volume",
 
 	Flags: []cli.Flag{
@@ -19,6 +20,10 @@ var StatsCommand = cli.Command{
 			Name:  "volume-path",
 			Usage: "Path to the volume",
 		},
+		cli.StringFlag{
+			Name:  "qgroup",
+			Usage: "Get stats for this qgroup of the filesystem containing the volume instead of the volume itself",
+		},
 		cli.BoolFlag{
 			Name:  "force-sync",
 			Usage: "Force BTRFS to update stats immediately",
@@ -30,19 +35,37 @@ var StatsCommand = cli.Command{
 		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
 
 		commandRunner := linux_command_runner.New()
-		metrix := metrix.NewBtrfsStats(ctx.GlobalString("btrfs-bin"), commandRunner)
-		stats, err := metrix.VolumeStats(
-			logger,
-			ctx.String("volume-path"),
-			ctx.Bool("force-sync"),
+		btrfsStats := metrix.NewBtrfsStats(ctx.GlobalString("btrfs-bin"), commandRunner)
+
+		var (
+			stats metrix.QgroupUsage
+			err   error
 		)
+		if ctx.IsSet("qgroup") {
+			stats, err = btrfsStats.QgroupStats(
+				logger,
+				ctx.String("volume-path"),
+				ctx.String("qgroup"),
+				ctx.Bool("force-sync"),
+			)
+		} else {
+			stats, err = btrfsStats.VolumeStats(
+				logger,
+				ctx.String("volume-path"),
+				ctx.Bool("force-sync"),
+			)
+		}
 
 		if err != nil {
-			logger.Error("fetching-volume-stats", err)
+			if metrix.IsNotVolume(err) {
+				logger.Debug("fetching-volume-stats-not-a-volume", lager.Data{"error": err.Error()})
+			} else {
+				logger.Error("fetching-volume-stats", err)
+			}
 			return cli.NewExitError(err.Error(), 1)
 		}
 
-		if _, err := os.Stdout.Write(stats); err != nil {
+		if err := json.NewEncoder(os.Stdout).Encode(stats); err != nil {
 			logger.Error("encoding-stats", err)
 			return cli.NewExitError(err.Error(), 1)
 		}
@@ -5,8 +5,10 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"code.cloudfoundry.org/commandrunner"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
 	errorspkg "github.com/pkg/errors"
 
 	"code.cloudfoundry.org/lager"
@@ -29,6 +31,12 @@ func (i *BtrfsLimiter) ApplyDiskLimit(logger lager.Logger, path string, diskLimi
 	logger.Info("starting")
 	defer logger.Info("ending")
 
+	ioctlErr := ioctl.LimitQgroup(path, 0, diskLimit, exclusiveLimit)
+	if ioctlErr == nil {
+		return nil
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
 	cmd := exec.Command(i.btrfsBin, i.argsForLimit(path, strconv.FormatInt(diskLimit, 10), exclusiveLimit)...)
 	combinedBuffer := bytes.NewBuffer([]byte{})
 	cmd.Stdout = combinedBuffer
@@ -37,7 +45,132 @@ func (i *BtrfsLimiter) ApplyDiskLimit(logger lager.Logger, path string, diskLimi
 	logger.Debug("starting-btrfs-command", lager.Data{"cmd": cmd.Path, "args": cmd.Args})
 	if err := i.commandRunner.Run(cmd); err != nil {
 		logger.Error("command-failed", err, lager.Data{"commandOutput": combinedBuffer.String()})
-		return errorspkg.Errorf(strings.TrimSpace(combinedBuffer.String()))
+		return errorspkg.New(strings.TrimSpace(combinedBuffer.String()))
+	}
+
+	return nil
+}
+
+// LimitQuotaGroup limits the referenced bytes of the given qgroup, e.g. one of
+// the level-1 qgroups of a store, in the filesystem containing path.
+func (i *BtrfsLimiter) LimitQuotaGroup(logger lager.Logger, path, qgroupID string, diskLimit int64) error {
+	logger = logger.Session("btrfs-limiting-qgroup", lager.Data{"path": path, "qgroupID": qgroupID, "diskLimit": diskLimit})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	id, err := ioctl.ParseQgroupID(qgroupID)
+	if err != nil {
+		return err
+	}
+
+	ioctlErr := ioctl.LimitQgroup(path, id, diskLimit, false)
+	if ioctlErr == nil {
+		return nil
+	}
+	if errorspkg.Cause(ioctlErr) == syscall.ENOTCONN {
+		return errorspkg.Errorf("quotas are not enabled on `%s`", path)
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
+	cmd := exec.Command(i.btrfsBin, "qgroup", "limit", strconv.FormatInt(diskLimit, 10), qgroupID, path)
+	combinedBuffer := bytes.NewBuffer([]byte{})
+	cmd.Stdout = combinedBuffer
+	cmd.Stderr = combinedBuffer
+
+	logger.Debug("starting-btrfs-command", lager.Data{"cmd": cmd.Path, "args": cmd.Args})
+	if err := i.commandRunner.Run(cmd); err != nil {
+		logger.Error("command-failed", err, lager.Data{"commandOutput": combinedBuffer.String()})
+		return errorspkg.New(strings.TrimSpace(combinedBuffer.String()))
+	}
+
+	return nil
+}
+
+// CreateQuotaGroups creates the given qgroups in the filesystem containing
+// path. Qgroups that already exist are left as they are.
+func (i *BtrfsLimiter) CreateQuotaGroups(logger lager.Logger, path string, qgroupIDs []string) error {
+	logger = logger.Session("btrfs-creating-qgroups", lager.Data{"path": path, "qgroupIDs": qgroupIDs})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	for _, qgroupID := range qgroupIDs {
+		if err := i.createQuotaGroup(logger, path, qgroupID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *BtrfsLimiter) createQuotaGroup(logger lager.Logger, path, qgroupID string) error {
+	id, err := ioctl.ParseQgroupID(qgroupID)
+	if err != nil {
+		return err
+	}
+
+	ioctlErr := ioctl.CreateQgroup(path, id)
+	switch errorspkg.Cause(ioctlErr) {
+	case nil, syscall.EEXIST:
+		return nil
+	case syscall.ENOTCONN:
+		return errorspkg.Errorf("quotas are not enabled on `%s`", path)
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
+	cmd := exec.Command(i.btrfsBin, "qgroup", "create", qgroupID, path)
+	combinedBuffer := bytes.NewBuffer([]byte{})
+	cmd.Stdout = combinedBuffer
+	cmd.Stderr = combinedBuffer
+
+	logger.Debug("starting-btrfs-command", lager.Data{"cmd": cmd.Path, "args": cmd.Args})
+	if err := i.commandRunner.Run(cmd); err != nil {
+		if strings.Contains(combinedBuffer.String(), "File exists") {
+			return nil
+		}
+
+		logger.Error("command-failed", err, lager.Data{"commandOutput": combinedBuffer.String()})
+		return errorspkg.New(strings.TrimSpace(combinedBuffer.String()))
+	}
+
+	return nil
+}
+
+// AssignQuotaGroup adds the qgroup of the subvolume at path to the given
+// qgroup, e.g. one of the level-1 qgroups of a store.
+func (i *BtrfsLimiter) AssignQuotaGroup(logger lager.Logger, path, qgroupID string) error {
+	logger = logger.Session("btrfs-assigning-qgroup", lager.Data{"path": path, "qgroupID": qgroupID})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	id, err := ioctl.ParseQgroupID(qgroupID)
+	if err != nil {
+		return err
+	}
+
+	subvolumeID, err := ioctl.SubvolumeID(path)
+	if err != nil {
+		return err
+	}
+	subvolumeQgroupID := ioctl.QgroupID(0, subvolumeID)
+
+	ioctlErr := ioctl.AssignQgroup(path, subvolumeQgroupID, id)
+	switch errorspkg.Cause(ioctlErr) {
+	case nil, syscall.EEXIST:
+		return nil
+	case syscall.ENOTCONN:
+		return errorspkg.Errorf("quotas are not enabled on `%s`", path)
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
+	cmd := exec.Command(i.btrfsBin, "qgroup", "assign", ioctl.FormatQgroupID(subvolumeQgroupID), qgroupID, path)
+	combinedBuffer := bytes.NewBuffer([]byte{})
+	cmd.Stdout = combinedBuffer
+	cmd.Stderr = combinedBuffer
+
+	logger.Debug("starting-btrfs-command", lager.Data{"cmd": cmd.Path, "args": cmd.Args})
+	if err := i.commandRunner.Run(cmd); err != nil {
+		logger.Error("command-failed", err, lager.Data{"commandOutput": combinedBuffer.String()})
+		return errorspkg.New(strings.TrimSpace(combinedBuffer.String()))
 	}
 
 	return nil
@@ -48,6 +181,12 @@ func (i *BtrfsLimiter) DestroyQuotaGroup(logger lager.Logger, path string) error
 	logger.Info("starting")
 	defer logger.Info("ending")
 
+	ioctlErr := destroyQgroup(path)
+	if ioctlErr == nil {
+		return nil
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
 	cmd := exec.Command(i.btrfsBin, "qgroup", "destroy", path, path)
 	combinedBuffer := bytes.NewBuffer([]byte{})
 	cmd.Stdout = combinedBuffer
@@ -55,12 +194,21 @@ func (i *BtrfsLimiter) DestroyQuotaGroup(logger lager.Logger, path string) error
 
 	if err := i.commandRunner.Run(cmd); err != nil {
 		logger.Error("command-failed", err)
-		return errorspkg.Errorf(strings.TrimSpace(combinedBuffer.String()))
+		return errorspkg.New(strings.TrimSpace(combinedBuffer.String()))
 	}
 
 	return nil
 }
 
+func destroyQgroup(path string) error {
+	subvolumeID, err := ioctl.SubvolumeID(path)
+	if err != nil {
+		return err
+	}
+
+	return ioctl.DestroyQgroup(path, ioctl.QgroupID(0, subvolumeID))
+}
+
 func (i *BtrfsLimiter) argsForLimit(path, diskLimit string, exclusiveLimit bool) []string {
 	args := []string{"qgroup", "limit"}
 	if exclusiveLimit {
@@ -74,6 +74,118 @@ var _ = Describe("Btrfs", func() {
 		})
 	})
 
+	Describe("LimitQuotaGroup", func() {
+		It("limits the provided qgroup", func() {
+			Expect(limiter.LimitQuotaGroup(logger, "/full/path/to/store", "1/1", 1024*1024)).To(Succeed())
+
+			Expect(fakeCommandRunner).Should(HaveExecutedSerially(fake_command_runner.CommandSpec{
+				Path: "custom-btrfs-bin",
+				Args: []string{"qgroup", "limit", "1048576", "1/1", "/full/path/to/store"},
+			}))
+		})
+
+		Context("when the qgroup id is invalid", func() {
+			It("returns an error without running btrfs", func() {
+				err := limiter.LimitQuotaGroup(logger, "/full/path/to/store", "1-1", 1024*1024)
+				Expect(err).To(MatchError(ContainSubstring("invalid qgroup id `1-1`")))
+				Expect(fakeCommandRunner.ExecutedCommands()).To(BeEmpty())
+			})
+		})
+
+		Context("when setting the limit fails", func() {
+			BeforeEach(func() {
+				fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+				}, func(cmd *exec.Cmd) error {
+					_, err := cmd.Stdout.Write([]byte("failed to set btrfs limit"))
+					Expect(err).NotTo(HaveOccurred())
+
+					return errors.New("exit status 1")
+				})
+			})
+
+			It("forwards the output", func() {
+				err := limiter.LimitQuotaGroup(logger, "/full/path/to/store", "1/1", 1024*1024)
+				Expect(err).To(MatchError(ContainSubstring("failed to set btrfs limit")))
+			})
+		})
+	})
+
+	Describe("CreateQuotaGroups", func() {
+		It("creates every qgroup", func() {
+			Expect(limiter.CreateQuotaGroups(logger, "/full/path/to/store", []string{"1/1", "1/2"})).To(Succeed())
+
+			Expect(fakeCommandRunner).Should(HaveExecutedSerially(
+				fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+					Args: []string{"qgroup", "create", "1/1", "/full/path/to/store"},
+				},
+				fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+					Args: []string{"qgroup", "create", "1/2", "/full/path/to/store"},
+				},
+			))
+		})
+
+		Context("when a qgroup already exists", func() {
+			BeforeEach(func() {
+				fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+					Args: []string{"qgroup", "create", "1/1", "/full/path/to/store"},
+				}, func(cmd *exec.Cmd) error {
+					_, err := cmd.Stderr.Write([]byte("ERROR: unable to create quota group: File exists"))
+					Expect(err).NotTo(HaveOccurred())
+
+					return errors.New("exit status 1")
+				})
+			})
+
+			It("carries on with the next one", func() {
+				Expect(limiter.CreateQuotaGroups(logger, "/full/path/to/store", []string{"1/1", "1/2"})).To(Succeed())
+
+				Expect(fakeCommandRunner).Should(HaveExecutedSerially(fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+					Args: []string{"qgroup", "create", "1/2", "/full/path/to/store"},
+				}))
+			})
+		})
+
+		Context("when creating a qgroup fails", func() {
+			BeforeEach(func() {
+				fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+					Path: "custom-btrfs-bin",
+				}, func(cmd *exec.Cmd) error {
+					_, err := cmd.Stderr.Write([]byte("ERROR: quota not enabled"))
+					Expect(err).NotTo(HaveOccurred())
+
+					return errors.New("exit status 1")
+				})
+			})
+
+			It("returns an error", func() {
+				err := limiter.CreateQuotaGroups(logger, "/full/path/to/store", []string{"1/1"})
+				Expect(err).To(MatchError(ContainSubstring("quota not enabled")))
+			})
+		})
+	})
+
+	Describe("AssignQuotaGroup", func() {
+		Context("when the qgroup id is invalid", func() {
+			It("returns an error", func() {
+				err := limiter.AssignQuotaGroup(logger, "/full/path/to/volume", "one")
+				Expect(err).To(MatchError(ContainSubstring("invalid qgroup id")))
+			})
+		})
+
+		Context("when the volume does not exist", func() {
+			It("returns an error without running btrfs", func() {
+				err := limiter.AssignQuotaGroup(logger, "/full/path/to/volume", "1/1")
+				Expect(err).To(MatchError(ContainSubstring("/full/path/to/volume")))
+				Expect(fakeCommandRunner.ExecutedCommands()).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("DestroyQuotaGroup", func() {
 		It("destroys the qgroup for the path", func() {
 			Expect(limiter.DestroyQuotaGroup(logger, "/full/path/to/volume")).To(Succeed())
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"code.cloudfoundry.org/commandrunner"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
 
 	"code.cloudfoundry.org/lager"
 	errorspkg "github.com/pkg/errors"
@@ -33,6 +34,12 @@ func (l *BtrfsLister) List(logger lager.Logger, imagePath string) ([]string, err
 	logger.Info("starting")
 	defer logger.Info("ending")
 
+	volumes, ioctlErr := ioctl.ListSubvolumes(imagePath)
+	if ioctlErr == nil {
+		return volumes, nil
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
 	cmd := exec.Command(l.btrfsBin, "subvolume", "list", imagePath)
 	outputBuffer := bytes.NewBuffer([]byte{})
 	cmd.Stdout = outputBuffer
@@ -52,7 +59,7 @@ func (l *BtrfsLister) List(logger lager.Logger, imagePath string) ([]string, err
 		return nil, errorspkg.Wrap(err, "find mount point")
 	}
 
-	volumes, err := l.extractPaths(outputBuffer, mountPoint, imagePath)
+	volumes, err = l.extractPaths(outputBuffer, mountPoint, imagePath)
 	if err != nil {
 		logger.Error("parsing-list-output-failed", err)
 		return nil, errorspkg.Wrap(err, "parse subvolume list")
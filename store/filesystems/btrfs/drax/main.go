@@ -34,6 +34,9 @@ func main() {
 		commands.ListCommand,
 		commands.DestroyCommand,
 		commands.StatsCommand,
+		commands.CompressionStatsCommand,
+		commands.CreateQgroupsCommand,
+		commands.AssignQgroupCommand,
 	}
 
 	drax.Run(os.Args)
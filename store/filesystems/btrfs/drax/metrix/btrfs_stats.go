@@ -3,13 +3,21 @@ package metrix // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/dr
 import (
 	"bytes"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"code.cloudfoundry.org/commandrunner"
 	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
 	errorspkg "github.com/pkg/errors"
 )
 
+// QgroupUsage is the usage accounted to a qgroup. drax prints it as JSON.
+type QgroupUsage struct {
+	ReferencedBytes int64 `json:"referenced_bytes"`
+	ExclusiveBytes  int64 `json:"exclusive_bytes"`
+}
+
 type BtrfsStats struct {
 	commandRunner commandrunner.CommandRunner
 	btrfsBin      string
@@ -22,13 +30,21 @@ func NewBtrfsStats(btrfsBin string, commandRunner commandrunner.CommandRunner) *
 	}
 }
 
-func (m *BtrfsStats) VolumeStats(logger lager.Logger, path string, forceSync bool) ([]byte, error) {
+func (m *BtrfsStats) VolumeStats(logger lager.Logger, path string, forceSync bool) (QgroupUsage, error) {
 	logger = logger.Session("btrfs-fetching-volume-stats", lager.Data{"path": path, "forceSync": forceSync})
 	logger.Info("starting")
 	defer logger.Info("ending")
 
+	if stats, ioctlErr := m.volumeStatsFromIoctls(path, forceSync); ioctlErr == nil {
+		return stats, nil
+	} else if errorspkg.Cause(ioctlErr) == errNotSubvolume {
+		return QgroupUsage{}, errorspkg.Wrapf(errNotSubvolume, "`%s` is not a btrfs volume", path)
+	} else {
+		logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+	}
+
 	if err := m.isSubvolume(logger, path); err != nil {
-		return nil, err
+		return QgroupUsage{}, err
 	}
 
 	if forceSync {
@@ -39,7 +55,7 @@ func (m *BtrfsStats) VolumeStats(logger lager.Logger, path string, forceSync boo
 
 		if err := m.commandRunner.Run(cmd); err != nil {
 			logger.Error("command-failed", err)
-			return nil, errorspkg.Errorf("syncing filesystem: %s", strings.TrimSpace(combinedBuffer.String()))
+			return QgroupUsage{}, errorspkg.Errorf("syncing filesystem: %s", strings.TrimSpace(combinedBuffer.String()))
 		}
 	}
 
@@ -51,13 +67,131 @@ func (m *BtrfsStats) VolumeStats(logger lager.Logger, path string, forceSync boo
 
 	if err := m.commandRunner.Run(cmd); err != nil {
 		logger.Error("command-failed", err)
-		return nil, errorspkg.Errorf("qgroup usage: %s, %s",
+		return QgroupUsage{}, errorspkg.Errorf("qgroup usage: %s, %s",
 			strings.TrimSpace(outputBuffer.String()),
 			strings.TrimSpace(errorBuffer.String()))
 	}
 
 	logger.Debug("btrfs-output", lager.Data{"output": outputBuffer.String()})
-	return outputBuffer.Bytes(), nil
+
+	// `-F` lists the qgroups the volume belongs to, its own comes last
+	lines := strings.Split(strings.TrimSpace(outputBuffer.String()), "\n")
+	return parseQgroupLine(lines[len(lines)-1])
+}
+
+// QgroupStats reports the usage of a qgroup of the filesystem containing
+// path, e.g. one of the level-1 qgroups of a store, in the same format as
+// VolumeStats.
+func (m *BtrfsStats) QgroupStats(logger lager.Logger, path, qgroupID string, forceSync bool) (QgroupUsage, error) {
+	logger = logger.Session("btrfs-fetching-qgroup-stats", lager.Data{"path": path, "qgroupID": qgroupID, "forceSync": forceSync})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	id, err := ioctl.ParseQgroupID(qgroupID)
+	if err != nil {
+		return QgroupUsage{}, err
+	}
+
+	stats, ioctlErr := m.qgroupStatsFromIoctls(path, id, forceSync)
+	if ioctlErr == nil {
+		return stats, nil
+	}
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+
+	if forceSync {
+		cmd := exec.Command(m.btrfsBin, "filesystem", "sync", path)
+		combinedBuffer := bytes.NewBuffer([]byte{})
+		cmd.Stdout = combinedBuffer
+		cmd.Stderr = combinedBuffer
+
+		if err := m.commandRunner.Run(cmd); err != nil {
+			logger.Error("command-failed", err)
+			return QgroupUsage{}, errorspkg.Errorf("syncing filesystem: %s", strings.TrimSpace(combinedBuffer.String()))
+		}
+	}
+
+	cmd := exec.Command(m.btrfsBin, "qgroup", "show", "--raw", path)
+	outputBuffer := bytes.NewBuffer([]byte{})
+	cmd.Stdout = outputBuffer
+	errorBuffer := bytes.NewBuffer([]byte{})
+	cmd.Stderr = errorBuffer
+
+	if err := m.commandRunner.Run(cmd); err != nil {
+		logger.Error("command-failed", err)
+		return QgroupUsage{}, errorspkg.Errorf("qgroup usage: %s, %s",
+			strings.TrimSpace(outputBuffer.String()),
+			strings.TrimSpace(errorBuffer.String()))
+	}
+
+	for _, line := range strings.Split(outputBuffer.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == qgroupID {
+			return parseQgroupLine(line)
+		}
+	}
+
+	return QgroupUsage{}, errorspkg.Errorf("qgroup %s not found", qgroupID)
+}
+
+// parseQgroupLine reads a line of `btrfs qgroup show --raw`, which ends with
+// the referenced and exclusive bytes of the qgroup.
+func parseQgroupLine(line string) (QgroupUsage, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return QgroupUsage{}, errorspkg.Errorf("could not parse qgroup usage: %s", line)
+	}
+
+	referenced, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return QgroupUsage{}, errorspkg.Wrapf(err, "could not parse qgroup usage: %s", line)
+	}
+	exclusive, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return QgroupUsage{}, errorspkg.Wrapf(err, "could not parse qgroup usage: %s", line)
+	}
+
+	return QgroupUsage{ReferencedBytes: referenced, ExclusiveBytes: exclusive}, nil
+}
+
+func (m *BtrfsStats) qgroupStatsFromIoctls(path string, qgroupID uint64, forceSync bool) (QgroupUsage, error) {
+	if forceSync {
+		if err := ioctl.Sync(path); err != nil {
+			return QgroupUsage{}, err
+		}
+	}
+
+	usage, err := ioctl.QgroupUsage(path, qgroupID)
+	if err != nil {
+		return QgroupUsage{}, err
+	}
+
+	return QgroupUsage{ReferencedBytes: usage.Referenced, ExclusiveBytes: usage.Exclusive}, nil
+}
+
+var errNotSubvolume = errorspkg.New("not a subvolume")
+
+// IsNotVolume tells whether the stats failed because the path is not a btrfs
+// subvolume, which callers probing paths expect to happen.
+func IsNotVolume(err error) bool {
+	return errorspkg.Cause(err) == errNotSubvolume
+}
+
+// volumeStatsFromIoctls reads the qgroup usage of the volume.
+func (m *BtrfsStats) volumeStatsFromIoctls(path string, forceSync bool) (QgroupUsage, error) {
+	isSubvolume, err := ioctl.IsSubvolume(path)
+	if err != nil {
+		return QgroupUsage{}, err
+	}
+	if !isSubvolume {
+		return QgroupUsage{}, errNotSubvolume
+	}
+
+	subvolumeID, err := ioctl.SubvolumeID(path)
+	if err != nil {
+		return QgroupUsage{}, err
+	}
+
+	return m.qgroupStatsFromIoctls(path, ioctl.QgroupID(0, subvolumeID), forceSync)
 }
 
 func (m *BtrfsStats) isSubvolume(logger lager.Logger, path string) error {
@@ -77,3 +211,28 @@ func (m *BtrfsStats) isSubvolume(logger lager.Logger, path string) error {
 
 	return nil
 }
+
+// CompressionStats reports the logical size of the data in the volume and
+// the space it takes on disk once compressed. btrfs-progs has no equivalent,
+// so there is no fallback to the CLI.
+func (m *BtrfsStats) CompressionStats(logger lager.Logger, path string) (ioctl.CompressionInfo, error) {
+	logger = logger.Session("btrfs-fetching-compression-stats", lager.Data{"path": path})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	isSubvolume, err := ioctl.IsSubvolume(path)
+	if err != nil {
+		return ioctl.CompressionInfo{}, err
+	}
+	if !isSubvolume {
+		return ioctl.CompressionInfo{}, errorspkg.Wrapf(errNotSubvolume, "`%s` is not a btrfs volume", path)
+	}
+
+	usage, err := ioctl.CompressionUsage(path)
+	if err != nil {
+		logger.Error("compression-usage-failed", err)
+		return ioctl.CompressionInfo{}, errorspkg.Wrap(err, "compression usage")
+	}
+
+	return usage, nil
+}
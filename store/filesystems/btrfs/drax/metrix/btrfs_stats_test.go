@@ -2,6 +2,8 @@ package metrix_test
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
 	"os/exec"
 
 	"code.cloudfoundry.org/lager"
@@ -47,7 +49,7 @@ var _ = Describe("Stats", func() {
 			m, err := btrfsStats.VolumeStats(logger, "/full/path/to/volume", false)
 			Expect(err).ToNot(HaveOccurred())
 
-			Expect(m).To(Equal(stats))
+			Expect(m).To(Equal(metrix.QgroupUsage{ReferencedBytes: 2113536, ExclusiveBytes: 1064960}))
 		})
 
 		It("runs the correct btrfs command", func() {
@@ -154,6 +156,17 @@ var _ = Describe("Stats", func() {
 			})
 		})
 
+		Context("when the output cannot be parsed", func() {
+			BeforeEach(func() {
+				stats = []byte("qgroupid         rfer         excl\n--------         ----         ----\n0/259         2113536      lots")
+			})
+
+			It("returns an error", func() {
+				_, err := btrfsStats.VolumeStats(logger, "/full/path/to/volume", false)
+				Expect(err).To(MatchError(ContainSubstring("could not parse qgroup usage")))
+			})
+		})
+
 		Context("when qgroup fails", func() {
 			BeforeEach(func() {
 				fakeCommandRunner = fake_command_runner.New()
@@ -177,4 +190,89 @@ var _ = Describe("Stats", func() {
 			})
 		})
 	})
+
+	Describe("QgroupStats", func() {
+		BeforeEach(func() {
+			fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+				Path: "custom-btrfs-bin",
+				Args: []string{"qgroup", "show", "--raw", "/full/path/to/store"},
+			}, func(cmd *exec.Cmd) error {
+				_, err := cmd.Stdout.Write([]byte("qgroupid         rfer         excl \n--------         ----         ---- \n0/5          16384        16384 \n0/259      2113536      1064960 \n1/1        4227072      4227072 \n1/2        1064960      1064960 \n"))
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+		})
+
+		It("returns the usage of the qgroup alone", func() {
+			m, err := btrfsStats.QgroupStats(logger, "/full/path/to/store", "1/1", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(m).To(Equal(metrix.QgroupUsage{ReferencedBytes: 4227072, ExclusiveBytes: 4227072}))
+		})
+
+		Context("when force-sync is given", func() {
+			It("forces the filesystem to sync", func() {
+				_, err := btrfsStats.QgroupStats(logger, "/full/path/to/store", "1/1", true)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeCommandRunner).Should(HaveExecutedSerially(
+					fake_command_runner.CommandSpec{
+						Path: "custom-btrfs-bin",
+						Args: []string{"filesystem", "sync", "/full/path/to/store"},
+					},
+					fake_command_runner.CommandSpec{
+						Path: "custom-btrfs-bin",
+						Args: []string{"qgroup", "show", "--raw", "/full/path/to/store"},
+					},
+				))
+			})
+		})
+
+		Context("when the qgroup does not exist", func() {
+			It("returns an error", func() {
+				_, err := btrfsStats.QgroupStats(logger, "/full/path/to/store", "1/3", false)
+				Expect(err).To(MatchError("qgroup 1/3 not found"))
+			})
+		})
+
+		Context("when the qgroup id is invalid", func() {
+			It("returns an error", func() {
+				_, err := btrfsStats.QgroupStats(logger, "/full/path/to/store", "one", false)
+				Expect(err).To(MatchError(ContainSubstring("invalid qgroup id")))
+			})
+		})
+	})
+
+	Describe("CompressionStats", func() {
+		Context("when the path is not a volume", func() {
+			var volumePath string
+
+			BeforeEach(func() {
+				var err error
+				volumePath, err = ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(volumePath)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				_, err := btrfsStats.CompressionStats(logger, volumePath)
+				Expect(err).To(MatchError(ContainSubstring("is not a btrfs volume")))
+			})
+
+			It("doesn't run the btrfs binary", func() {
+				_, _ = btrfsStats.CompressionStats(logger, volumePath)
+				Expect(fakeCommandRunner.ExecutedCommands()).To(BeEmpty())
+			})
+		})
+
+		Context("when the path does not exist", func() {
+			It("returns an error", func() {
+				_, err := btrfsStats.CompressionStats(logger, "/full/path/to/volume")
+				Expect(err).To(MatchError(ContainSubstring("/full/path/to/volume")))
+			})
+		})
+	})
 })
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/tscolari/lagregator"
 
@@ -20,6 +23,7 @@ import (
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/store"
 	"github.com/SUSE/groot-btrfs/store/filesystems"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
 	"github.com/SUSE/groot-btrfs/store/filesystems/spec"
 	"github.com/SUSE/groot-btrfs/store/image_cloner"
 	errorspkg "github.com/pkg/errors"
@@ -27,6 +31,18 @@ import (
 
 const (
 	BtrfsType = 0x9123683E
+
+	// trashBatchSize is how many trashed subvolumes EmptyTrash destroys
+	// before waiting for the deletion to be committed
+	trashBatchSize = 32
+)
+
+// Every layer volume is added to LayersQgroup and every image to ImagesQgroup,
+// so that the usage of the layer cache and of the images can be queried, and
+// the layer cache limited, as a whole.
+const (
+	LayersQgroup = "1/1"
+	ImagesQgroup = "1/2"
 )
 
 type Driver struct {
@@ -34,6 +50,9 @@ type Driver struct {
 	btrfsBinPath string
 	mkfsBinPath  string
 	storePath    string
+	compression  string
+
+	compressionStats bool
 }
 
 func NewDriver(btrfsBinPath, mkfsBinPath, draxBinPath, storePath string) *Driver {
@@ -45,6 +64,21 @@ func NewDriver(btrfsBinPath, mkfsBinPath, draxBinPath, storePath string) *Driver
 	}
 }
 
+// WithCompression sets the compression, `<algorithm>[:<level>]`, that the
+// filesystems created by InitFilesystem are mounted with.
+func (d *Driver) WithCompression(compression string) *Driver {
+	d.compression = compression
+	return d
+}
+
+// WithCompressionStats makes FetchStats report the logical and compressed
+// usage of the image too. That walks every extent of the image, so it is off
+// by default.
+func (d *Driver) WithCompressionStats(compressionStats bool) *Driver {
+	d.compressionStats = compressionStats
+	return d
+}
+
 func (d *Driver) InitFilesystem(logger lager.Logger, filesystemPath, storePath string) error {
 	logger = logger.Session("btrfs-init-filesystem")
 	logger.Debug("starting")
@@ -62,6 +96,105 @@ func (d *Driver) InitFilesystem(logger lager.Logger, filesystemPath, storePath s
 	return nil
 }
 
+// InitQuotaGroups creates the store qgroups and limits the layer cache to
+// cacheLimitBytes, when it is positive. Without a limit the qgroups are only
+// used for reporting, so failing to create them, e.g. because quotas are not
+// enabled, is not an error.
+func (d *Driver) InitQuotaGroups(logger lager.Logger, cacheLimitBytes int64) error {
+	logger = logger.Session("btrfs-init-quota-groups", lager.Data{"cacheLimitBytes": cacheLimitBytes})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	args := []string{
+		"--btrfs-bin", d.btrfsBinPath,
+		"create-qgroups",
+		"--path", d.storePath,
+		"--qgroup", LayersQgroup,
+		"--qgroup", ImagesQgroup,
+	}
+	if _, err := d.runDrax(logger, args...); err != nil {
+		if cacheLimitBytes <= 0 {
+			logger.Info("creating-qgroups-failed", lager.Data{"warning": "store usage will not be reported", "error": err.Error()})
+			return nil
+		}
+		return errorspkg.Wrap(err, "creating store qgroups")
+	}
+
+	if cacheLimitBytes <= 0 {
+		return nil
+	}
+
+	args = []string{
+		"--btrfs-bin", d.btrfsBinPath,
+		"limit",
+		"--volume-path", d.storePath,
+		"--qgroup", LayersQgroup,
+		"--disk-limit-bytes", strconv.FormatInt(cacheLimitBytes, 10),
+	}
+	if _, err := d.runDrax(logger, args...); err != nil {
+		return errorspkg.Wrap(err, "limiting layer cache")
+	}
+
+	return nil
+}
+
+// ResizeFilesystem grows or shrinks the mounted store filesystem to
+// sizeBytes. The device has to be large enough for it already.
+func (d *Driver) ResizeFilesystem(logger lager.Logger, sizeBytes int64) error {
+	logger = logger.Session("btrfs-resizing-filesystem", lager.Data{"sizeBytes": sizeBytes})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	ioctlErr := ioctl.ResizeFilesystem(d.storePath, sizeBytes)
+	if ioctlErr == nil {
+		return nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return errorspkg.Wrap(ioctlErr, "resizing filesystem")
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "filesystem", "resize", strconv.FormatInt(sizeBytes, 10), d.storePath)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if contents, err := cmd.CombinedOutput(); err != nil {
+		logger.Error("btrfs-failed", err)
+		return errorspkg.Wrapf(err, "resizing filesystem: %s", strings.TrimSpace(string(contents)))
+	}
+
+	return nil
+}
+
+// AllocatedBytes reports how much of the store filesystem is allocated to
+// chunks, which is the least it can be shrunk to.
+func (d *Driver) AllocatedBytes(logger lager.Logger) (int64, error) {
+	logger = logger.Session("btrfs-reading-allocated-bytes")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	allocated, ioctlErr := ioctl.AllocatedBytes(d.storePath)
+	if ioctlErr == nil {
+		return allocated, nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return 0, errorspkg.Wrap(ioctlErr, "reading allocated bytes")
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "filesystem", "usage", "-b", d.storePath)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	contents, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, errorspkg.Wrapf(err, "reading allocated bytes: %s", strings.TrimSpace(string(contents)))
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) == 2 && fields[0] == "Device allocated" {
+			return strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		}
+	}
+
+	return 0, errorspkg.Errorf("reading allocated bytes: no allocation in `%s`", strings.TrimSpace(string(contents)))
+}
+
 func (d *Driver) ConfigureStore(logger lager.Logger, storePath string, ownerUID, ownerGID int) error {
 	return nil
 }
@@ -94,13 +227,29 @@ func (d *Driver) CreateVolume(logger lager.Logger, parentID, id string) (string,
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	var cmd *exec.Cmd
+	var (
+		cmd      *exec.Cmd
+		ioctlErr error
+	)
 	volPath := filepath.Join(d.storePath, store.VolumesDirName, id)
 	if parentID == "" {
-		cmd = exec.Command(d.btrfsBinPath, "subvolume", "create", volPath)
+		ioctlErr = d.createInQgroup(logger, LayersQgroup, func(qgroupIDs ...uint64) error {
+			return ioctl.CreateSubvolume(volPath, qgroupIDs...)
+		})
+		cmd = exec.Command(d.btrfsBinPath, "subvolume", "create", "-i", LayersQgroup, volPath)
 	} else {
 		parentVolPath := filepath.Join(d.storePath, store.VolumesDirName, parentID)
-		cmd = exec.Command(d.btrfsBinPath, "subvolume", "snapshot", parentVolPath, volPath)
+		ioctlErr = d.createInQgroup(logger, LayersQgroup, func(qgroupIDs ...uint64) error {
+			return ioctl.CreateSnapshot(parentVolPath, volPath, false, qgroupIDs...)
+		})
+		cmd = exec.Command(d.btrfsBinPath, "subvolume", "snapshot", "-i", LayersQgroup, parentVolPath, volPath)
+	}
+
+	if ioctlErr == nil {
+		return volPath, nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return "", errorspkg.Wrapf(ioctlErr, "creating btrfs volume `%s`", volPath)
 	}
 
 	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
@@ -157,6 +306,56 @@ func cleanWhiteoutDir(path string) error {
 	return nil
 }
 
+func (d *Driver) SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error {
+	logger = logger.Session("btrfs-setting-volume-read-only", lager.Data{"volumeID": id, "readOnly": readOnly})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	return d.setReadOnly(logger, filepath.Join(d.storePath, store.VolumesDirName, id), readOnly)
+}
+
+func (d *Driver) IsVolumeReadOnly(logger lager.Logger, id string) (bool, error) {
+	logger = logger.Session("btrfs-checking-volume-read-only", lager.Data{"volumeID": id})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	volumePath := filepath.Join(d.storePath, store.VolumesDirName, id)
+	readOnly, ioctlErr := ioctl.IsReadOnly(volumePath)
+	if ioctlErr == nil {
+		return readOnly, nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return false, errorspkg.Wrapf(ioctlErr, "checking if volume `%s` is read-only", id)
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "property", "get", volumePath, "ro")
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	contents, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, errorspkg.Wrapf(err, "checking if volume `%s` is read-only: %s", id, strings.TrimSpace(string(contents)))
+	}
+
+	return strings.TrimSpace(string(contents)) == "ro=true", nil
+}
+
+func (d *Driver) setReadOnly(logger lager.Logger, path string, readOnly bool) error {
+	ioctlErr := ioctl.SetReadOnly(path, readOnly)
+	if ioctlErr == nil {
+		return nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return errorspkg.Wrapf(ioctlErr, "setting read-only on `%s`", path)
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "property", "set", path, "ro", strconv.FormatBool(readOnly))
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if contents, err := cmd.CombinedOutput(); err != nil {
+		return errorspkg.Wrapf(err, "setting read-only on `%s`: %s", path, strings.TrimSpace(string(contents)))
+	}
+
+	return nil
+}
+
 func (d *Driver) WriteVolumeMeta(logger lager.Logger, id string, metadata base_image_puller.VolumeMeta) error {
 	logger = logger.Session("btrfs-writing-volume-metadata", lager.Data{"volumeID": id})
 	logger.Debug("starting")
@@ -193,13 +392,8 @@ func (d *Driver) CreateImage(logger lager.Logger, spec image_cloner.ImageDriverS
 		toPath = mountInfo.Source
 	}
 
-	cmd := exec.Command(d.btrfsBinPath, "subvolume", "snapshot", baseVolumePath, toPath)
-	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
-	if contents, err := cmd.CombinedOutput(); err != nil {
-		return groot.MountInfo{}, errorspkg.Errorf(
-			"creating btrfs snapshot from `%s` to `%s` (%s): %s",
-			baseVolumePath, toPath, err, string(contents),
-		)
+	if err := d.snapshot(logger, baseVolumePath, toPath); err != nil {
+		return groot.MountInfo{}, err
 	}
 
 	if err := os.Chmod(toPath, 0755); err != nil {
@@ -207,9 +401,80 @@ func (d *Driver) CreateImage(logger lager.Logger, spec image_cloner.ImageDriverS
 		return mountInfo, errorspkg.Wrap(err, "chmoding snapshot")
 	}
 
+	if spec.Compression != "" {
+		if err := d.setCompression(logger, toPath, spec.Compression); err != nil {
+			return mountInfo, err
+		}
+	}
+
 	return mountInfo, d.applyDiskLimit(logger, spec)
 }
 
+// createInQgroup runs create, which creates a subvolume in the given store
+// qgroup. When quotas are enabled but the store was initialized before it had
+// qgroups, the kernel refuses the qgroup and the subvolume is created outside
+// of it instead.
+func (d *Driver) createInQgroup(logger lager.Logger, qgroupID string, create func(qgroupIDs ...uint64) error) error {
+	id, err := ioctl.ParseQgroupID(qgroupID)
+	if err != nil {
+		return err
+	}
+
+	err = create(id)
+	if errorspkg.Cause(err) == syscall.ENOENT {
+		logger.Info("store-qgroup-not-found", lager.Data{"qgroupID": qgroupID})
+		return create()
+	}
+
+	return err
+}
+
+// setCompression sets the compression property of the snapshot, which files
+// written to the image inherit. The property takes no level, that can only be
+// set for the whole filesystem when mounting it.
+func (d *Driver) setCompression(logger lager.Logger, path, compression string) error {
+	algorithm := strings.SplitN(compression, ":", 2)[0]
+
+	ioctlErr := ioctl.SetCompression(path, algorithm)
+	if ioctlErr == nil {
+		return nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return errorspkg.Wrapf(ioctlErr, "setting compression of `%s`", path)
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "property", "set", path, "compression", algorithm)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if contents, err := cmd.CombinedOutput(); err != nil {
+		return errorspkg.Errorf("setting compression of `%s` (%s): %s", path, err, string(contents))
+	}
+
+	return nil
+}
+
+func (d *Driver) snapshot(logger lager.Logger, fromPath, toPath string) error {
+	ioctlErr := d.createInQgroup(logger, ImagesQgroup, func(qgroupIDs ...uint64) error {
+		return ioctl.CreateSnapshot(fromPath, toPath, false, qgroupIDs...)
+	})
+	if ioctlErr == nil {
+		return nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return errorspkg.Wrapf(ioctlErr, "creating btrfs snapshot from `%s` to `%s`", fromPath, toPath)
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "subvolume", "snapshot", "-i", ImagesQgroup, fromPath, toPath)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if contents, err := cmd.CombinedOutput(); err != nil {
+		return errorspkg.Errorf(
+			"creating btrfs snapshot from `%s` to `%s` (%s): %s",
+			fromPath, toPath, err, string(contents),
+		)
+	}
+
+	return nil
+}
+
 func (d *Driver) Volumes(logger lager.Logger) ([]string, error) {
 	logger = logger.Session("btrfs-listing-volumes")
 	logger.Debug("starting")
@@ -235,6 +500,16 @@ func (d *Driver) VolumeSize(logger lager.Logger, id string) (int64, error) {
 	return filesystems.VolumeSize(logger, d.storePath, id)
 }
 
+func (d *Driver) VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error) {
+	logger = logger.Session("btrfs-reading-volume-metadata", lager.Data{"volumeID": id})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	return filesystems.ReadVolumeMeta(logger, d.storePath, id)
+}
+
+// DestroyVolume moves the volume to the trash, from where EmptyTrash destroys
+// it.
 func (d *Driver) DestroyVolume(logger lager.Logger, id string) error {
 	logger = logger.Session("btrfs-destroying-volume", lager.Data{"volumeID": id})
 	logger.Info("starting")
@@ -245,9 +520,35 @@ func (d *Driver) DestroyVolume(logger lager.Logger, id string) error {
 		logger.Error("deleting-metadata-file-failed", err, lager.Data{"path": volumeMetaFilePath})
 	}
 
-	return d.destroyBtrfsVolume(logger, filepath.Join(d.storePath, "volumes", id))
+	volumePath := filepath.Join(d.storePath, store.VolumesDirName, id)
+	return d.moveToTrash(logger, volumePath, id)
 }
 
+// ImageHasSubvolume reports whether the image at imagePath has its rootfs
+// snapshot, in rootfs when it is mounted or in snapshot otherwise.
+func (d *Driver) ImageHasSubvolume(logger lager.Logger, imagePath string) (bool, error) {
+	logger = logger.Session("btrfs-checking-image-subvolume", lager.Data{"imagePath": imagePath})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	for _, name := range []string{"rootfs", "snapshot"} {
+		isSubvolume, err := ioctl.IsSubvolume(filepath.Join(imagePath, name))
+		if os.IsNotExist(errorspkg.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if isSubvolume {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DestroyImage moves the image subvolume to the trash, from where EmptyTrash
+// destroys it, and removes the image directory.
 func (d *Driver) DestroyImage(logger lager.Logger, imagePath string) error {
 	logger = logger.Session("btrfs-destroying-image", lager.Data{"imagePath": imagePath})
 	logger.Info("starting")
@@ -262,29 +563,267 @@ func (d *Driver) DestroyImage(logger lager.Logger, imagePath string) error {
 		snapshotMountPath = filepath.Join(imagePath, "snapshot")
 	}
 
-	err := d.destroyBtrfsVolume(logger, snapshotMountPath)
-	if err != nil && strings.Contains(err.Error(), "Directory not empty") {
-		subvolumes, listErr := d.listSubvolumes(logger, imagePath)
-		if listErr != nil {
-			logger.Error("listing-subvolumes-failed", listErr)
-			return errorspkg.Wrap(listErr, "list subvolumes")
-		}
+	err := d.moveToTrash(logger, snapshotMountPath, filepath.Base(imagePath))
+
+	if err := os.RemoveAll(imagePath); err != nil {
+		logger.Error("removing-image-path", err)
+	}
+
+	return err
+}
+
+// SendVolume writes the `btrfs send` stream of the volume to stream,
+// incremental against parentID when it is given. Complete volumes are
+// read-only, as sending requires.
+func (d *Driver) SendVolume(logger lager.Logger, id, parentID string, stream io.Writer) error {
+	logger = logger.Session("btrfs-sending-volume", lager.Data{"volumeID": id, "parentID": parentID})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	args := []string{"send", "-q"}
+	if parentID != "" {
+		args = append(args, "-p", filepath.Join(d.storePath, store.VolumesDirName, parentID))
+	}
+	args = append(args, filepath.Join(d.storePath, store.VolumesDirName, id))
+
+	stderr := bytes.NewBuffer([]byte{})
+	cmd := exec.Command(d.btrfsBinPath, args...)
+	cmd.Stdout = stream
+	cmd.Stderr = stderr
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if err := cmd.Run(); err != nil {
+		logger.Error("btrfs-failed", err, lager.Data{"stderr": stderr.String()})
+		return errorspkg.Wrapf(err, "sending volume `%s`: %s", id, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// ReceiveVolume creates the volume from a `btrfs send` stream. The stream is
+// received in the store tmp directory and only moved into the volumes once
+// it is complete.
+func (d *Driver) ReceiveVolume(logger lager.Logger, id string, stream io.Reader) error {
+	logger = logger.Session("btrfs-receiving-volume", lager.Data{"volumeID": id})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	receivePath, err := ioutil.TempDir(filepath.Join(d.storePath, store.TempDirName), "receive-")
+	if err != nil {
+		return errorspkg.Wrap(err, "creating receive directory")
+	}
+	defer os.RemoveAll(receivePath)
+
+	receivedPath := filepath.Join(receivePath, id)
+	stderr := bytes.NewBuffer([]byte{})
+	cmd := exec.Command(d.btrfsBinPath, "receive", "-e", receivePath)
+	cmd.Stdin = stream
+	cmd.Stderr = stderr
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if err := cmd.Run(); err != nil {
+		logger.Error("btrfs-failed", err, lager.Data{"stderr": stderr.String()})
+		d.trashReceived(logger, receivedPath, id)
+		return errorspkg.Wrapf(err, "receiving volume `%s`: %s", id, strings.TrimSpace(stderr.String()))
+	}
+
+	if _, err := os.Stat(receivedPath); err != nil {
+		return errorspkg.Errorf("stream does not contain volume `%s`", id)
+	}
+
+	volumePath := filepath.Join(d.storePath, store.VolumesDirName, id)
+	if err := os.Rename(receivedPath, volumePath); err != nil {
+		logger.Error("moving-received-volume-failed", err)
+		d.trashReceived(logger, receivedPath, id)
+		return errorspkg.Wrapf(err, "moving received volume `%s`", id)
+	}
 
-		for _, subvolume := range subvolumes {
-			if err := d.destroyBtrfsVolume(logger, subvolume); err != nil {
-				return err
+	// receiving cannot create the subvolume in a qgroup like CreateVolume
+	// does. As there, a store without qgroups still gets the volume.
+	if err := d.assignQgroup(logger, volumePath, LayersQgroup); err != nil {
+		logger.Info("assigning-layers-qgroup-failed", lager.Data{"warning": "the volume is not accounted to the layer cache", "error": err.Error()})
+	}
+
+	return nil
+}
+
+// AssignStoreQgroups adds the volumes and image snapshots created before the
+// store had qgroups to LayersQgroup and ImagesQgroup, and rescans the quotas
+// so that the qgroups account for their data. As when creating them, a
+// subvolume that can't be assigned, e.g. because quotas are not enabled, is
+// only logged.
+func (d *Driver) AssignStoreQgroups(logger lager.Logger) error {
+	logger = logger.Session("btrfs-assigning-store-qgroups")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	subvolumes := map[string]string{}
+	volumes, err := d.Volumes(logger)
+	if err != nil {
+		return err
+	}
+	for _, id := range volumes {
+		subvolumes[filepath.Join(d.storePath, store.VolumesDirName, id)] = LayersQgroup
+	}
+
+	images, err := ioutil.ReadDir(filepath.Join(d.storePath, store.ImageDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return errorspkg.Wrap(err, "listing images")
+	}
+	for _, image := range images {
+		for _, name := range []string{"rootfs", "snapshot"} {
+			subvolumePath := filepath.Join(d.storePath, store.ImageDirName, image.Name(), name)
+			if isSubvolume, err := ioctl.IsSubvolume(subvolumePath); err == nil && isSubvolume {
+				subvolumes[subvolumePath] = ImagesQgroup
 			}
 		}
-		err = nil
 	}
 
-	if err := os.RemoveAll(imagePath); err != nil {
-		logger.Error("removing-image-path", err)
+	assigned := 0
+	for subvolumePath, qgroupID := range subvolumes {
+		if err := d.assignQgroup(logger, subvolumePath, qgroupID); err != nil {
+			logger.Info("assigning-qgroup-failed", lager.Data{"warning": "the subvolume is not accounted to the store qgroups", "path": subvolumePath, "error": err.Error()})
+			continue
+		}
+		assigned++
+	}
+
+	if assigned == 0 {
+		return nil
+	}
+
+	if _, err := d.QuotaRescan(logger); err != nil {
+		logger.Info("rescanning-quotas-failed", lager.Data{"warning": "the store qgroups are inaccurate until the quotas are rescanned", "error": err.Error()})
 	}
 
+	return nil
+}
+
+func (d *Driver) assignQgroup(logger lager.Logger, path, qgroupID string) error {
+	args := []string{
+		"--btrfs-bin", d.btrfsBinPath,
+		"assign-qgroup",
+		"--volume-path", path,
+		"--qgroup", qgroupID,
+	}
+	_, err := d.runDrax(logger, args...)
 	return err
 }
 
+// VolumeUUIDs returns the UUID of the volume and, when it was received, the
+// UUID of the volume it was sent from.
+func (d *Driver) VolumeUUIDs(logger lager.Logger, id string) (string, string, error) {
+	logger = logger.Session("btrfs-reading-volume-uuids", lager.Data{"volumeID": id})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	volumePath := filepath.Join(d.storePath, store.VolumesDirName, id)
+	uuid, receivedUUID, ioctlErr := ioctl.SubvolumeUUIDs(volumePath)
+	if ioctlErr == nil {
+		return uuid, receivedUUID, nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return "", "", errorspkg.Wrapf(ioctlErr, "reading uuids of volume `%s`", id)
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "subvolume", "show", volumePath)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	contents, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", "", errorspkg.Wrapf(err, "reading uuids of volume `%s`: %s", id, strings.TrimSpace(string(contents)))
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(fields[1])
+		if value == "-" {
+			value = ""
+		}
+		switch fields[0] {
+		case "UUID":
+			uuid = value
+		case "Received UUID":
+			receivedUUID = value
+		}
+	}
+
+	return uuid, receivedUUID, nil
+}
+
+// trashReceived gets rid of what an aborted receive left behind.
+func (d *Driver) trashReceived(logger lager.Logger, receivedPath, id string) {
+	if _, err := os.Stat(receivedPath); err != nil {
+		return
+	}
+
+	if err := d.moveToTrash(logger, receivedPath, id); err != nil {
+		logger.Error("trashing-received-volume-failed", err)
+	}
+}
+
+// EmptyTrash destroys the subvolumes in the trash, trashBatchSize at a time,
+// waiting for each batch to be committed like `btrfs subvolume delete
+// --commit-after` does. Callers hold groot.TrashLockKey meanwhile, so that the
+// background worker and clean don't destroy the same subvolumes.
+func (d *Driver) EmptyTrash(logger lager.Logger) error {
+	logger = logger.Session("btrfs-emptying-trash")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	trashPaths, err := d.trashPaths()
+	if err != nil {
+		return err
+	}
+
+	var emptyErr error
+	for len(trashPaths) > 0 {
+		batchSize := trashBatchSize
+		if len(trashPaths) < batchSize {
+			batchSize = len(trashPaths)
+		}
+
+		if err := d.destroyTrashBatch(logger, trashPaths[:batchSize]); err != nil {
+			logger.Error("destroying-trash-batch-failed", err)
+			emptyErr = err
+		}
+		trashPaths = trashPaths[batchSize:]
+	}
+
+	return emptyErr
+}
+
+// TrashStats reports the subvolumes waiting in the trash and the space
+// destroying them would free.
+func (d *Driver) TrashStats(logger lager.Logger) (groot.TrashStats, error) {
+	logger = logger.Session("btrfs-fetching-trash-stats")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	trashPaths, err := d.trashPaths()
+	if err != nil {
+		return groot.TrashStats{}, err
+	}
+
+	stats := groot.TrashStats{Volumes: len(trashPaths)}
+	for _, trashPath := range trashPaths {
+		stdoutBuffer, err := d.runDrax(logger, "--btrfs-bin", d.btrfsBinPath, "stats", "--volume-path", trashPath)
+		if err != nil {
+			logger.Error("fetching-trash-volume-stats-failed", err, lager.Data{"path": trashPath})
+			continue
+		}
+
+		usage, err := parseQgroupStats(logger, stdoutBuffer.String())
+		if err != nil {
+			continue
+		}
+		stats.ExclusiveBytesUsed += usage.ExclusiveBytesUsed
+	}
+
+	return stats, nil
+}
+
 func (d *Driver) FetchStats(logger lager.Logger, imagePath string) (groot.VolumeStats, error) {
 	logger = logger.Session("btrfs-fetching-stats", lager.Data{"imagePath": imagePath})
 	logger.Debug("starting")
@@ -302,21 +841,119 @@ func (d *Driver) FetchStats(logger lager.Logger, imagePath string) (groot.Volume
 		return groot.VolumeStats{}, err
 	}
 
-	usageRegexp := regexp.MustCompile(`.*\s+(\d+)\s+(\d+)$`)
-	usage := usageRegexp.FindStringSubmatch(strings.TrimSpace(stdoutBuffer.String()))
-
 	var stats groot.VolumeStats
-	if len(usage) != 3 {
-		logger.Error("parsing-stats-failed", errorspkg.Errorf("raw stats: %s", stdoutBuffer.String()))
-		return stats, errorspkg.New("could not parse stats")
+	if stats.DiskUsage, err = parseQgroupStats(logger, stdoutBuffer.String()); err != nil {
+		return stats, err
 	}
 
-	fmt.Sscanf(usage[1], "%d", &stats.DiskUsage.TotalBytesUsed)
-	fmt.Sscanf(usage[2], "%d", &stats.DiskUsage.ExclusiveBytesUsed)
+	if !d.compressionStats {
+		return stats, nil
+	}
+
+	// walking the extents is best effort, the quota usage is still valid
+	// without it
+	compressionBuffer, err := d.runDrax(logger, "--btrfs-bin", d.btrfsBinPath, "compression-stats", "--volume-path", filepath.Join(imagePath, "rootfs"))
+	if err != nil {
+		logger.Error("fetching-compression-stats-failed", err)
+		return stats, nil
+	}
+
+	var compression struct {
+		LogicalBytes    int64 `json:"logical_bytes"`
+		CompressedBytes int64 `json:"compressed_bytes"`
+	}
+	if err := json.Unmarshal(compressionBuffer.Bytes(), &compression); err != nil {
+		logger.Error("parsing-compression-stats-failed", err, lager.Data{"raw": compressionBuffer.String()})
+		return stats, nil
+	}
+
+	stats.DiskUsage.LogicalBytesUsed = compression.LogicalBytes
+	stats.DiskUsage.CompressedBytesUsed = compression.CompressedBytes
 
 	return stats, nil
 }
 
+// StoreStats reports the usage of the store qgroups, that is of the layer
+// cache and of the images.
+func (d *Driver) StoreStats(logger lager.Logger) (groot.StoreStats, error) {
+	logger = logger.Session("btrfs-fetching-store-stats")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	var stats groot.StoreStats
+	usages := map[string]*groot.DiskUsage{
+		LayersQgroup: &stats.Layers,
+		ImagesQgroup: &stats.Images,
+	}
+
+	qgroupIDs := make([]string, 0, len(usages))
+	for qgroupID := range usages {
+		qgroupIDs = append(qgroupIDs, qgroupID)
+	}
+	sort.Strings(qgroupIDs)
+
+	for _, qgroupID := range qgroupIDs {
+		usage := usages[qgroupID]
+		args := []string{
+			"--btrfs-bin", d.btrfsBinPath,
+			"stats",
+			"--volume-path", d.storePath,
+			"--qgroup", qgroupID,
+			"--force-sync",
+		}
+
+		stdoutBuffer, err := d.runDrax(logger, args...)
+		if err != nil {
+			return groot.StoreStats{}, errorspkg.Wrapf(err, "fetching stats of qgroup %s", qgroupID)
+		}
+
+		if *usage, err = parseQgroupStats(logger, stdoutBuffer.String()); err != nil {
+			return groot.StoreStats{}, err
+		}
+	}
+
+	return stats, nil
+}
+
+// VolumeUsage reports the qgroup usage of a volume. Unlike the other stats it
+// does not sync the filesystem first, as it is asked for many volumes at once.
+func (d *Driver) VolumeUsage(logger lager.Logger, id string) (groot.DiskUsage, error) {
+	logger = logger.Session("btrfs-fetching-volume-usage", lager.Data{"volumeID": id})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	args := []string{
+		"--btrfs-bin", d.btrfsBinPath,
+		"stats",
+		"--volume-path", filepath.Join(d.storePath, store.VolumesDirName, id),
+	}
+
+	stdoutBuffer, err := d.runDrax(logger, args...)
+	if err != nil {
+		return groot.DiskUsage{}, errorspkg.Wrapf(err, "fetching usage of volume %s", id)
+	}
+
+	return parseQgroupStats(logger, stdoutBuffer.String())
+}
+
+// parseQgroupStats decodes the referenced and exclusive bytes printed by
+// `drax stats`.
+func parseQgroupStats(logger lager.Logger, output string) (groot.DiskUsage, error) {
+	var usage struct {
+		ReferencedBytes int64 `json:"referenced_bytes"`
+		ExclusiveBytes  int64 `json:"exclusive_bytes"`
+	}
+	if err := json.Unmarshal([]byte(output), &usage); err != nil {
+		logger.Error("parsing-stats-failed", err, lager.Data{"raw": output})
+		return groot.DiskUsage{}, errorspkg.Wrap(err, "could not parse stats")
+	}
+
+	return groot.DiskUsage{
+		TotalBytesUsed:     usage.ReferencedBytes,
+		ExclusiveBytesUsed: usage.ExclusiveBytes,
+	}, nil
+}
+
 func (d *Driver) Marshal(logger lager.Logger) ([]byte, error) {
 	driverSpec := spec.DriverSpec{
 		Type:           "btrfs",
@@ -324,6 +961,7 @@ func (d *Driver) Marshal(logger lager.Logger) ([]byte, error) {
 		FsBinaryPath:   d.btrfsBinPath,
 		MkfsBinaryPath: d.mkfsBinPath,
 		SuidBinaryPath: d.draxBinPath,
+		Compression:    d.compression,
 	}
 
 	return json.Marshal(driverSpec)
@@ -349,6 +987,9 @@ func (d *Driver) formatFilesystem(logger lager.Logger, filesystemPath string) er
 
 func (d *Driver) mountFilesystem(option, source, destination string) error {
 	allOpts := strings.Trim(fmt.Sprintf("%s,user_subvol_rm_allowed,rw", option), ",")
+	if d.compression != "" && d.compression != "none" {
+		allOpts = fmt.Sprintf("%s,compress=%s", allOpts, d.compression)
+	}
 
 	cmd := exec.Command("mount", "-o", allOpts, "-t", "btrfs", source, destination)
 	if output, err := cmd.CombinedOutput(); err != nil {
@@ -358,32 +999,160 @@ func (d *Driver) mountFilesystem(option, source, destination string) error {
 	return nil
 }
 
-func (d *Driver) destroyBtrfsVolume(logger lager.Logger, path string) error {
-	logger = logger.Session("destroying-subvolume", lager.Data{"path": path})
-	logger.Info("starting")
-	defer logger.Info("ending")
+// moveToTrash renames the subvolume at path into the trash. The rename is
+// what makes it disappear from the store, destroying it can wait.
+func (d *Driver) moveToTrash(logger lager.Logger, path, name string) error {
+	logger = logger.Session("moving-to-trash", lager.Data{"path": path})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
 
 	if _, err := os.Stat(path); err != nil {
 		return errorspkg.Wrap(err, "image path not found")
 	}
 
-	if err := d.destroyQgroup(logger, path); err != nil {
+	trashDir := filepath.Join(d.storePath, store.TrashDirName)
+	if err := os.MkdirAll(trashDir, 0700); err != nil {
+		return errorspkg.Wrap(err, "creating trash directory")
+	}
+
+	trashPath := filepath.Join(trashDir, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	if err := os.Rename(path, trashPath); err != nil {
+		logger.Error("renaming-failed", err, lager.Data{"trashPath": trashPath})
+		return errorspkg.Wrapf(err, "moving `%s` to the trash", path)
+	}
+
+	return nil
+}
+
+func (d *Driver) trashPaths() ([]string, error) {
+	trashDir := filepath.Join(d.storePath, store.TrashDirName)
+	entries, err := ioutil.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing trash")
+	}
+
+	trashPaths := []string{}
+	for _, entry := range entries {
+		trashPaths = append(trashPaths, filepath.Join(trashDir, entry.Name()))
+	}
+
+	return trashPaths, nil
+}
+
+// destroyTrashBatch destroys the qgroups of the trashed subvolumes with a
+// single drax call, then the subvolumes themselves. Subvolumes the ioctl
+// fails to destroy are handed to a single `btrfs subvolume delete`.
+func (d *Driver) destroyTrashBatch(logger lager.Logger, trashPaths []string) error {
+	logger = logger.Session("destroying-trash-batch", lager.Data{"paths": trashPaths})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	subvolumePaths := []string{}
+	for _, trashPath := range trashPaths {
+		if isSubvolume, err := ioctl.IsSubvolume(trashPath); err == nil && !isSubvolume {
+			if err := os.RemoveAll(trashPath); err != nil {
+				logger.Error("removing-trash-directory-failed", err, lager.Data{"path": trashPath})
+			}
+			continue
+		}
+
+		// complete volumes are read-only, which not every kernel lets an
+		// unprivileged user delete
+		if err := d.setReadOnly(logger, trashPath, false); err != nil {
+			logger.Error("clearing-read-only-failed", err, lager.Data{"path": trashPath})
+		}
+		subvolumePaths = append(subvolumePaths, trashPath)
+	}
+
+	if len(subvolumePaths) == 0 {
+		return nil
+	}
+
+	if err := d.destroyQgroups(logger, subvolumePaths...); err != nil {
 		logger.Error("destroying-quota-groups-failed", err, lager.Data{
-			"warning": "could not delete quota group"})
+			"warning": "could not delete quota groups"})
+	}
+
+	cliPaths := []string{}
+	var ioctlErr error
+	for _, subvolumePath := range subvolumePaths {
+		if err := d.destroySubvolumeTree(logger, subvolumePath); err != nil {
+			ioctlErr = err
+			cliPaths = append(cliPaths, subvolumePath)
+		}
+	}
+
+	if len(cliPaths) == 0 {
+		if err := ioctl.Sync(filepath.Join(d.storePath, store.TrashDirName)); err != nil {
+			logger.Error("committing-deletion-failed", err)
+		}
+		return nil
+	}
+
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		logger.Error("btrfs-ioctl-failed", ioctlErr)
+		return errorspkg.Wrap(ioctlErr, "destroying volume")
 	}
 
-	cmd := exec.Command(d.btrfsBinPath, "subvolume", "delete", path)
+	args := append([]string{"subvolume", "delete", "--commit-after"}, cliPaths...)
+	cmd := exec.Command(d.btrfsBinPath, args...)
 	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
 	if contents, err := cmd.CombinedOutput(); err != nil {
 		logger.Error("btrfs-failed", err)
 		return errorspkg.Wrapf(err, "destroying volume %s", strings.TrimSpace(string(contents)))
 	}
+
 	return nil
 }
 
-func (d *Driver) destroyQgroup(logger lager.Logger, path string) error {
-	_, err := d.runDrax(logger, "--btrfs-bin", d.btrfsBinPath, "destroy", "--volume-path", path)
+// destroySubvolumeTree destroys the subvolume at path with the ioctl. Images
+// can have subvolumes nested in them, which have to go first.
+func (d *Driver) destroySubvolumeTree(logger lager.Logger, path string) error {
+	err := ioctl.DestroySubvolume(path)
+	if err == nil || !strings.Contains(strings.ToLower(err.Error()), "directory not empty") {
+		return err
+	}
+
+	subvolumes, listErr := d.listSubvolumes(logger, path)
+	if listErr != nil {
+		logger.Error("listing-subvolumes-failed", listErr)
+		return err
+	}
+
+	for _, subvolume := range subvolumes {
+		if subvolume == "" {
+			continue
+		}
+
+		if err := ioctl.DestroySubvolume(subvolume); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// canFallBackToCLI reports whether a failed ioctl should be retried with the
+// btrfs CLI, which is only the case when btrfs-progs is installed.
+func (d *Driver) canFallBackToCLI(logger lager.Logger, ioctlErr error) bool {
+	if _, err := exec.LookPath(d.btrfsBinPath); err != nil {
+		return false
+	}
+
+	logger.Info("btrfs-ioctl-failed-falling-back-to-cli", lager.Data{"error": ioctlErr.Error()})
+	return true
+}
+
+func (d *Driver) destroyQgroups(logger lager.Logger, paths ...string) error {
+	args := []string{"--btrfs-bin", d.btrfsBinPath, "destroy"}
+	for _, path := range paths {
+		args = append(args, "--volume-path", path)
+	}
 
+	_, err := d.runDrax(logger, args...)
 	return err
 }
 
@@ -1,6 +1,7 @@
 package btrfs_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -107,6 +108,34 @@ var _ = Describe("Btrfs", func() {
 			Expect(string(mountinfo)).To(MatchRegexp(fmt.Sprintf("%s[^\n]*rw[^\n]*user_subvol_rm_allowed", newStorePath)))
 		})
 
+		Context("when a compression is set", func() {
+			JustBeforeEach(func() {
+				driver = btrfs.NewDriver("btrfs", "mkfs.btrfs", draxBinPath, storePath).WithCompression("zstd:3")
+			})
+
+			It("mounts the filesystem with that compression", func() {
+				Expect(driver.InitFilesystem(logger, fsFile, newStorePath)).To(Succeed())
+				mountinfo, err := ioutil.ReadFile("/proc/self/mountinfo")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(mountinfo)).To(MatchRegexp(fmt.Sprintf("%s[^\n]*compress=zstd:3", newStorePath)))
+			})
+		})
+
+		Context("when the compression is none", func() {
+			JustBeforeEach(func() {
+				driver = btrfs.NewDriver("btrfs", "mkfs.btrfs", draxBinPath, storePath).WithCompression("none")
+			})
+
+			It("mounts the filesystem without compression", func() {
+				Expect(driver.InitFilesystem(logger, fsFile, newStorePath)).To(Succeed())
+				mountinfo, err := ioutil.ReadFile("/proc/self/mountinfo")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(mountinfo)).NotTo(MatchRegexp(fmt.Sprintf("%s[^\n]*compress", newStorePath)))
+			})
+		})
+
 		Context("when creating the filesystem fails", func() {
 			It("returns an error", func() {
 				err := driver.InitFilesystem(logger, "/tmp/no-valid", newStorePath)
@@ -192,6 +221,135 @@ var _ = Describe("Btrfs", func() {
 		})
 	})
 
+	Describe("InitQuotaGroups", func() {
+		It("creates the store qgroups", func() {
+			Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+
+			output, err := exec.Command("btrfs", "qgroup", "show", "--raw", storePath).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).To(MatchRegexp(`(?m)^1/1\s`))
+			Expect(string(output)).To(MatchRegexp(`(?m)^1/2\s`))
+		})
+
+		It("can be run again", func() {
+			Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+			Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+		})
+
+		Context("when a cache limit is given", func() {
+			It("limits the layers qgroup", func() {
+				Expect(driver.InitQuotaGroups(logger, 1024*1024)).To(Succeed())
+
+				output, err := exec.Command("btrfs", "qgroup", "show", "--raw", "-r", storePath).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(MatchRegexp(`(?m)^1/1\s+\d+\s+\d+\s+1048576\s`))
+			})
+
+			It("stops layers from growing beyond the limit", func() {
+				Expect(driver.InitQuotaGroups(logger, 1024*1024)).To(Succeed())
+
+				volPath, err := driver.CreateVolume(logger, "", randVolumeID())
+				Expect(err).NotTo(HaveOccurred())
+
+				cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(volPath, "vol-file")), "bs=1048576", "count=4")
+				sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(sess).Should(gexec.Exit(1))
+				Expect(sess.Err).To(gbytes.Say("Disk quota exceeded"))
+			})
+		})
+
+		Context("when drax does not exist", func() {
+			JustBeforeEach(func() {
+				driver = btrfs.NewDriver("btrfs", "mkfs.btrfs", "/path/to/non-existent-drax", storePath)
+			})
+
+			It("doesn't fail without a cache limit", func() {
+				Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+			})
+
+			It("fails with a cache limit", func() {
+				err := driver.InitQuotaGroups(logger, 1024*1024)
+				Expect(err).To(MatchError(ContainSubstring("drax was not found in the $PATH")))
+			})
+		})
+	})
+
+	Describe("ResizeFilesystem", func() {
+		Context("when the new size is smaller than the data in the store", func() {
+			It("returns an error", func() {
+				err := driver.ResizeFilesystem(logger, 1024)
+				Expect(err).To(MatchError(ContainSubstring("resizing filesystem")))
+			})
+		})
+	})
+
+	Describe("StoreStats", func() {
+		JustBeforeEach(func() {
+			Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+		})
+
+		It("reports the usage of the layers and of the images apart", func() {
+			volumeID := randVolumeID()
+			volPath, err := driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+
+			cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(volPath, "vol-file")), "bs=4210688", "count=1")
+			sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+
+			imagePath, err := ioutil.TempDir(storePath, "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = driver.CreateImage(logger, image_cloner.ImageDriverSpec{
+				ImagePath:     imagePath,
+				BaseVolumeIDs: []string{volumeID},
+				Mount:         true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			stats, err := driver.StoreStats(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Layers.TotalBytesUsed).To(BeNumerically(">=", 4210688))
+			Expect(stats.Images.TotalBytesUsed).To(BeNumerically(">=", 4210688))
+			Expect(stats.Images.ExclusiveBytesUsed).To(BeNumerically("<", 1024*1024))
+		})
+
+		Context("when drax does not exist", func() {
+			It("returns an error", func() {
+				driver = btrfs.NewDriver("btrfs", "mkfs.btrfs", "/path/to/non-existent-drax", storePath)
+				_, err := driver.StoreStats(logger)
+				Expect(err).To(MatchError(ContainSubstring("drax was not found in the $PATH")))
+			})
+		})
+	})
+
+	Describe("VolumeUsage", func() {
+		It("reports the usage of the volume", func() {
+			volumeID := randVolumeID()
+			volPath, err := driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+
+			cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(volPath, "vol-file")), "bs=4210688", "count=1")
+			sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+			Expect(exec.Command("sync").Run()).To(Succeed())
+
+			usage, err := driver.VolumeUsage(logger, volumeID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage.TotalBytesUsed).To(BeNumerically(">=", 4210688))
+			Expect(usage.ExclusiveBytesUsed).To(BeNumerically(">=", 4210688))
+		})
+
+		Context("when the volume does not exist", func() {
+			It("returns an error", func() {
+				_, err := driver.VolumeUsage(logger, "non-existent-id")
+				Expect(err).To(MatchError(ContainSubstring("fetching usage of volume non-existent-id")))
+			})
+		})
+	})
+
 	Describe("VolumePath", func() {
 		It("returns the volume path when it exists", func() {
 			volID := randVolumeID()
@@ -230,7 +388,7 @@ var _ = Describe("Btrfs", func() {
 					Debug(
 						Message("btrfs.btrfs-creating-volume.starting-btrfs"),
 						Data("path", "/usr/sbin/btrfs"),
-						Data("args", []string{"btrfs", "subvolume", "create", volumePath}),
+						Data("args", []string{"btrfs", "subvolume", "create", "-i", btrfs.LayersQgroup, volumePath}),
 						Data("id", volID),
 					),
 				))
@@ -238,10 +396,11 @@ var _ = Describe("Btrfs", func() {
 		})
 
 		Context("custom btrfs binary path", func() {
-			It("uses the custom btrfs binary given", func() {
+			It("doesn't need the btrfs binary", func() {
 				driver = btrfs.NewDriver("cool-btrfs", "mkfs.btrfs", draxBinPath, storePath)
-				_, err := driver.CreateVolume(logger, "", randomImageID)
-				Expect(err).To(MatchError(ContainSubstring(`"cool-btrfs": executable file not found in $PATH`)))
+				volumePath, err := driver.CreateVolume(logger, "", randomImageID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(volumePath).To(BeADirectory())
 			})
 		})
 
@@ -275,7 +434,7 @@ var _ = Describe("Btrfs", func() {
 					Debug(
 						Message("btrfs.btrfs-creating-volume.starting-btrfs"),
 						Data("path", "/usr/sbin/btrfs"),
-						Data("args", []string{"btrfs", "subvolume", "snapshot", fromPath, destVolPath}),
+						Data("args", []string{"btrfs", "subvolume", "snapshot", "-i", btrfs.LayersQgroup, fromPath, destVolPath}),
 						Data("id", destVolID),
 						Data("parentID", volumeID),
 					),
@@ -339,7 +498,7 @@ var _ = Describe("Btrfs", func() {
 			Expect(logger).To(ContainSequence(
 				Debug(
 					Message("btrfs.btrfs-creating-snapshot.starting-btrfs"),
-					Data("args", []string{"btrfs", "subvolume", "snapshot", filepath.Join(storePath, store.VolumesDirName, volumeID), filepath.Join(spec.ImagePath, "rootfs")}),
+					Data("args", []string{"btrfs", "subvolume", "snapshot", "-i", btrfs.ImagesQgroup, filepath.Join(storePath, store.VolumesDirName, volumeID), filepath.Join(spec.ImagePath, "rootfs")}),
 					Data("path", "/usr/sbin/btrfs"),
 				),
 			))
@@ -363,6 +522,30 @@ var _ = Describe("Btrfs", func() {
 			Expect(mountJson).To(Equal(groot.MountInfo{}))
 		})
 
+		It("doesn't set a compression property", func() {
+			_, err := driver.CreateImage(logger, spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := exec.Command("btrfs", "property", "get", filepath.Join(spec.ImagePath, "rootfs"), "compression").CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).NotTo(ContainSubstring("compression="))
+		})
+
+		Context("when a compression is given", func() {
+			BeforeEach(func() {
+				spec.Compression = "zstd:3"
+			})
+
+			It("sets the compression property of the snapshot without the level", func() {
+				_, err := driver.CreateImage(logger, spec)
+				Expect(err).NotTo(HaveOccurred())
+
+				output, err := exec.Command("btrfs", "property", "get", filepath.Join(spec.ImagePath, "rootfs"), "compression").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(Equal("compression=zstd"))
+			})
+		})
+
 		Context("when mount is false", func() {
 			BeforeEach(func() {
 				spec.Mount = false
@@ -443,14 +626,14 @@ var _ = Describe("Btrfs", func() {
 						Expect(os.RemoveAll(tempFolder)).To(Succeed())
 					})
 
-					It("will force drax to use that binary", func() {
+					It("applies the limit through drax without calling that binary", func() {
 						driver = btrfs.NewDriver(btrfsBin.Name(), "mkfs.btrfs", draxBinPath, storePath)
 						_, err := driver.CreateImage(logger, spec)
 						Expect(err).NotTo(HaveOccurred())
 
 						contents, err := ioutil.ReadFile(btrfsCalledFile.Name())
 						Expect(err).NotTo(HaveOccurred())
-						Expect(string(contents)).To(Equal("I'm groot - btrfs"))
+						Expect(string(contents)).To(BeEmpty())
 					})
 				})
 
@@ -490,10 +673,10 @@ var _ = Describe("Btrfs", func() {
 		})
 
 		Context("custom btrfs binary path", func() {
-			It("uses the custom btrfs binary given", func() {
+			It("doesn't need the btrfs binary", func() {
 				driver = btrfs.NewDriver("cool-btrfs", "mkfs.btrfs", draxBinPath, storePath)
 				_, err := driver.CreateImage(logger, spec)
-				Expect(err).To(MatchError(ContainSubstring(`"cool-btrfs": executable file not found in $PATH`)))
+				Expect(err).NotTo(HaveOccurred())
 			})
 		})
 
@@ -621,6 +804,58 @@ var _ = Describe("Btrfs", func() {
 		})
 	})
 
+	Describe("SetVolumeReadOnly", func() {
+		var (
+			volumeID   string
+			volumePath string
+		)
+
+		JustBeforeEach(func() {
+			volumeID = randVolumeID()
+			var err error
+			volumePath, err = driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("prevents the volume from being changed", func() {
+			Expect(driver.SetVolumeReadOnly(logger, volumeID, true)).To(Succeed())
+
+			err := ioutil.WriteFile(filepath.Join(volumePath, "a_file"), []byte{}, 0644)
+			Expect(err).To(MatchError(ContainSubstring("read-only file system")))
+
+			readOnly, err := driver.IsVolumeReadOnly(logger, volumeID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readOnly).To(BeTrue())
+		})
+
+		It("can make the volume writable again", func() {
+			Expect(driver.SetVolumeReadOnly(logger, volumeID, true)).To(Succeed())
+			Expect(driver.SetVolumeReadOnly(logger, volumeID, false)).To(Succeed())
+
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "a_file"), []byte{}, 0644)).To(Succeed())
+
+			readOnly, err := driver.IsVolumeReadOnly(logger, volumeID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readOnly).To(BeFalse())
+		})
+
+		Context("when a child volume is created from a read-only volume", func() {
+			It("is writable", func() {
+				Expect(driver.SetVolumeReadOnly(logger, volumeID, true)).To(Succeed())
+
+				childPath, err := driver.CreateVolume(logger, volumeID, randVolumeID())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(filepath.Join(childPath, "a_file"), []byte{}, 0644)).To(Succeed())
+			})
+		})
+
+		Context("when the volume does not exist", func() {
+			It("returns an error", func() {
+				Expect(driver.SetVolumeReadOnly(logger, "not-here", true)).NotTo(Succeed())
+			})
+		})
+	})
+
 	Describe("DestroyVolume", func() {
 		var (
 			volumeID   string
@@ -641,6 +876,25 @@ var _ = Describe("Btrfs", func() {
 			Expect(volumePath).ToNot(BeAnExistingFile())
 		})
 
+		It("moves the volume to the trash", func() {
+			Expect(driver.DestroyVolume(logger, volumeID)).To(Succeed())
+
+			trashed, err := filepath.Glob(filepath.Join(storePath, store.TrashDirName, volumeID+"-*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trashed).To(HaveLen(1))
+		})
+
+		Context("when the volume is read-only", func() {
+			JustBeforeEach(func() {
+				Expect(driver.SetVolumeReadOnly(logger, volumeID, true)).To(Succeed())
+			})
+
+			It("deletes it", func() {
+				Expect(driver.DestroyVolume(logger, volumeID)).To(Succeed())
+				Expect(volumePath).ToNot(BeAnExistingFile())
+			})
+		})
+
 		It("deletes the metadata file", func() {
 			metaFilePath := filepath.Join(storePath, store.MetaDirName, fmt.Sprintf("volume-%s", volumeID))
 			Expect(ioutil.WriteFile(metaFilePath, []byte{}, 0644)).To(Succeed())
@@ -649,10 +903,43 @@ var _ = Describe("Btrfs", func() {
 		})
 
 		Context("custom btrfs binary path", func() {
-			It("uses the custom btrfs binary given", func() {
+			It("doesn't need the btrfs binary", func() {
 				driver = btrfs.NewDriver("cool-btrfs", "mkfs.btrfs", draxBinPath, storePath)
-				err := driver.DestroyVolume(logger, volumeID)
-				Expect(err).To(MatchError(ContainSubstring(`"cool-btrfs": executable file not found in $PATH`)))
+				Expect(driver.DestroyVolume(logger, volumeID)).To(Succeed())
+				Expect(volumePath).ToNot(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("ImageHasSubvolume", func() {
+		var imagePath string
+
+		BeforeEach(func() {
+			imagePath = filepath.Join(storePath, store.ImageDirName, fmt.Sprintf("image-%d", rand.Int()))
+			Expect(os.MkdirAll(imagePath, 0777)).To(Succeed())
+		})
+
+		It("finds the snapshot of unmounted images", func() {
+			volumeID := randVolumeID()
+			_, err := driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = driver.CreateImage(logger, image_cloner.ImageDriverSpec{
+				ImagePath:     imagePath,
+				BaseVolumeIDs: []string{volumeID},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(driver.ImageHasSubvolume(logger, imagePath)).To(BeTrue())
+		})
+
+		Context("when the rootfs is a plain directory", func() {
+			BeforeEach(func() {
+				Expect(os.Mkdir(filepath.Join(imagePath, "rootfs"), 0755)).To(Succeed())
+			})
+
+			It("returns false", func() {
+				Expect(driver.ImageHasSubvolume(logger, imagePath)).To(BeFalse())
 			})
 		})
 	})
@@ -693,38 +980,12 @@ var _ = Describe("Btrfs", func() {
 				Expect(rootfsPath).ToNot(BeAnExistingFile())
 			})
 
-			It("deletes the quota group for the volume", func() {
-				rootIDBuffer := gbytes.NewBuffer()
-				sess, err := gexec.Start(exec.Command("sudo", "btrfs", "inspect-internal", "rootid", rootfsPath), rootIDBuffer, GinkgoWriter)
-				Expect(err).NotTo(HaveOccurred())
-				Eventually(sess).Should(gexec.Exit(0))
-				rootID := strings.TrimSpace(string(rootIDBuffer.Contents()))
-
-				sess, err = gexec.Start(exec.Command("sudo", "btrfs", "qgroup", "show", storePath), GinkgoWriter, GinkgoWriter)
-				Expect(err).NotTo(HaveOccurred())
-				Eventually(sess).Should(gexec.Exit(0))
-				Expect(sess).To(gbytes.Say(rootID))
-
+			It("moves the btrfs volume to the trash", func() {
 				Expect(driver.DestroyImage(logger, spec.ImagePath)).To(Succeed())
 
-				sess, err = gexec.Start(exec.Command("sudo", "btrfs", "qgroup", "show", storePath), GinkgoWriter, GinkgoWriter)
+				trashed, err := filepath.Glob(filepath.Join(storePath, store.TrashDirName, "image-id-*"))
 				Expect(err).NotTo(HaveOccurred())
-				Eventually(sess).Should(gexec.Exit(0))
-				Expect(sess).ToNot(gbytes.Say(rootID))
-			})
-
-			It("logs the correct btrfs command", func() {
-				Expect(rootfsPath).To(BeADirectory())
-
-				Expect(driver.DestroyImage(logger, spec.ImagePath)).To(Succeed())
-
-				Expect(logger).To(ContainSequence(
-					Debug(
-						Message("btrfs.btrfs-destroying-image.destroying-subvolume.starting-btrfs"),
-						Data("path", "/usr/sbin/btrfs"),
-						Data("args", []string{"btrfs", "subvolume", "delete", rootfsPath}),
-					),
-				))
+				Expect(trashed).To(HaveLen(1))
 			})
 
 			Context("when the rootfs folder has subvolumes inside", func() {
@@ -774,10 +1035,10 @@ var _ = Describe("Btrfs", func() {
 			})
 
 			Context("custom btrfs binary path", func() {
-				It("uses the custom btrfs binary given", func() {
+				It("doesn't need the btrfs binary", func() {
 					driver = btrfs.NewDriver("cool-btrfs", "mkfs.btrfs", draxBinPath, storePath)
-					err := driver.DestroyImage(logger, spec.ImagePath)
-					Expect(err).To(MatchError(ContainSubstring(`"cool-btrfs": executable file not found in $PATH`)))
+					Expect(driver.DestroyImage(logger, spec.ImagePath)).To(Succeed())
+					Expect(spec.ImagePath).ToNot(BeAnExistingFile())
 				})
 			})
 
@@ -800,13 +1061,259 @@ var _ = Describe("Btrfs", func() {
 			})
 		})
 
-		Context("when destroying the volume fails", func() {
+		Context("when moving the volume to the trash fails", func() {
 			It("returns an error", func() {
 				tmpDir, _ := ioutil.TempDir("", "")
 				Expect(os.Mkdir(filepath.Join(tmpDir, "rootfs"), 0755)).To(Succeed())
 
 				err := driver.DestroyImage(logger, tmpDir)
-				Expect(err).To(MatchError(ContainSubstring("destroying volume")))
+				Expect(err).To(MatchError(ContainSubstring("to the trash")))
+			})
+		})
+	})
+
+	Describe("SendVolume and ReceiveVolume", func() {
+		var (
+			otherStorePath string
+			otherDriver    *btrfs.Driver
+			parentID       string
+			volumeID       string
+		)
+
+		JustBeforeEach(func() {
+			var err error
+			otherStorePath, err = ioutil.TempDir(filepath.Join(btrfsMountPath, storeName), "")
+			Expect(err).NotTo(HaveOccurred())
+			for _, folder := range []string{store.VolumesDirName, store.MetaDirName, store.TempDirName} {
+				Expect(os.MkdirAll(filepath.Join(otherStorePath, folder), 0755)).To(Succeed())
+			}
+			otherDriver = btrfs.NewDriver("btrfs", "mkfs.btrfs", draxBinPath, otherStorePath)
+
+			parentID = randVolumeID()
+			parentPath, err := driver.CreateVolume(logger, "", parentID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(parentPath, "parent-file"), []byte("parent"), 0644)).To(Succeed())
+			Expect(driver.SetVolumeReadOnly(logger, parentID, true)).To(Succeed())
+
+			volumeID = randVolumeID()
+			volumePath, err := driver.CreateVolume(logger, parentID, volumeID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "child-file"), []byte("child"), 0644)).To(Succeed())
+			Expect(driver.SetVolumeReadOnly(logger, volumeID, true)).To(Succeed())
+		})
+
+		It("copies a volume to another store", func() {
+			stream := bytes.NewBuffer([]byte{})
+			Expect(driver.SendVolume(logger, parentID, "", stream)).To(Succeed())
+			Expect(otherDriver.ReceiveVolume(logger, parentID, stream)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(otherStorePath, store.VolumesDirName, parentID, "parent-file"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("parent"))
+		})
+
+		It("copies a volume incrementally against its parent", func() {
+			stream := bytes.NewBuffer([]byte{})
+			Expect(driver.SendVolume(logger, parentID, "", stream)).To(Succeed())
+			Expect(otherDriver.ReceiveVolume(logger, parentID, stream)).To(Succeed())
+
+			stream.Reset()
+			Expect(driver.SendVolume(logger, volumeID, parentID, stream)).To(Succeed())
+			Expect(otherDriver.ReceiveVolume(logger, volumeID, stream)).To(Succeed())
+
+			receivedPath := filepath.Join(otherStorePath, store.VolumesDirName, volumeID)
+			Expect(filepath.Join(receivedPath, "parent-file")).To(BeAnExistingFile())
+			Expect(filepath.Join(receivedPath, "child-file")).To(BeAnExistingFile())
+		})
+
+		Context("when the stream is not valid", func() {
+			It("returns an error and does not create the volume", func() {
+				err := otherDriver.ReceiveVolume(logger, volumeID, strings.NewReader("not a stream"))
+				Expect(err).To(MatchError(ContainSubstring("receiving volume")))
+				Expect(filepath.Join(otherStorePath, store.VolumesDirName, volumeID)).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the parent was not received first", func() {
+			It("returns an error", func() {
+				stream := bytes.NewBuffer([]byte{})
+				Expect(driver.SendVolume(logger, volumeID, parentID, stream)).To(Succeed())
+
+				err := otherDriver.ReceiveVolume(logger, volumeID, stream)
+				Expect(err).To(MatchError(ContainSubstring("receiving volume")))
+			})
+		})
+	})
+
+	Describe("EmptyTrash", func() {
+		var (
+			imagePath  string
+			rootfsPath string
+		)
+
+		JustBeforeEach(func() {
+			volumeID := randVolumeID()
+			_, err := driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+
+			imagePath = filepath.Join(storePath, store.ImageDirName, "image-id")
+			Expect(os.MkdirAll(imagePath, 0777)).To(Succeed())
+
+			_, err = driver.CreateImage(logger, image_cloner.ImageDriverSpec{
+				ImagePath:     imagePath,
+				BaseVolumeIDs: []string{volumeID},
+				Mount:         true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			rootfsPath = filepath.Join(imagePath, "rootfs")
+		})
+
+		It("destroys the trashed subvolumes", func() {
+			Expect(driver.DestroyImage(logger, imagePath)).To(Succeed())
+			Expect(driver.EmptyTrash(logger)).To(Succeed())
+
+			trashed, err := ioutil.ReadDir(filepath.Join(storePath, store.TrashDirName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trashed).To(BeEmpty())
+		})
+
+		It("deletes the quota groups of the trashed subvolumes", func() {
+			rootIDBuffer := gbytes.NewBuffer()
+			sess, err := gexec.Start(exec.Command("sudo", "btrfs", "inspect-internal", "rootid", rootfsPath), rootIDBuffer, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+			rootID := strings.TrimSpace(string(rootIDBuffer.Contents()))
+
+			Expect(driver.DestroyImage(logger, imagePath)).To(Succeed())
+
+			sess, err = gexec.Start(exec.Command("sudo", "btrfs", "qgroup", "show", storePath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+			Expect(sess).To(gbytes.Say(rootID))
+
+			Expect(driver.EmptyTrash(logger)).To(Succeed())
+
+			sess, err = gexec.Start(exec.Command("sudo", "btrfs", "qgroup", "show", storePath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+			Expect(sess).ToNot(gbytes.Say(rootID))
+		})
+
+		Context("when a trashed subvolume has subvolumes inside", func() {
+			JustBeforeEach(func() {
+				sess, err := gexec.Start(exec.Command("btrfs", "sub", "create", filepath.Join(rootfsPath, "subvolume")), GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(sess).Should(gexec.Exit(0))
+
+				sess, err = gexec.Start(exec.Command("btrfs", "sub", "create", filepath.Join(rootfsPath, "subvolume", "subsubvolume")), GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(sess).Should(gexec.Exit(0))
+			})
+
+			It("destroys them all", func() {
+				Expect(driver.DestroyImage(logger, imagePath)).To(Succeed())
+				Expect(driver.EmptyTrash(logger)).To(Succeed())
+
+				trashed, err := ioutil.ReadDir(filepath.Join(storePath, store.TrashDirName))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(trashed).To(BeEmpty())
+			})
+		})
+
+		Context("when the trash does not exist", func() {
+			It("succeeds", func() {
+				Expect(os.RemoveAll(filepath.Join(storePath, store.TrashDirName))).To(Succeed())
+				Expect(driver.EmptyTrash(logger)).To(Succeed())
+			})
+		})
+	})
+
+	Describe("TrashStats", func() {
+		It("reports the subvolumes in the trash", func() {
+			volumeID := randVolumeID()
+			volumePath, err := driver.CreateVolume(logger, "", volumeID)
+			Expect(err).NotTo(HaveOccurred())
+
+			cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", filepath.Join(volumePath, "vol-file")), "bs=4210688", "count=1")
+			sess, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+			Expect(exec.Command("sync").Run()).To(Succeed())
+
+			Expect(driver.DestroyVolume(logger, volumeID)).To(Succeed())
+
+			stats, err := driver.TrashStats(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Volumes).To(BeNumerically(">=", 1))
+			Expect(stats.ExclusiveBytesUsed).To(BeNumerically(">=", 4210688))
+		})
+	})
+
+	Describe("Scrub", func() {
+		It("verifies the data in the store", func() {
+			volumePath, err := driver.CreateVolume(logger, "", randVolumeID())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "file"), bytes.Repeat([]byte("a"), 8192), 0600)).To(Succeed())
+			Expect(exec.Command("sync").Run()).To(Succeed())
+
+			result, err := driver.Scrub(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.DataBytesScrubbed).To(BeNumerically(">", 0))
+			Expect(result.Errors()).To(BeZero())
+		})
+	})
+
+	Describe("Balance", func() {
+		It("relocates the chunks below the usage filter", func() {
+			result, err := driver.Balance(logger, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.UsageFilter).To(Equal(0))
+			Expect(result.RelocatedChunks).To(BeNumerically("<=", result.ConsideredChunks))
+		})
+	})
+
+	Describe("AssignStoreQgroups", func() {
+		var volumeID string
+
+		BeforeEach(func() {
+			volumeID = randVolumeID()
+		})
+
+		JustBeforeEach(func() {
+			sess, err := gexec.Start(exec.Command("btrfs", "subvolume", "create", filepath.Join(volumesPath, volumeID)), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+
+			Expect(driver.InitQuotaGroups(logger, 0)).To(Succeed())
+		})
+
+		It("adds the volumes created outside of the store qgroups to the layers qgroup", func() {
+			Expect(driver.AssignStoreQgroups(logger)).To(Succeed())
+
+			output, err := exec.Command("btrfs", "qgroup", "show", "--raw", "-p", "-f", filepath.Join(volumesPath, volumeID)).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).To(MatchRegexp(`(?m)^0/\d+\s.*\s1/1\s*$`))
+		})
+
+		It("can be run again", func() {
+			Expect(driver.AssignStoreQgroups(logger)).To(Succeed())
+			Expect(driver.AssignStoreQgroups(logger)).To(Succeed())
+		})
+	})
+
+	Describe("QuotaRescan", func() {
+		It("recomputes the qgroup numbers", func() {
+			_, err := driver.QuotaRescan(logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("MaintenanceStatus", func() {
+		Context("when nothing is running", func() {
+			It("reports nothing", func() {
+				report, err := driver.MaintenanceStatus(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report).To(Equal(groot.MaintenanceReport{}))
 			})
 		})
 	})
@@ -860,6 +1367,29 @@ var _ = Describe("Btrfs", func() {
 				Expect(stats.DiskUsage.TotalBytesUsed).To(BeNumerically("~", 8425472, 100))
 			})
 
+			It("does not walk the extents of the image", func() {
+				stats, err := driver.FetchStats(logger, imagePath)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(stats.DiskUsage.LogicalBytesUsed).To(BeZero())
+				Expect(stats.DiskUsage.CompressedBytesUsed).To(BeZero())
+			})
+
+			Context("when compression stats are enabled", func() {
+				JustBeforeEach(func() {
+					driver = driver.WithCompressionStats(true)
+				})
+
+				It("returns the logical and compressed usage", func() {
+					stats, err := driver.FetchStats(logger, imagePath)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(stats.DiskUsage.LogicalBytesUsed).To(BeNumerically("~", 4210688, 4096))
+					// the store is not compressed
+					Expect(stats.DiskUsage.CompressedBytesUsed).To(Equal(stats.DiskUsage.LogicalBytesUsed))
+				})
+			})
+
 			Context("when using a custom btrfs binary", func() {
 				var (
 					btrfsCalledFile *os.File
@@ -875,14 +1405,14 @@ var _ = Describe("Btrfs", func() {
 					Expect(os.RemoveAll(tempFolder)).To(Succeed())
 				})
 
-				It("will force drax to use that binary", func() {
+				It("fetches the stats through drax without calling that binary", func() {
 					driver = btrfs.NewDriver(btrfsBin.Name(), "mkfs.btrfs", draxBinPath, storePath)
 					_, err := driver.FetchStats(logger, imagePath)
-					Expect(err).To(HaveOccurred())
+					Expect(err).NotTo(HaveOccurred())
 
 					contents, err := ioutil.ReadFile(btrfsCalledFile.Name())
 					Expect(err).NotTo(HaveOccurred())
-					Expect(string(contents)).To(Equal("I'm groot - btrfs"))
+					Expect(string(contents)).To(BeEmpty())
 				})
 			})
 
@@ -937,6 +1467,15 @@ var _ = Describe("Btrfs", func() {
 		})
 	})
 
+	Describe("VolumeMeta", func() {
+		It("reads the metadata file", func() {
+			meta := base_image_puller.VolumeMeta{Size: 1024, DiffID: "sha256:diff"}
+			Expect(driver.WriteVolumeMeta(logger, "1234", meta)).To(Succeed())
+
+			Expect(driver.VolumeMeta(logger, "1234")).To(Equal(meta))
+		})
+	})
+
 	Describe("VolumeSize", func() {
 		It("returns the volume size", func() {
 			volumeID := randVolumeID()
@@ -0,0 +1,126 @@
+package ioctl // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
+
+import (
+	"encoding/binary"
+	"math"
+	"syscall"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	compressionXattr = "btrfs.compression"
+
+	extentDataKey = 108
+
+	fileExtentInline   = 0
+	fileExtentHeader   = 21
+	fileExtentItemSize = 53
+)
+
+// CompressionInfo is the logical size of the data in a subvolume and the
+// space its extents take on disk.
+type CompressionInfo struct {
+	LogicalBytes    int64
+	CompressedBytes int64
+}
+
+// SetCompression sets the compression property of path, which new files
+// below it inherit. This is what `btrfs property set <path> compression`
+// does.
+func SetCompression(path, algorithm string) error {
+	return errorspkg.Wrapf(syscall.Setxattr(path, compressionXattr, []byte(algorithm), 0), "setting compression of `%s`", path)
+}
+
+// CompressionUsage walks the file extents of the subvolume at path, the way
+// compsize does. Extents shared within the subvolume are only counted once
+// on disk. Searching the subvolume tree requires CAP_SYS_ADMIN.
+func CompressionUsage(path string) (CompressionInfo, error) {
+	subvolumeID, err := SubvolumeID(path)
+	if err != nil {
+		return CompressionInfo{}, err
+	}
+
+	key := searchKey{
+		treeID:      subvolumeID,
+		maxObjectID: math.MaxUint64,
+		maxOffset:   math.MaxUint64,
+		minType:     extentDataKey,
+		maxType:     extentDataKey,
+	}
+
+	items, err := treeSearch(path, key)
+	if err != nil {
+		return CompressionInfo{}, err
+	}
+
+	usage := CompressionInfo{}
+	seenExtents := map[uint64]bool{}
+	for _, item := range items {
+		if item.itemType != extentDataKey {
+			continue
+		}
+
+		extent, err := parseFileExtent(item.data)
+		if err != nil {
+			return CompressionInfo{}, err
+		}
+
+		if extent.hole {
+			continue
+		}
+
+		usage.LogicalBytes += extent.logicalBytes
+		if extent.inline {
+			usage.CompressedBytes += extent.diskBytes
+			continue
+		}
+
+		if !seenExtents[extent.diskBytenr] {
+			seenExtents[extent.diskBytenr] = true
+			usage.CompressedBytes += extent.diskBytes
+		}
+	}
+
+	return usage, nil
+}
+
+type fileExtent struct {
+	inline       bool
+	hole         bool
+	diskBytenr   uint64
+	logicalBytes int64
+	diskBytes    int64
+}
+
+// parseFileExtent decodes a struct btrfs_file_extent_item, which is stored on
+// disk in little endian. Inline extents keep their data in the item itself,
+// and holes have no disk extent at all.
+func parseFileExtent(data []byte) (fileExtent, error) {
+	if len(data) < fileExtentHeader {
+		return fileExtent{}, errorspkg.New("file extent is truncated")
+	}
+
+	if data[20] == fileExtentInline {
+		return fileExtent{
+			inline:       true,
+			logicalBytes: int64(binary.LittleEndian.Uint64(data[8:16])),
+			diskBytes:    int64(len(data) - fileExtentHeader),
+		}, nil
+	}
+
+	if len(data) < fileExtentItemSize {
+		return fileExtent{}, errorspkg.New("file extent is truncated")
+	}
+
+	extent := fileExtent{
+		diskBytenr:   binary.LittleEndian.Uint64(data[21:29]),
+		logicalBytes: int64(binary.LittleEndian.Uint64(data[45:53])),
+	}
+	extent.hole = extent.diskBytenr == 0
+	if !extent.hole {
+		extent.diskBytes = int64(binary.LittleEndian.Uint64(data[29:37]))
+	}
+
+	return extent, nil
+}
@@ -0,0 +1,432 @@
+// Package ioctl talks to btrfs through its ioctl interface, so that the driver
+// and drax don't need to shell out to btrfs-progs and parse its output.
+package ioctl // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	btrfsIoctlMagic = 0x94
+
+	// the kernel's BTRFS_PATH_NAME_MAX and BTRFS_SUBVOL_NAME_MAX
+	pathNameMax   = 4087
+	subvolNameMax = 4039
+
+	firstFreeObjectID = 256
+	fsTreeObjectID    = 5
+
+	subvolReadOnlyFlag      = 1 << 1
+	subvolQgroupInheritFlag = 1 << 2
+
+	qgroupLimitMaxReferenced = 1 << 0
+	qgroupLimitMaxExclusive  = 1 << 1
+)
+
+var (
+	iocResize         = iow(btrfsIoctlMagic, 3, unsafe.Sizeof(volArgs{}))
+	iocSync           = io(btrfsIoctlMagic, 8)
+	iocSubvolCreate   = iow(btrfsIoctlMagic, 14, unsafe.Sizeof(volArgs{}))
+	iocSnapDestroy    = iow(btrfsIoctlMagic, 15, unsafe.Sizeof(volArgs{}))
+	iocTreeSearch     = iowr(btrfsIoctlMagic, 17, unsafe.Sizeof(searchArgs{}))
+	iocInoLookup      = iowr(btrfsIoctlMagic, 18, unsafe.Sizeof(inoLookupArgs{}))
+	iocSnapCreateV2   = iow(btrfsIoctlMagic, 23, unsafe.Sizeof(volArgsV2{}))
+	iocSubvolCreateV2 = iow(btrfsIoctlMagic, 24, unsafe.Sizeof(volArgsV2{}))
+	iocSubvolGetflag  = ior(btrfsIoctlMagic, 25, unsafe.Sizeof(uint64(0)))
+	iocSubvolSetflag  = iow(btrfsIoctlMagic, 26, unsafe.Sizeof(uint64(0)))
+	iocQgroupAssign   = iow(btrfsIoctlMagic, 41, unsafe.Sizeof(qgroupAssignArgs{}))
+	iocQgroupCreate   = iow(btrfsIoctlMagic, 42, unsafe.Sizeof(qgroupCreateArgs{}))
+	iocQgroupLimit    = ior(btrfsIoctlMagic, 43, unsafe.Sizeof(qgroupLimitArgs{}))
+	iocGetSubvolInfo  = ior(btrfsIoctlMagic, 60, unsafe.Sizeof(subvolInfoArgs{}))
+)
+
+// struct btrfs_ioctl_vol_args
+type volArgs struct {
+	fd   int64
+	name [pathNameMax + 1]byte
+}
+
+// struct btrfs_ioctl_vol_args_v2, the size and the pointer of the qgroup
+// inherit struct share the union with the unused fields
+type volArgsV2 struct {
+	fd          int64
+	transid     uint64
+	flags       uint64
+	inheritSize uint64
+	inheritPtr  uint64
+	unused      [2]uint64
+	name        [subvolNameMax + 1]byte
+}
+
+// struct btrfs_qgroup_inherit, followed by the IDs of the qgroups the new
+// subvolume is added to
+type qgroupInherit struct {
+	flags         uint64
+	numQgroups    uint64
+	numRefCopies  uint64
+	numExclCopies uint64
+	limit         [5]uint64
+}
+
+// struct btrfs_ioctl_ino_lookup_args
+type inoLookupArgs struct {
+	treeID   uint64
+	objectID uint64
+	name     [4080]byte
+}
+
+// struct btrfs_ioctl_qgroup_assign_args
+type qgroupAssignArgs struct {
+	assign uint64
+	src    uint64
+	dst    uint64
+}
+
+// struct btrfs_ioctl_timespec
+type timespec struct {
+	sec  uint64
+	nsec uint32
+}
+
+// struct btrfs_ioctl_get_subvol_info_args
+type subvolInfoArgs struct {
+	treeID       uint64
+	name         [256]byte
+	parentID     uint64
+	dirID        uint64
+	generation   uint64
+	flags        uint64
+	uuid         [16]byte
+	parentUUID   [16]byte
+	receivedUUID [16]byte
+	ctransid     uint64
+	otransid     uint64
+	stransid     uint64
+	rtransid     uint64
+	ctime        timespec
+	otime        timespec
+	stime        timespec
+	rtime        timespec
+	reserved     [8]uint64
+}
+
+// struct btrfs_ioctl_qgroup_create_args
+type qgroupCreateArgs struct {
+	create   uint64
+	qgroupID uint64
+}
+
+// struct btrfs_ioctl_qgroup_limit_args
+type qgroupLimitArgs struct {
+	qgroupID           uint64
+	flags              uint64
+	maxReferenced      uint64
+	maxExclusive       uint64
+	reservedReferenced uint64
+	reservedExclusive  uint64
+}
+
+// CreateSubvolume creates an empty subvolume at path and adds it to the given
+// qgroups.
+func CreateSubvolume(path string, qgroupIDs ...uint64) error {
+	if len(qgroupIDs) == 0 {
+		args := volArgs{}
+		if err := setName(args.name[:], filepath.Base(path)); err != nil {
+			return err
+		}
+
+		return withDir(filepath.Dir(path), func(parentFd uintptr) error {
+			return errorspkg.Wrapf(ioctl(parentFd, iocSubvolCreate, unsafe.Pointer(&args)), "creating subvolume `%s`", path)
+		})
+	}
+
+	args := volArgsV2{}
+	if err := setName(args.name[:], filepath.Base(path)); err != nil {
+		return err
+	}
+	inherit := setQgroupInherit(&args, qgroupIDs)
+	defer runtime.KeepAlive(inherit)
+
+	return withDir(filepath.Dir(path), func(parentFd uintptr) error {
+		return errorspkg.Wrapf(ioctl(parentFd, iocSubvolCreateV2, unsafe.Pointer(&args)), "creating subvolume `%s`", path)
+	})
+}
+
+// CreateSnapshot snapshots the source subvolume into destination and adds the
+// snapshot to the given qgroups.
+func CreateSnapshot(source, destination string, readOnly bool, qgroupIDs ...uint64) error {
+	args := volArgsV2{}
+	if err := setName(args.name[:], filepath.Base(destination)); err != nil {
+		return err
+	}
+	if readOnly {
+		args.flags |= subvolReadOnlyFlag
+	}
+	if len(qgroupIDs) > 0 {
+		inherit := setQgroupInherit(&args, qgroupIDs)
+		defer runtime.KeepAlive(inherit)
+	}
+
+	return withDir(source, func(sourceFd uintptr) error {
+		args.fd = int64(sourceFd)
+		return withDir(filepath.Dir(destination), func(parentFd uintptr) error {
+			return errorspkg.Wrapf(ioctl(parentFd, iocSnapCreateV2, unsafe.Pointer(&args)), "snapshotting `%s` to `%s`", source, destination)
+		})
+	})
+}
+
+// setQgroupInherit points args at a struct btrfs_qgroup_inherit listing the
+// qgroups. The returned buffer must be kept alive until the ioctl returns.
+func setQgroupInherit(args *volArgsV2, qgroupIDs []uint64) []uint64 {
+	headerWords := int(unsafe.Sizeof(qgroupInherit{}) / 8)
+	buf := make([]uint64, headerWords+len(qgroupIDs))
+
+	header := (*qgroupInherit)(unsafe.Pointer(&buf[0]))
+	header.numQgroups = uint64(len(qgroupIDs))
+	copy(buf[headerWords:], qgroupIDs)
+
+	args.flags |= subvolQgroupInheritFlag
+	args.inheritSize = uint64(len(buf) * 8)
+	args.inheritPtr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+
+	return buf
+}
+
+// DestroySubvolume deletes the subvolume at path. Unprivileged callers need
+// the filesystem to be mounted with user_subvol_rm_allowed.
+func DestroySubvolume(path string) error {
+	args := volArgs{}
+	if err := setName(args.name[:], filepath.Base(path)); err != nil {
+		return err
+	}
+
+	return withDir(filepath.Dir(path), func(parentFd uintptr) error {
+		return errorspkg.Wrapf(ioctl(parentFd, iocSnapDestroy, unsafe.Pointer(&args)), "destroying subvolume `%s`", path)
+	})
+}
+
+// IsSubvolume reports whether path is the root of a btrfs subvolume.
+func IsSubvolume(path string) (bool, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return false, errorspkg.Wrapf(err, "statfs `%s`", path)
+	}
+	if statfs.Type != btrfsSuperMagic {
+		return false, nil
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false, errorspkg.Wrapf(err, "stat `%s`", path)
+	}
+
+	return stat.Ino == firstFreeObjectID && stat.Mode&syscall.S_IFMT == syscall.S_IFDIR, nil
+}
+
+// SubvolumeID returns the ID of the subvolume that contains path.
+func SubvolumeID(path string) (uint64, error) {
+	args := inoLookupArgs{objectID: firstFreeObjectID}
+	err := withDir(path, func(fd uintptr) error {
+		return ioctl(fd, iocInoLookup, unsafe.Pointer(&args))
+	})
+	if err != nil {
+		return 0, errorspkg.Wrapf(err, "looking up subvolume id of `%s`", path)
+	}
+
+	return args.treeID, nil
+}
+
+// SubvolumeUUIDs returns the UUID of the subvolume at path and, when it was
+// created by `btrfs receive`, the UUID of the subvolume it was sent from.
+// Either is empty when unset.
+func SubvolumeUUIDs(path string) (uuid, receivedUUID string, err error) {
+	args := subvolInfoArgs{}
+	err = withDir(path, func(fd uintptr) error {
+		return ioctl(fd, iocGetSubvolInfo, unsafe.Pointer(&args))
+	})
+	if err != nil {
+		return "", "", errorspkg.Wrapf(err, "reading subvolume info of `%s`", path)
+	}
+
+	return formatUUID(args.uuid), formatUUID(args.receivedUUID), nil
+}
+
+// formatUUID formats uuid the way btrfs-progs prints it, or returns an empty
+// string for the nil UUID.
+func formatUUID(uuid [16]byte) string {
+	if uuid == [16]byte{} {
+		return ""
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// SetReadOnly sets or clears the read-only flag of the subvolume at path.
+func SetReadOnly(path string, readOnly bool) error {
+	return withDir(path, func(fd uintptr) error {
+		var flags uint64
+		if err := ioctl(fd, iocSubvolGetflag, unsafe.Pointer(&flags)); err != nil {
+			return errorspkg.Wrapf(err, "reading flags of `%s`", path)
+		}
+
+		if readOnly {
+			flags |= subvolReadOnlyFlag
+		} else {
+			flags &^= subvolReadOnlyFlag
+		}
+
+		return errorspkg.Wrapf(ioctl(fd, iocSubvolSetflag, unsafe.Pointer(&flags)), "setting flags of `%s`", path)
+	})
+}
+
+// IsReadOnly reports whether the subvolume at path is read-only.
+func IsReadOnly(path string) (bool, error) {
+	var flags uint64
+	err := withDir(path, func(fd uintptr) error {
+		return ioctl(fd, iocSubvolGetflag, unsafe.Pointer(&flags))
+	})
+	if err != nil {
+		return false, errorspkg.Wrapf(err, "reading flags of `%s`", path)
+	}
+
+	return flags&subvolReadOnlyFlag != 0, nil
+}
+
+// ResizeFilesystem grows or shrinks the filesystem containing path to
+// sizeBytes, like `btrfs filesystem resize <size> <path>`. Only the first
+// device is resized, which is the only one a loop-backed store has.
+func ResizeFilesystem(path string, sizeBytes int64) error {
+	args := volArgs{}
+	if err := setName(args.name[:], fmt.Sprintf("%d", sizeBytes)); err != nil {
+		return err
+	}
+
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocResize, unsafe.Pointer(&args)), "resizing filesystem of `%s`", path)
+	})
+}
+
+// Sync commits the current transaction of the filesystem containing path.
+func Sync(path string) error {
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocSync, nil), "syncing `%s`", path)
+	})
+}
+
+// QgroupID builds a qgroup ID from its level and ID, as in `<level>/<id>`.
+func QgroupID(level, id uint64) uint64 {
+	return level<<48 | id
+}
+
+// ParseQgroupID parses a qgroup ID formatted as `<level>/<id>`.
+func ParseQgroupID(qgroupID string) (uint64, error) {
+	var level, id uint64
+	var rest string
+	if n, _ := fmt.Sscanf(qgroupID, "%d/%d%s", &level, &id, &rest); n != 2 || level >= 1<<16 || id >= 1<<48 {
+		return 0, errorspkg.Errorf("invalid qgroup id `%s`", qgroupID)
+	}
+
+	return QgroupID(level, id), nil
+}
+
+// CreateQgroup creates the given qgroup in the filesystem containing path.
+func CreateQgroup(path string, qgroupID uint64) error {
+	args := qgroupCreateArgs{create: 1, qgroupID: qgroupID}
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocQgroupCreate, unsafe.Pointer(&args)), "creating qgroup %s", FormatQgroupID(qgroupID))
+	})
+}
+
+// AssignQgroup makes the qgroup src a member of the qgroup dst, in the
+// filesystem containing path.
+func AssignQgroup(path string, src, dst uint64) error {
+	args := qgroupAssignArgs{assign: 1, src: src, dst: dst}
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocQgroupAssign, unsafe.Pointer(&args)), "assigning qgroup %s to %s", FormatQgroupID(src), FormatQgroupID(dst))
+	})
+}
+
+// DestroyQgroup destroys the given qgroup in the filesystem containing path.
+func DestroyQgroup(path string, qgroupID uint64) error {
+	args := qgroupCreateArgs{create: 0, qgroupID: qgroupID}
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocQgroupCreate, unsafe.Pointer(&args)), "destroying qgroup %s", FormatQgroupID(qgroupID))
+	})
+}
+
+// LimitQgroup limits the referenced, or the exclusive, bytes of the given
+// qgroup. A qgroupID of 0 stands for the subvolume at path.
+func LimitQgroup(path string, qgroupID uint64, limitBytes int64, exclusive bool) error {
+	args := qgroupLimitArgs{qgroupID: qgroupID}
+	if exclusive {
+		args.flags = qgroupLimitMaxExclusive
+		args.maxExclusive = uint64(limitBytes)
+	} else {
+		args.flags = qgroupLimitMaxReferenced
+		args.maxReferenced = uint64(limitBytes)
+	}
+
+	return withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocQgroupLimit, unsafe.Pointer(&args)), "limiting qgroup of `%s`", path)
+	})
+}
+
+func setName(field []byte, name string) error {
+	if len(name) >= len(field) {
+		return errorspkg.Errorf("name `%s` is too long", name)
+	}
+
+	copy(field, name)
+	return nil
+}
+
+func withDir(path string, fn func(fd uintptr) error) error {
+	dir, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return errorspkg.Wrapf(err, "opening `%s`", path)
+	}
+	defer dir.Close()
+
+	return fn(dir.Fd())
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+const (
+	iocNone  = 0
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func ioc(dir, typ, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | typ<<8 | nr
+}
+
+func io(typ, nr uintptr) uintptr {
+	return ioc(iocNone, typ, nr, 0)
+}
+
+func iow(typ, nr, size uintptr) uintptr {
+	return ioc(iocWrite, typ, nr, size)
+}
+
+func ior(typ, nr, size uintptr) uintptr {
+	return ioc(iocRead, typ, nr, size)
+}
+
+func iowr(typ, nr, size uintptr) uintptr {
+	return ioc(iocRead|iocWrite, typ, nr, size)
+}
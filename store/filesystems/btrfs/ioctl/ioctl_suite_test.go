@@ -0,0 +1,13 @@
+package ioctl
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestIoctl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ioctl Suite")
+}
@@ -0,0 +1,290 @@
+package ioctl
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ioctl", func() {
+	Describe("request numbers", func() {
+		It("matches the kernel's btrfs ioctls", func() {
+			Expect(iocResize).To(Equal(uintptr(0x50009403)))
+			Expect(iocSync).To(Equal(uintptr(0x9408)))
+			Expect(iocSubvolCreate).To(Equal(uintptr(0x5000940e)))
+			Expect(iocSnapDestroy).To(Equal(uintptr(0x5000940f)))
+			Expect(iocTreeSearch).To(Equal(uintptr(0xd0009411)))
+			Expect(iocInoLookup).To(Equal(uintptr(0xd0009412)))
+			Expect(iocSnapCreateV2).To(Equal(uintptr(0x50009417)))
+			Expect(iocSubvolCreateV2).To(Equal(uintptr(0x50009418)))
+			Expect(iocSubvolGetflag).To(Equal(uintptr(0x80089419)))
+			Expect(iocSubvolSetflag).To(Equal(uintptr(0x4008941a)))
+			Expect(iocQgroupAssign).To(Equal(uintptr(0x40189429)))
+			Expect(iocQgroupCreate).To(Equal(uintptr(0x4010942a)))
+			Expect(iocQgroupLimit).To(Equal(uintptr(0x8030942b)))
+			Expect(iocScrub).To(Equal(uintptr(0xc400941b)))
+			Expect(iocScrubProgress).To(Equal(uintptr(0xc400941d)))
+			Expect(iocDevInfo).To(Equal(uintptr(0xd000941e)))
+			Expect(iocFsInfo).To(Equal(uintptr(0x8400941f)))
+			Expect(iocBalanceV2).To(Equal(uintptr(0xc4009420)))
+			Expect(iocBalanceProgress).To(Equal(uintptr(0x84009422)))
+			Expect(iocQuotaRescan).To(Equal(uintptr(0x4040942c)))
+			Expect(iocQuotaRescanStatus).To(Equal(uintptr(0x8040942d)))
+			Expect(iocQuotaRescanWait).To(Equal(uintptr(0x942e)))
+			Expect(iocGetSubvolInfo).To(Equal(uintptr(0x81f8943c)))
+		})
+
+		It("uses argument structs of the kernel's size", func() {
+			Expect(unsafe.Sizeof(searchKey{})).To(Equal(uintptr(searchKeySize)))
+			Expect(unsafe.Sizeof(searchArgs{})).To(Equal(uintptr(searchArgsSize)))
+			Expect(unsafe.Sizeof(volArgsV2{})).To(Equal(uintptr(4096)))
+			Expect(unsafe.Sizeof(qgroupInherit{})).To(Equal(uintptr(72)))
+			Expect(unsafe.Sizeof(balanceArgs{})).To(Equal(uintptr(136)))
+			Expect(unsafe.Sizeof(balanceIoctlArgs{})).To(Equal(uintptr(1024)))
+			Expect(unsafe.Sizeof(scrubArgs{})).To(Equal(uintptr(1024)))
+			Expect(unsafe.Sizeof(fsInfoArgs{})).To(Equal(uintptr(1024)))
+			Expect(unsafe.Sizeof(devInfoArgs{})).To(Equal(uintptr(4096)))
+			Expect(unsafe.Sizeof(subvolInfoArgs{})).To(Equal(uintptr(504)))
+		})
+	})
+
+	Describe("formatUUID", func() {
+		It("formats the uuid like btrfs-progs", func() {
+			uuid := [16]byte{0x6c, 0x1f, 0x4b, 0x2e, 0x8d, 0x0a, 0x4f, 0x41, 0x9c, 0x2b, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab}
+			Expect(formatUUID(uuid)).To(Equal("6c1f4b2e-8d0a-4f41-9c2b-0123456789ab"))
+		})
+
+		It("returns an empty string for the nil uuid", func() {
+			Expect(formatUUID([16]byte{})).To(BeEmpty())
+		})
+	})
+
+	Describe("ScrubProgress", func() {
+		It("sums the counters of the devices", func() {
+			total := ScrubProgress{DataBytesScrubbed: 4096, CsumErrors: 1, LastPhysical: 100}
+			total.add(ScrubProgress{DataBytesScrubbed: 8192, CorrectedErrors: 2, LastPhysical: 50})
+
+			Expect(total).To(Equal(ScrubProgress{
+				DataBytesScrubbed: 12288,
+				CsumErrors:        1,
+				CorrectedErrors:   2,
+				LastPhysical:      100,
+			}))
+		})
+	})
+
+	Describe("QgroupID", func() {
+		It("puts the level in the upper 16 bits", func() {
+			Expect(QgroupID(1, 100)).To(Equal(uint64(1<<48 | 100)))
+			Expect(FormatQgroupID(QgroupID(1, 100))).To(Equal("1/100"))
+			Expect(FormatQgroupID(259)).To(Equal("0/259"))
+		})
+	})
+
+	Describe("ParseQgroupID", func() {
+		It("parses the level and the ID", func() {
+			qgroupID, err := ParseQgroupID("1/100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(qgroupID).To(Equal(QgroupID(1, 100)))
+		})
+
+		It("fails on malformed IDs", func() {
+			for _, invalid := range []string{"", "1", "1/", "1/2/3", "1/2a", "a/1", "70000/1"} {
+				_, err := ParseQgroupID(invalid)
+				Expect(err).To(MatchError(ContainSubstring("invalid qgroup id")), invalid)
+			}
+		})
+	})
+
+	Describe("setQgroupInherit", func() {
+		It("lists the qgroups after the inherit header", func() {
+			args := volArgsV2{}
+			buf := setQgroupInherit(&args, []uint64{QgroupID(1, 1), QgroupID(1, 2)})
+
+			Expect(args.flags & subvolQgroupInheritFlag).NotTo(BeZero())
+			Expect(args.inheritSize).To(Equal(uint64(72 + 16)))
+			Expect(args.inheritPtr).To(Equal(uint64(uintptr(unsafe.Pointer(&buf[0])))))
+			Expect(buf[1]).To(Equal(uint64(2)))
+			Expect(buf[9:]).To(Equal([]uint64{QgroupID(1, 1), QgroupID(1, 2)}))
+		})
+	})
+
+	Describe("parseSearchItems", func() {
+		It("splits the buffer into items", func() {
+			buf := make([]byte, 0)
+			buf = append(buf, searchHeader(256, 5, rootBackrefKey, 3)...)
+			buf = append(buf, 'a', 'b', 'c')
+			buf = append(buf, searchHeader(257, 256, rootBackrefKey, 2)...)
+			buf = append(buf, 'd', 'e')
+
+			items, err := parseSearchItems(buf, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(items).To(HaveLen(2))
+			Expect(items[0].objectID).To(Equal(uint64(256)))
+			Expect(items[0].offset).To(Equal(uint64(5)))
+			Expect(items[0].data).To(Equal([]byte("abc")))
+			Expect(items[1].objectID).To(Equal(uint64(257)))
+			Expect(items[1].itemType).To(Equal(uint32(rootBackrefKey)))
+			Expect(items[1].data).To(Equal([]byte("de")))
+		})
+
+		Context("when the buffer is truncated", func() {
+			It("returns an error", func() {
+				buf := append(searchHeader(256, 5, rootBackrefKey, 10), 'a')
+
+				_, err := parseSearchItems(buf, 1)
+				Expect(err).To(MatchError(ContainSubstring("truncated")))
+			})
+		})
+	})
+
+	Describe("parseRootRef", func() {
+		It("decodes the directory and name", func() {
+			data := make([]byte, rootRefSize)
+			binary.LittleEndian.PutUint64(data[0:8], 260)
+			binary.LittleEndian.PutUint16(data[16:18], 6)
+			data = append(data, []byte("rootfs")...)
+
+			ref, err := parseRootRef(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.dirID).To(Equal(uint64(260)))
+			Expect(ref.name).To(Equal("rootfs"))
+		})
+
+		Context("when the name is truncated", func() {
+			It("returns an error", func() {
+				data := make([]byte, rootRefSize)
+				binary.LittleEndian.PutUint16(data[16:18], 6)
+
+				_, err := parseRootRef(data)
+				Expect(err).To(MatchError(ContainSubstring("truncated")))
+			})
+		})
+	})
+
+	Describe("parseQgroupInfo", func() {
+		It("decodes the referenced and exclusive bytes", func() {
+			data := make([]byte, qgroupInfoSize)
+			binary.LittleEndian.PutUint64(data[8:16], 2113536)
+			binary.LittleEndian.PutUint64(data[16:24], 1000)
+			binary.LittleEndian.PutUint64(data[24:32], 1064960)
+			binary.LittleEndian.PutUint64(data[32:40], 500)
+
+			info, err := parseQgroupInfo(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info).To(Equal(QgroupInfo{
+				Referenced:           2113536,
+				ReferencedCompressed: 1000,
+				Exclusive:            1064960,
+				ExclusiveCompressed:  500,
+			}))
+		})
+	})
+
+	Describe("parseFileExtent", func() {
+		It("decodes a regular extent", func() {
+			data := make([]byte, fileExtentItemSize)
+			data[16] = 3
+			data[20] = 1
+			binary.LittleEndian.PutUint64(data[21:29], 13631488)
+			binary.LittleEndian.PutUint64(data[29:37], 4096)
+			binary.LittleEndian.PutUint64(data[45:53], 131072)
+
+			extent, err := parseFileExtent(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extent).To(Equal(fileExtent{
+				diskBytenr:   13631488,
+				logicalBytes: 131072,
+				diskBytes:    4096,
+			}))
+		})
+
+		It("decodes an inline extent", func() {
+			data := make([]byte, fileExtentHeader+30)
+			binary.LittleEndian.PutUint64(data[8:16], 100)
+
+			extent, err := parseFileExtent(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extent).To(Equal(fileExtent{inline: true, logicalBytes: 100, diskBytes: 30}))
+		})
+
+		It("recognises holes", func() {
+			data := make([]byte, fileExtentItemSize)
+			data[20] = 1
+			binary.LittleEndian.PutUint64(data[45:53], 8192)
+
+			extent, err := parseFileExtent(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extent.hole).To(BeTrue())
+			Expect(extent.diskBytes).To(BeZero())
+		})
+
+		It("fails when the item is truncated", func() {
+			data := make([]byte, fileExtentHeader)
+			data[20] = 1
+
+			_, err := parseFileExtent(data)
+			Expect(err).To(MatchError("file extent is truncated"))
+		})
+	})
+
+	Describe("nextSearchKey", func() {
+		It("moves past the offset of the last item", func() {
+			key := searchKey{}
+			Expect(nextSearchKey(&key, searchItem{objectID: 256, itemType: 144, offset: 5})).To(BeTrue())
+			Expect(key.minObjectID).To(Equal(uint64(256)))
+			Expect(key.minType).To(Equal(uint32(144)))
+			Expect(key.minOffset).To(Equal(uint64(6)))
+		})
+
+		It("carries over into the type and the object id", func() {
+			key := searchKey{}
+			Expect(nextSearchKey(&key, searchItem{objectID: 256, itemType: 144, offset: math.MaxUint64})).To(BeTrue())
+			Expect(key.minType).To(Equal(uint32(145)))
+			Expect(key.minOffset).To(BeZero())
+
+			Expect(nextSearchKey(&key, searchItem{objectID: 256, itemType: math.MaxUint8, offset: math.MaxUint64})).To(BeTrue())
+			Expect(key.minObjectID).To(Equal(uint64(257)))
+			Expect(key.minType).To(BeZero())
+		})
+
+		It("stops at the end of the key space", func() {
+			key := searchKey{}
+			Expect(nextSearchKey(&key, searchItem{objectID: math.MaxUint64, itemType: math.MaxUint8, offset: math.MaxUint64})).To(BeFalse())
+		})
+	})
+
+	Describe("setName", func() {
+		It("fails when the name doesn't fit", func() {
+			field := make([]byte, 4)
+			Expect(setName(field, "abc")).To(Succeed())
+			Expect(setName(field, "abcd")).To(MatchError(ContainSubstring("too long")))
+		})
+	})
+
+	Describe("IsSubvolume", func() {
+		It("returns false outside of btrfs", func() {
+			dir, err := ioutil.TempDir("", "ioctl")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			isSubvolume, err := IsSubvolume(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(isSubvolume).To(BeFalse())
+		})
+	})
+})
+
+func searchHeader(objectID, offset uint64, itemType, length uint32) []byte {
+	header := make([]byte, searchHeaderSize)
+	nativeEndian.PutUint64(header[8:16], objectID)
+	nativeEndian.PutUint64(header[16:24], offset)
+	nativeEndian.PutUint32(header[24:28], itemType)
+	nativeEndian.PutUint32(header[28:32], length)
+	return header
+}
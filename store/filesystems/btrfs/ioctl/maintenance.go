@@ -0,0 +1,293 @@
+package ioctl // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
+
+import (
+	"math"
+	"syscall"
+	"unsafe"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	balanceData     = 1 << 0
+	balanceSystem   = 1 << 1
+	balanceMetadata = 1 << 2
+
+	balanceArgsUsage = 1 << 3
+
+	quotaRescanRunning = 1 << 0
+)
+
+var (
+	iocScrub             = iowr(btrfsIoctlMagic, 27, unsafe.Sizeof(scrubArgs{}))
+	iocScrubProgress     = iowr(btrfsIoctlMagic, 29, unsafe.Sizeof(scrubArgs{}))
+	iocDevInfo           = iowr(btrfsIoctlMagic, 30, unsafe.Sizeof(devInfoArgs{}))
+	iocFsInfo            = ior(btrfsIoctlMagic, 31, unsafe.Sizeof(fsInfoArgs{}))
+	iocBalanceV2         = iowr(btrfsIoctlMagic, 32, unsafe.Sizeof(balanceIoctlArgs{}))
+	iocBalanceProgress   = ior(btrfsIoctlMagic, 34, unsafe.Sizeof(balanceIoctlArgs{}))
+	iocQuotaRescan       = iow(btrfsIoctlMagic, 44, unsafe.Sizeof(quotaRescanArgs{}))
+	iocQuotaRescanStatus = ior(btrfsIoctlMagic, 45, unsafe.Sizeof(quotaRescanArgs{}))
+	iocQuotaRescanWait   = io(btrfsIoctlMagic, 46)
+)
+
+// ScrubProgress is the kernel's struct btrfs_scrub_progress, summed over the
+// devices of the filesystem.
+type ScrubProgress struct {
+	DataExtentsScrubbed uint64
+	TreeExtentsScrubbed uint64
+	DataBytesScrubbed   uint64
+	TreeBytesScrubbed   uint64
+	ReadErrors          uint64
+	CsumErrors          uint64
+	VerifyErrors        uint64
+	NoCsum              uint64
+	CsumDiscards        uint64
+	SuperErrors         uint64
+	MallocErrors        uint64
+	UncorrectableErrors uint64
+	CorrectedErrors     uint64
+	LastPhysical        uint64
+	UnverifiedErrors    uint64
+}
+
+// BalanceProgress is the kernel's struct btrfs_balance_progress, counted in
+// chunks.
+type BalanceProgress struct {
+	Expected   uint64
+	Considered uint64
+	Completed  uint64
+}
+
+// struct btrfs_ioctl_scrub_args
+type scrubArgs struct {
+	devID    uint64
+	start    uint64
+	end      uint64
+	flags    uint64
+	progress ScrubProgress
+	unused   [109]uint64
+}
+
+// struct btrfs_ioctl_dev_info_args
+type devInfoArgs struct {
+	devID      uint64
+	uuid       [16]byte
+	bytesUsed  uint64
+	totalBytes uint64
+	unused     [379]uint64
+	path       [1024]byte
+}
+
+// struct btrfs_ioctl_fs_info_args, only the device IDs are of interest
+type fsInfoArgs struct {
+	maxID      uint64
+	numDevices uint64
+	reserved   [1008]byte
+}
+
+// struct btrfs_balance_args, the usage filter shares its union with
+// usage_min and usage_max
+type balanceArgs struct {
+	profiles   uint64
+	usage      uint64
+	devID      uint64
+	pstart     uint64
+	pend       uint64
+	vstart     uint64
+	vend       uint64
+	target     uint64
+	flags      uint64
+	limit      uint64
+	stripesMin uint32
+	stripesMax uint32
+	unused     [6]uint64
+}
+
+// struct btrfs_ioctl_balance_args
+type balanceIoctlArgs struct {
+	flags  uint64
+	state  uint64
+	data   balanceArgs
+	meta   balanceArgs
+	sys    balanceArgs
+	stat   BalanceProgress
+	unused [72]uint64
+}
+
+// struct btrfs_ioctl_quota_rescan_args
+type quotaRescanArgs struct {
+	flags    uint64
+	progress uint64
+	reserved [6]uint64
+}
+
+// Scrub reads all data and metadata of the filesystem containing path and
+// verifies their checksums, repairing what can be repaired from a good copy.
+// It blocks until every device has been scrubbed, like `btrfs scrub start -B`.
+func Scrub(path string) (ScrubProgress, error) {
+	var total ScrubProgress
+	err := withDir(path, func(fd uintptr) error {
+		return eachDevice(fd, func(devID uint64) error {
+			args := scrubArgs{devID: devID, end: math.MaxUint64}
+			if err := ioctl(fd, iocScrub, unsafe.Pointer(&args)); err != nil {
+				return errorspkg.Wrapf(err, "scrubbing device %d of `%s`", devID, path)
+			}
+
+			total.add(args.progress)
+			return nil
+		})
+	})
+
+	return total, err
+}
+
+// ScrubStatus reports the progress of the scrub running on the filesystem
+// containing path, and false when none is running.
+func ScrubStatus(path string) (ScrubProgress, bool, error) {
+	var (
+		total   ScrubProgress
+		running bool
+	)
+	err := withDir(path, func(fd uintptr) error {
+		return eachDevice(fd, func(devID uint64) error {
+			args := scrubArgs{devID: devID}
+			err := ioctl(fd, iocScrubProgress, unsafe.Pointer(&args))
+			if err == syscall.ENOTCONN {
+				return nil
+			}
+			if err != nil {
+				return errorspkg.Wrapf(err, "reading scrub progress of device %d of `%s`", devID, path)
+			}
+
+			running = true
+			total.add(args.progress)
+			return nil
+		})
+	})
+
+	return total, running, err
+}
+
+// Balance relocates the data and metadata chunks of the filesystem containing
+// path that are at most usageFilter percent full, or all of them when
+// usageFilter is negative. It blocks until the balance is done.
+func Balance(path string, usageFilter int) (BalanceProgress, error) {
+	args := balanceIoctlArgs{flags: balanceData | balanceMetadata}
+	if usageFilter >= 0 {
+		args.data.flags = balanceArgsUsage
+		args.data.usage = uint64(usageFilter)
+		args.meta.flags = balanceArgsUsage
+		args.meta.usage = uint64(usageFilter)
+	}
+
+	err := withDir(path, func(fd uintptr) error {
+		return errorspkg.Wrapf(ioctl(fd, iocBalanceV2, unsafe.Pointer(&args)), "balancing `%s`", path)
+	})
+
+	return args.stat, err
+}
+
+// BalanceStatus reports the progress of the balance running on the filesystem
+// containing path, and false when none is running.
+func BalanceStatus(path string) (BalanceProgress, bool, error) {
+	args := balanceIoctlArgs{}
+	err := withDir(path, func(fd uintptr) error {
+		return ioctl(fd, iocBalanceProgress, unsafe.Pointer(&args))
+	})
+	if err == syscall.ENOTCONN {
+		return BalanceProgress{}, false, nil
+	}
+	if err != nil {
+		return BalanceProgress{}, false, errorspkg.Wrapf(err, "reading balance progress of `%s`", path)
+	}
+
+	return args.stat, true, nil
+}
+
+// QuotaRescan recomputes the qgroup numbers of the filesystem containing path
+// and waits for the rescan to finish, like `btrfs quota rescan -w`. A rescan
+// that is already running is waited for instead.
+func QuotaRescan(path string) error {
+	return withDir(path, func(fd uintptr) error {
+		args := quotaRescanArgs{}
+		if err := ioctl(fd, iocQuotaRescan, unsafe.Pointer(&args)); err != nil && err != syscall.EINPROGRESS {
+			return errorspkg.Wrapf(err, "starting quota rescan of `%s`", path)
+		}
+
+		return errorspkg.Wrapf(ioctl(fd, iocQuotaRescanWait, nil), "waiting for quota rescan of `%s`", path)
+	})
+}
+
+// QuotaRescanStatus reports the last object the running quota rescan of the
+// filesystem containing path has accounted, and false when none is running.
+func QuotaRescanStatus(path string) (uint64, bool, error) {
+	args := quotaRescanArgs{}
+	err := withDir(path, func(fd uintptr) error {
+		return ioctl(fd, iocQuotaRescanStatus, unsafe.Pointer(&args))
+	})
+	if err != nil {
+		return 0, false, errorspkg.Wrapf(err, "reading quota rescan status of `%s`", path)
+	}
+
+	return args.progress, args.flags&quotaRescanRunning != 0, nil
+}
+
+// AllocatedBytes is how much of the devices of the filesystem containing
+// path is allocated to chunks. Chunks are only partly filled, so this is more
+// than the used bytes statfs reports, and it is what a shrink has to fit.
+func AllocatedBytes(path string) (int64, error) {
+	var allocated int64
+	err := withDir(path, func(fd uintptr) error {
+		return eachDevice(fd, func(devID uint64) error {
+			info := devInfoArgs{devID: devID}
+			if err := ioctl(fd, iocDevInfo, unsafe.Pointer(&info)); err != nil {
+				return errorspkg.Wrapf(err, "reading info of device %d", devID)
+			}
+
+			allocated += int64(info.bytesUsed)
+			return nil
+		})
+	})
+
+	return allocated, err
+}
+
+// eachDevice calls fn with the ID of every device of the filesystem fd is
+// in. Device IDs can have gaps after devices were removed.
+func eachDevice(fd uintptr, fn func(devID uint64) error) error {
+	info := fsInfoArgs{}
+	if err := ioctl(fd, iocFsInfo, unsafe.Pointer(&info)); err != nil {
+		return errorspkg.Wrap(err, "reading filesystem info")
+	}
+
+	for devID := uint64(1); devID <= info.maxID; devID++ {
+		if err := fn(devID); err != nil {
+			if errorspkg.Cause(err) == syscall.ENODEV {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *ScrubProgress) add(other ScrubProgress) {
+	p.DataExtentsScrubbed += other.DataExtentsScrubbed
+	p.TreeExtentsScrubbed += other.TreeExtentsScrubbed
+	p.DataBytesScrubbed += other.DataBytesScrubbed
+	p.TreeBytesScrubbed += other.TreeBytesScrubbed
+	p.ReadErrors += other.ReadErrors
+	p.CsumErrors += other.CsumErrors
+	p.VerifyErrors += other.VerifyErrors
+	p.NoCsum += other.NoCsum
+	p.CsumDiscards += other.CsumDiscards
+	p.SuperErrors += other.SuperErrors
+	p.MallocErrors += other.MallocErrors
+	p.UncorrectableErrors += other.UncorrectableErrors
+	p.CorrectedErrors += other.CorrectedErrors
+	p.UnverifiedErrors += other.UnverifiedErrors
+	if other.LastPhysical > p.LastPhysical {
+		p.LastPhysical = other.LastPhysical
+	}
+}
@@ -0,0 +1,385 @@
+package ioctl // import "github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	btrfsSuperMagic = 0x9123683E
+
+	rootTreeObjectID  = 1
+	quotaTreeObjectID = 8
+
+	rootBackrefKey = 144
+	qgroupInfoKey  = 242
+
+	searchArgsSize   = 4096
+	searchKeySize    = 104
+	searchHeaderSize = 32
+	rootRefSize      = 18
+	qgroupInfoSize   = 40
+	searchBatchSize  = 4096
+)
+
+// the headers of tree search results are in the CPU byte order, unlike the
+// items themselves
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	probe := uint16(1)
+	if *(*byte)(unsafe.Pointer(&probe)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// struct btrfs_ioctl_search_key
+type searchKey struct {
+	treeID      uint64
+	minObjectID uint64
+	maxObjectID uint64
+	minOffset   uint64
+	maxOffset   uint64
+	minTransID  uint64
+	maxTransID  uint64
+	minType     uint32
+	maxType     uint32
+	nrItems     uint32
+	unused      uint32
+	unused1     [4]uint64
+}
+
+// struct btrfs_ioctl_search_args
+type searchArgs struct {
+	key searchKey
+	buf [searchArgsSize - searchKeySize]byte
+}
+
+type searchItem struct {
+	objectID uint64
+	offset   uint64
+	itemType uint32
+	data     []byte
+}
+
+// QgroupInfo is the usage accounted to a qgroup.
+type QgroupInfo struct {
+	Referenced           int64
+	ReferencedCompressed int64
+	Exclusive            int64
+	ExclusiveCompressed  int64
+}
+
+// FormatQgroupID formats a qgroup ID the way btrfs-progs does, e.g. `0/259`.
+func FormatQgroupID(qgroupID uint64) string {
+	return fmt.Sprintf("%d/%d", qgroupID>>48, qgroupID&(1<<48-1))
+}
+
+// QgroupUsage reads the usage of the given qgroup. A qgroupID of 0 stands for
+// the subvolume at path. Reading the quota tree requires CAP_SYS_ADMIN.
+func QgroupUsage(path string, qgroupID uint64) (QgroupInfo, error) {
+	if qgroupID == 0 {
+		subvolumeID, err := SubvolumeID(path)
+		if err != nil {
+			return QgroupInfo{}, err
+		}
+		qgroupID = subvolumeID
+	}
+
+	key := searchKey{
+		treeID:    quotaTreeObjectID,
+		minOffset: qgroupID,
+		maxOffset: qgroupID,
+		minType:   qgroupInfoKey,
+		maxType:   qgroupInfoKey,
+	}
+
+	items, err := treeSearch(path, key)
+	if err != nil {
+		return QgroupInfo{}, err
+	}
+
+	if len(items) == 0 {
+		return QgroupInfo{}, errorspkg.Errorf("qgroup %s not found, are quotas enabled?", FormatQgroupID(qgroupID))
+	}
+
+	return parseQgroupInfo(items[0].data)
+}
+
+// ListSubvolumes returns the absolute paths of path, when it is a subvolume,
+// and of every subvolume below it, sorted in reverse so that nested subvolumes come before their parents.
+// Searching the root tree requires CAP_SYS_ADMIN.
+func ListSubvolumes(path string) ([]string, error) {
+	key := searchKey{
+		treeID:      rootTreeObjectID,
+		minObjectID: firstFreeObjectID,
+		maxObjectID: math.MaxUint64,
+		maxOffset:   math.MaxUint64,
+		minType:     rootBackrefKey,
+		maxType:     rootBackrefKey,
+	}
+
+	items, err := treeSearch(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	backrefs := map[uint64]rootRef{}
+	for _, item := range items {
+		ref, err := parseRootRef(item.data)
+		if err != nil {
+			return nil, err
+		}
+		ref.parentID = item.offset
+		backrefs[item.objectID] = ref
+	}
+
+	resolver := &subvolumeResolver{
+		path:     path,
+		backrefs: backrefs,
+		resolved: map[uint64]string{fsTreeObjectID: ""},
+	}
+
+	pathInFilesystem, err := resolver.pathInFilesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	subvolumes := []string{}
+	for id := range backrefs {
+		subvolumePath, err := resolver.resolve(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if subvolumePath == pathInFilesystem {
+			subvolumes = append(subvolumes, path)
+			continue
+		}
+
+		if relativePath := strings.TrimPrefix(subvolumePath, pathInFilesystem+"/"); relativePath != subvolumePath || pathInFilesystem == "" {
+			subvolumes = append(subvolumes, filepath.Join(path, relativePath))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(subvolumes)))
+	return subvolumes, nil
+}
+
+type rootRef struct {
+	parentID uint64
+	dirID    uint64
+	name     string
+}
+
+// subvolumeResolver turns subvolume IDs into paths relative to the top level
+// of the filesystem, by following the root backrefs up to the FS tree.
+type subvolumeResolver struct {
+	path     string
+	backrefs map[uint64]rootRef
+	resolved map[uint64]string
+}
+
+func (r *subvolumeResolver) resolve(id uint64) (string, error) {
+	if resolvedPath, ok := r.resolved[id]; ok {
+		return resolvedPath, nil
+	}
+
+	ref, ok := r.backrefs[id]
+	if !ok {
+		return "", errorspkg.Errorf("subvolume %d has no parent", id)
+	}
+
+	parentPath, err := r.resolve(ref.parentID)
+	if err != nil {
+		return "", err
+	}
+
+	dirPath, err := r.lookupInode(ref.parentID, ref.dirID)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedPath := strings.TrimPrefix(filepath.Join(parentPath, dirPath, ref.name), "/")
+	r.resolved[id] = resolvedPath
+	return resolvedPath, nil
+}
+
+// pathInFilesystem is the path of r.path relative to the top level of the
+// filesystem, so that it can be compared with the resolved subvolume paths.
+func (r *subvolumeResolver) pathInFilesystem() (string, error) {
+	subvolumeID, err := SubvolumeID(r.path)
+	if err != nil {
+		return "", err
+	}
+
+	subvolumePath, err := r.resolve(subvolumeID)
+	if err != nil {
+		return "", err
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(r.path, &stat); err != nil {
+		return "", errorspkg.Wrapf(err, "stat `%s`", r.path)
+	}
+
+	dirPath, err := r.lookupInode(subvolumeID, stat.Ino)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Join(subvolumePath, dirPath), "/"), "/"), nil
+}
+
+func (r *subvolumeResolver) lookupInode(treeID, objectID uint64) (string, error) {
+	args := inoLookupArgs{treeID: treeID, objectID: objectID}
+	err := withDir(r.path, func(fd uintptr) error {
+		return ioctl(fd, iocInoLookup, unsafe.Pointer(&args))
+	})
+	if err != nil {
+		return "", errorspkg.Wrapf(err, "looking up inode %d in tree %d", objectID, treeID)
+	}
+
+	return cString(args.name[:]), nil
+}
+
+func treeSearch(path string, key searchKey) ([]searchItem, error) {
+	if key.maxTransID == 0 {
+		key.maxTransID = math.MaxUint64
+	}
+
+	items := []searchItem{}
+	err := withDir(path, func(fd uintptr) error {
+		for {
+			args := searchArgs{key: key}
+			args.key.nrItems = searchBatchSize
+			if err := ioctl(fd, iocTreeSearch, unsafe.Pointer(&args)); err != nil {
+				return err
+			}
+
+			if args.key.nrItems == 0 {
+				return nil
+			}
+
+			found, err := parseSearchItems(args.buf[:], int(args.key.nrItems))
+			if err != nil {
+				return err
+			}
+			items = append(items, found...)
+
+			last := found[len(found)-1]
+			if !nextSearchKey(&key, last) {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "searching tree %d", key.treeID)
+	}
+
+	return items, nil
+}
+
+// nextSearchKey moves the start of the key past the last item found, the way
+// btrfs-progs does. It returns false when the whole key space has been
+// searched.
+func nextSearchKey(key *searchKey, last searchItem) bool {
+	key.minObjectID = last.objectID
+	key.minType = last.itemType
+	key.minOffset = last.offset
+
+	switch {
+	case key.minOffset < math.MaxUint64:
+		key.minOffset++
+	case key.minType < math.MaxUint8:
+		key.minOffset = 0
+		key.minType++
+	case key.minObjectID < math.MaxUint64:
+		key.minOffset = 0
+		key.minType = 0
+		key.minObjectID++
+	default:
+		return false
+	}
+
+	return true
+}
+
+func parseSearchItems(buf []byte, count int) ([]searchItem, error) {
+	items := []searchItem{}
+	offset := 0
+
+	for i := 0; i < count; i++ {
+		if offset+searchHeaderSize > len(buf) {
+			return nil, errorspkg.New("tree search result is truncated")
+		}
+
+		header := buf[offset : offset+searchHeaderSize]
+		length := int(nativeEndian.Uint32(header[28:32]))
+		offset += searchHeaderSize
+
+		if offset+length > len(buf) {
+			return nil, errorspkg.New("tree search item is truncated")
+		}
+
+		items = append(items, searchItem{
+			objectID: nativeEndian.Uint64(header[8:16]),
+			offset:   nativeEndian.Uint64(header[16:24]),
+			itemType: nativeEndian.Uint32(header[24:28]),
+			data:     buf[offset : offset+length],
+		})
+		offset += length
+	}
+
+	return items, nil
+}
+
+// parseRootRef decodes a struct btrfs_root_ref, which is stored on disk in
+// little endian.
+func parseRootRef(data []byte) (rootRef, error) {
+	if len(data) < rootRefSize {
+		return rootRef{}, errorspkg.New("root ref is truncated")
+	}
+
+	nameLength := int(binary.LittleEndian.Uint16(data[16:18]))
+	if len(data) < rootRefSize+nameLength {
+		return rootRef{}, errorspkg.New("root ref name is truncated")
+	}
+
+	return rootRef{
+		dirID: binary.LittleEndian.Uint64(data[0:8]),
+		name:  string(data[rootRefSize : rootRefSize+nameLength]),
+	}, nil
+}
+
+// parseQgroupInfo decodes a struct btrfs_qgroup_info_item, which is stored on
+// disk in little endian.
+func parseQgroupInfo(data []byte) (QgroupInfo, error) {
+	if len(data) < qgroupInfoSize {
+		return QgroupInfo{}, errorspkg.New("qgroup info is truncated")
+	}
+
+	return QgroupInfo{
+		Referenced:           int64(binary.LittleEndian.Uint64(data[8:16])),
+		ReferencedCompressed: int64(binary.LittleEndian.Uint64(data[16:24])),
+		Exclusive:            int64(binary.LittleEndian.Uint64(data[24:32])),
+		ExclusiveCompressed:  int64(binary.LittleEndian.Uint64(data[32:40])),
+	}, nil
+}
+
+func cString(buf []byte) string {
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i])
+		}
+	}
+
+	return string(buf)
+}
@@ -0,0 +1,216 @@
+package btrfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs/ioctl"
+	errorspkg "github.com/pkg/errors"
+)
+
+var balanceDoneRegexp = regexp.MustCompile(`had to relocate (\d+) out of (\d+) chunks`)
+
+// Scrub verifies the checksums of all the data and metadata in the store and
+// blocks until it is done.
+func (d *Driver) Scrub(logger lager.Logger) (groot.ScrubResult, error) {
+	logger = logger.Session("btrfs-scrubbing", lager.Data{"storePath": d.storePath})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	progress, ioctlErr := ioctl.Scrub(d.storePath)
+	if ioctlErr == nil {
+		return scrubResult(progress), nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return groot.ScrubResult{}, errorspkg.Wrap(ioctlErr, "scrubbing")
+	}
+
+	stdout := bytes.NewBuffer([]byte{})
+	stderr := bytes.NewBuffer([]byte{})
+	cmd := exec.Command(d.btrfsBinPath, "scrub", "start", "-B", "-R", d.storePath)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	err := cmd.Run()
+
+	// the exit status is not zero when errors were found, the counters
+	// still tell what happened
+	result, found := parseScrubStats(stdout.String())
+	if err != nil && !found {
+		logger.Error("btrfs-failed", err)
+		return groot.ScrubResult{}, errorspkg.Wrapf(err, "scrubbing: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return result, nil
+}
+
+// Balance relocates the chunks of the store that are at most usageFilter
+// percent full, or all of them when usageFilter is groot.BalanceAllChunks,
+// and blocks until it is done.
+func (d *Driver) Balance(logger lager.Logger, usageFilter int) (groot.BalanceResult, error) {
+	logger = logger.Session("btrfs-balancing", lager.Data{"storePath": d.storePath, "usageFilter": usageFilter})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	progress, ioctlErr := ioctl.Balance(d.storePath, usageFilter)
+	if ioctlErr == nil {
+		return balanceResult(usageFilter, progress), nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return groot.BalanceResult{}, errorspkg.Wrap(ioctlErr, "balancing")
+	}
+
+	args := []string{"balance", "start", "-d", "-m"}
+	if usageFilter != groot.BalanceAllChunks {
+		args = []string{"balance", "start", fmt.Sprintf("-dusage=%d", usageFilter), fmt.Sprintf("-musage=%d", usageFilter)}
+	}
+	cmd := exec.Command(d.btrfsBinPath, append(args, d.storePath)...)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	contents, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Error("btrfs-failed", err)
+		return groot.BalanceResult{}, errorspkg.Wrapf(err, "balancing: %s", strings.TrimSpace(string(contents)))
+	}
+
+	return parseBalanceOutput(usageFilter, string(contents)), nil
+}
+
+// QuotaRescan recomputes the qgroup numbers of the store and blocks until it
+// is done.
+func (d *Driver) QuotaRescan(logger lager.Logger) (groot.QuotaRescanResult, error) {
+	logger = logger.Session("btrfs-rescanning-quotas", lager.Data{"storePath": d.storePath})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	ioctlErr := ioctl.QuotaRescan(d.storePath)
+	if ioctlErr == nil {
+		return groot.QuotaRescanResult{}, nil
+	}
+	if !d.canFallBackToCLI(logger, ioctlErr) {
+		return groot.QuotaRescanResult{}, errorspkg.Wrap(ioctlErr, "rescanning quotas")
+	}
+
+	cmd := exec.Command(d.btrfsBinPath, "quota", "rescan", "-w", d.storePath)
+	logger.Debug("starting-btrfs", lager.Data{"path": cmd.Path, "args": cmd.Args})
+	if contents, err := cmd.CombinedOutput(); err != nil {
+		logger.Error("btrfs-failed", err)
+		return groot.QuotaRescanResult{}, errorspkg.Wrapf(err, "rescanning quotas: %s", strings.TrimSpace(string(contents)))
+	}
+
+	return groot.QuotaRescanResult{}, nil
+}
+
+// MaintenanceStatus reports the progress of the scrub, balance and quota
+// rescan running on the store, leaving out the ones that are not running.
+func (d *Driver) MaintenanceStatus(logger lager.Logger) (groot.MaintenanceReport, error) {
+	logger = logger.Session("btrfs-maintenance-status", lager.Data{"storePath": d.storePath})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	report := groot.MaintenanceReport{}
+
+	scrubProgress, running, err := ioctl.ScrubStatus(d.storePath)
+	if err != nil {
+		return groot.MaintenanceReport{}, err
+	}
+	if running {
+		result := scrubResult(scrubProgress)
+		report.Scrub = &result
+	}
+
+	balanceProgress, running, err := ioctl.BalanceStatus(d.storePath)
+	if err != nil {
+		return groot.MaintenanceReport{}, err
+	}
+	if running {
+		result := balanceResult(groot.BalanceAllChunks, balanceProgress)
+		report.Balance = &result
+	}
+
+	rescanProgress, running, err := ioctl.QuotaRescanStatus(d.storePath)
+	if err != nil {
+		return groot.MaintenanceReport{}, err
+	}
+	if running {
+		report.QuotaRescan = &groot.QuotaRescanResult{Progress: rescanProgress}
+	}
+
+	return report, nil
+}
+
+func scrubResult(progress ioctl.ScrubProgress) groot.ScrubResult {
+	return groot.ScrubResult{
+		DataBytesScrubbed:   progress.DataBytesScrubbed,
+		TreeBytesScrubbed:   progress.TreeBytesScrubbed,
+		ReadErrors:          progress.ReadErrors,
+		CsumErrors:          progress.CsumErrors,
+		VerifyErrors:        progress.VerifyErrors,
+		SuperErrors:         progress.SuperErrors,
+		CorrectedErrors:     progress.CorrectedErrors,
+		UncorrectableErrors: progress.UncorrectableErrors,
+	}
+}
+
+func balanceResult(usageFilter int, progress ioctl.BalanceProgress) groot.BalanceResult {
+	return groot.BalanceResult{
+		UsageFilter:      usageFilter,
+		ExpectedChunks:   progress.Expected,
+		ConsideredChunks: progress.Considered,
+		RelocatedChunks:  progress.Completed,
+	}
+}
+
+// parseScrubStats reads the raw counters `btrfs scrub start -R` prints, one
+// `name: value` pair per line.
+func parseScrubStats(output string) (groot.ScrubResult, bool) {
+	counters := map[string]uint64{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[fields[0]] = value
+	}
+
+	if _, ok := counters["data_bytes_scrubbed"]; !ok {
+		return groot.ScrubResult{}, false
+	}
+
+	return groot.ScrubResult{
+		DataBytesScrubbed:   counters["data_bytes_scrubbed"],
+		TreeBytesScrubbed:   counters["tree_bytes_scrubbed"],
+		ReadErrors:          counters["read_errors"],
+		CsumErrors:          counters["csum_errors"],
+		VerifyErrors:        counters["verify_errors"],
+		SuperErrors:         counters["super_errors"],
+		CorrectedErrors:     counters["corrected_errors"],
+		UncorrectableErrors: counters["uncorrectable_errors"],
+	}, true
+}
+
+func parseBalanceOutput(usageFilter int, output string) groot.BalanceResult {
+	result := groot.BalanceResult{UsageFilter: usageFilter}
+
+	matches := balanceDoneRegexp.FindStringSubmatch(output)
+	if matches == nil {
+		return result
+	}
+
+	result.RelocatedChunks, _ = strconv.ParseUint(matches[1], 10, 64)
+	result.ConsideredChunks, _ = strconv.ParseUint(matches[2], 10, 64)
+	result.ExpectedChunks = result.ConsideredChunks
+	return result
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -17,12 +17,12 @@ import (
 )
 
 func WriteVolumeMeta(logger lager.Logger, storePath, id string, metadata base_image_puller.VolumeMeta) error {
-	metaFile, err := os.Create(VolumeMetaFilePath(storePath, id))
+	contents, err := json.Marshal(metadata)
 	if err != nil {
-		return errorspkg.Wrap(err, "creating metadata file")
+		return errorspkg.Wrap(err, "encoding metadata file")
 	}
 
-	if err = json.NewEncoder(metaFile).Encode(metadata); err != nil {
+	if err := store.WriteFileAtomically(VolumeMetaFilePath(storePath, id), contents, 0644); err != nil {
 		return errorspkg.Wrap(err, "writing metadata file")
 	}
 
@@ -30,18 +30,30 @@ func WriteVolumeMeta(logger lager.Logger, storePath, id string, metadata base_im
 }
 
 func VolumeSize(logger lager.Logger, storePath, id string) (int64, error) {
-	metaFile, err := os.Open(VolumeMetaFilePath(storePath, id))
+	metadata, err := ReadVolumeMeta(logger, storePath, id)
 	if err != nil {
-		return 0, errorspkg.Wrapf(err, "opening volume `%s` metadata", id)
+		return 0, err
 	}
 
-	var metadata base_image_puller.VolumeMeta
-	err = json.NewDecoder(metaFile).Decode(&metadata)
+	return metadata.Size, nil
+}
+
+// ReadVolumeMeta reads the metadata of the volume. Files that can't be parsed
+// are moved to quarantine and reported with a store.CorruptMetadataError.
+func ReadVolumeMeta(logger lager.Logger, storePath, id string) (base_image_puller.VolumeMeta, error) {
+	metaFilePath := VolumeMetaFilePath(storePath, id)
+	contents, err := ioutil.ReadFile(metaFilePath)
 	if err != nil {
-		return 0, errorspkg.Wrapf(err, "parsing volume `%s` metadata", id)
+		return base_image_puller.VolumeMeta{}, errorspkg.Wrapf(err, "opening volume `%s` metadata", id)
 	}
 
-	return metadata.Size, nil
+	var metadata base_image_puller.VolumeMeta
+	if err := json.Unmarshal(contents, &metadata); err != nil {
+		logger.Error("corrupt-volume-metadata", err, lager.Data{"volumeID": id})
+		return base_image_puller.VolumeMeta{}, errorspkg.Wrapf(store.QuarantineCorruptFile(metaFilePath, err), "parsing volume `%s` metadata", id)
+	}
+
+	return metadata, nil
 }
 
 func VolumeMetaFilePath(storePath, id string) string {
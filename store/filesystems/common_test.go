@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/store"
 	"github.com/SUSE/groot-btrfs/store/filesystems"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -49,6 +51,44 @@ var _ = Describe("Common", func() {
 		})
 	})
 
+	Describe("volume metadata", func() {
+		var storePath string
+
+		BeforeEach(func() {
+			var err error
+			storePath, err = ioutil.TempDir("", "store")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Mkdir(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(storePath)).To(Succeed())
+		})
+
+		It("reads back what was written", func() {
+			metadata := base_image_puller.VolumeMeta{Size: 1024, DiffID: "sha256:diff"}
+			Expect(filesystems.WriteVolumeMeta(logger, storePath, "vol-1", metadata)).To(Succeed())
+
+			Expect(filesystems.ReadVolumeMeta(logger, storePath, "vol-1")).To(Equal(metadata))
+		})
+
+		Context("when the metadata file is corrupt", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filesystems.VolumeMetaFilePath(storePath, "vol-1"), []byte{}, 0644)).To(Succeed())
+			})
+
+			It("moves it to quarantine and returns an error", func() {
+				_, err := filesystems.ReadVolumeMeta(logger, storePath, "vol-1")
+				Expect(err).To(HaveOccurred())
+				Expect(store.IsCorruptMetadata(err)).To(BeTrue())
+
+				Expect(filesystems.VolumeMetaFilePath(storePath, "vol-1")).NotTo(BeAnExistingFile())
+				quarantined, err := filepath.Glob(filepath.Join(storePath, store.MetaDirName, store.QuarantineDirName, "volume-vol-1.*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quarantined).To(HaveLen(1))
+			})
+		})
+	})
 })
 
 func writeFile(path string, size int64) {
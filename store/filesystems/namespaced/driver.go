@@ -24,10 +24,15 @@ import (
 type internalDriver interface {
 	CreateVolume(logger lager.Logger, parentID string, id string) (string, error)
 	DestroyVolume(logger lager.Logger, id string) error
+	EmptyTrash(logger lager.Logger) error
 	HandleOpaqueWhiteouts(logger lager.Logger, id string, opaqueWhiteouts []string) error
+	SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error
+	IsVolumeReadOnly(logger lager.Logger, id string) (bool, error)
 	MoveVolume(logger lager.Logger, from, to string) error
 	VolumePath(logger lager.Logger, id string) (string, error)
 	Volumes(logger lager.Logger) ([]string, error)
+	VolumeSize(logger lager.Logger, id string) (int64, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
 	WriteVolumeMeta(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error
 
 	CreateImage(logger lager.Logger, spec image_cloner.ImageDriverSpec) (groot.MountInfo, error)
@@ -73,7 +78,7 @@ func init() {
 		logger.Debug("got-back-from-control-pipe")
 
 		outputBuffer := bytes.NewBuffer([]byte{})
-		cmd := reexec.Command(os.Args[1], os.Args[2], os.Args[3])
+		cmd := reexec.Command(os.Args[1:]...)
 		cmd.Stderr = lagregator.NewRelogger(logger)
 		cmd.Stdout = outputBuffer
 
@@ -112,6 +117,34 @@ func init() {
 		}
 	})
 
+	reexec.Register("empty-trash", func() {
+		cli.ErrWriter = os.Stdout
+		logger := lager.NewLogger("empty-trash")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
+
+		if len(os.Args) != 2 {
+			logger.Error("parsing-command", errors.New("drivers json not specified"))
+			os.Exit(1)
+		}
+
+		var driverSpec spec.DriverSpec
+		if err := json.Unmarshal([]byte(os.Args[1]), &driverSpec); err != nil {
+			logger.Error("unmarshalling driver spec", err)
+			os.Exit(1)
+		}
+
+		driver, err := specToDriver(driverSpec)
+		if err != nil {
+			logger.Error("creating fsdriver", err)
+			os.Exit(1)
+		}
+
+		if err := driver.EmptyTrash(logger); err != nil {
+			logger.Error("emptying trash", err)
+			os.Exit(1)
+		}
+	})
+
 	reexec.Register("destroy-image", func() {
 		cli.ErrWriter = os.Stdout
 		logger := lager.NewLogger("destroy-image")
@@ -199,10 +232,67 @@ func (d *Driver) DestroyVolume(logger lager.Logger, id string) error {
 	return nil
 }
 
+func (d *Driver) EmptyTrash(logger lager.Logger) error {
+	if len(d.idMappings.UIDMappings)+len(d.idMappings.GIDMappings) == 0 || os.Getuid() == 0 {
+		return d.driver.EmptyTrash(logger)
+	}
+
+	logger = logger.Session("ns-empty-trash")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	driverJSON, _ := d.driver.Marshal(logger)
+
+	ctrlPipeR, ctrlPipeW, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "creating control pipe")
+	}
+
+	outputBuffer := bytes.NewBuffer([]byte{})
+	cmd := reexec.Command("with-caps-in-userns", "empty-trash", string(driverJSON))
+	cmd.Stderr = lagregator.NewRelogger(logger)
+	cmd.Stdout = outputBuffer
+	cmd.ExtraFiles = []*os.File{ctrlPipeR}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER,
+	}
+
+	logger.Debug("starting-empty-trash-reexec", lager.Data{"args": cmd.Args})
+	if err := d.runner.Start(cmd); err != nil {
+		return errors.Wrap(err, "reexecing empty trash")
+	}
+
+	if err := d.idMapper.MapUIDs(logger, cmd.Process.Pid, d.idMappings.UIDMappings); err != nil {
+		return errors.Wrap(err, "mapping uids")
+	}
+
+	if err := d.idMapper.MapGIDs(logger, cmd.Process.Pid, d.idMappings.GIDMappings); err != nil {
+		return errors.Wrap(err, "mapping gids")
+	}
+
+	if _, err := ctrlPipeW.Write([]byte{0}); err != nil {
+		return errors.Wrap(err, "writing to control pipe")
+	}
+
+	if err := d.runner.Wait(cmd); err != nil {
+		return errors.Wrapf(err, "waiting for empty trash rexec: %s", outputBuffer.String())
+	}
+
+	return nil
+}
+
 func (d *Driver) Volumes(logger lager.Logger) ([]string, error) {
 	return d.driver.Volumes(logger)
 }
 
+func (d *Driver) VolumeSize(logger lager.Logger, id string) (int64, error) {
+	return d.driver.VolumeSize(logger, id)
+}
+
+func (d *Driver) VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error) {
+	return d.driver.VolumeMeta(logger, id)
+}
+
 func (d *Driver) MoveVolume(logger lager.Logger, from, to string) error {
 	return d.driver.MoveVolume(logger, from, to)
 }
@@ -215,6 +305,14 @@ func (d *Driver) HandleOpaqueWhiteouts(logger lager.Logger, id string, opaqueWhi
 	return d.driver.HandleOpaqueWhiteouts(logger, id, opaqueWhiteouts)
 }
 
+func (d *Driver) SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error {
+	return d.driver.SetVolumeReadOnly(logger, id, readOnly)
+}
+
+func (d *Driver) IsVolumeReadOnly(logger lager.Logger, id string) (bool, error) {
+	return d.driver.IsVolumeReadOnly(logger, id)
+}
+
 func (d *Driver) CreateImage(logger lager.Logger, spec image_cloner.ImageDriverSpec) (groot.MountInfo, error) {
 	return d.driver.CreateImage(logger, spec)
 }
@@ -278,7 +376,7 @@ func specToDriver(spec spec.DriverSpec) (internalDriver, error) {
 			spec.FsBinaryPath,
 			spec.MkfsBinaryPath,
 			spec.SuidBinaryPath,
-			spec.StorePath), nil
+			spec.StorePath).WithCompression(spec.Compression), nil
 	default:
 		return nil, errors.Errorf("invalid filesystem spec: %s not recognized", spec.Type)
 	}
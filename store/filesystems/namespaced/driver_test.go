@@ -210,6 +210,68 @@ var _ = Describe("Driver", func() {
 		})
 	})
 
+	Describe("EmptyTrash", func() {
+		JustBeforeEach(func() {
+			fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+				Path: "/proc/self/exe",
+			}, func(cmd *exec.Cmd) error {
+				cmd.Process = &os.Process{
+					Pid: 12, // don't panic
+				}
+
+				return nil
+			})
+
+			internalDriver.MarshalReturns([]byte(`{"super-cool":"json"}`), nil)
+		})
+
+		Context("when the running user is root", func() {
+			BeforeEach(func() {
+				integration.SkipIfNonRoot(os.Getuid())
+			})
+
+			JustBeforeEach(func() {
+				internalDriver.EmptyTrashReturns(errors.New("error"))
+			})
+
+			It("decorates the internal driver function", func() {
+				err := driver.EmptyTrash(logger)
+				Expect(err).To(MatchError("error"))
+				Expect(internalDriver.EmptyTrashCallCount()).To(Equal(1))
+				Expect(idMapper.MapUIDsCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the running user is not root", func() {
+			BeforeEach(func() {
+				integration.SkipIfRoot(os.Getuid())
+			})
+
+			It("reexecs with the correct arguments", func() {
+				Expect(driver.EmptyTrash(logger)).To(Succeed())
+
+				cmds := fakeCommandRunner.StartedCommands()
+				Expect(cmds).To(HaveLen(1))
+				Expect(cmds[0].Args).To(ConsistOf([]string{"with-caps-in-userns", "empty-trash", `{"super-cool":"json"}`}))
+
+				Expect(idMapper.MapUIDsCallCount()).To(Equal(1))
+				Expect(idMapper.MapGIDsCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the idmappings are empty", func() {
+			BeforeEach(func() {
+				idMappings = groot.IDMappings{}
+			})
+
+			It("decorates the internal driver function", func() {
+				Expect(driver.EmptyTrash(logger)).To(Succeed())
+				Expect(internalDriver.EmptyTrashCallCount()).To(Equal(1))
+				Expect(fakeCommandRunner.StartedCommands()).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("Volumes", func() {
 		JustBeforeEach(func() {
 			internalDriver.VolumesReturns([]string{"abc"}, errors.New("error"))
@@ -225,6 +287,22 @@ var _ = Describe("Driver", func() {
 		})
 	})
 
+	Describe("VolumeSize", func() {
+		JustBeforeEach(func() {
+			internalDriver.VolumeSizeReturns(1024, errors.New("error"))
+		})
+
+		It("decorates the internal driver function", func() {
+			size, err := driver.VolumeSize(logger, "123")
+			Expect(size).To(Equal(int64(1024)))
+			Expect(err).To(MatchError("error"))
+			Expect(internalDriver.VolumeSizeCallCount()).To(Equal(1))
+			loggerArg, id := internalDriver.VolumeSizeArgsForCall(0)
+			Expect(loggerArg).To(Equal(logger))
+			Expect(id).To(Equal("123"))
+		})
+	})
+
 	Describe("MoveVolume", func() {
 		JustBeforeEach(func() {
 			internalDriver.MoveVolumeReturns(errors.New("error"))
@@ -274,6 +352,38 @@ var _ = Describe("Driver", func() {
 		})
 	})
 
+	Describe("SetVolumeReadOnly", func() {
+		JustBeforeEach(func() {
+			internalDriver.SetVolumeReadOnlyReturns(errors.New("error"))
+		})
+
+		It("decorates the internal driver function", func() {
+			err := driver.SetVolumeReadOnly(logger, "123", true)
+			Expect(err).To(MatchError("error"))
+			Expect(internalDriver.SetVolumeReadOnlyCallCount()).To(Equal(1))
+			loggerArg, id, readOnly := internalDriver.SetVolumeReadOnlyArgsForCall(0)
+			Expect(loggerArg).To(Equal(logger))
+			Expect(id).To(Equal("123"))
+			Expect(readOnly).To(BeTrue())
+		})
+	})
+
+	Describe("IsVolumeReadOnly", func() {
+		JustBeforeEach(func() {
+			internalDriver.IsVolumeReadOnlyReturns(true, errors.New("error"))
+		})
+
+		It("decorates the internal driver function", func() {
+			readOnly, err := driver.IsVolumeReadOnly(logger, "123")
+			Expect(err).To(MatchError("error"))
+			Expect(readOnly).To(BeTrue())
+			Expect(internalDriver.IsVolumeReadOnlyCallCount()).To(Equal(1))
+			loggerArg, id := internalDriver.IsVolumeReadOnlyArgsForCall(0)
+			Expect(loggerArg).To(Equal(logger))
+			Expect(id).To(Equal("123"))
+		})
+	})
+
 	Describe("CreateImage", func() {
 		JustBeforeEach(func() {
 			internalDriver.CreateImageReturns(groot.MountInfo{Destination: "Dimension 31-C"}, errors.New("error"))
@@ -38,6 +38,17 @@ type FakeInternalDriver struct {
 	destroyVolumeReturnsOnCall map[int]struct {
 		result1 error
 	}
+	EmptyTrashStub        func(logger lager.Logger) error
+	emptyTrashMutex       sync.RWMutex
+	emptyTrashArgsForCall []struct {
+		logger lager.Logger
+	}
+	emptyTrashReturns struct {
+		result1 error
+	}
+	emptyTrashReturnsOnCall map[int]struct {
+		result1 error
+	}
 	HandleOpaqueWhiteoutsStub        func(logger lager.Logger, id string, opaqueWhiteouts []string) error
 	handleOpaqueWhiteoutsMutex       sync.RWMutex
 	handleOpaqueWhiteoutsArgsForCall []struct {
@@ -51,6 +62,33 @@ type FakeInternalDriver struct {
 	handleOpaqueWhiteoutsReturnsOnCall map[int]struct {
 		result1 error
 	}
+	SetVolumeReadOnlyStub        func(logger lager.Logger, id string, readOnly bool) error
+	setVolumeReadOnlyMutex       sync.RWMutex
+	setVolumeReadOnlyArgsForCall []struct {
+		logger   lager.Logger
+		id       string
+		readOnly bool
+	}
+	setVolumeReadOnlyReturns struct {
+		result1 error
+	}
+	setVolumeReadOnlyReturnsOnCall map[int]struct {
+		result1 error
+	}
+	IsVolumeReadOnlyStub        func(logger lager.Logger, id string) (bool, error)
+	isVolumeReadOnlyMutex       sync.RWMutex
+	isVolumeReadOnlyArgsForCall []struct {
+		logger lager.Logger
+		id     string
+	}
+	isVolumeReadOnlyReturns struct {
+		result1 bool
+		result2 error
+	}
+	isVolumeReadOnlyReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
 	MoveVolumeStub        func(logger lager.Logger, from, to string) error
 	moveVolumeMutex       sync.RWMutex
 	moveVolumeArgsForCall []struct {
@@ -91,6 +129,34 @@ type FakeInternalDriver struct {
 		result1 []string
 		result2 error
 	}
+	VolumeSizeStub        func(logger lager.Logger, id string) (int64, error)
+	volumeSizeMutex       sync.RWMutex
+	volumeSizeArgsForCall []struct {
+		logger lager.Logger
+		id     string
+	}
+	volumeSizeReturns struct {
+		result1 int64
+		result2 error
+	}
+	volumeSizeReturnsOnCall map[int]struct {
+		result1 int64
+		result2 error
+	}
+	VolumeMetaStub        func(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+	volumeMetaMutex       sync.RWMutex
+	volumeMetaArgsForCall []struct {
+		logger lager.Logger
+		id     string
+	}
+	volumeMetaReturns struct {
+		result1 base_image_puller.VolumeMeta
+		result2 error
+	}
+	volumeMetaReturnsOnCall map[int]struct {
+		result1 base_image_puller.VolumeMeta
+		result2 error
+	}
 	WriteVolumeMetaStub        func(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error
 	writeVolumeMetaMutex       sync.RWMutex
 	writeVolumeMetaArgsForCall []struct {
@@ -263,6 +329,54 @@ func (fake *FakeInternalDriver) DestroyVolumeReturnsOnCall(i int, result1 error)
 	}{result1}
 }
 
+func (fake *FakeInternalDriver) EmptyTrash(logger lager.Logger) error {
+	fake.emptyTrashMutex.Lock()
+	ret, specificReturn := fake.emptyTrashReturnsOnCall[len(fake.emptyTrashArgsForCall)]
+	fake.emptyTrashArgsForCall = append(fake.emptyTrashArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.recordInvocation("EmptyTrash", []interface{}{logger})
+	fake.emptyTrashMutex.Unlock()
+	if fake.EmptyTrashStub != nil {
+		return fake.EmptyTrashStub(logger)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.emptyTrashReturns.result1
+}
+
+func (fake *FakeInternalDriver) EmptyTrashCallCount() int {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return len(fake.emptyTrashArgsForCall)
+}
+
+func (fake *FakeInternalDriver) EmptyTrashArgsForCall(i int) lager.Logger {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return fake.emptyTrashArgsForCall[i].logger
+}
+
+func (fake *FakeInternalDriver) EmptyTrashReturns(result1 error) {
+	fake.EmptyTrashStub = nil
+	fake.emptyTrashReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeInternalDriver) EmptyTrashReturnsOnCall(i int, result1 error) {
+	fake.EmptyTrashStub = nil
+	if fake.emptyTrashReturnsOnCall == nil {
+		fake.emptyTrashReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.emptyTrashReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeInternalDriver) HandleOpaqueWhiteouts(logger lager.Logger, id string, opaqueWhiteouts []string) error {
 	var opaqueWhiteoutsCopy []string
 	if opaqueWhiteouts != nil {
@@ -318,6 +432,108 @@ func (fake *FakeInternalDriver) HandleOpaqueWhiteoutsReturnsOnCall(i int, result
 	}{result1}
 }
 
+func (fake *FakeInternalDriver) SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error {
+	fake.setVolumeReadOnlyMutex.Lock()
+	ret, specificReturn := fake.setVolumeReadOnlyReturnsOnCall[len(fake.setVolumeReadOnlyArgsForCall)]
+	fake.setVolumeReadOnlyArgsForCall = append(fake.setVolumeReadOnlyArgsForCall, struct {
+		logger   lager.Logger
+		id       string
+		readOnly bool
+	}{logger, id, readOnly})
+	fake.recordInvocation("SetVolumeReadOnly", []interface{}{logger, id, readOnly})
+	fake.setVolumeReadOnlyMutex.Unlock()
+	if fake.SetVolumeReadOnlyStub != nil {
+		return fake.SetVolumeReadOnlyStub(logger, id, readOnly)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.setVolumeReadOnlyReturns.result1
+}
+
+func (fake *FakeInternalDriver) SetVolumeReadOnlyCallCount() int {
+	fake.setVolumeReadOnlyMutex.RLock()
+	defer fake.setVolumeReadOnlyMutex.RUnlock()
+	return len(fake.setVolumeReadOnlyArgsForCall)
+}
+
+func (fake *FakeInternalDriver) SetVolumeReadOnlyArgsForCall(i int) (lager.Logger, string, bool) {
+	fake.setVolumeReadOnlyMutex.RLock()
+	defer fake.setVolumeReadOnlyMutex.RUnlock()
+	return fake.setVolumeReadOnlyArgsForCall[i].logger, fake.setVolumeReadOnlyArgsForCall[i].id, fake.setVolumeReadOnlyArgsForCall[i].readOnly
+}
+
+func (fake *FakeInternalDriver) SetVolumeReadOnlyReturns(result1 error) {
+	fake.SetVolumeReadOnlyStub = nil
+	fake.setVolumeReadOnlyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeInternalDriver) SetVolumeReadOnlyReturnsOnCall(i int, result1 error) {
+	fake.SetVolumeReadOnlyStub = nil
+	if fake.setVolumeReadOnlyReturnsOnCall == nil {
+		fake.setVolumeReadOnlyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.setVolumeReadOnlyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeInternalDriver) IsVolumeReadOnly(logger lager.Logger, id string) (bool, error) {
+	fake.isVolumeReadOnlyMutex.Lock()
+	ret, specificReturn := fake.isVolumeReadOnlyReturnsOnCall[len(fake.isVolumeReadOnlyArgsForCall)]
+	fake.isVolumeReadOnlyArgsForCall = append(fake.isVolumeReadOnlyArgsForCall, struct {
+		logger lager.Logger
+		id     string
+	}{logger, id})
+	fake.recordInvocation("IsVolumeReadOnly", []interface{}{logger, id})
+	fake.isVolumeReadOnlyMutex.Unlock()
+	if fake.IsVolumeReadOnlyStub != nil {
+		return fake.IsVolumeReadOnlyStub(logger, id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.isVolumeReadOnlyReturns.result1, fake.isVolumeReadOnlyReturns.result2
+}
+
+func (fake *FakeInternalDriver) IsVolumeReadOnlyCallCount() int {
+	fake.isVolumeReadOnlyMutex.RLock()
+	defer fake.isVolumeReadOnlyMutex.RUnlock()
+	return len(fake.isVolumeReadOnlyArgsForCall)
+}
+
+func (fake *FakeInternalDriver) IsVolumeReadOnlyArgsForCall(i int) (lager.Logger, string) {
+	fake.isVolumeReadOnlyMutex.RLock()
+	defer fake.isVolumeReadOnlyMutex.RUnlock()
+	return fake.isVolumeReadOnlyArgsForCall[i].logger, fake.isVolumeReadOnlyArgsForCall[i].id
+}
+
+func (fake *FakeInternalDriver) IsVolumeReadOnlyReturns(result1 bool, result2 error) {
+	fake.IsVolumeReadOnlyStub = nil
+	fake.isVolumeReadOnlyReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeInternalDriver) IsVolumeReadOnlyReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.IsVolumeReadOnlyStub = nil
+	if fake.isVolumeReadOnlyReturnsOnCall == nil {
+		fake.isVolumeReadOnlyReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isVolumeReadOnlyReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeInternalDriver) MoveVolume(logger lager.Logger, from string, to string) error {
 	fake.moveVolumeMutex.Lock()
 	ret, specificReturn := fake.moveVolumeReturnsOnCall[len(fake.moveVolumeArgsForCall)]
@@ -471,6 +687,110 @@ func (fake *FakeInternalDriver) VolumesReturnsOnCall(i int, result1 []string, re
 	}{result1, result2}
 }
 
+func (fake *FakeInternalDriver) VolumeSize(logger lager.Logger, id string) (int64, error) {
+	fake.volumeSizeMutex.Lock()
+	ret, specificReturn := fake.volumeSizeReturnsOnCall[len(fake.volumeSizeArgsForCall)]
+	fake.volumeSizeArgsForCall = append(fake.volumeSizeArgsForCall, struct {
+		logger lager.Logger
+		id     string
+	}{logger, id})
+	fake.recordInvocation("VolumeSize", []interface{}{logger, id})
+	fake.volumeSizeMutex.Unlock()
+	if fake.VolumeSizeStub != nil {
+		return fake.VolumeSizeStub(logger, id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.volumeSizeReturns.result1, fake.volumeSizeReturns.result2
+}
+
+func (fake *FakeInternalDriver) VolumeSizeCallCount() int {
+	fake.volumeSizeMutex.RLock()
+	defer fake.volumeSizeMutex.RUnlock()
+	return len(fake.volumeSizeArgsForCall)
+}
+
+func (fake *FakeInternalDriver) VolumeSizeArgsForCall(i int) (lager.Logger, string) {
+	fake.volumeSizeMutex.RLock()
+	defer fake.volumeSizeMutex.RUnlock()
+	return fake.volumeSizeArgsForCall[i].logger, fake.volumeSizeArgsForCall[i].id
+}
+
+func (fake *FakeInternalDriver) VolumeSizeReturns(result1 int64, result2 error) {
+	fake.VolumeSizeStub = nil
+	fake.volumeSizeReturns = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeInternalDriver) VolumeSizeReturnsOnCall(i int, result1 int64, result2 error) {
+	fake.VolumeSizeStub = nil
+	if fake.volumeSizeReturnsOnCall == nil {
+		fake.volumeSizeReturnsOnCall = make(map[int]struct {
+			result1 int64
+			result2 error
+		})
+	}
+	fake.volumeSizeReturnsOnCall[i] = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeInternalDriver) VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error) {
+	fake.volumeMetaMutex.Lock()
+	ret, specificReturn := fake.volumeMetaReturnsOnCall[len(fake.volumeMetaArgsForCall)]
+	fake.volumeMetaArgsForCall = append(fake.volumeMetaArgsForCall, struct {
+		logger lager.Logger
+		id     string
+	}{logger, id})
+	fake.recordInvocation("VolumeMeta", []interface{}{logger, id})
+	fake.volumeMetaMutex.Unlock()
+	if fake.VolumeMetaStub != nil {
+		return fake.VolumeMetaStub(logger, id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.volumeMetaReturns.result1, fake.volumeMetaReturns.result2
+}
+
+func (fake *FakeInternalDriver) VolumeMetaCallCount() int {
+	fake.volumeMetaMutex.RLock()
+	defer fake.volumeMetaMutex.RUnlock()
+	return len(fake.volumeMetaArgsForCall)
+}
+
+func (fake *FakeInternalDriver) VolumeMetaArgsForCall(i int) (lager.Logger, string) {
+	fake.volumeMetaMutex.RLock()
+	defer fake.volumeMetaMutex.RUnlock()
+	return fake.volumeMetaArgsForCall[i].logger, fake.volumeMetaArgsForCall[i].id
+}
+
+func (fake *FakeInternalDriver) VolumeMetaReturns(result1 base_image_puller.VolumeMeta, result2 error) {
+	fake.VolumeMetaStub = nil
+	fake.volumeMetaReturns = struct {
+		result1 base_image_puller.VolumeMeta
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeInternalDriver) VolumeMetaReturnsOnCall(i int, result1 base_image_puller.VolumeMeta, result2 error) {
+	fake.VolumeMetaStub = nil
+	if fake.volumeMetaReturnsOnCall == nil {
+		fake.volumeMetaReturnsOnCall = make(map[int]struct {
+			result1 base_image_puller.VolumeMeta
+			result2 error
+		})
+	}
+	fake.volumeMetaReturnsOnCall[i] = struct {
+		result1 base_image_puller.VolumeMeta
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeInternalDriver) WriteVolumeMeta(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error {
 	fake.writeVolumeMetaMutex.Lock()
 	ret, specificReturn := fake.writeVolumeMetaReturnsOnCall[len(fake.writeVolumeMetaArgsForCall)]
@@ -732,14 +1052,24 @@ func (fake *FakeInternalDriver) Invocations() map[string][][]interface{} {
 	defer fake.createVolumeMutex.RUnlock()
 	fake.destroyVolumeMutex.RLock()
 	defer fake.destroyVolumeMutex.RUnlock()
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
 	fake.handleOpaqueWhiteoutsMutex.RLock()
 	defer fake.handleOpaqueWhiteoutsMutex.RUnlock()
+	fake.setVolumeReadOnlyMutex.RLock()
+	defer fake.setVolumeReadOnlyMutex.RUnlock()
+	fake.isVolumeReadOnlyMutex.RLock()
+	defer fake.isVolumeReadOnlyMutex.RUnlock()
 	fake.moveVolumeMutex.RLock()
 	defer fake.moveVolumeMutex.RUnlock()
 	fake.volumePathMutex.RLock()
 	defer fake.volumePathMutex.RUnlock()
 	fake.volumesMutex.RLock()
 	defer fake.volumesMutex.RUnlock()
+	fake.volumeSizeMutex.RLock()
+	defer fake.volumeSizeMutex.RUnlock()
+	fake.volumeMetaMutex.RLock()
+	defer fake.volumeMetaMutex.RUnlock()
 	fake.writeVolumeMetaMutex.RLock()
 	defer fake.writeVolumeMetaMutex.RUnlock()
 	fake.createImageMutex.RLock()
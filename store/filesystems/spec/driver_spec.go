@@ -6,4 +6,5 @@ type DriverSpec struct {
 	FsBinaryPath   string `json:"fs_binary_path"`
 	MkfsBinaryPath string `json:"mkfs_binary_path"`
 	SuidBinaryPath string `json:"suid_binary_path"`
+	Compression    string `json:"compression"`
 }
@@ -0,0 +1,507 @@
+// Package fsck cross-checks the volumes, images and metadata of a store and
+// repairs the inconsistencies crashes leave behind.
+package fsck // import "github.com/SUSE/groot-btrfs/store/fsck"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/filesystems"
+	errorspkg "github.com/pkg/errors"
+)
+
+//go:generate counterfeiter . VolumeDriver
+//go:generate counterfeiter . ImageDriver
+//go:generate counterfeiter . DependencyManager
+
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+const (
+	KindIncompleteVolume      = "incomplete-volume"
+	KindUncollectedVolume     = "uncollected-volume"
+	KindMissingVolumeMeta     = "missing-volume-meta"
+	KindOrphanVolumeMeta      = "orphan-volume-meta"
+	KindImageWithoutSubvolume = "image-without-subvolume"
+	KindDanglingDependency    = "dangling-dependency"
+	KindOrphanDependency      = "orphan-dependency"
+	KindCorruptDependency     = "corrupt-dependency"
+	KindMissingContentDigest  = "missing-content-digest"
+	KindContentMismatch       = "content-mismatch"
+	KindWritableVolume        = "writable-volume"
+)
+
+const (
+	volumeMetaPrefix = "volume-"
+	gcVolumePrefix   = "gc."
+	imageRefPrefix   = "image:"
+)
+
+type VolumeDriver interface {
+	Volumes(logger lager.Logger) ([]string, error)
+	VolumePath(logger lager.Logger, id string) (string, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+	WriteVolumeMeta(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error
+	IsVolumeReadOnly(logger lager.Logger, id string) (bool, error)
+	SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error
+	DestroyVolume(logger lager.Logger, id string) error
+}
+
+type ImageDriver interface {
+	ImageHasSubvolume(logger lager.Logger, imagePath string) (bool, error)
+}
+
+type DependencyManager interface {
+	IDs() ([]string, error)
+	Dependencies(id string) ([]string, error)
+	Quarantined() (map[string]string, error)
+	Register(id string, chainIDs []string) error
+	Deregister(id string) error
+}
+
+type Options struct {
+	Repair bool
+	// Rehash compares the contents of every volume with the digest recorded
+	// in its metadata by an earlier repair.
+	Rehash bool
+}
+
+type Finding struct {
+	Kind        string   `json:"kind"`
+	Severity    Severity `json:"severity"`
+	Subject     string   `json:"subject"`
+	Message     string   `json:"message"`
+	Repair      string   `json:"repair"`
+	Repaired    bool     `json:"repaired"`
+	RepairError string   `json:"repair_error,omitempty"`
+
+	repair func(logger lager.Logger) error
+}
+
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// HasErrors reports whether an error finding was left unrepaired.
+func (r Report) HasErrors() bool {
+	for _, finding := range r.Findings {
+		if finding.Severity == SeverityError && !finding.Repaired {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Checker struct {
+	storePath         string
+	volumeDriver      VolumeDriver
+	imageDriver       ImageDriver
+	dependencyManager DependencyManager
+	locksmith         groot.Locksmith
+}
+
+func NewChecker(storePath string, volumeDriver VolumeDriver, imageDriver ImageDriver,
+	dependencyManager DependencyManager, locksmith groot.Locksmith,
+) *Checker {
+	return &Checker{
+		storePath:         storePath,
+		volumeDriver:      volumeDriver,
+		imageDriver:       imageDriver,
+		dependencyManager: dependencyManager,
+		locksmith:         locksmith,
+	}
+}
+
+// Check holds the global lock while it looks for inconsistencies, so that
+// volumes that are being pulled are not mistaken for leftovers, and repairs
+// them when asked to.
+func (c *Checker) Check(logger lager.Logger, opts Options) (Report, error) {
+	logger = logger.Session("checking-store", lager.Data{"options": opts})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	lockFile, err := c.locksmith.Lock(groot.GlobalLockKey)
+	if err != nil {
+		return Report{}, errorspkg.Wrap(err, "locking store")
+	}
+	defer func() {
+		if err := c.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-failed", err)
+		}
+	}()
+
+	volumes, err := c.volumeDriver.Volumes(logger)
+	if err != nil {
+		return Report{}, errorspkg.Wrap(err, "listing volumes")
+	}
+
+	findings, err := c.checkVolumes(logger, volumes, opts.Rehash)
+	if err != nil {
+		return Report{}, err
+	}
+
+	metaFindings, err := c.checkVolumeMetas(volumes)
+	if err != nil {
+		return Report{}, err
+	}
+	findings = append(findings, metaFindings...)
+
+	imageFindings, brokenImages, err := c.checkImages(logger)
+	if err != nil {
+		return Report{}, err
+	}
+	findings = append(findings, imageFindings...)
+
+	dependencyFindings, err := c.checkDependencies(volumes, brokenImages)
+	if err != nil {
+		return Report{}, err
+	}
+	findings = append(findings, dependencyFindings...)
+
+	if opts.Repair {
+		for i := range findings {
+			if findings[i].repair == nil {
+				continue
+			}
+
+			if err := findings[i].repair(logger); err != nil {
+				logger.Error("repairing-failed", err, lager.Data{"kind": findings[i].Kind, "subject": findings[i].Subject})
+				findings[i].RepairError = err.Error()
+				continue
+			}
+			findings[i].Repaired = true
+		}
+	}
+
+	return Report{Findings: findings}, nil
+}
+
+func (c *Checker) checkVolumes(logger lager.Logger, volumes []string, rehash bool) ([]Finding, error) {
+	findings := []Finding{}
+
+	for _, id := range volumes {
+		volumeID := id
+		destroy := func(logger lager.Logger) error {
+			return c.volumeDriver.DestroyVolume(logger, volumeID)
+		}
+
+		switch {
+		case base_image_puller.IsIncompleteVolume(volumeID):
+			findings = append(findings, Finding{
+				Kind:     KindIncompleteVolume,
+				Severity: SeverityWarning,
+				Subject:  volumeID,
+				Message:  "volume was left behind by a pull that did not complete",
+				Repair:   "destroy the volume",
+				repair:   destroy,
+			})
+			continue
+
+		case strings.HasPrefix(volumeID, gcVolumePrefix):
+			findings = append(findings, Finding{
+				Kind:     KindUncollectedVolume,
+				Severity: SeverityWarning,
+				Subject:  volumeID,
+				Message:  "volume was marked as unused but never collected",
+				Repair:   "destroy the volume",
+				repair:   destroy,
+			})
+			continue
+		}
+
+		readOnly, err := c.volumeDriver.IsVolumeReadOnly(logger, volumeID)
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "checking volume `%s`", volumeID)
+		}
+		if !readOnly {
+			findings = append(findings, Finding{
+				Kind:     KindWritableVolume,
+				Severity: SeverityError,
+				Subject:  volumeID,
+				Message:  "volume is no longer read-only, its contents may have changed",
+				Repair:   "make the volume read-only again, re-hash it to check the contents",
+				repair: func(logger lager.Logger) error {
+					return c.volumeDriver.SetVolumeReadOnly(logger, volumeID, true)
+				},
+			})
+		}
+
+		meta, err := c.volumeDriver.VolumeMeta(logger, volumeID)
+		if err != nil {
+			findings = append(findings, Finding{
+				Kind:     KindMissingVolumeMeta,
+				Severity: SeverityError,
+				Subject:  volumeID,
+				Message:  fmt.Sprintf("volume metadata cannot be read: %s", err),
+				Repair:   "regenerate the metadata from the volume contents",
+				repair: func(logger lager.Logger) error {
+					return c.regenerateVolumeMeta(logger, volumeID)
+				},
+			})
+			continue
+		}
+
+		if rehash {
+			if finding, found := c.rehashVolume(logger, volumeID, meta); found {
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+func (c *Checker) rehashVolume(logger lager.Logger, volumeID string, meta base_image_puller.VolumeMeta) (Finding, bool) {
+	volumePath, err := c.volumeDriver.VolumePath(logger, volumeID)
+	if err != nil {
+		logger.Error("fetching-volume-path-failed", err, lager.Data{"volumeID": volumeID})
+		return Finding{}, false
+	}
+
+	// pulls don't hash the volumes, the first repair records the digest the
+	// later rehashes compare with
+	if meta.ContentDigest == "" {
+		return Finding{
+			Kind:     KindMissingContentDigest,
+			Severity: SeverityInfo,
+			Subject:  volumeID,
+			Message:  "no content digest was recorded for the volume yet",
+			Repair:   "record the digest of the current contents",
+			repair: func(logger lager.Logger) error {
+				digest, err := base_image_puller.ContentDigest(volumePath)
+				if err != nil {
+					return errorspkg.Wrapf(err, "hashing volume `%s`", volumeID)
+				}
+
+				meta.ContentDigest = digest
+				return c.volumeDriver.WriteVolumeMeta(logger, volumeID, meta)
+			},
+		}, true
+	}
+
+	digest, err := base_image_puller.ContentDigest(volumePath)
+	if err != nil {
+		return Finding{
+			Kind:     KindContentMismatch,
+			Severity: SeverityError,
+			Subject:  volumeID,
+			Message:  fmt.Sprintf("volume contents cannot be read: %s", err),
+			Repair:   "destroy the volume, the next create pulls it again",
+			repair: func(logger lager.Logger) error {
+				return c.volumeDriver.DestroyVolume(logger, volumeID)
+			},
+		}, true
+	}
+
+	if meta.ContentDigest != digest {
+		return Finding{
+			Kind:     KindContentMismatch,
+			Severity: SeverityError,
+			Subject:  volumeID,
+			Message:  fmt.Sprintf("volume contents hash to %s, %s was recorded for diff id `%s`", digest, meta.ContentDigest, meta.DiffID),
+			Repair:   "destroy the volume, the next create pulls it again",
+			repair: func(logger lager.Logger) error {
+				return c.volumeDriver.DestroyVolume(logger, volumeID)
+			},
+		}, true
+	}
+
+	return Finding{}, false
+}
+
+func (c *Checker) regenerateVolumeMeta(logger lager.Logger, volumeID string) error {
+	volumePath, err := c.volumeDriver.VolumePath(logger, volumeID)
+	if err != nil {
+		return err
+	}
+
+	size, err := filesystems.CalculatePathSize(logger, volumePath)
+	if err != nil {
+		return err
+	}
+
+	return c.volumeDriver.WriteVolumeMeta(logger, volumeID, base_image_puller.VolumeMeta{Size: size})
+}
+
+func (c *Checker) checkVolumeMetas(volumes []string) ([]Finding, error) {
+	existing := map[string]bool{}
+	for _, id := range volumes {
+		existing[strings.TrimPrefix(id, gcVolumePrefix)] = true
+	}
+
+	metaPaths, err := filepath.Glob(filepath.Join(c.storePath, store.MetaDirName, volumeMetaPrefix+"*"))
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing volume metadata")
+	}
+
+	findings := []Finding{}
+	for _, metaPath := range metaPaths {
+		path := metaPath
+		id := strings.TrimPrefix(filepath.Base(path), volumeMetaPrefix)
+		if existing[id] {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Kind:     KindOrphanVolumeMeta,
+			Severity: SeverityWarning,
+			Subject:  id,
+			Message:  "volume metadata has no volume",
+			Repair:   "remove the metadata file",
+			repair: func(lager.Logger) error {
+				return os.Remove(path)
+			},
+		})
+	}
+
+	return findings, nil
+}
+
+func (c *Checker) checkImages(logger lager.Logger) ([]Finding, map[string]bool, error) {
+	imagesPath := filepath.Join(c.storePath, store.ImageDirName)
+	entries, err := ioutil.ReadDir(imagesPath)
+	if err != nil {
+		return nil, nil, errorspkg.Wrap(err, "listing images")
+	}
+
+	findings := []Finding{}
+	brokenImages := map[string]bool{}
+	for _, entry := range entries {
+		imageID := entry.Name()
+		imagePath := filepath.Join(imagesPath, imageID)
+
+		hasSubvolume, err := c.imageDriver.ImageHasSubvolume(logger, imagePath)
+		if err != nil {
+			return nil, nil, errorspkg.Wrapf(err, "checking image `%s`", imageID)
+		}
+		if hasSubvolume {
+			continue
+		}
+
+		brokenImages[imageID] = true
+		findings = append(findings, Finding{
+			Kind:     KindImageWithoutSubvolume,
+			Severity: SeverityError,
+			Subject:  imageID,
+			Message:  "image has no rootfs subvolume",
+			Repair:   "remove the image and its dependencies",
+			repair: func(logger lager.Logger) error {
+				if err := os.RemoveAll(imagePath); err != nil {
+					return err
+				}
+
+				err := c.dependencyManager.Deregister(imageRefPrefix + imageID)
+				if os.IsNotExist(errorspkg.Cause(err)) {
+					return nil
+				}
+				return err
+			},
+		})
+	}
+
+	return findings, brokenImages, nil
+}
+
+func (c *Checker) checkDependencies(volumes []string, brokenImages map[string]bool) ([]Finding, error) {
+	existing := map[string]bool{}
+	for _, id := range volumes {
+		existing[id] = true
+	}
+
+	ids, err := c.dependencyManager.IDs()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing dependencies")
+	}
+
+	quarantined, err := c.dependencyManager.Quarantined()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing quarantined dependencies")
+	}
+
+	quarantinedIDs := []string{}
+	for id := range quarantined {
+		quarantinedIDs = append(quarantinedIDs, id)
+	}
+	sort.Strings(quarantinedIDs)
+
+	findings := []Finding{}
+	for _, id := range quarantinedIDs {
+		findings = append(findings, Finding{
+			Kind:     KindCorruptDependency,
+			Severity: SeverityError,
+			Subject:  id,
+			Message:  fmt.Sprintf("dependencies were corrupt and moved to %s, no volume is collected until the image is deleted", quarantined[id]),
+			Repair:   "none, delete the image and create it again",
+		})
+	}
+
+	for _, id := range ids {
+		dependentID := id
+		if !strings.HasPrefix(dependentID, imageRefPrefix) {
+			continue
+		}
+
+		imageID := strings.TrimPrefix(dependentID, imageRefPrefix)
+		if brokenImages[imageID] {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(c.storePath, store.ImageDirName, imageID)); os.IsNotExist(err) {
+			findings = append(findings, Finding{
+				Kind:     KindOrphanDependency,
+				Severity: SeverityWarning,
+				Subject:  dependentID,
+				Message:  "dependencies are registered for an image that does not exist",
+				Repair:   "deregister the dependencies",
+				repair: func(lager.Logger) error {
+					return c.dependencyManager.Deregister(dependentID)
+				},
+			})
+			continue
+		}
+
+		chainIDs, err := c.dependencyManager.Dependencies(dependentID)
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "reading dependencies of `%s`", dependentID)
+		}
+
+		kept, missing := []string{}, []string{}
+		for _, chainID := range chainIDs {
+			if existing[chainID] {
+				kept = append(kept, chainID)
+			} else {
+				missing = append(missing, chainID)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Kind:     KindDanglingDependency,
+			Severity: SeverityWarning,
+			Subject:  dependentID,
+			Message:  fmt.Sprintf("dependencies point at missing volumes: %s", strings.Join(missing, ", ")),
+			Repair:   "drop the missing volumes from the dependencies",
+			repair: func(lager.Logger) error {
+				return c.dependencyManager.Register(dependentID, kept)
+			},
+		})
+	}
+
+	return findings, nil
+}
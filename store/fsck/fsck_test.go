@@ -0,0 +1,490 @@
+package fsck_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/fsck"
+	"github.com/SUSE/groot-btrfs/store/fsck/fsckfakes"
+	errorspkg "github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		storePath             string
+		volumesPath           string
+		fakeVolumeDriver      *fsckfakes.FakeVolumeDriver
+		fakeImageDriver       *fsckfakes.FakeImageDriver
+		fakeDependencyManager *fsckfakes.FakeDependencyManager
+		fakeLocksmith         *grootfakes.FakeLocksmith
+		lockFile              *os.File
+		volumes               []string
+		options               fsck.Options
+
+		checker *fsck.Checker
+		logger  lager.Logger
+		report  fsck.Report
+		err     error
+	)
+
+	findingsOfKind := func(kind string) []fsck.Finding {
+		findings := []fsck.Finding{}
+		for _, finding := range report.Findings {
+			if finding.Kind == kind {
+				findings = append(findings, finding)
+			}
+		}
+		return findings
+	}
+
+	BeforeEach(func() {
+		storePath, err = ioutil.TempDir("", "fsck-store")
+		Expect(err).NotTo(HaveOccurred())
+		for _, dir := range []string{store.ImageDirName, store.VolumesDirName, store.MetaDirName} {
+			Expect(os.Mkdir(filepath.Join(storePath, dir), 0755)).To(Succeed())
+		}
+		volumesPath = filepath.Join(storePath, store.VolumesDirName)
+
+		lockFile, err = ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+		fakeLocksmith.LockReturns(lockFile, nil)
+
+		volumes = []string{}
+		fakeVolumeDriver = new(fsckfakes.FakeVolumeDriver)
+		fakeVolumeDriver.VolumesStub = func(lager.Logger) ([]string, error) {
+			return volumes, nil
+		}
+		fakeVolumeDriver.VolumePathStub = func(_ lager.Logger, id string) (string, error) {
+			return filepath.Join(volumesPath, id), nil
+		}
+		fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 1024}, nil)
+		fakeVolumeDriver.IsVolumeReadOnlyReturns(true, nil)
+
+		fakeImageDriver = new(fsckfakes.FakeImageDriver)
+		fakeImageDriver.ImageHasSubvolumeReturns(true, nil)
+		fakeDependencyManager = new(fsckfakes.FakeDependencyManager)
+		fakeDependencyManager.IDsReturns([]string{}, nil)
+
+		options = fsck.Options{}
+		logger = lagertest.NewTestLogger("fsck")
+	})
+
+	JustBeforeEach(func() {
+		checker = fsck.NewChecker(storePath, fakeVolumeDriver, fakeImageDriver, fakeDependencyManager, fakeLocksmith)
+		report, err = checker.Check(logger, options)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(storePath)).To(Succeed())
+		Expect(os.Remove(lockFile.Name())).To(Succeed())
+	})
+
+	It("holds the global lock", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+		Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+		Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+	})
+
+	Context("when the store is consistent", func() {
+		BeforeEach(func() {
+			volumes = []string{"chain-1"}
+			Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, "volume-chain-1"), []byte{}, 0644)).To(Succeed())
+		})
+
+		It("finds nothing", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Findings).To(BeEmpty())
+			Expect(report.HasErrors()).To(BeFalse())
+		})
+	})
+
+	Context("when there are leftover volumes", func() {
+		BeforeEach(func() {
+			volumes = []string{"chain-1-incomplete-123-456", "gc.chain-2"}
+			Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, "volume-chain-2"), []byte{}, 0644)).To(Succeed())
+		})
+
+		It("reports them as warnings", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			incomplete := findingsOfKind(fsck.KindIncompleteVolume)
+			Expect(incomplete).To(HaveLen(1))
+			Expect(incomplete[0].Subject).To(Equal("chain-1-incomplete-123-456"))
+			Expect(incomplete[0].Severity).To(Equal(fsck.SeverityWarning))
+
+			uncollected := findingsOfKind(fsck.KindUncollectedVolume)
+			Expect(uncollected).To(HaveLen(1))
+			Expect(uncollected[0].Subject).To(Equal("gc.chain-2"))
+			Expect(uncollected[0].Repaired).To(BeFalse())
+		})
+
+		It("does not treat their metadata as orphaned", func() {
+			Expect(findingsOfKind(fsck.KindOrphanVolumeMeta)).To(BeEmpty())
+		})
+
+		It("does not check whether they are read-only", func() {
+			Expect(fakeVolumeDriver.IsVolumeReadOnlyCallCount()).To(BeZero())
+		})
+
+		It("does not destroy them without repairing", func() {
+			Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(BeZero())
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("destroys them", func() {
+				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(2))
+				_, id := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
+				Expect(id).To(Equal("chain-1-incomplete-123-456"))
+				_, id = fakeVolumeDriver.DestroyVolumeArgsForCall(1)
+				Expect(id).To(Equal("gc.chain-2"))
+
+				Expect(report.Findings[0].Repaired).To(BeTrue())
+			})
+
+			Context("and destroying fails", func() {
+				BeforeEach(func() {
+					fakeVolumeDriver.DestroyVolumeReturns(errors.New("busy"))
+				})
+
+				It("records the failure", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(report.Findings[0].Repaired).To(BeFalse())
+					Expect(report.Findings[0].RepairError).To(Equal("busy"))
+				})
+			})
+		})
+	})
+
+	Context("when a volume has no metadata", func() {
+		BeforeEach(func() {
+			volumes = []string{"chain-1"}
+			Expect(os.Mkdir(filepath.Join(volumesPath, "chain-1"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(volumesPath, "chain-1", "file"), make([]byte, 4096), 0644)).To(Succeed())
+			fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{}, errors.New("no such file or directory"))
+		})
+
+		It("reports an error", func() {
+			findings := findingsOfKind(fsck.KindMissingVolumeMeta)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Severity).To(Equal(fsck.SeverityError))
+			Expect(report.HasErrors()).To(BeTrue())
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("regenerates the metadata", func() {
+				Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(Equal(1))
+				_, id, meta := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
+				Expect(id).To(Equal("chain-1"))
+				Expect(meta.Size).To(BeNumerically(">=", 4096))
+				Expect(report.HasErrors()).To(BeFalse())
+			})
+		})
+	})
+
+	Context("when volume metadata has no volume", func() {
+		var metaPath string
+
+		BeforeEach(func() {
+			metaPath = filepath.Join(storePath, store.MetaDirName, "volume-chain-1")
+			Expect(ioutil.WriteFile(metaPath, []byte{}, 0644)).To(Succeed())
+		})
+
+		It("reports a warning", func() {
+			findings := findingsOfKind(fsck.KindOrphanVolumeMeta)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("chain-1"))
+			Expect(metaPath).To(BeAnExistingFile())
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("removes the metadata file", func() {
+				Expect(metaPath).NotTo(BeAnExistingFile())
+			})
+		})
+	})
+
+	Context("when an image has no subvolume", func() {
+		var imagePath string
+
+		BeforeEach(func() {
+			imagePath = filepath.Join(storePath, store.ImageDirName, "my-image")
+			Expect(os.Mkdir(imagePath, 0755)).To(Succeed())
+			fakeImageDriver.ImageHasSubvolumeReturns(false, nil)
+			fakeDependencyManager.IDsReturns([]string{"image:my-image"}, nil)
+			fakeDependencyManager.DependenciesReturns([]string{"missing"}, nil)
+		})
+
+		It("reports an error", func() {
+			findings := findingsOfKind(fsck.KindImageWithoutSubvolume)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("my-image"))
+			Expect(findings[0].Severity).To(Equal(fsck.SeverityError))
+
+			_, checkedPath := fakeImageDriver.ImageHasSubvolumeArgsForCall(0)
+			Expect(checkedPath).To(Equal(imagePath))
+		})
+
+		It("does not check its dependencies", func() {
+			Expect(findingsOfKind(fsck.KindDanglingDependency)).To(BeEmpty())
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("removes the image and deregisters its dependencies", func() {
+				Expect(imagePath).NotTo(BeADirectory())
+				Expect(fakeDependencyManager.DeregisterCallCount()).To(Equal(1))
+				Expect(fakeDependencyManager.DeregisterArgsForCall(0)).To(Equal("image:my-image"))
+			})
+
+			Context("when no dependencies are registered for it", func() {
+				BeforeEach(func() {
+					fakeDependencyManager.DeregisterReturns(errorspkg.Wrap(os.ErrNotExist, "deregistering `image:my-image`"))
+				})
+
+				It("counts as repaired", func() {
+					findings := findingsOfKind(fsck.KindImageWithoutSubvolume)
+					Expect(findings).To(HaveLen(1))
+					Expect(findings[0].Repaired).To(BeTrue())
+				})
+			})
+		})
+	})
+
+	Context("when dependencies point at missing volumes", func() {
+		BeforeEach(func() {
+			volumes = []string{"chain-1"}
+			Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, "volume-chain-1"), []byte{}, 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(storePath, store.ImageDirName, "my-image"), 0755)).To(Succeed())
+			fakeDependencyManager.IDsReturns([]string{"image:my-image", "other:ref"}, nil)
+			fakeDependencyManager.DependenciesReturns([]string{"chain-1", "chain-2"}, nil)
+		})
+
+		It("reports a warning", func() {
+			findings := findingsOfKind(fsck.KindDanglingDependency)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("image:my-image"))
+			Expect(findings[0].Message).To(ContainSubstring("chain-2"))
+		})
+
+		It("only checks image dependencies", func() {
+			Expect(fakeDependencyManager.DependenciesCallCount()).To(Equal(1))
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("drops the missing volumes", func() {
+				Expect(fakeDependencyManager.RegisterCallCount()).To(Equal(1))
+				id, chainIDs := fakeDependencyManager.RegisterArgsForCall(0)
+				Expect(id).To(Equal("image:my-image"))
+				Expect(chainIDs).To(Equal([]string{"chain-1"}))
+			})
+		})
+	})
+
+	Context("when the dependencies of an image were quarantined", func() {
+		BeforeEach(func() {
+			Expect(os.Mkdir(filepath.Join(storePath, store.ImageDirName, "my-image"), 0755)).To(Succeed())
+			fakeDependencyManager.QuarantinedReturns(map[string]string{
+				"image:my-image": "/meta/dependencies/quarantine/image:my-image.json.1",
+			}, nil)
+		})
+
+		It("reports an error", func() {
+			Expect(err).NotTo(HaveOccurred())
+			findings := findingsOfKind(fsck.KindCorruptDependency)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("image:my-image"))
+			Expect(findings[0].Severity).To(Equal(fsck.SeverityError))
+			Expect(findings[0].Message).To(ContainSubstring("/meta/dependencies/quarantine/image:my-image.json.1"))
+		})
+	})
+
+	Context("when listing the quarantined dependencies fails", func() {
+		BeforeEach(func() {
+			fakeDependencyManager.QuarantinedReturns(nil, errors.New("no quarantine"))
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("no quarantine")))
+		})
+	})
+
+	Context("when dependencies are registered for a missing image", func() {
+		BeforeEach(func() {
+			fakeDependencyManager.IDsReturns([]string{"image:gone"}, nil)
+		})
+
+		It("reports a warning", func() {
+			findings := findingsOfKind(fsck.KindOrphanDependency)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("image:gone"))
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("deregisters them", func() {
+				Expect(fakeDependencyManager.DeregisterCallCount()).To(Equal(1))
+				Expect(fakeDependencyManager.DeregisterArgsForCall(0)).To(Equal("image:gone"))
+			})
+		})
+	})
+
+	Context("when a volume is no longer read-only", func() {
+		BeforeEach(func() {
+			volumes = []string{"chain-1", "chain-2"}
+			for _, id := range volumes {
+				Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, "volume-"+id), []byte{}, 0644)).To(Succeed())
+			}
+			fakeVolumeDriver.IsVolumeReadOnlyStub = func(_ lager.Logger, id string) (bool, error) {
+				return id == "chain-1", nil
+			}
+		})
+
+		It("reports an error", func() {
+			findings := findingsOfKind(fsck.KindWritableVolume)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Subject).To(Equal("chain-2"))
+			Expect(findings[0].Severity).To(Equal(fsck.SeverityError))
+			Expect(report.HasErrors()).To(BeTrue())
+		})
+
+		Context("when repairing", func() {
+			BeforeEach(func() {
+				options.Repair = true
+			})
+
+			It("makes it read-only again", func() {
+				Expect(fakeVolumeDriver.SetVolumeReadOnlyCallCount()).To(Equal(1))
+				_, id, readOnly := fakeVolumeDriver.SetVolumeReadOnlyArgsForCall(0)
+				Expect(id).To(Equal("chain-2"))
+				Expect(readOnly).To(BeTrue())
+			})
+		})
+
+		Context("when checking it fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.IsVolumeReadOnlyStub = nil
+				fakeVolumeDriver.IsVolumeReadOnlyReturns(false, errors.New("flags unavailable"))
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("flags unavailable")))
+			})
+		})
+	})
+
+	Context("when rehashing", func() {
+		var digest string
+
+		BeforeEach(func() {
+			volumes = []string{"chain-1"}
+			Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, "volume-chain-1"), []byte{}, 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(volumesPath, "chain-1"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(volumesPath, "chain-1", "file"), []byte("hello"), 0644)).To(Succeed())
+
+			digest, err = base_image_puller.ContentDigest(filepath.Join(volumesPath, "chain-1"))
+			Expect(err).NotTo(HaveOccurred())
+			options.Rehash = true
+		})
+
+		Context("when the digest matches", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{DiffID: "sha256:diff", ContentDigest: digest}, nil)
+			})
+
+			It("finds nothing", func() {
+				Expect(report.Findings).To(BeEmpty())
+			})
+		})
+
+		Context("when the contents changed", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{DiffID: "sha256:diff", ContentDigest: "sha256:other"}, nil)
+			})
+
+			It("reports an error", func() {
+				findings := findingsOfKind(fsck.KindContentMismatch)
+				Expect(findings).To(HaveLen(1))
+				Expect(findings[0].Severity).To(Equal(fsck.SeverityError))
+				Expect(findings[0].Message).To(ContainSubstring("sha256:diff"))
+			})
+		})
+
+		Context("when no digest was recorded yet", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 5, DiffID: "sha256:diff"}, nil)
+			})
+
+			It("reports it", func() {
+				findings := findingsOfKind(fsck.KindMissingContentDigest)
+				Expect(findings).To(HaveLen(1))
+				Expect(findings[0].Severity).To(Equal(fsck.SeverityInfo))
+				Expect(findings[0].Repaired).To(BeFalse())
+
+				Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(BeZero())
+			})
+
+			Context("when repairing", func() {
+				BeforeEach(func() {
+					options.Repair = true
+				})
+
+				It("records the digest of the current contents", func() {
+					findings := findingsOfKind(fsck.KindMissingContentDigest)
+					Expect(findings).To(HaveLen(1))
+					Expect(findings[0].Repaired).To(BeTrue())
+
+					Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(Equal(1))
+					_, id, meta := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
+					Expect(id).To(Equal("chain-1"))
+					Expect(meta).To(Equal(base_image_puller.VolumeMeta{Size: 5, DiffID: "sha256:diff", ContentDigest: digest}))
+				})
+			})
+		})
+	})
+
+	Context("when locking fails", func() {
+		BeforeEach(func() {
+			fakeLocksmith.LockReturns(nil, errors.New("locked"))
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("locked")))
+			Expect(fakeVolumeDriver.VolumesCallCount()).To(BeZero())
+		})
+	})
+})
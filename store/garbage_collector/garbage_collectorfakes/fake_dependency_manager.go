@@ -21,6 +21,17 @@ type FakeDependencyManager struct {
 		result1 []string
 		result2 error
 	}
+	IDsStub        func() ([]string, error)
+	iDsMutex       sync.RWMutex
+	iDsArgsForCall []struct{}
+	iDsReturns     struct {
+		result1 []string
+		result2 error
+	}
+	iDsReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -76,11 +87,56 @@ func (fake *FakeDependencyManager) DependenciesReturnsOnCall(i int, result1 []st
 	}{result1, result2}
 }
 
+func (fake *FakeDependencyManager) IDs() ([]string, error) {
+	fake.iDsMutex.Lock()
+	ret, specificReturn := fake.iDsReturnsOnCall[len(fake.iDsArgsForCall)]
+	fake.iDsArgsForCall = append(fake.iDsArgsForCall, struct{}{})
+	fake.recordInvocation("IDs", []interface{}{})
+	fake.iDsMutex.Unlock()
+	if fake.IDsStub != nil {
+		return fake.IDsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.iDsReturns.result1, fake.iDsReturns.result2
+}
+
+func (fake *FakeDependencyManager) IDsCallCount() int {
+	fake.iDsMutex.RLock()
+	defer fake.iDsMutex.RUnlock()
+	return len(fake.iDsArgsForCall)
+}
+
+func (fake *FakeDependencyManager) IDsReturns(result1 []string, result2 error) {
+	fake.IDsStub = nil
+	fake.iDsReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDependencyManager) IDsReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.IDsStub = nil
+	if fake.iDsReturnsOnCall == nil {
+		fake.iDsReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.iDsReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeDependencyManager) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.dependenciesMutex.RLock()
 	defer fake.dependenciesMutex.RUnlock()
+	fake.iDsMutex.RLock()
+	defer fake.iDsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
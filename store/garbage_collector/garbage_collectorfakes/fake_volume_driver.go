@@ -48,6 +48,17 @@ type FakeVolumeDriver struct {
 	destroyVolumeReturnsOnCall map[int]struct {
 		result1 error
 	}
+	EmptyTrashStub        func(logger lager.Logger) error
+	emptyTrashMutex       sync.RWMutex
+	emptyTrashArgsForCall []struct {
+		logger lager.Logger
+	}
+	emptyTrashReturns struct {
+		result1 error
+	}
+	emptyTrashReturnsOnCall map[int]struct {
+		result1 error
+	}
 	VolumesStub        func(logger lager.Logger) ([]string, error)
 	volumesMutex       sync.RWMutex
 	volumesArgsForCall []struct {
@@ -216,6 +227,54 @@ func (fake *FakeVolumeDriver) DestroyVolumeReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeVolumeDriver) EmptyTrash(logger lager.Logger) error {
+	fake.emptyTrashMutex.Lock()
+	ret, specificReturn := fake.emptyTrashReturnsOnCall[len(fake.emptyTrashArgsForCall)]
+	fake.emptyTrashArgsForCall = append(fake.emptyTrashArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.recordInvocation("EmptyTrash", []interface{}{logger})
+	fake.emptyTrashMutex.Unlock()
+	if fake.EmptyTrashStub != nil {
+		return fake.EmptyTrashStub(logger)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.emptyTrashReturns.result1
+}
+
+func (fake *FakeVolumeDriver) EmptyTrashCallCount() int {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return len(fake.emptyTrashArgsForCall)
+}
+
+func (fake *FakeVolumeDriver) EmptyTrashArgsForCall(i int) lager.Logger {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return fake.emptyTrashArgsForCall[i].logger
+}
+
+func (fake *FakeVolumeDriver) EmptyTrashReturns(result1 error) {
+	fake.EmptyTrashStub = nil
+	fake.emptyTrashReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeVolumeDriver) EmptyTrashReturnsOnCall(i int, result1 error) {
+	fake.EmptyTrashStub = nil
+	if fake.emptyTrashReturnsOnCall == nil {
+		fake.emptyTrashReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.emptyTrashReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeVolumeDriver) Volumes(logger lager.Logger) ([]string, error) {
 	fake.volumesMutex.Lock()
 	ret, specificReturn := fake.volumesReturnsOnCall[len(fake.volumesArgsForCall)]
@@ -276,6 +335,8 @@ func (fake *FakeVolumeDriver) Invocations() map[string][][]interface{} {
 	defer fake.moveVolumeMutex.RUnlock()
 	fake.destroyVolumeMutex.RLock()
 	defer fake.destroyVolumeMutex.RUnlock()
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
 	fake.volumesMutex.RLock()
 	defer fake.volumesMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
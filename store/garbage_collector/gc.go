@@ -3,16 +3,24 @@ package garbage_collector
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
 	"github.com/SUSE/groot-btrfs/groot"
 	errorspkg "github.com/pkg/errors"
 )
 
+// DefaultIncompleteVolumeGracePeriod is how long an incomplete volume is left
+// alone before it is considered abandoned by the pull that created it.
+const DefaultIncompleteVolumeGracePeriod = time.Hour
+
 //go:generate counterfeiter . ImageCloner
 //go:generate counterfeiter . DependencyManager
 //go:generate counterfeiter . VolumeDriver
+//go:generate counterfeiter . UsageTracker
 
 type ImageCloner interface {
 	ImageIDs(logger lager.Logger) ([]string, error)
@@ -20,29 +28,70 @@ type ImageCloner interface {
 
 type DependencyManager interface {
 	Dependencies(id string) ([]string, error)
+	IDs() ([]string, error)
 }
 
 type VolumeDriver interface {
 	VolumePath(logger lager.Logger, id string) (string, error)
 	MoveVolume(logger lager.Logger, from, to string) error
 	DestroyVolume(logger lager.Logger, id string) error
+	EmptyTrash(logger lager.Logger) error
 	Volumes(logger lager.Logger) ([]string, error)
 }
 
+type UsageTracker interface {
+	Usage(logger lager.Logger) (map[string]groot.VolumeUsage, error)
+	Forget(chainID string) error
+}
+
+// RetentionPolicy keeps unused volumes that are likely to be used again. A
+// zero value keeps nothing.
+type RetentionPolicy struct {
+	// KeepFor keeps the volumes used more recently than this.
+	KeepFor time.Duration
+	// KeepBaseImages keeps the volumes of this many of the most recently used
+	// base images.
+	KeepBaseImages int
+}
+
 type GarbageCollector struct {
 	volumeDriver      VolumeDriver
 	imageCloner       ImageCloner
 	dependencyManager DependencyManager
+	locksmith         groot.Locksmith
+
+	incompleteVolumeGracePeriod time.Duration
+	usageTracker                UsageTracker
+	retentionPolicy             RetentionPolicy
 }
 
-func NewGC(volumeDriver VolumeDriver, imageCloner ImageCloner, dependencyManager DependencyManager) *GarbageCollector {
+func NewGC(volumeDriver VolumeDriver, imageCloner ImageCloner, dependencyManager DependencyManager, locksmith groot.Locksmith) *GarbageCollector {
 	return &GarbageCollector{
 		volumeDriver:      volumeDriver,
 		imageCloner:       imageCloner,
 		dependencyManager: dependencyManager,
+		locksmith:         locksmith,
+
+		incompleteVolumeGracePeriod: DefaultIncompleteVolumeGracePeriod,
 	}
 }
 
+// WithIncompleteVolumeGracePeriod sets how old incomplete volumes must be for
+// UnusedVolumes to return them.
+func (g *GarbageCollector) WithIncompleteVolumeGracePeriod(gracePeriod time.Duration) *GarbageCollector {
+	g.incompleteVolumeGracePeriod = gracePeriod
+	return g
+}
+
+// WithRetentionPolicy makes UnusedVolumes leave out the volumes the policy
+// keeps, and return the others least recently used first. Volumes without a
+// recorded usage count as the least recently used.
+func (g *GarbageCollector) WithRetentionPolicy(usageTracker UsageTracker, policy RetentionPolicy) *GarbageCollector {
+	g.usageTracker = usageTracker
+	g.retentionPolicy = policy
+	return g
+}
+
 func (g *GarbageCollector) MarkUnused(logger lager.Logger, unusedVolumes []string) error {
 	logger = logger.Session("garbage-collector-mark-unused", lager.Data{"unusedVolumes": unusedVolumes})
 	logger.Info("starting")
@@ -83,7 +132,32 @@ func (g *GarbageCollector) Collect(logger lager.Logger) error {
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	return g.collectVolumes(logger)
+	collectErr := g.collectVolumes(logger)
+
+	if err := g.emptyTrash(logger); err != nil {
+		logger.Error("emptying-trash-failed", err)
+		if collectErr == nil {
+			collectErr = errorspkg.Wrap(err, "emptying trash")
+		}
+	}
+
+	return collectErr
+}
+
+// emptyTrash holds the trash lock, so that clean and the background worker
+// don't destroy the same subvolumes.
+func (g *GarbageCollector) emptyTrash(logger lager.Logger) error {
+	lockFile, err := g.locksmith.Lock(groot.TrashLockKey)
+	if err != nil {
+		return errorspkg.Wrap(err, "locking the trash")
+	}
+	defer func() {
+		if err := g.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-trash-failed", err)
+		}
+	}()
+
+	return g.volumeDriver.EmptyTrash(logger)
 }
 
 func (g *GarbageCollector) collectVolumes(logger lager.Logger) error {
@@ -105,6 +179,13 @@ func (g *GarbageCollector) collectVolumes(logger lager.Logger) error {
 		if err := g.volumeDriver.DestroyVolume(logger, volID); err != nil {
 			logger.Error("failed-to-destroy-volume", err, lager.Data{"volumeID": volID})
 			cleanupErr = errorspkg.New("destroying volumes failed")
+			continue
+		}
+
+		if g.usageTracker != nil {
+			if err := g.usageTracker.Forget(strings.TrimPrefix(volID, "gc.")); err != nil {
+				logger.Error("failed-to-forget-volume-usage", err, lager.Data{"volumeID": volID})
+			}
 		}
 	}
 
@@ -143,9 +224,15 @@ func (g *GarbageCollector) UnusedVolumes(logger lager.Logger, chainIDsToPreserve
 
 	orphanedVolumes := make(map[string]struct{})
 	for _, vol := range volumes {
-		if !strings.HasPrefix(vol, "gc.") {
-			orphanedVolumes[vol] = struct{}{}
+		if strings.HasPrefix(vol, "gc.") {
+			continue
 		}
+
+		if base_image_puller.IsIncompleteVolume(vol) && !g.incompleteVolumeAbandoned(logger, vol) {
+			continue
+		}
+
+		orphanedVolumes[vol] = struct{}{}
 	}
 
 	imageIDs, err := g.imageCloner.ImageIDs(logger)
@@ -157,18 +244,143 @@ func (g *GarbageCollector) UnusedVolumes(logger lager.Logger, chainIDsToPreserve
 		imageRefName := fmt.Sprintf(groot.ImageReferenceFormat, imageID)
 		usedVolumes, err := g.dependencyManager.Dependencies(imageRefName)
 		if err != nil {
-			return nil, err
+			// the volumes of the image can't be told apart from unused ones
+			logger.Error("unresolvable-image-keeping-all-volumes", err, lager.Data{"dependentID": imageRefName})
+			return []string{}, nil
 		}
 		g.removeDependencyFromOrphanList(orphanedVolumes, usedVolumes)
 	}
 
+	dependentIDs, err := g.dependencyManager.IDs()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "failed to retrieve pins")
+	}
+
+	for _, dependentID := range dependentIDs {
+		if !strings.HasPrefix(dependentID, groot.PinReferencePrefix) {
+			continue
+		}
+
+		pinnedVolumes, err := g.dependencyManager.Dependencies(dependentID)
+		if err != nil {
+			logger.Error("unresolvable-pin-keeping-all-volumes", err, lager.Data{"dependentID": dependentID})
+			return []string{}, nil
+		}
+		g.removeDependencyFromOrphanList(orphanedVolumes, pinnedVolumes)
+	}
+
 	g.removeDependencyFromOrphanList(orphanedVolumes, chainIDsToPreserve)
 
 	orphanedVolumeIDs := []string{}
 	for id := range orphanedVolumes {
 		orphanedVolumeIDs = append(orphanedVolumeIDs, id)
 	}
-	return orphanedVolumeIDs, nil
+
+	if g.usageTracker == nil {
+		return orphanedVolumeIDs, nil
+	}
+
+	usage, err := g.usageTracker.Usage(logger)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "failed to retrieve volume usage")
+	}
+
+	return g.applyRetentionPolicy(logger, orphanedVolumeIDs, usage), nil
+}
+
+func (g *GarbageCollector) applyRetentionPolicy(logger lager.Logger, volumeIDs []string, usage map[string]groot.VolumeUsage) []string {
+	keptBaseImages := g.recentBaseImages(usage)
+
+	collectable := []string{}
+	for _, volumeID := range volumeIDs {
+		volumeUsage, ok := usage[volumeID]
+		if ok && g.retentionPolicy.KeepFor > 0 && time.Since(volumeUsage.LastUsed) < g.retentionPolicy.KeepFor {
+			logger.Debug("keeping-recently-used-volume", lager.Data{"volumeID": volumeID, "lastUsed": volumeUsage.LastUsed})
+			continue
+		}
+
+		if ok && keptBaseImages[volumeUsage.BaseImage] {
+			logger.Debug("keeping-recent-base-image-volume", lager.Data{"volumeID": volumeID, "baseImage": volumeUsage.BaseImage})
+			continue
+		}
+
+		collectable = append(collectable, volumeID)
+	}
+
+	sort.SliceStable(collectable, func(i, j int) bool {
+		return usage[collectable[i]].LastUsed.Before(usage[collectable[j]].LastUsed)
+	})
+
+	return collectable
+}
+
+// recentBaseImages returns the KeepBaseImages most recently used base images,
+// counting the ones images still use.
+func (g *GarbageCollector) recentBaseImages(usage map[string]groot.VolumeUsage) map[string]bool {
+	if g.retentionPolicy.KeepBaseImages <= 0 {
+		return map[string]bool{}
+	}
+
+	lastUsed := map[string]time.Time{}
+	for _, volumeUsage := range usage {
+		if volumeUsage.BaseImage == "" {
+			continue
+		}
+
+		if volumeUsage.LastUsed.After(lastUsed[volumeUsage.BaseImage]) {
+			lastUsed[volumeUsage.BaseImage] = volumeUsage.LastUsed
+		}
+	}
+
+	baseImages := []string{}
+	for baseImage := range lastUsed {
+		baseImages = append(baseImages, baseImage)
+	}
+	sort.Slice(baseImages, func(i, j int) bool {
+		return lastUsed[baseImages[i]].After(lastUsed[baseImages[j]])
+	})
+
+	kept := map[string]bool{}
+	for i := 0; i < len(baseImages) && i < g.retentionPolicy.KeepBaseImages; i++ {
+		kept[baseImages[i]] = true
+	}
+
+	return kept
+}
+
+// incompleteVolumeAbandoned reports whether the incomplete volume is older
+// than the grace period and nobody holds the lock of its chain ID, which the
+// pull that created it holds while unpacking it. Volumes whose age or chain ID
+// can't be told are kept.
+func (g *GarbageCollector) incompleteVolumeAbandoned(logger lager.Logger, volumeID string) bool {
+	startedAt, ok := base_image_puller.IncompleteVolumeStartTime(volumeID)
+	if !ok {
+		logger.Info("unknown-incomplete-volume-age", lager.Data{"volumeID": volumeID})
+		return false
+	}
+	if time.Since(startedAt) <= g.incompleteVolumeGracePeriod {
+		return false
+	}
+
+	chainID, ok := base_image_puller.IncompleteVolumeChainID(volumeID)
+	if !ok {
+		return false
+	}
+
+	lockFile, err := g.locksmith.TryLock(chainID)
+	if err != nil {
+		logger.Error("locking-incomplete-volume-failed", err, lager.Data{"volumeID": volumeID})
+		return false
+	}
+	if lockFile == nil {
+		logger.Info("incomplete-volume-is-being-pulled", lager.Data{"volumeID": volumeID})
+		return false
+	}
+
+	if err := g.locksmith.Unlock(lockFile); err != nil {
+		logger.Error("unlocking-incomplete-volume-failed", err, lager.Data{"volumeID": volumeID})
+	}
+	return true
 }
 
 func (g *GarbageCollector) removeDependencyFromOrphanList(volumesList map[string]struct{}, usedVolumes []string) {
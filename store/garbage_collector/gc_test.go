@@ -2,10 +2,15 @@ package garbage_collector_test
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
 	"github.com/SUSE/groot-btrfs/store/garbage_collector"
 	"github.com/SUSE/groot-btrfs/store/garbage_collector/garbage_collectorfakes"
 
@@ -21,18 +26,21 @@ var _ = Describe("Gc", func() {
 		fakeVolumeDriver      *garbage_collectorfakes.FakeVolumeDriver
 		fakeDependencyManager *garbage_collectorfakes.FakeDependencyManager
 		fakeImageCloner       *garbage_collectorfakes.FakeImageCloner
+		fakeLocksmith         *grootfakes.FakeLocksmith
 	)
 
 	BeforeEach(func() {
 		fakeImageCloner = new(garbage_collectorfakes.FakeImageCloner)
 		fakeVolumeDriver = new(garbage_collectorfakes.FakeVolumeDriver)
 		fakeDependencyManager = new(garbage_collectorfakes.FakeDependencyManager)
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+		fakeLocksmith.TryLockReturns(new(os.File), nil)
 
 		logger = lagertest.NewTestLogger("garbage_collector")
 	})
 
 	JustBeforeEach(func() {
-		garbageCollector = garbage_collector.NewGC(fakeVolumeDriver, fakeImageCloner, fakeDependencyManager)
+		garbageCollector = garbage_collector.NewGC(fakeVolumeDriver, fakeImageCloner, fakeDependencyManager, fakeLocksmith)
 	})
 
 	Describe("UnusedVolumes", func() {
@@ -83,6 +91,186 @@ var _ = Describe("Gc", func() {
 			})
 		})
 
+		Context("when base images are pinned", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.IDsReturns([]string{
+					"image:idA",
+					"pin:docker:///ubuntu",
+				}, nil)
+				fakeDependencyManager.DependenciesStub = func(id string) ([]string, error) {
+					return map[string][]string{
+						"image:idA":            []string{"volDocker1", "volDocker2"},
+						"image:idB":            []string{"volDocker1", "volDocker3"},
+						"image:idLocal":        []string{"usedLocalVolume-timestamp"},
+						"pin:docker:///ubuntu": []string{"sha256ubuntu", "unusedLayerVolume"},
+					}[id], nil
+				}
+			})
+
+			It("doesn't list their volumes as unused", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(unusedVolumes).To(ConsistOf("sha256privateubuntu", "unusedLocalVolume-timestamp"))
+			})
+
+			Context("when listing the pins fails", func() {
+				BeforeEach(func() {
+					fakeDependencyManager.IDsReturns(nil, errors.New("failed to list dependencies"))
+				})
+
+				It("returns an error", func() {
+					_, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).To(MatchError(ContainSubstring("failed to list dependencies")))
+				})
+			})
+		})
+
+		Context("when there are incomplete volumes", func() {
+			var staleVolume, freshVolume string
+
+			BeforeEach(func() {
+				staleVolume = fmt.Sprintf("sha256stale-incomplete-%d-42", time.Now().Add(-2*time.Hour).UnixNano())
+				freshVolume = fmt.Sprintf("sha256fresh-incomplete-%d-42", time.Now().UnixNano())
+
+				fakeVolumeDriver.VolumesReturns([]string{
+					"volDocker1",
+					staleVolume,
+					freshVolume,
+					"sha256odd-incomplete-notatimestamp",
+				}, nil)
+			})
+
+			It("lists the ones older than the grace period as unused", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(unusedVolumes).To(ConsistOf(staleVolume))
+			})
+
+			It("tries the lock of their chain id", func() {
+				_, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeLocksmith.TryLockCallCount()).To(Equal(1))
+				Expect(fakeLocksmith.TryLockArgsForCall(0)).To(Equal("sha256stale"))
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+			})
+
+			Context("when the pull that created them still holds the lock", func() {
+				BeforeEach(func() {
+					fakeLocksmith.TryLockReturns(nil, nil)
+				})
+
+				It("keeps them", func() {
+					unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(unusedVolumes).To(BeEmpty())
+				})
+			})
+
+			Context("when trying the lock fails", func() {
+				BeforeEach(func() {
+					fakeLocksmith.TryLockReturns(nil, errors.New("no locks"))
+				})
+
+				It("keeps them", func() {
+					unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(unusedVolumes).To(BeEmpty())
+				})
+			})
+
+			Context("when the grace period is changed", func() {
+				JustBeforeEach(func() {
+					garbageCollector.WithIncompleteVolumeGracePeriod(3 * time.Hour)
+				})
+
+				It("honours it", func() {
+					unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(unusedVolumes).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when a retention policy is set", func() {
+			var (
+				fakeUsageTracker *garbage_collectorfakes.FakeUsageTracker
+				policy           garbage_collector.RetentionPolicy
+			)
+
+			BeforeEach(func() {
+				now := time.Now()
+				fakeUsageTracker = new(garbage_collectorfakes.FakeUsageTracker)
+				fakeUsageTracker.UsageReturns(map[string]groot.VolumeUsage{
+					"volDocker1":          {LastUsed: now.Add(-10 * time.Minute), BaseImage: "docker:///in-use"},
+					"sha256ubuntu":        {LastUsed: now.Add(-1 * time.Hour), BaseImage: "docker:///ubuntu"},
+					"sha256privateubuntu": {LastUsed: now.Add(-48 * time.Hour), BaseImage: "docker://private/ubuntu"},
+					"unusedLayerVolume":   {LastUsed: now.Add(-72 * time.Hour), BaseImage: "docker:///old"},
+				}, nil)
+
+				policy = garbage_collector.RetentionPolicy{}
+			})
+
+			JustBeforeEach(func() {
+				garbageCollector.WithRetentionPolicy(fakeUsageTracker, policy)
+			})
+
+			It("returns the least recently used volumes first", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(unusedVolumes).To(Equal([]string{
+					"unusedLocalVolume-timestamp", "unusedLayerVolume", "sha256privateubuntu", "sha256ubuntu",
+				}))
+			})
+
+			Context("when volumes are kept for a while", func() {
+				BeforeEach(func() {
+					policy.KeepFor = 24 * time.Hour
+				})
+
+				It("doesn't list the ones used since as unused", func() {
+					unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(unusedVolumes).To(Equal([]string{
+						"unusedLocalVolume-timestamp", "unusedLayerVolume", "sha256privateubuntu",
+					}))
+				})
+			})
+
+			Context("when the most recent base images are kept", func() {
+				BeforeEach(func() {
+					policy.KeepBaseImages = 2
+				})
+
+				It("doesn't list their volumes as unused, counting the base images in use", func() {
+					unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(unusedVolumes).To(Equal([]string{
+						"unusedLocalVolume-timestamp", "unusedLayerVolume", "sha256privateubuntu",
+					}))
+				})
+			})
+
+			Context("when reading the usage fails", func() {
+				BeforeEach(func() {
+					fakeUsageTracker.UsageReturns(nil, errors.New("failed to read usage"))
+				})
+
+				It("returns an error", func() {
+					_, err := garbageCollector.UnusedVolumes(logger, nil)
+					Expect(err).To(MatchError(ContainSubstring("failed to read usage")))
+				})
+			})
+		})
+
 		Context("when retrieving images fails", func() {
 			BeforeEach(func() {
 				fakeImageCloner.ImageIDsReturns(nil, errors.New("failed to retrieve images"))
@@ -94,14 +282,38 @@ var _ = Describe("Gc", func() {
 			})
 		})
 
-		Context("when getting the dependencies of a image fails", func() {
+		Context("when the dependencies of an image cannot be resolved", func() {
 			BeforeEach(func() {
-				fakeDependencyManager.DependenciesReturns(nil, errors.New("failed to access deps"))
+				fakeDependencyManager.DependenciesStub = func(id string) ([]string, error) {
+					if id == "image:idB" {
+						return nil, errors.New("image `image:idB` not found")
+					}
+					return []string{"volDocker1"}, nil
+				}
 			})
 
-			It("returns an error", func() {
-				_, err := garbageCollector.UnusedVolumes(logger, nil)
-				Expect(err).To(MatchError(ContainSubstring("failed to access deps")))
+			It("does not consider any volume unused", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(unusedVolumes).To(BeEmpty())
+			})
+		})
+
+		Context("when the dependencies of a pin cannot be resolved", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.IDsReturns([]string{"pin:docker:///ubuntu"}, nil)
+				fakeDependencyManager.DependenciesStub = func(id string) ([]string, error) {
+					if id == "pin:docker:///ubuntu" {
+						return nil, errors.New("decoding dependencies")
+					}
+					return []string{"volDocker1"}, nil
+				}
+			})
+
+			It("does not consider any volume unused", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(unusedVolumes).To(BeEmpty())
 			})
 		})
 
@@ -248,6 +460,80 @@ var _ = Describe("Gc", func() {
 				Expect(garbageCollector.Collect(logger)).To(MatchError(ContainSubstring("destroying volumes failed")))
 				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(3))
 			})
+
+			It("still empties the trash", func() {
+				Expect(garbageCollector.Collect(logger)).NotTo(Succeed())
+				Expect(fakeVolumeDriver.EmptyTrashCallCount()).To(Equal(1))
+			})
+		})
+
+		It("empties the trash", func() {
+			Expect(garbageCollector.Collect(logger)).To(Succeed())
+			Expect(fakeVolumeDriver.EmptyTrashCallCount()).To(Equal(1))
+		})
+
+		It("holds the trash lock while emptying the trash", func() {
+			trashLockFile := new(os.File)
+			fakeLocksmith.LockReturns(trashLockFile, nil)
+			unlocksBeforeEmptying := -1
+			fakeVolumeDriver.EmptyTrashStub = func(_ lager.Logger) error {
+				unlocksBeforeEmptying = fakeLocksmith.UnlockCallCount()
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+				Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.TrashLockKey))
+				return nil
+			}
+
+			Expect(garbageCollector.Collect(logger)).To(Succeed())
+			Expect(fakeVolumeDriver.EmptyTrashCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(unlocksBeforeEmptying + 1))
+			Expect(fakeLocksmith.UnlockArgsForCall(unlocksBeforeEmptying)).To(Equal(trashLockFile))
+		})
+
+		Context("when locking the trash fails", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockReturns(nil, errors.New("trash is locked"))
+			})
+
+			It("returns an error without emptying the trash", func() {
+				Expect(garbageCollector.Collect(logger)).To(MatchError(ContainSubstring("trash is locked")))
+				Expect(fakeVolumeDriver.EmptyTrashCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the usage is tracked", func() {
+			var fakeUsageTracker *garbage_collectorfakes.FakeUsageTracker
+
+			BeforeEach(func() {
+				fakeUsageTracker = new(garbage_collectorfakes.FakeUsageTracker)
+				fakeVolumeDriver.DestroyVolumeStub = func(_ lager.Logger, volID string) error {
+					if volID == "gc.vol-f" {
+						return errors.New("failed to destroy volume")
+					}
+
+					return nil
+				}
+			})
+
+			JustBeforeEach(func() {
+				garbageCollector.WithRetentionPolicy(fakeUsageTracker, garbage_collector.RetentionPolicy{})
+			})
+
+			It("forgets the usage of the destroyed volumes", func() {
+				Expect(garbageCollector.Collect(logger)).NotTo(Succeed())
+
+				Expect(fakeUsageTracker.ForgetCallCount()).To(Equal(2))
+				Expect([]string{fakeUsageTracker.ForgetArgsForCall(0), fakeUsageTracker.ForgetArgsForCall(1)}).To(ConsistOf("vol-b", "vol-c"))
+			})
+		})
+
+		Context("when emptying the trash fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.EmptyTrashReturns(errors.New("failed to empty trash"))
+			})
+
+			It("returns an error", func() {
+				Expect(garbageCollector.Collect(logger)).To(MatchError(ContainSubstring("failed to empty trash")))
+			})
 		})
 	})
 })
@@ -22,6 +22,7 @@ type ImageDriverSpec struct {
 	ImagePath          string
 	DiskLimit          int64
 	ExclusiveDiskLimit bool
+	Compression        string
 }
 
 //go:generate counterfeiter . ImageDriver
@@ -97,6 +98,7 @@ func (b *ImageCloner) Create(logger lager.Logger, spec groot.ImageSpec) (groot.I
 		ImagePath:          imagePath,
 		DiskLimit:          spec.DiskLimit,
 		ExclusiveDiskLimit: spec.ExcludeBaseImageFromQuota,
+		Compression:        spec.Compression,
 	}
 
 	var mountInfo groot.MountInfo
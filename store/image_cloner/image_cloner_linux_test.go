@@ -319,6 +319,20 @@ var _ = Describe("Image", func() {
 				})
 			})
 		})
+
+		Context("when a compression is set", func() {
+			It("passes it to the image driver", func() {
+				_, err := imageCloner.Create(logger, groot.ImageSpec{
+					ID:          "some-id",
+					Compression: "zstd",
+					BaseImage:   imageConfig,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, spec := fakeImageDriver.CreateImageArgsForCall(0)
+				Expect(spec.Compression).To(Equal("zstd"))
+			})
+		})
 	})
 
 	Describe("Destroy", func() {
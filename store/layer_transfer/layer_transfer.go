@@ -0,0 +1,168 @@
+package layer_transfer // import "github.com/SUSE/groot-btrfs/store/layer_transfer"
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	errorspkg "github.com/pkg/errors"
+)
+
+//go:generate counterfeiter . VolumeDriver
+
+type VolumeDriver interface {
+	VolumePath(logger lager.Logger, id string) (string, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+	WriteVolumeMeta(logger lager.Logger, id string, metadata base_image_puller.VolumeMeta) error
+	SendVolume(logger lager.Logger, id, parentID string, stream io.Writer) error
+	ReceiveVolume(logger lager.Logger, id string, stream io.Reader) error
+	VolumeUUIDs(logger lager.Logger, id string) (string, string, error)
+}
+
+// Header is the first line of a layer stream, followed by the btrfs send
+// stream of the layer volume.
+type Header struct {
+	ChainID       string `json:"chain_id"`
+	ParentChainID string `json:"parent_chain_id,omitempty"`
+	// ParentUUID is the UUID the send stream refers to the parent by, which
+	// the parent on the receiving end must have been received from
+	ParentUUID string                       `json:"parent_uuid,omitempty"`
+	VolumeMeta base_image_puller.VolumeMeta `json:"volume_meta"`
+}
+
+type Exporter struct {
+	volumeDriver VolumeDriver
+}
+
+func NewExporter(volumeDriver VolumeDriver) *Exporter {
+	return &Exporter{
+		volumeDriver: volumeDriver,
+	}
+}
+
+// Export writes the layer volume of chainID to stream. When parentChainID is
+// given only the difference to that layer is sent, and the parent has to be
+// imported on the other end first.
+func (e *Exporter) Export(logger lager.Logger, chainID, parentChainID string, stream io.Writer) error {
+	logger = logger.Session("exporting-layer", lager.Data{"chainID": chainID, "parentChainID": parentChainID})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	if _, err := e.volumeDriver.VolumePath(logger, chainID); err != nil {
+		return errorspkg.Wrapf(err, "layer `%s` does not exist", chainID)
+	}
+
+	var parentUUID string
+	if parentChainID != "" {
+		if _, err := e.volumeDriver.VolumePath(logger, parentChainID); err != nil {
+			return errorspkg.Wrapf(err, "parent layer `%s` does not exist", parentChainID)
+		}
+
+		uuid, receivedUUID, err := e.volumeDriver.VolumeUUIDs(logger, parentChainID)
+		if err != nil {
+			return errorspkg.Wrapf(err, "reading uuids of parent layer `%s`", parentChainID)
+		}
+		// like btrfs send, refer to a received parent by where it came from
+		parentUUID = uuid
+		if receivedUUID != "" {
+			parentUUID = receivedUUID
+		}
+	}
+
+	volumeMeta, err := e.volumeDriver.VolumeMeta(logger, chainID)
+	if err != nil {
+		return errorspkg.Wrapf(err, "reading metadata of layer `%s`", chainID)
+	}
+
+	header := Header{
+		ChainID:       chainID,
+		ParentChainID: parentChainID,
+		ParentUUID:    parentUUID,
+		VolumeMeta:    volumeMeta,
+	}
+	if err := json.NewEncoder(stream).Encode(header); err != nil {
+		return errorspkg.Wrap(err, "writing stream header")
+	}
+
+	return e.volumeDriver.SendVolume(logger, chainID, parentChainID, stream)
+}
+
+type Importer struct {
+	volumeDriver VolumeDriver
+}
+
+func NewImporter(volumeDriver VolumeDriver) *Importer {
+	return &Importer{
+		volumeDriver: volumeDriver,
+	}
+}
+
+// Import creates the layer volume sent in stream, and returns its chain ID.
+func (i *Importer) Import(logger lager.Logger, stream io.Reader) (string, error) {
+	logger = logger.Session("importing-layer")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	reader := bufio.NewReader(stream)
+	headerLine, err := reader.ReadBytes('\n')
+	if err != nil {
+		return "", errorspkg.Wrap(err, "reading stream header")
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return "", errorspkg.Wrap(err, "parsing stream header")
+	}
+	if header.ChainID == "" {
+		return "", errorspkg.New("stream header has no chain id")
+	}
+	logger = logger.WithData(lager.Data{"chainID": header.ChainID, "parentChainID": header.ParentChainID})
+
+	if _, err := i.volumeDriver.VolumePath(logger, header.ChainID); err == nil {
+		return "", errorspkg.Errorf("layer `%s` already exists", header.ChainID)
+	}
+
+	if header.ParentChainID != "" {
+		if err := i.checkParent(logger, header); err != nil {
+			return "", err
+		}
+	}
+
+	if err := i.volumeDriver.ReceiveVolume(logger, header.ChainID, reader); err != nil {
+		return "", err
+	}
+
+	if err := i.volumeDriver.WriteVolumeMeta(logger, header.ChainID, header.VolumeMeta); err != nil {
+		return "", errorspkg.Wrapf(err, "writing metadata of layer `%s`", header.ChainID)
+	}
+
+	return header.ChainID, nil
+}
+
+// checkParent makes sure btrfs receive will find the parent of an incremental
+// stream. A layer pulled here rather than imported has the same content but
+// not the identity of the exporter's, so the whole layer has to be exported
+// instead.
+func (i *Importer) checkParent(logger lager.Logger, header Header) error {
+	if _, err := i.volumeDriver.VolumePath(logger, header.ParentChainID); err != nil {
+		return errorspkg.Wrapf(err, "parent layer `%s` does not exist", header.ParentChainID)
+	}
+
+	uuid, receivedUUID, err := i.volumeDriver.VolumeUUIDs(logger, header.ParentChainID)
+	if err != nil {
+		return errorspkg.Wrapf(err, "reading uuids of parent layer `%s`", header.ParentChainID)
+	}
+
+	matches := header.ParentUUID == receivedUUID || header.ParentUUID == uuid
+	if header.ParentUUID == "" {
+		// streams that don't name the parent can only be checked for one
+		matches = receivedUUID != ""
+	}
+	if !matches {
+		return errorspkg.Errorf("parent layer `%s` was not imported from the exporting store, export the layer without a parent", header.ParentChainID)
+	}
+
+	return nil
+}
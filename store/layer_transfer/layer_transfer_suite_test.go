@@ -0,0 +1,13 @@
+package layer_transfer_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLayerTransfer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LayerTransfer Suite")
+}
@@ -0,0 +1,261 @@
+package layer_transfer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/store/layer_transfer"
+	"github.com/SUSE/groot-btrfs/store/layer_transfer/layer_transferfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LayerTransfer", func() {
+	var (
+		fakeVolumeDriver *layer_transferfakes.FakeVolumeDriver
+		logger           lager.Logger
+	)
+
+	BeforeEach(func() {
+		fakeVolumeDriver = new(layer_transferfakes.FakeVolumeDriver)
+		logger = lagertest.NewTestLogger("layer-transfer")
+	})
+
+	Describe("Exporter", func() {
+		var (
+			exporter *layer_transfer.Exporter
+			stream   *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			exporter = layer_transfer.NewExporter(fakeVolumeDriver)
+			stream = bytes.NewBuffer([]byte{})
+
+			fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 1024, DiffID: "sha256:diff"}, nil)
+			fakeVolumeDriver.VolumeUUIDsReturns("parent-uuid", "", nil)
+			fakeVolumeDriver.SendVolumeStub = func(_ lager.Logger, _, _ string, w io.Writer) error {
+				_, err := w.Write([]byte("btrfs-stream"))
+				return err
+			}
+		})
+
+		It("writes the header followed by the send stream", func() {
+			Expect(exporter.Export(logger, "chain-id", "parent-chain-id", stream)).To(Succeed())
+			Expect(stream.String()).To(Equal(
+				`{"chain_id":"chain-id","parent_chain_id":"parent-chain-id","parent_uuid":"parent-uuid","volume_meta":{"Size":1024,"DiffID":"sha256:diff"}}` + "\nbtrfs-stream",
+			))
+		})
+
+		Context("when the parent was itself received", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeUUIDsReturns("parent-uuid", "original-parent-uuid", nil)
+			})
+
+			It("names the parent by the uuid it was received from", func() {
+				Expect(exporter.Export(logger, "chain-id", "parent-chain-id", stream)).To(Succeed())
+				Expect(stream.String()).To(ContainSubstring(`"parent_uuid":"original-parent-uuid"`))
+			})
+		})
+
+		It("sends the volume against the parent", func() {
+			Expect(exporter.Export(logger, "chain-id", "parent-chain-id", stream)).To(Succeed())
+
+			Expect(fakeVolumeDriver.SendVolumeCallCount()).To(Equal(1))
+			_, id, parentID, _ := fakeVolumeDriver.SendVolumeArgsForCall(0)
+			Expect(id).To(Equal("chain-id"))
+			Expect(parentID).To(Equal("parent-chain-id"))
+		})
+
+		Context("when the volume does not exist", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumePathReturns("", errors.New("volume does not exist"))
+			})
+
+			It("returns an error", func() {
+				err := exporter.Export(logger, "chain-id", "", stream)
+				Expect(err).To(MatchError(ContainSubstring("layer `chain-id` does not exist")))
+				Expect(fakeVolumeDriver.SendVolumeCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the parent does not exist", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumePathStub = func(_ lager.Logger, id string) (string, error) {
+					if id == "parent-chain-id" {
+						return "", errors.New("volume does not exist")
+					}
+					return "/volumes/" + id, nil
+				}
+			})
+
+			It("returns an error", func() {
+				err := exporter.Export(logger, "chain-id", "parent-chain-id", stream)
+				Expect(err).To(MatchError(ContainSubstring("parent layer `parent-chain-id` does not exist")))
+			})
+		})
+
+		Context("when sending the volume fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.SendVolumeReturns(errors.New("send failed"))
+			})
+
+			It("returns an error", func() {
+				Expect(exporter.Export(logger, "chain-id", "", stream)).To(MatchError("send failed"))
+			})
+		})
+	})
+
+	Describe("Importer", func() {
+		var (
+			importer     *layer_transfer.Importer
+			stream       io.Reader
+			receivedData string
+		)
+
+		BeforeEach(func() {
+			importer = layer_transfer.NewImporter(fakeVolumeDriver)
+			stream = strings.NewReader(
+				`{"chain_id":"chain-id","parent_chain_id":"parent-chain-id","parent_uuid":"parent-uuid","volume_meta":{"Size":1024,"DiffID":"sha256:diff"}}` + "\nbtrfs-stream",
+			)
+			fakeVolumeDriver.VolumeUUIDsReturns("local-uuid", "parent-uuid", nil)
+
+			fakeVolumeDriver.VolumePathStub = func(_ lager.Logger, id string) (string, error) {
+				if id == "parent-chain-id" {
+					return "/volumes/parent-chain-id", nil
+				}
+				return "", errors.New("volume does not exist")
+			}
+			fakeVolumeDriver.ReceiveVolumeStub = func(_ lager.Logger, _ string, r io.Reader) error {
+				data, err := ioutil.ReadAll(r)
+				receivedData = string(data)
+				return err
+			}
+		})
+
+		It("receives the send stream into the chain volume", func() {
+			chainID, err := importer.Import(logger, stream)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chainID).To(Equal("chain-id"))
+
+			Expect(fakeVolumeDriver.ReceiveVolumeCallCount()).To(Equal(1))
+			_, id, _ := fakeVolumeDriver.ReceiveVolumeArgsForCall(0)
+			Expect(id).To(Equal("chain-id"))
+			Expect(receivedData).To(Equal("btrfs-stream"))
+		})
+
+		It("writes the volume metadata", func() {
+			_, err := importer.Import(logger, stream)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(Equal(1))
+			_, id, meta := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
+			Expect(id).To(Equal("chain-id"))
+			Expect(meta).To(Equal(base_image_puller.VolumeMeta{Size: 1024, DiffID: "sha256:diff"}))
+		})
+
+		Context("when the parent does not exist", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumePathReturns("", errors.New("volume does not exist"))
+				fakeVolumeDriver.VolumePathStub = nil
+			})
+
+			It("returns an error without receiving", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError(ContainSubstring("parent layer `parent-chain-id` does not exist")))
+				Expect(fakeVolumeDriver.ReceiveVolumeCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the parent was not received from the exporting store", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeUUIDsReturns("local-uuid", "", nil)
+			})
+
+			It("returns an error without receiving", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError(ContainSubstring("export the layer without a parent")))
+				Expect(fakeVolumeDriver.ReceiveVolumeCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the stream does not name the parent uuid", func() {
+			BeforeEach(func() {
+				stream = strings.NewReader(
+					`{"chain_id":"chain-id","parent_chain_id":"parent-chain-id","volume_meta":{"Size":1024,"DiffID":"sha256:diff"}}` + "\nbtrfs-stream",
+				)
+			})
+
+			It("accepts a parent that was received", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("and the parent was not received", func() {
+				BeforeEach(func() {
+					fakeVolumeDriver.VolumeUUIDsReturns("local-uuid", "", nil)
+				})
+
+				It("returns an error without receiving", func() {
+					_, err := importer.Import(logger, stream)
+					Expect(err).To(MatchError(ContainSubstring("export the layer without a parent")))
+					Expect(fakeVolumeDriver.ReceiveVolumeCallCount()).To(BeZero())
+				})
+			})
+		})
+
+		Context("when the layer already exists", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumePathReturns("/volumes/chain-id", nil)
+				fakeVolumeDriver.VolumePathStub = nil
+			})
+
+			It("returns an error without receiving", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError("layer `chain-id` already exists"))
+				Expect(fakeVolumeDriver.ReceiveVolumeCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the header is invalid", func() {
+			BeforeEach(func() {
+				stream = strings.NewReader("not-json\nbtrfs-stream")
+			})
+
+			It("returns an error", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError(ContainSubstring("parsing stream header")))
+			})
+		})
+
+		Context("when the header has no chain id", func() {
+			BeforeEach(func() {
+				stream = strings.NewReader("{}\nbtrfs-stream")
+			})
+
+			It("returns an error", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError("stream header has no chain id"))
+			})
+		})
+
+		Context("when receiving fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.ReceiveVolumeStub = nil
+				fakeVolumeDriver.ReceiveVolumeReturns(errors.New("receive failed"))
+			})
+
+			It("returns an error without writing the metadata", func() {
+				_, err := importer.Import(logger, stream)
+				Expect(err).To(MatchError("receive failed"))
+				Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(BeZero())
+			})
+		})
+	})
+})
@@ -1,7 +1,10 @@
 package locksmith // import "github.com/SUSE/groot-btrfs/store/locksmith"
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,11 +20,59 @@ import (
 const ExclusiveMetricsLockingTime = "ExclusiveLockingTime"
 const SharedMetricsLockingTime = "SharedLockingTime"
 
+const ownerFileSuffix = ".owner"
+
+// PollInterval is how often a lock with a timeout retries to take the lock.
+var PollInterval = 100 * time.Millisecond
+
+// OwnerCommand is recorded as the command of the process holding a lock. It
+// only names the command, as its arguments can carry registry credentials.
+var OwnerCommand = filepath.Base(os.Args[0])
+
 type FileSystem struct {
 	storePath      string
 	metricsEmitter groot.MetricsEmitter
 	lockType       int
 	metricName     string
+	timeout        time.Duration
+}
+
+// Owner describes a process holding a lock, as it recorded itself next to
+// the lock file.
+type Owner struct {
+	Key       string    `json:"key"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Exclusive bool      `json:"exclusive"`
+	LockedAt  time.Time `json:"locked_at"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("pid %d (%s) since %s", o.PID, o.Command, o.LockedAt.Format(time.RFC3339))
+}
+
+type LockTimeoutError struct {
+	Key     string
+	Timeout time.Duration
+	Holders []Owner
+}
+
+func (e *LockTimeoutError) Error() string {
+	holders := "an unknown process"
+	if len(e.Holders) > 0 {
+		descriptions := []string{}
+		for _, holder := range e.Holders {
+			descriptions = append(descriptions, holder.String())
+		}
+		holders = strings.Join(descriptions, ", ")
+	}
+
+	return fmt.Sprintf("timed out after %s waiting for lock `%s` held by %s", e.Timeout, e.Key, holders)
+}
+
+func IsLockTimeout(err error) bool {
+	_, ok := errorspkg.Cause(err).(*LockTimeoutError)
+	return ok
 }
 
 func NewExclusiveFileSystem(storePath string, metricsEmitter groot.MetricsEmitter) *FileSystem {
@@ -42,9 +93,28 @@ func NewSharedFileSystem(storePath string, metricsEmitter groot.MetricsEmitter)
 	}
 }
 
+// WithTimeout makes Lock give up after waiting for timeout. A zero timeout
+// waits for as long as it takes.
+func (l *FileSystem) WithTimeout(timeout time.Duration) *FileSystem {
+	l.timeout = timeout
+	return l
+}
+
 var FlockSyscall = syscall.Flock
 
 func (l *FileSystem) Lock(key string) (*os.File, error) {
+	if l.timeout == 0 {
+		return l.LockContext(context.Background(), key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	return l.LockContext(ctx, key)
+}
+
+// LockContext takes the lock, polling for it while ctx can be cancelled. When
+// ctx is done first the error names the processes holding the lock.
+func (l *FileSystem) LockContext(ctx context.Context, key string) (*os.File, error) {
 	defer l.metricsEmitter.TryEmitDurationFrom(lager.NewLogger("nil"), l.metricName, time.Now())
 
 	key = strings.Replace(key, "/", "", -1)
@@ -53,20 +123,156 @@ func (l *FileSystem) Lock(key string) (*os.File, error) {
 		return nil, errorspkg.Wrapf(err, "creating lock file for key `%s`", key)
 	}
 
-	fd := int(lockFile.Fd())
-	if err := FlockSyscall(fd, l.lockType); err != nil {
+	if err := l.flock(ctx, key, lockFile); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	l.recordOwner(key)
+	return lockFile, nil
+}
+
+// TryLock takes the lock only when nobody else holds it, and returns a nil
+// file otherwise.
+func (l *FileSystem) TryLock(key string) (*os.File, error) {
+	key = strings.Replace(key, "/", "", -1)
+	lockFile, err := os.OpenFile(l.path(key), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "creating lock file for key `%s`", key)
+	}
+
+	err = FlockSyscall(int(lockFile.Fd()), l.lockType|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		lockFile.Close()
+		return nil, nil
+	}
+	if err != nil {
+		lockFile.Close()
 		return nil, err
 	}
 
+	l.recordOwner(key)
 	return lockFile, nil
 }
 
 func (l *FileSystem) Unlock(lockFile *os.File) error {
 	defer lockFile.Close()
+	// the owner file goes first, once the lock is released it may belong to
+	// someone else
+	_ = os.Remove(ownerPath(strings.TrimSuffix(lockFile.Name(), ".lock"), os.Getpid()))
+
 	fd := int(lockFile.Fd())
 	return FlockSyscall(fd, syscall.LOCK_UN)
 }
 
+// Holders lists the live processes that recorded holding a lock in the store.
+func Holders(storePath string) ([]Owner, error) {
+	ownerPaths, err := filepath.Glob(filepath.Join(storePath, store.LocksDirName, "*"+ownerFileSuffix))
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing lock owners")
+	}
+
+	owners := []Owner{}
+	for _, ownerPath := range ownerPaths {
+		contents, err := ioutil.ReadFile(ownerPath)
+		if err != nil {
+			// released while listing
+			continue
+		}
+
+		var owner Owner
+		if err := json.Unmarshal(contents, &owner); err != nil || !processExists(owner.PID) {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+
+	return owners, nil
+}
+
+func (l *FileSystem) flock(ctx context.Context, key string, lockFile *os.File) error {
+	fd := int(lockFile.Fd())
+	if ctx.Done() == nil {
+		return FlockSyscall(fd, l.lockType)
+	}
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := FlockSyscall(fd, l.lockType|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return l.timeoutError(key)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *FileSystem) timeoutError(key string) error {
+	owners, err := Holders(l.storePath)
+	if err != nil {
+		owners = []Owner{}
+	}
+
+	holders := []Owner{}
+	for _, owner := range owners {
+		if owner.Key == key {
+			holders = append(holders, owner)
+		}
+	}
+
+	return &LockTimeoutError{Key: key, Timeout: l.timeout, Holders: holders}
+}
+
+// recordOwner is best effort, it only feeds diagnostics and must neither fail
+// nor slow down the lock, so the owner file is not synced.
+func (l *FileSystem) recordOwner(key string) {
+	if l.lockType == syscall.LOCK_EX {
+		// nobody else holds the lock, so the remaining owner files are left
+		// behind by processes that died holding it
+		staleOwners, _ := filepath.Glob(ownerPath(l.keyPath(key), -1))
+		for _, staleOwner := range staleOwners {
+			_ = os.Remove(staleOwner)
+		}
+	}
+
+	owner := Owner{
+		Key:       key,
+		PID:       os.Getpid(),
+		Command:   OwnerCommand,
+		Exclusive: l.lockType == syscall.LOCK_EX,
+		LockedAt:  time.Now(),
+	}
+	contents, err := json.Marshal(owner)
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(ownerPath(l.keyPath(key), owner.PID), contents, 0600)
+}
+
 func (l *FileSystem) path(key string) string {
-	return filepath.Join(l.storePath, store.LocksDirName, fmt.Sprintf("%s.lock", key))
+	return l.keyPath(key) + ".lock"
+}
+
+func (l *FileSystem) keyPath(key string) string {
+	return filepath.Join(l.storePath, store.LocksDirName, key)
+}
+
+// ownerPath is the owner file of pid for the lock at keyPath, or a pattern
+// matching the owner files of every process when pid is negative.
+func ownerPath(keyPath string, pid int) string {
+	if pid < 0 {
+		return fmt.Sprintf("%s.*%s", keyPath, ownerFileSuffix)
+	}
+	return fmt.Sprintf("%s.%d%s", keyPath, pid, ownerFileSuffix)
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
 }
@@ -1,6 +1,7 @@
 package locksmith_test
 
 import (
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -93,6 +94,97 @@ var _ = Describe("Filesystem", func() {
 				Expect(fromTime.Unix()).To(BeNumerically("~", startTime.Unix(), 1))
 			})
 
+			It("records the owner of the lock", func() {
+				before := time.Now()
+				_, err := exclusiveLocksmith.Lock("key")
+				Expect(err).NotTo(HaveOccurred())
+
+				holders, err := locksmith.Holders(storePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(holders).To(HaveLen(1))
+				Expect(holders[0].Key).To(Equal("key"))
+				Expect(holders[0].PID).To(Equal(os.Getpid()))
+				Expect(holders[0].Command).To(Equal(filepath.Base(os.Args[0])))
+				Expect(holders[0].Exclusive).To(BeTrue())
+				Expect(holders[0].LockedAt).To(BeTemporally(">=", before))
+			})
+
+			Context("when the owner command is set", func() {
+				BeforeEach(func() {
+					locksmith.OwnerCommand = "grootfs create"
+				})
+
+				AfterEach(func() {
+					locksmith.OwnerCommand = filepath.Base(os.Args[0])
+				})
+
+				It("records it instead of the arguments", func() {
+					_, err := exclusiveLocksmith.Lock("key")
+					Expect(err).NotTo(HaveOccurred())
+
+					holders, err := locksmith.Holders(storePath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(holders).To(HaveLen(1))
+					Expect(holders[0].Command).To(Equal("grootfs create"))
+				})
+			})
+
+			It("drops the owners left behind by processes that died holding the lock", func() {
+				staleOwnerPath := filepath.Join(storePath, store.LocksDirName, "key.4194305.owner")
+				contents, err := json.Marshal(locksmith.Owner{Key: "key", PID: 4194305})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(staleOwnerPath, contents, 0600)).To(Succeed())
+
+				_, err = exclusiveLocksmith.Lock("key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(staleOwnerPath).NotTo(BeAnExistingFile())
+			})
+
+			Describe("TryLock", func() {
+				It("takes the lock when nobody holds it", func() {
+					lockFile, err := exclusiveLocksmith.TryLock("key")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(lockFile).NotTo(BeNil())
+				})
+
+				It("returns no lock file when the lock is held", func() {
+					_, err := locksmith.NewSharedFileSystem(storePath, metricsEmitter).Lock("key")
+					Expect(err).NotTo(HaveOccurred())
+
+					lockFile, err := exclusiveLocksmith.TryLock("key")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(lockFile).To(BeNil())
+				})
+			})
+
+			Context("when a timeout is set", func() {
+				JustBeforeEach(func() {
+					exclusiveLocksmith.WithTimeout(500 * time.Millisecond)
+				})
+
+				It("takes the lock once it is released", func() {
+					lockFile, err := exclusiveLocksmith.Lock("key")
+					Expect(err).NotTo(HaveOccurred())
+
+					go func() {
+						time.Sleep(200 * time.Millisecond)
+						Expect(exclusiveLocksmith.Unlock(lockFile)).To(Succeed())
+					}()
+
+					_, err = exclusiveLocksmith.Lock("key")
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("returns an error naming the holder when the lock is not released in time", func() {
+					_, err := exclusiveLocksmith.Lock("key")
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = exclusiveLocksmith.Lock("key")
+					Expect(locksmith.IsLockTimeout(err)).To(BeTrue())
+					Expect(err).To(MatchError(ContainSubstring("timed out after 500ms waiting for lock `key` held by pid %d", os.Getpid())))
+				})
+			})
+
 			Context("when creating the lock file fails", func() {
 				BeforeEach(func() {
 					storePath = "/not/real"
@@ -123,6 +215,14 @@ var _ = Describe("Filesystem", func() {
 		})
 
 		Context("Unlock", func() {
+			It("drops the owner of the lock", func() {
+				lockFile, err := exclusiveLocksmith.Lock("key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exclusiveLocksmith.Unlock(lockFile)).To(Succeed())
+
+				Expect(locksmith.Holders(storePath)).To(BeEmpty())
+			})
+
 			Context("when unlocking a file descriptor fails", func() {
 				var lockFile *os.File
 
@@ -166,6 +266,27 @@ var _ = Describe("Filesystem", func() {
 			Expect(sharedLocksmith.Unlock(lockFd)).To(Succeed())
 		})
 
+		It("times out while the key is locked exclusively", func() {
+			exclusiveLocksmith := locksmith.NewExclusiveFileSystem(storePath, metricsEmitter)
+			_, err := exclusiveLocksmith.Lock("key")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = sharedLocksmith.WithTimeout(200 * time.Millisecond).Lock("key")
+			Expect(locksmith.IsLockTimeout(err)).To(BeTrue())
+		})
+
+		It("records every holder", func() {
+			_, err := sharedLocksmith.Lock("key")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = sharedLocksmith.Lock("other-key")
+			Expect(err).NotTo(HaveOccurred())
+
+			holders, err := locksmith.Holders(storePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holders).To(HaveLen(2))
+			Expect(holders[0].Exclusive).To(BeFalse())
+		})
+
 		Describe("Lock", func() {
 			It("creates the lock file in the lock path when it does not exist", func() {
 				lockFile := filepath.Join(storePath, store.LocksDirName, "key.lock")
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+// LOOP_SET_CAPACITY from linux/loop.h
+const loopSetCapacity = 0x4C07
+
+// refreshLoopCapacity makes the loop devices backed by backingFile pick up its
+// new size, like `losetup --set-capacity` does.
+func refreshLoopCapacity(backingFile string) error {
+	backingFile, err := filepath.Abs(backingFile)
+	if err != nil {
+		return errorspkg.Wrap(err, "resolving backing store file")
+	}
+
+	backingFiles, err := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	if err != nil {
+		return errorspkg.Wrap(err, "listing loop devices")
+	}
+
+	refreshed := false
+	for _, backingFilePath := range backingFiles {
+		contents, err := ioutil.ReadFile(backingFilePath)
+		if err != nil || strings.TrimSpace(string(contents)) != backingFile {
+			continue
+		}
+
+		deviceName := filepath.Base(filepath.Dir(filepath.Dir(backingFilePath)))
+		if err := setLoopCapacity(filepath.Join("/dev", deviceName)); err != nil {
+			return err
+		}
+		refreshed = true
+	}
+
+	if !refreshed {
+		return errorspkg.Errorf("no loop device is backed by `%s`", backingFile)
+	}
+
+	return nil
+}
+
+func setLoopCapacity(devicePath string) error {
+	device, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return errorspkg.Wrapf(err, "opening loop device `%s`", devicePath)
+	}
+	defer device.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetCapacity, 0); errno != 0 {
+		return errorspkg.Wrapf(errno, "setting capacity of loop device `%s`", devicePath)
+	}
+
+	return nil
+}
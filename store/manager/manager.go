@@ -22,6 +22,11 @@ type StoreDriver interface {
 	ConfigureStore(logger lager.Logger, storePath string, ownerUID, ownerGID int) error
 	ValidateFileSystem(logger lager.Logger, path string) error
 	InitFilesystem(logger lager.Logger, filesystemPath, storePath string) error
+	InitQuotaGroups(logger lager.Logger, cacheLimitBytes int64) error
+	EmptyTrash(logger lager.Logger) error
+	ResizeFilesystem(logger lager.Logger, sizeBytes int64) error
+	AllocatedBytes(logger lager.Logger) (int64, error)
+	Marshal(logger lager.Logger) ([]byte, error)
 }
 
 type Manager struct {
@@ -34,13 +39,18 @@ type Manager struct {
 
 //go:generate counterfeiter . StoreNamespacer
 type StoreNamespacer interface {
-	ApplyMappings(uidMappings, gidMappings []groot.IDMappingSpec) error
+	ApplyMappings(mappings groot.IDMappings) error
+	Read() (groot.IDMappings, error)
+	Remap(uidMappings, gidMappings []groot.IDMappingSpec) error
 }
 
 type InitSpec struct {
-	UIDMappings    []groot.IDMappingSpec
-	GIDMappings    []groot.IDMappingSpec
-	StoreSizeBytes int64
+	UIDMappings     []groot.IDMappingSpec
+	GIDMappings     []groot.IDMappingSpec
+	OverflowUID     int
+	OverflowGID     int
+	StoreSizeBytes  int64
+	CacheLimitBytes int64
 }
 
 func New(storePath string, storeNamespacer StoreNamespacer, volumeDriver base_image_puller.VolumeDriver, imageDriver image_cloner.ImageDriver, storeDriver StoreDriver) *Manager {
@@ -58,17 +68,7 @@ func (m *Manager) InitStore(logger lager.Logger, spec InitSpec) error {
 	logger.Debug("starting")
 	defer logger.Debug("ending")
 
-	validationPath := filepath.Dir(m.storePath)
-	stat, err := os.Stat(m.storePath)
-	if err == nil && stat.IsDir() {
-		logger.Debug("store-path-already-exists", lager.Data{"StorePath": m.storePath})
-		validationPath = m.storePath
-	}
-
-	if spec.StoreSizeBytes > 0 {
-		validationPath = m.storePath
-	}
-
+	validationPath := m.validationPath(logger, spec)
 	if err := m.storeDriver.ValidateFileSystem(logger, validationPath); err != nil {
 		logger.Debug(errorspkg.Wrap(err, "store-could-not-be-validated").Error())
 		if spec.StoreSizeBytes <= 0 {
@@ -83,18 +83,63 @@ func (m *Manager) InitStore(logger lager.Logger, spec InitSpec) error {
 		logger.Debug("store-already-initialized")
 	}
 
+	ownerUID, ownerGID := m.findStoreOwner(spec.UIDMappings, spec.GIDMappings)
+	if err := m.initStoreStructure(logger, spec, ownerUID, ownerGID); err != nil {
+		return err
+	}
+
+	return m.initQuotaGroups(logger, spec)
+}
+
+// initQuotaGroups runs outside of any user namespace, as creating qgroups
+// needs privileges on the host. Rootless stores get them from drax, which is
+// setuid, so it must not be called from the init-store-in-userns child.
+func (m *Manager) initQuotaGroups(logger lager.Logger, spec InitSpec) error {
+	if err := m.storeDriver.InitQuotaGroups(logger, spec.CacheLimitBytes); err != nil {
+		logger.Error("initializing-quota-groups-failed", err)
+		return errorspkg.Wrap(err, "initializing quota groups")
+	}
+
+	return nil
+}
+
+func (m *Manager) validationPath(logger lager.Logger, spec InitSpec) string {
+	validationPath := filepath.Dir(m.storePath)
+	stat, err := os.Stat(m.storePath)
+	if err == nil && stat.IsDir() {
+		logger.Debug("store-path-already-exists", lager.Data{"StorePath": m.storePath})
+		validationPath = m.storePath
+	}
+
+	if spec.StoreSizeBytes > 0 {
+		validationPath = m.storePath
+	}
+
+	return validationPath
+}
+
+func (m *Manager) initStoreStructure(logger lager.Logger, spec InitSpec, ownerUID, ownerGID int) error {
+	// stores that have a namespace file but no version predate the version
+	// file, they are left for migrate-store to bring up to date
+	_, err := os.Stat(filepath.Join(m.storePath, store.MetaDirName, groot.NamespaceFilename))
+	unversionedStore := err == nil
+
 	if err := os.MkdirAll(filepath.Join(m.storePath, store.MetaDirName), 0755); err != nil {
 		logger.Error("init-store-failed", err)
 		return errorspkg.Wrap(err, "initializing store")
 	}
 
-	err = m.storeNamespacer.ApplyMappings(spec.UIDMappings, spec.GIDMappings)
+	err = m.storeNamespacer.ApplyMappings(groot.IDMappings{
+		UIDMappings: spec.UIDMappings,
+		GIDMappings: spec.GIDMappings,
+		OverflowUID: spec.OverflowUID,
+		OverflowGID: spec.OverflowGID,
+	})
 	if err != nil {
 		logger.Error("applying-namespace-mappings-failed", err)
 		return err
 	}
 
-	ownerUID, ownerGID := m.findStoreOwner(spec.UIDMappings, spec.GIDMappings)
 	if err := os.Chown(m.storePath, ownerUID, ownerGID); err != nil {
 		logger.Error("chowning-store-path-failed", err, lager.Data{"uid": ownerUID, "gid": ownerGID})
 		return errorspkg.Wrap(err, "chowing store")
@@ -105,7 +150,22 @@ func (m *Manager) InitStore(logger lager.Logger, spec InitSpec) error {
 		return errorspkg.Wrap(err, "running filesystem-specific configuration")
 	}
 
-	return nil
+	return m.recordVersion(logger, unversionedStore)
+}
+
+func (m *Manager) recordVersion(logger lager.Logger, unversionedStore bool) error {
+	_, err := store.ReadVersion(m.storePath)
+	if err == nil || !os.IsNotExist(errorspkg.Cause(err)) {
+		return err
+	}
+
+	version := store.CurrentVersion
+	if unversionedStore {
+		version = store.UnversionedStore
+	}
+
+	logger.Debug("recording-store-version", lager.Data{"version": version})
+	return store.WriteVersion(m.storePath, version)
 }
 
 func (m *Manager) IsStoreInitialized(logger lager.Logger) bool {
@@ -118,6 +178,13 @@ func (m *Manager) IsStoreInitialized(logger lager.Logger) bool {
 	if _, err := os.Stat(filepath.Join(m.storePath, store.MetaDirName, groot.NamespaceFilename)); os.IsNotExist(err) {
 		return false
 	}
+
+	// stores without a version predate it, the migrator brings them up to
+	// date
+	if _, err := store.ReadVersion(m.storePath); err != nil && !os.IsNotExist(errorspkg.Cause(err)) {
+		logger.Debug("reading-store-version-failed", lager.Data{"error": err.Error()})
+		return false
+	}
 	return true
 }
 
@@ -154,7 +221,7 @@ func (m *Manager) configureStore(logger lager.Logger, ownerUID, ownerGID int) er
 	return nil
 }
 
-func (m *Manager) DeleteStore(logger lager.Logger, locksmith groot.Locksmith) error {
+func (m *Manager) DeleteStore(logger lager.Logger, locksmith, trashLocksmith groot.Locksmith) error {
 	logger = logger.Session("store-manager-delete-store")
 	logger.Debug("starting")
 	defer logger.Debug("ending")
@@ -195,6 +262,11 @@ func (m *Manager) DeleteStore(logger lager.Logger, locksmith groot.Locksmith) er
 		}
 	}
 
+	if err := m.emptyTrash(logger, trashLocksmith); err != nil {
+		logger.Error("emptying-trash-failed", err)
+		return errorspkg.Wrap(err, "emptying trash")
+	}
+
 	if err := os.RemoveAll(m.storePath); err != nil {
 		logger.Error("deleting-store-path-failed", err, lager.Data{"storePath": m.storePath})
 		return errorspkg.Wrapf(err, "deleting store path")
@@ -203,6 +275,98 @@ func (m *Manager) DeleteStore(logger lager.Logger, locksmith groot.Locksmith) er
 	return nil
 }
 
+// ResizeStore grows or shrinks a loop-backed store while it is mounted. Its
+// backing file is grown before the filesystem, and shrunk after it.
+// emptyTrash holds the trash lock, so that the background worker doesn't
+// destroy the same subvolumes.
+func (m *Manager) emptyTrash(logger lager.Logger, locksmith groot.Locksmith) error {
+	lockFile, err := locksmith.Lock(groot.TrashLockKey)
+	if err != nil {
+		return errorspkg.Wrap(err, "locking the trash")
+	}
+	defer func() {
+		if err := locksmith.Unlock(lockFile); err != nil {
+			logger.Error("unlocking-trash-failed", err)
+		}
+	}()
+
+	return m.storeDriver.EmptyTrash(logger)
+}
+
+func (m *Manager) ResizeStore(logger lager.Logger, locksmith groot.Locksmith, storeSizeBytes int64) error {
+	logger = logger.Session("store-manager-resize-store", lager.Data{"storePath": m.storePath, "storeSizeBytes": storeSizeBytes})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if storeSizeBytes < MinStoreSizeBytes {
+		return errorspkg.New("store size must be at least 200Mb")
+	}
+
+	backingStoreFile := fmt.Sprintf("%s.backing-store", m.storePath)
+	backingStoreInfo, err := os.Stat(backingStoreFile)
+	if err != nil {
+		logger.Error("backing-store-file-not-found", err, lager.Data{"backingstoreFile": backingStoreFile})
+		return errorspkg.Wrap(err, "store is not backed by a file")
+	}
+
+	if err := m.storeDriver.ValidateFileSystem(logger, m.storePath); err != nil {
+		return errorspkg.Wrap(err, "validating store path filesystem")
+	}
+
+	fileLock, err := locksmith.Lock(groot.GlobalLockKey)
+	if err != nil {
+		logger.Error("locking-failed", err)
+		return errorspkg.Wrap(err, "locking store")
+	}
+	defer func() {
+		if err := locksmith.Unlock(fileLock); err != nil {
+			logger.Error("unlocking-failed", err)
+		}
+	}()
+
+	currentSizeBytes := backingStoreInfo.Size()
+	if storeSizeBytes == currentSizeBytes {
+		logger.Info("store-already-has-size")
+		return nil
+	}
+
+	if storeSizeBytes > currentSizeBytes {
+		if err := m.resizeBackingStoreFile(logger, backingStoreFile, storeSizeBytes); err != nil {
+			return err
+		}
+
+		return m.storeDriver.ResizeFilesystem(logger, storeSizeBytes)
+	}
+
+	allocatedBytes, err := m.storeDriver.AllocatedBytes(logger)
+	if err != nil {
+		return errorspkg.Wrapf(err, "measuring store %s", m.storePath)
+	}
+	if allocatedBytes >= storeSizeBytes {
+		return errorspkg.Errorf("cannot shrink the store to %d bytes, %d bytes are allocated", storeSizeBytes, allocatedBytes)
+	}
+
+	if err := m.storeDriver.ResizeFilesystem(logger, storeSizeBytes); err != nil {
+		return err
+	}
+
+	return m.resizeBackingStoreFile(logger, backingStoreFile, storeSizeBytes)
+}
+
+func (m *Manager) resizeBackingStoreFile(logger lager.Logger, backingStoreFile string, storeSizeBytes int64) error {
+	if err := os.Truncate(backingStoreFile, storeSizeBytes); err != nil {
+		logger.Error("truncating-backing-store-file-failed", err, lager.Data{"backingstoreFile": backingStoreFile, "size": storeSizeBytes})
+		return errorspkg.Wrap(err, "truncating backing store file")
+	}
+
+	if err := refreshLoopCapacity(backingStoreFile); err != nil {
+		logger.Error("refreshing-loop-device-failed", err, lager.Data{"backingstoreFile": backingStoreFile})
+		return errorspkg.Wrap(err, "refreshing loop device")
+	}
+
+	return nil
+}
+
 func (m *Manager) createAndMountFilesystem(logger lager.Logger, storeSizeBytes int64) error {
 	if storeSizeBytes < MinStoreSizeBytes {
 		logger.Error("init-store-failed", errors.New("store size must be at least 200Mb"), lager.Data{"storeSize": storeSizeBytes})
@@ -287,26 +451,23 @@ func (m *Manager) createInternalDirectory(logger lager.Logger, folderName string
 }
 
 func (m *Manager) findStoreOwner(uidMappings, gidMappings []groot.IDMappingSpec) (int, int) {
-	uid := os.Getuid()
-	gid := os.Getgid()
+	return findRootOwner(uidMappings, os.Getuid()), findRootOwner(gidMappings, os.Getgid())
+}
 
-	for _, mapping := range uidMappings {
-		if mapping.Size == 1 && mapping.NamespaceID == 0 {
-			uid = mapping.HostID
-			break
-		}
-		uid = -1
+// findRootOwner returns the host ID that root is mapped to. When there are
+// mappings but none of them covers root, -1 is returned so that chown leaves
+// the ID untouched.
+func findRootOwner(mappings []groot.IDMappingSpec, currentID int) int {
+	table := groot.NewIDMappingTable(mappings, groot.DefaultOverflowID)
+	if table.Empty() {
+		return currentID
 	}
 
-	for _, mapping := range gidMappings {
-		if mapping.Size == 1 && mapping.NamespaceID == 0 {
-			gid = mapping.HostID
-			break
-		}
-		gid = -1
+	if hostID, ok := table.Lookup(0); ok {
+		return hostID
 	}
 
-	return uid, gid
+	return -1
 }
 
 func isDirectory(requiredPath string) error {
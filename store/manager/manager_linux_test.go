@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"syscall"
 
+	"code.cloudfoundry.org/commandrunner/fake_command_runner"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"github.com/SUSE/groot-btrfs/base_image_puller/base_image_pullerfakes"
+	"github.com/SUSE/groot-btrfs/base_image_puller/unpacker/unpackerfakes"
 	"github.com/SUSE/groot-btrfs/groot"
 	"github.com/SUSE/groot-btrfs/groot/grootfakes"
 	"github.com/SUSE/groot-btrfs/store"
@@ -26,15 +30,16 @@ var _ = Describe("Manager", func() {
 	var (
 		originalTmpDir string
 
-		imgDriver   *image_clonerfakes.FakeImageDriver
-		volDriver   *base_image_pullerfakes.FakeVolumeDriver
-		storeDriver *managerfakes.FakeStoreDriver
-		locksmith   *grootfakes.FakeLocksmith
-		manager     *managerpkg.Manager
-		storePath   string
-		logger      *lagertest.TestLogger
-		spec        managerpkg.InitSpec
-		namespacer  *managerfakes.FakeStoreNamespacer
+		imgDriver      *image_clonerfakes.FakeImageDriver
+		volDriver      *base_image_pullerfakes.FakeVolumeDriver
+		storeDriver    *managerfakes.FakeStoreDriver
+		locksmith      *grootfakes.FakeLocksmith
+		trashLocksmith *grootfakes.FakeLocksmith
+		manager        *managerpkg.Manager
+		storePath      string
+		logger         *lagertest.TestLogger
+		spec           managerpkg.InitSpec
+		namespacer     *managerfakes.FakeStoreNamespacer
 	)
 
 	BeforeEach(func() {
@@ -44,6 +49,7 @@ var _ = Describe("Manager", func() {
 		volDriver = new(base_image_pullerfakes.FakeVolumeDriver)
 		storeDriver = new(managerfakes.FakeStoreDriver)
 		locksmith = new(grootfakes.FakeLocksmith)
+		trashLocksmith = new(grootfakes.FakeLocksmith)
 		namespacer = new(managerfakes.FakeStoreNamespacer)
 
 		logger = lagertest.NewTestLogger("store-manager")
@@ -116,13 +122,77 @@ var _ = Describe("Manager", func() {
 			Expect(filepath.Join(storePath, "meta", "dependencies")).To(BeADirectory())
 		})
 
+		It("records the current store version", func() {
+			Expect(manager.InitStore(logger, spec)).To(Succeed())
+			Expect(store.ReadVersion(storePath)).To(Equal(store.CurrentVersion))
+		})
+
+		Context("when the store predates the version file", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, groot.NamespaceFilename), []byte("{}"), 0644)).To(Succeed())
+			})
+
+			It("records it as unversioned, so that it gets migrated", func() {
+				Expect(manager.InitStore(logger, spec)).To(Succeed())
+				Expect(store.ReadVersion(storePath)).To(Equal(store.UnversionedStore))
+			})
+		})
+
+		Context("when the store already has a version", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+				Expect(store.WriteVersion(storePath, 1)).To(Succeed())
+			})
+
+			It("keeps it", func() {
+				Expect(manager.InitStore(logger, spec)).To(Succeed())
+				Expect(store.ReadVersion(storePath)).To(Equal(1))
+			})
+		})
+
+		It("initializes the quota groups with the cache limit", func() {
+			spec.CacheLimitBytes = 1024 * 1024
+			Expect(manager.InitStore(logger, spec)).To(Succeed())
+
+			Expect(storeDriver.InitQuotaGroupsCallCount()).To(Equal(1))
+			_, cacheLimitBytes := storeDriver.InitQuotaGroupsArgsForCall(0)
+			Expect(cacheLimitBytes).To(Equal(int64(1024 * 1024)))
+		})
+
+		Context("when initializing the quota groups fails", func() {
+			BeforeEach(func() {
+				storeDriver.InitQuotaGroupsReturns(errors.New("quotas are not enabled"))
+			})
+
+			It("returns an error", func() {
+				err := manager.InitStore(logger, spec)
+				Expect(err).To(MatchError(ContainSubstring("quotas are not enabled")))
+			})
+		})
+
 		It("calls the namespace writer to set the metadata", func() {
 			Expect(manager.InitStore(logger, spec)).To(Succeed())
 			Expect(namespacer.ApplyMappingsCallCount()).To(Equal(1))
 
-			uidMappings, gidMappings := namespacer.ApplyMappingsArgsForCall(0)
-			Expect(uidMappings).To(BeEmpty())
-			Expect(gidMappings).To(BeEmpty())
+			mappings := namespacer.ApplyMappingsArgsForCall(0)
+			Expect(mappings.UIDMappings).To(BeEmpty())
+			Expect(mappings.GIDMappings).To(BeEmpty())
+		})
+
+		Context("when overflow ids are provided", func() {
+			BeforeEach(func() {
+				spec.OverflowUID = 0
+				spec.OverflowGID = 1010
+			})
+
+			It("passes them to the namespace writer", func() {
+				Expect(manager.InitStore(logger, spec)).To(Succeed())
+
+				mappings := namespacer.ApplyMappingsArgsForCall(0)
+				Expect(mappings.OverflowUID).To(Equal(0))
+				Expect(mappings.OverflowGID).To(Equal(1010))
+			})
 		})
 
 		It("calls the store driver to configure the store", func() {
@@ -208,9 +278,9 @@ var _ = Describe("Manager", func() {
 				Expect(manager.InitStore(logger, spec)).To(Succeed())
 				Expect(namespacer.ApplyMappingsCallCount()).To(Equal(1))
 
-				uidMappingsArg, gidMappingsArg := namespacer.ApplyMappingsArgsForCall(0)
-				Expect(uidMappingsArg).To(Equal(uidMappings))
-				Expect(gidMappingsArg).To(Equal(gidMappings))
+				mappings := namespacer.ApplyMappingsArgsForCall(0)
+				Expect(mappings.UIDMappings).To(Equal(uidMappings))
+				Expect(mappings.GIDMappings).To(Equal(gidMappings))
 			})
 
 			It("calls the store driver to configure the store", func() {
@@ -379,13 +449,189 @@ var _ = Describe("Manager", func() {
 		})
 	})
 
+	Describe("InitStoreInUserNamespace", func() {
+		var (
+			fakeIDMapper      *unpackerfakes.FakeIDMapper
+			fakeCommandRunner *fake_command_runner.FakeCommandRunner
+		)
+
+		BeforeEach(func() {
+			storePath = filepath.Join(os.TempDir(), fmt.Sprintf("init-store-userns-%d", GinkgoParallelNode()))
+			fakeIDMapper = new(unpackerfakes.FakeIDMapper)
+			fakeCommandRunner = fake_command_runner.New()
+			fakeCommandRunner.WhenRunning(fake_command_runner.CommandSpec{
+				Path: "/proc/self/exe",
+			}, func(cmd *exec.Cmd) error {
+				cmd.Process = &os.Process{
+					Pid: 12, // don't panic
+				}
+				return nil
+			})
+
+			storeDriver.MarshalReturns([]byte(`{"type":"btrfs"}`), nil)
+		})
+
+		Context("when no id mappings are provided", func() {
+			It("initializes the store directly", func() {
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+				Expect(fakeCommandRunner.StartedCommands()).To(BeEmpty())
+
+				for _, folderName := range store.StoreFolders {
+					Expect(filepath.Join(storePath, folderName)).To(BeADirectory())
+				}
+			})
+
+			It("initializes the quota groups", func() {
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+				Expect(storeDriver.InitQuotaGroupsCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when id mappings are provided", func() {
+			BeforeEach(func() {
+				spec.UIDMappings = []groot.IDMappingSpec{
+					{HostID: os.Getuid(), NamespaceID: 0, Size: 1},
+					{HostID: 100000, NamespaceID: 1, Size: 65000},
+				}
+				spec.GIDMappings = []groot.IDMappingSpec{
+					{HostID: os.Getgid(), NamespaceID: 0, Size: 1},
+					{HostID: 100000, NamespaceID: 1, Size: 65000},
+				}
+			})
+
+			It("validates the store path with the store driver", func() {
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+				Expect(storeDriver.ValidateFileSystemCallCount()).To(Equal(1))
+
+				_, path := storeDriver.ValidateFileSystemArgsForCall(0)
+				Expect(path).To(Equal(filepath.Dir(storePath)))
+			})
+
+			It("initializes the store from a user namespace", func() {
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+
+				commands := fakeCommandRunner.StartedCommands()
+				Expect(commands).To(HaveLen(1))
+				Expect(commands[0].Args[0]).To(Equal("init-store-in-userns"))
+				Expect(commands[0].Args[1]).To(Equal(`{"type":"btrfs"}`))
+				Expect(commands[0].SysProcAttr.Cloneflags).To(Equal(uintptr(syscall.CLONE_NEWUSER)))
+			})
+
+			It("initializes the quota groups outside of the user namespace", func() {
+				spec.CacheLimitBytes = 2048
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+
+				Expect(storeDriver.InitQuotaGroupsCallCount()).To(Equal(1))
+				_, cacheLimitBytes := storeDriver.InitQuotaGroupsArgsForCall(0)
+				Expect(cacheLimitBytes).To(Equal(int64(2048)))
+			})
+
+			It("maps the ids of the user namespace", func() {
+				Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).To(Succeed())
+
+				Expect(fakeIDMapper.MapUIDsCallCount()).To(Equal(1))
+				_, pid, uidMappings := fakeIDMapper.MapUIDsArgsForCall(0)
+				Expect(pid).To(Equal(12))
+				Expect(uidMappings).To(Equal(spec.UIDMappings))
+
+				Expect(fakeIDMapper.MapGIDsCallCount()).To(Equal(1))
+				_, pid, gidMappings := fakeIDMapper.MapGIDsArgsForCall(0)
+				Expect(pid).To(Equal(12))
+				Expect(gidMappings).To(Equal(spec.GIDMappings))
+			})
+
+			Context("when the root mapping is not the caller", func() {
+				BeforeEach(func() {
+					spec.UIDMappings[0].HostID = 100000 + 65000
+				})
+
+				It("returns an error", func() {
+					err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+					Expect(err).To(MatchError(ContainSubstring("must be the current user")))
+					Expect(fakeCommandRunner.StartedCommands()).To(BeEmpty())
+				})
+			})
+
+			Context("when the init command fails", func() {
+				BeforeEach(func() {
+					fakeCommandRunner.WhenWaitingFor(fake_command_runner.CommandSpec{
+						Path: "/proc/self/exe",
+					}, func(cmd *exec.Cmd) error {
+						_, err := cmd.Stdout.Write([]byte("permission denied\n"))
+						Expect(err).NotTo(HaveOccurred())
+						return errors.New("exit status 1")
+					})
+				})
+
+				It("returns the command output", func() {
+					err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+					Expect(err).To(MatchError("initializing store in user namespace: permission denied"))
+				})
+			})
+
+			Context("when mapping the uids fails", func() {
+				BeforeEach(func() {
+					fakeIDMapper.MapUIDsReturns(errors.New("newuidmap failed"))
+				})
+
+				It("returns an error", func() {
+					err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+					Expect(err).To(MatchError(ContainSubstring("newuidmap failed")))
+				})
+
+				It("waits for the init command and closes the control pipe", func() {
+					Expect(manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)).NotTo(Succeed())
+
+					Expect(fakeCommandRunner.WaitedCommands()).To(HaveLen(1))
+					_, err := fakeCommandRunner.StartedCommands()[0].ExtraFiles[0].Read(make([]byte, 1))
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			Context("when mapping the gids fails", func() {
+				BeforeEach(func() {
+					fakeIDMapper.MapGIDsReturns(errors.New("newgidmap failed"))
+				})
+
+				It("waits for the init command", func() {
+					err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+					Expect(err).To(MatchError(ContainSubstring("newgidmap failed")))
+					Expect(fakeCommandRunner.WaitedCommands()).To(HaveLen(1))
+				})
+			})
+		})
+
+		Context("when the store size is provided", func() {
+			BeforeEach(func() {
+				spec.StoreSizeBytes = 1024 * 1024 * 500
+			})
+
+			It("returns an error", func() {
+				err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+				Expect(err).To(MatchError(ContainSubstring("can only be created with a store size by Root user")))
+				Expect(storeDriver.InitFilesystemCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the store path is not a valid filesystem", func() {
+			BeforeEach(func() {
+				storeDriver.ValidateFileSystemReturns(errors.New("not a valid filesystem"))
+			})
+
+			It("returns an error", func() {
+				err := manager.InitStoreInUserNamespace(logger, spec, fakeIDMapper, fakeCommandRunner)
+				Expect(err).To(MatchError(ContainSubstring("not a valid filesystem")))
+			})
+		})
+	})
+
 	Describe("IsStoreInitialized", func() {
 		BeforeEach(func() {
 			var err error
 			storePath, err = ioutil.TempDir("", "init-store")
 			Expect(err).NotTo(HaveOccurred())
 
-			namespacer.ApplyMappingsStub = func(_, _ []groot.IDMappingSpec) error {
+			namespacer.ApplyMappingsStub = func(_ groot.IDMappings) error {
 				return ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, groot.NamespaceFilename), []byte{}, 0666)
 			}
 		})
@@ -466,6 +712,28 @@ var _ = Describe("Manager", func() {
 			})
 		})
 
+		Context("when the store is missing the version", func() {
+			JustBeforeEach(func() {
+				Expect(manager.InitStore(logger, spec)).To(Succeed())
+				Expect(os.Remove(filepath.Join(storePath, store.MetaDirName, store.VersionFileName))).To(Succeed())
+			})
+
+			It("returns true, as the store predates the version", func() {
+				Expect(manager.IsStoreInitialized(logger)).To(BeTrue())
+			})
+		})
+
+		Context("when the version is invalid", func() {
+			JustBeforeEach(func() {
+				Expect(manager.InitStore(logger, spec)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, store.VersionFileName), []byte("banana"), 0644)).To(Succeed())
+			})
+
+			It("returns false", func() {
+				Expect(manager.IsStoreInitialized(logger)).To(BeFalse())
+			})
+		})
+
 		Context("when the store is missing the namepsace.json", func() {
 			JustBeforeEach(func() {
 				Expect(manager.InitStore(logger, spec)).To(Succeed())
@@ -501,7 +769,7 @@ var _ = Describe("Manager", func() {
 		})
 
 		It("uses the image driver to delete all images in the store path", func() {
-			Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
 
 			Expect(imgDriver.DestroyImageCallCount()).To(Equal(2))
 
@@ -513,7 +781,7 @@ var _ = Describe("Manager", func() {
 		})
 
 		It("uses the volume driver to delete all volumes in the store path", func() {
-			Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
 
 			Expect(volDriver.DestroyVolumeCallCount()).To(Equal(2))
 
@@ -524,8 +792,51 @@ var _ = Describe("Manager", func() {
 			Expect(volId).To(Equal("vol-2"))
 		})
 
+		It("empties the trash", func() {
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
+			Expect(storeDriver.EmptyTrashCallCount()).To(Equal(1))
+		})
+
+		It("holds the trash lock while emptying the trash", func() {
+			storeDriver.EmptyTrashStub = func(_ lager.Logger) error {
+				Expect(trashLocksmith.LockCallCount()).To(Equal(1))
+				Expect(trashLocksmith.LockArgsForCall(0)).To(Equal(groot.TrashLockKey))
+				Expect(trashLocksmith.UnlockCallCount()).To(Equal(0))
+				return nil
+			}
+
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
+			Expect(storeDriver.EmptyTrashCallCount()).To(Equal(1))
+			Expect(trashLocksmith.UnlockCallCount()).To(Equal(1))
+		})
+
+		Context("when locking the trash fails", func() {
+			BeforeEach(func() {
+				trashLocksmith.LockReturns(nil, errors.New("trash is locked"))
+			})
+
+			It("returns an error and keeps the store", func() {
+				err := manager.DeleteStore(logger, locksmith, trashLocksmith)
+				Expect(err).To(MatchError(ContainSubstring("trash is locked")))
+				Expect(storeDriver.EmptyTrashCallCount()).To(Equal(0))
+				Expect(storePath).To(BeADirectory())
+			})
+		})
+
+		Context("when emptying the trash fails", func() {
+			BeforeEach(func() {
+				storeDriver.EmptyTrashReturns(errors.New("failed to empty"))
+			})
+
+			It("returns an error and keeps the store", func() {
+				err := manager.DeleteStore(logger, locksmith, trashLocksmith)
+				Expect(err).To(MatchError(ContainSubstring("failed to empty")))
+				Expect(storePath).To(BeADirectory())
+			})
+		})
+
 		It("requests a lock", func() {
-			Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
 			Expect(locksmith.LockCallCount()).To(Equal(1))
 			Expect(locksmith.UnlockCallCount()).To(Equal(1))
 
@@ -535,7 +846,7 @@ var _ = Describe("Manager", func() {
 
 		It("deletes the store path", func() {
 			Expect(storePath).To(BeAnExistingFile())
-			Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+			Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
 			Expect(storePath).ToNot(BeAnExistingFile())
 		})
 
@@ -545,7 +856,7 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("returns an error", func() {
-				err := manager.DeleteStore(logger, locksmith)
+				err := manager.DeleteStore(logger, locksmith, trashLocksmith)
 				Expect(err).To(MatchError(ContainSubstring("failed to delete")))
 			})
 		})
@@ -556,7 +867,7 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("returns an error", func() {
-				err := manager.DeleteStore(logger, locksmith)
+				err := manager.DeleteStore(logger, locksmith, trashLocksmith)
 				Expect(err).To(MatchError(ContainSubstring("failed to delete")))
 			})
 		})
@@ -567,7 +878,7 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("returns an error", func() {
-				err := manager.DeleteStore(logger, locksmith)
+				err := manager.DeleteStore(logger, locksmith, trashLocksmith)
 				Expect(err).To(MatchError(ContainSubstring("cant do it")))
 			})
 		})
@@ -578,7 +889,7 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("doesn't fail", func() {
-				Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+				Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
 			})
 		})
 
@@ -588,7 +899,430 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("fails", func() {
-				Expect(manager.DeleteStore(logger, locksmith)).To(Succeed())
+				Expect(manager.DeleteStore(logger, locksmith, trashLocksmith)).To(Succeed())
+			})
+		})
+	})
+
+	Describe("ResizeStore", func() {
+		var backingStoreFile string
+
+		BeforeEach(func() {
+			var err error
+			storePath, err = ioutil.TempDir("", "resize-store")
+			Expect(err).NotTo(HaveOccurred())
+
+			backingStoreFile = fmt.Sprintf("%s.backing-store", storePath)
+			Expect(ioutil.WriteFile(backingStoreFile, []byte{}, 0600)).To(Succeed())
+			Expect(os.Truncate(backingStoreFile, 2*managerpkg.MinStoreSizeBytes)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(backingStoreFile)).To(Succeed())
+		})
+
+		backingStoreSize := func() int64 {
+			stat, err := os.Stat(backingStoreFile)
+			Expect(err).NotTo(HaveOccurred())
+			return stat.Size()
+		}
+
+		It("grows the backing store file before the filesystem", func() {
+			// there is no loop device backed by the file in the tests
+			err := manager.ResizeStore(logger, locksmith, 3*managerpkg.MinStoreSizeBytes)
+			Expect(err).To(MatchError(ContainSubstring("no loop device is backed by")))
+
+			Expect(backingStoreSize()).To(Equal(int64(3 * managerpkg.MinStoreSizeBytes)))
+			Expect(storeDriver.ResizeFilesystemCallCount()).To(BeZero())
+		})
+
+		It("holds the global lock", func() {
+			_ = manager.ResizeStore(logger, locksmith, 3*managerpkg.MinStoreSizeBytes)
+
+			Expect(locksmith.LockCallCount()).To(Equal(1))
+			Expect(locksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(locksmith.UnlockCallCount()).To(Equal(1))
+		})
+
+		Context("when the size does not change", func() {
+			It("does nothing", func() {
+				Expect(manager.ResizeStore(logger, locksmith, 2*managerpkg.MinStoreSizeBytes)).To(Succeed())
+				Expect(storeDriver.ResizeFilesystemCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when shrinking the filesystem fails", func() {
+			BeforeEach(func() {
+				storeDriver.ResizeFilesystemReturns(errors.New("no space left"))
+			})
+
+			It("keeps the backing store file as it was", func() {
+				Expect(manager.ResizeStore(logger, locksmith, managerpkg.MinStoreSizeBytes)).NotTo(Succeed())
+				Expect(backingStoreSize()).To(Equal(int64(2 * managerpkg.MinStoreSizeBytes)))
+			})
+		})
+
+		Context("when more than the new size is allocated to chunks", func() {
+			BeforeEach(func() {
+				storeDriver.AllocatedBytesReturns(managerpkg.MinStoreSizeBytes+1, nil)
+			})
+
+			It("returns an error without shrinking", func() {
+				err := manager.ResizeStore(logger, locksmith, managerpkg.MinStoreSizeBytes)
+				Expect(err).To(MatchError(ContainSubstring("bytes are allocated")))
+				Expect(storeDriver.ResizeFilesystemCallCount()).To(BeZero())
+				Expect(backingStoreSize()).To(Equal(int64(2 * managerpkg.MinStoreSizeBytes)))
+			})
+		})
+
+		Context("when measuring the allocated bytes fails", func() {
+			BeforeEach(func() {
+				storeDriver.AllocatedBytesReturns(0, errors.New("no fs info"))
+			})
+
+			It("returns an error without shrinking", func() {
+				err := manager.ResizeStore(logger, locksmith, managerpkg.MinStoreSizeBytes)
+				Expect(err).To(MatchError(ContainSubstring("no fs info")))
+				Expect(storeDriver.ResizeFilesystemCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the size is below the minimum", func() {
+			It("returns an error", func() {
+				err := manager.ResizeStore(logger, locksmith, 1024)
+				Expect(err).To(MatchError("store size must be at least 200Mb"))
+			})
+		})
+
+		Context("when the store is not backed by a file", func() {
+			BeforeEach(func() {
+				Expect(os.Remove(backingStoreFile)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				err := manager.ResizeStore(logger, locksmith, 3*managerpkg.MinStoreSizeBytes)
+				Expect(err).To(MatchError(ContainSubstring("store is not backed by a file")))
+			})
+		})
+
+		Context("when the store filesystem is not valid", func() {
+			BeforeEach(func() {
+				storeDriver.ValidateFileSystemReturns(errors.New("not btrfs"))
+			})
+
+			It("returns an error without locking", func() {
+				err := manager.ResizeStore(logger, locksmith, 3*managerpkg.MinStoreSizeBytes)
+				Expect(err).To(MatchError(ContainSubstring("not btrfs")))
+				Expect(locksmith.LockCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when locking fails", func() {
+			BeforeEach(func() {
+				locksmith.LockReturns(nil, errors.New("cant do it"))
+			})
+
+			It("returns an error", func() {
+				err := manager.ResizeStore(logger, locksmith, 3*managerpkg.MinStoreSizeBytes)
+				Expect(err).To(MatchError(ContainSubstring("cant do it")))
+				Expect(backingStoreSize()).To(Equal(int64(2 * managerpkg.MinStoreSizeBytes)))
+			})
+		})
+	})
+
+	Describe("RemapStore", func() {
+		var (
+			remapSpec  managerpkg.RemapSpec
+			volumePath string
+		)
+
+		ownerOf := func(path string) (uint32, uint32) {
+			stat, err := os.Lstat(path)
+			Expect(err).NotTo(HaveOccurred())
+			return stat.Sys().(*syscall.Stat_t).Uid, stat.Sys().(*syscall.Stat_t).Gid
+		}
+
+		BeforeEach(func() {
+			var err error
+			storePath, err = ioutil.TempDir("", "remap-store")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, dir := range []string{store.ImageDirName, store.VolumesDirName, store.MetaDirName} {
+				Expect(os.MkdirAll(filepath.Join(storePath, dir), 0755)).To(Succeed())
+			}
+
+			volumePath = filepath.Join(storePath, store.VolumesDirName, "sha256:vol-a")
+			Expect(os.MkdirAll(filepath.Join(volumePath, "home"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "home", "file"), []byte{}, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "suid"), []byte{}, 0755)).To(Succeed())
+			Expect(os.Symlink("home/file", filepath.Join(volumePath, "link"))).To(Succeed())
+
+			Expect(os.Chown(storePath, 1000, 2000)).To(Succeed())
+			Expect(os.Chown(volumePath, 1000, 2000)).To(Succeed())
+			Expect(os.Chown(filepath.Join(volumePath, "home"), 100004, 200004)).To(Succeed())
+			Expect(os.Chown(filepath.Join(volumePath, "home", "file"), 100004, 200004)).To(Succeed())
+			Expect(os.Lchown(filepath.Join(volumePath, "link"), 100004, 200004)).To(Succeed())
+			Expect(os.Chown(filepath.Join(volumePath, "suid"), 1000, 2000)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(volumePath, "suid"), 0755|os.ModeSetuid)).To(Succeed())
+
+			namespacer.ReadReturns(groot.IDMappings{
+				UIDMappings: []groot.IDMappingSpec{
+					groot.IDMappingSpec{HostID: 1000, NamespaceID: 0, Size: 1},
+					groot.IDMappingSpec{HostID: 100000, NamespaceID: 1, Size: 10},
+				},
+				GIDMappings: []groot.IDMappingSpec{
+					groot.IDMappingSpec{HostID: 2000, NamespaceID: 0, Size: 1},
+					groot.IDMappingSpec{HostID: 200000, NamespaceID: 1, Size: 10},
+				},
+			}, nil)
+
+			remapSpec = managerpkg.RemapSpec{
+				UIDMappings: []groot.IDMappingSpec{
+					groot.IDMappingSpec{HostID: 3000, NamespaceID: 0, Size: 1},
+					groot.IDMappingSpec{HostID: 300000, NamespaceID: 1, Size: 10},
+				},
+				GIDMappings: []groot.IDMappingSpec{
+					groot.IDMappingSpec{HostID: 4000, NamespaceID: 0, Size: 1},
+					groot.IDMappingSpec{HostID: 400000, NamespaceID: 1, Size: 10},
+				},
+			}
+		})
+
+		It("shifts the ownership of the volumes to the new mappings", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			uid, gid := ownerOf(volumePath)
+			Expect([]uint32{uid, gid}).To(Equal([]uint32{3000, 4000}))
+			uid, gid = ownerOf(filepath.Join(volumePath, "home", "file"))
+			Expect([]uint32{uid, gid}).To(Equal([]uint32{300004, 400004}))
+			uid, gid = ownerOf(filepath.Join(volumePath, "link"))
+			Expect([]uint32{uid, gid}).To(Equal([]uint32{300004, 400004}))
+		})
+
+		Context("when the store has trash, locks and temporary files", func() {
+			BeforeEach(func() {
+				for _, dir := range []string{store.TrashDirName, store.LocksDirName, store.TempDirName} {
+					dirPath := filepath.Join(storePath, dir)
+					Expect(os.MkdirAll(filepath.Join(dirPath, "entry"), 0755)).To(Succeed())
+					Expect(os.Chown(dirPath, 1000, 2000)).To(Succeed())
+					Expect(os.Chown(filepath.Join(dirPath, "entry"), 100004, 200004)).To(Succeed())
+				}
+			})
+
+			It("shifts the directories but leaves their contents alone", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				for _, dir := range []string{store.TrashDirName, store.LocksDirName, store.TempDirName} {
+					uid, gid := ownerOf(filepath.Join(storePath, dir))
+					Expect([]uint32{uid, gid}).To(Equal([]uint32{3000, 4000}))
+					uid, gid = ownerOf(filepath.Join(storePath, dir, "entry"))
+					Expect([]uint32{uid, gid}).To(Equal([]uint32{100004, 200004}))
+				}
+			})
+		})
+
+		Context("when the new mappings don't cover an id", func() {
+			BeforeEach(func() {
+				namespacer.ReadReturns(groot.IDMappings{
+					UIDMappings: []groot.IDMappingSpec{
+						groot.IDMappingSpec{HostID: 1000, NamespaceID: 0, Size: 1},
+						groot.IDMappingSpec{HostID: 100000, NamespaceID: 1, Size: 10},
+					},
+					GIDMappings: []groot.IDMappingSpec{
+						groot.IDMappingSpec{HostID: 2000, NamespaceID: 0, Size: 1},
+						groot.IDMappingSpec{HostID: 200000, NamespaceID: 1, Size: 10},
+					},
+					OverflowUID: 65534,
+					OverflowGID: 65533,
+				}, nil)
+				remapSpec.UIDMappings[1].Size = 2
+				remapSpec.GIDMappings[1].Size = 2
+			})
+
+			It("gives it the store's overflow id", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				uid, gid := ownerOf(filepath.Join(volumePath, "home", "file"))
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{65534, 65533}))
+			})
+		})
+
+		Context("when a file has several hard links", func() {
+			BeforeEach(func() {
+				Expect(os.Link(filepath.Join(volumePath, "home", "file"), filepath.Join(volumePath, "zz-hardlink"))).To(Succeed())
+
+				// enough entries between the links for them to land in
+				// different batches
+				Expect(os.Mkdir(filepath.Join(volumePath, "many"), 0755)).To(Succeed())
+				for i := 0; i < 1100; i++ {
+					filePath := filepath.Join(volumePath, "many", fmt.Sprintf("file-%d", i))
+					Expect(ioutil.WriteFile(filePath, []byte{}, 0644)).To(Succeed())
+					Expect(os.Chown(filePath, 1000, 2000)).To(Succeed())
+				}
+
+				// the new range overlaps the old one, a second shift would
+				// move the file further
+				remapSpec.UIDMappings[1].HostID = 100002
+			})
+
+			It("shifts the file once", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				uid, gid := ownerOf(filepath.Join(volumePath, "zz-hardlink"))
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{100006, 400004}))
+			})
+		})
+
+		It("shifts the ownership of the store", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			uid, gid := ownerOf(storePath)
+			Expect([]uint32{uid, gid}).To(Equal([]uint32{3000, 4000}))
+		})
+
+		It("preserves the setuid bit", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			stat, err := os.Stat(filepath.Join(volumePath, "suid"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Mode() & os.ModeSetuid).To(Equal(os.ModeSetuid))
+		})
+
+		It("rewrites the store mappings", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			Expect(namespacer.RemapCallCount()).To(Equal(1))
+			uidMappings, gidMappings := namespacer.RemapArgsForCall(0)
+			Expect(uidMappings).To(Equal(remapSpec.UIDMappings))
+			Expect(gidMappings).To(Equal(remapSpec.GIDMappings))
+		})
+
+		It("makes the volumes writable while shifting them and read-only again after", func() {
+			volDriver.SetVolumeReadOnlyStub = func(_ lager.Logger, id string, readOnly bool) error {
+				if readOnly {
+					uid, _ := ownerOf(volumePath)
+					Expect(uid).To(Equal(uint32(3000)))
+				}
+				return nil
+			}
+
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			Expect(volDriver.SetVolumeReadOnlyCallCount()).To(Equal(2))
+			_, id, readOnly := volDriver.SetVolumeReadOnlyArgsForCall(0)
+			Expect(id).To(Equal("sha256:vol-a"))
+			Expect(readOnly).To(BeFalse())
+			_, id, readOnly = volDriver.SetVolumeReadOnlyArgsForCall(1)
+			Expect(id).To(Equal("sha256:vol-a"))
+			Expect(readOnly).To(BeTrue())
+		})
+
+		Context("when a volume is incomplete", func() {
+			BeforeEach(func() {
+				Expect(os.Rename(volumePath, volumePath+"-incomplete-1-2")).To(Succeed())
+				volumePath = volumePath + "-incomplete-1-2"
+			})
+
+			It("leaves it writable", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				Expect(volDriver.SetVolumeReadOnlyCallCount()).To(Equal(1))
+				_, _, readOnly := volDriver.SetVolumeReadOnlyArgsForCall(0)
+				Expect(readOnly).To(BeFalse())
+			})
+		})
+
+		It("removes the journal when it is done", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+			Expect(filepath.Join(storePath, store.MetaDirName, managerpkg.RemapJournalFilename)).NotTo(BeAnExistingFile())
+		})
+
+		It("holds the global lock", func() {
+			Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+			Expect(locksmith.LockCallCount()).To(Equal(1))
+			Expect(locksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(locksmith.UnlockCallCount()).To(Equal(1))
+		})
+
+		Context("when ids are not covered by the old mappings", func() {
+			BeforeEach(func() {
+				Expect(os.Chown(filepath.Join(volumePath, "home", "file"), 500, 600)).To(Succeed())
+			})
+
+			It("leaves them untouched", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				uid, gid := ownerOf(filepath.Join(volumePath, "home", "file"))
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{500, 600}))
+			})
+		})
+
+		Context("when the store has images", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(storePath, store.ImageDirName, "my-image"), 0755)).To(Succeed())
+			})
+
+			It("returns an error without changing anything", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(MatchError(ContainSubstring("store has 1 images")))
+
+				uid, _ := ownerOf(volumePath)
+				Expect(uid).To(Equal(uint32(1000)))
+				Expect(namespacer.RemapCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the mappings are invalid", func() {
+			BeforeEach(func() {
+				remapSpec.GIDMappings = append(remapSpec.GIDMappings, groot.IDMappingSpec{HostID: 400005, NamespaceID: 20, Size: 10})
+			})
+
+			It("returns an error", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(MatchError(ContainSubstring("invalid gid mappings")))
+				Expect(locksmith.LockCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when a previous remap was interrupted", func() {
+			BeforeEach(func() {
+				journal := fmt.Sprintf(`{
+					"OldUIDMappings": [{"NamespaceID": 0, "HostID": 1000, "Size": 1}, {"NamespaceID": 1, "HostID": 100000, "Size": 10}],
+					"OldGIDMappings": [{"NamespaceID": 0, "HostID": 2000, "Size": 1}, {"NamespaceID": 1, "HostID": 200000, "Size": 10}],
+					"NewUIDMappings": [{"NamespaceID": 0, "HostID": 3000, "Size": 1}, {"NamespaceID": 1, "HostID": 300000, "Size": 10}],
+					"NewGIDMappings": [{"NamespaceID": 0, "HostID": 4000, "Size": 1}, {"NamespaceID": 1, "HostID": 400000, "Size": 10}],
+					"CompletedVolumes": [],
+					"CurrentVolume": "sha256:vol-a",
+					"Processed": 2,
+					"PendingBatch": [{"Path": %q, "UID": 3000, "GID": 4000, "Mode": 2147484141}]
+				}`, volumePath)
+				Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, managerpkg.RemapJournalFilename), []byte(journal), 0600)).To(Succeed())
+			})
+
+			It("resumes from the journal", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				uid, gid := ownerOf(volumePath)
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{3000, 4000}))
+				uid, gid = ownerOf(filepath.Join(volumePath, "home", "file"))
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{300004, 400004}))
+				Expect(namespacer.ReadCallCount()).To(BeZero())
+			})
+
+			It("skips the entries that were already processed", func() {
+				Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(Succeed())
+
+				uid, gid := ownerOf(filepath.Join(volumePath, "home"))
+				Expect([]uint32{uid, gid}).To(Equal([]uint32{100004, 200004}))
+			})
+
+			Context("when it is resumed with different mappings", func() {
+				BeforeEach(func() {
+					remapSpec.UIDMappings[0].HostID = 5000
+				})
+
+				It("returns an error", func() {
+					Expect(manager.RemapStore(logger, remapSpec, locksmith)).To(MatchError(ContainSubstring("a remap to different mappings is in progress")))
+				})
 			})
 		})
 	})
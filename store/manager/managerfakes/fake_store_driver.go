@@ -48,6 +48,67 @@ type FakeStoreDriver struct {
 	initFilesystemReturnsOnCall map[int]struct {
 		result1 error
 	}
+	InitQuotaGroupsStub        func(logger lager.Logger, cacheLimitBytes int64) error
+	initQuotaGroupsMutex       sync.RWMutex
+	initQuotaGroupsArgsForCall []struct {
+		logger          lager.Logger
+		cacheLimitBytes int64
+	}
+	initQuotaGroupsReturns struct {
+		result1 error
+	}
+	initQuotaGroupsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	EmptyTrashStub        func(logger lager.Logger) error
+	emptyTrashMutex       sync.RWMutex
+	emptyTrashArgsForCall []struct {
+		logger lager.Logger
+	}
+	emptyTrashReturns struct {
+		result1 error
+	}
+	emptyTrashReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ResizeFilesystemStub        func(logger lager.Logger, sizeBytes int64) error
+	resizeFilesystemMutex       sync.RWMutex
+	resizeFilesystemArgsForCall []struct {
+		logger    lager.Logger
+		sizeBytes int64
+	}
+	resizeFilesystemReturns struct {
+		result1 error
+	}
+	resizeFilesystemReturnsOnCall map[int]struct {
+		result1 error
+	}
+	AllocatedBytesStub        func(logger lager.Logger) (int64, error)
+	allocatedBytesMutex       sync.RWMutex
+	allocatedBytesArgsForCall []struct {
+		logger lager.Logger
+	}
+	allocatedBytesReturns struct {
+		result1 int64
+		result2 error
+	}
+	allocatedBytesReturnsOnCall map[int]struct {
+		result1 int64
+		result2 error
+	}
+	MarshalStub        func(logger lager.Logger) ([]byte, error)
+	marshalMutex       sync.RWMutex
+	marshalArgsForCall []struct {
+		logger lager.Logger
+	}
+	marshalReturns struct {
+		result1 []byte
+		result2 error
+	}
+	marshalReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -202,6 +263,254 @@ func (fake *FakeStoreDriver) InitFilesystemReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeStoreDriver) InitQuotaGroups(logger lager.Logger, cacheLimitBytes int64) error {
+	fake.initQuotaGroupsMutex.Lock()
+	ret, specificReturn := fake.initQuotaGroupsReturnsOnCall[len(fake.initQuotaGroupsArgsForCall)]
+	fake.initQuotaGroupsArgsForCall = append(fake.initQuotaGroupsArgsForCall, struct {
+		logger          lager.Logger
+		cacheLimitBytes int64
+	}{logger, cacheLimitBytes})
+	fake.recordInvocation("InitQuotaGroups", []interface{}{logger, cacheLimitBytes})
+	fake.initQuotaGroupsMutex.Unlock()
+	if fake.InitQuotaGroupsStub != nil {
+		return fake.InitQuotaGroupsStub(logger, cacheLimitBytes)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.initQuotaGroupsReturns.result1
+}
+
+func (fake *FakeStoreDriver) InitQuotaGroupsCallCount() int {
+	fake.initQuotaGroupsMutex.RLock()
+	defer fake.initQuotaGroupsMutex.RUnlock()
+	return len(fake.initQuotaGroupsArgsForCall)
+}
+
+func (fake *FakeStoreDriver) InitQuotaGroupsArgsForCall(i int) (lager.Logger, int64) {
+	fake.initQuotaGroupsMutex.RLock()
+	defer fake.initQuotaGroupsMutex.RUnlock()
+	return fake.initQuotaGroupsArgsForCall[i].logger, fake.initQuotaGroupsArgsForCall[i].cacheLimitBytes
+}
+
+func (fake *FakeStoreDriver) InitQuotaGroupsReturns(result1 error) {
+	fake.InitQuotaGroupsStub = nil
+	fake.initQuotaGroupsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) InitQuotaGroupsReturnsOnCall(i int, result1 error) {
+	fake.InitQuotaGroupsStub = nil
+	if fake.initQuotaGroupsReturnsOnCall == nil {
+		fake.initQuotaGroupsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.initQuotaGroupsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) EmptyTrash(logger lager.Logger) error {
+	fake.emptyTrashMutex.Lock()
+	ret, specificReturn := fake.emptyTrashReturnsOnCall[len(fake.emptyTrashArgsForCall)]
+	fake.emptyTrashArgsForCall = append(fake.emptyTrashArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.recordInvocation("EmptyTrash", []interface{}{logger})
+	fake.emptyTrashMutex.Unlock()
+	if fake.EmptyTrashStub != nil {
+		return fake.EmptyTrashStub(logger)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.emptyTrashReturns.result1
+}
+
+func (fake *FakeStoreDriver) EmptyTrashCallCount() int {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return len(fake.emptyTrashArgsForCall)
+}
+
+func (fake *FakeStoreDriver) EmptyTrashArgsForCall(i int) lager.Logger {
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	return fake.emptyTrashArgsForCall[i].logger
+}
+
+func (fake *FakeStoreDriver) EmptyTrashReturns(result1 error) {
+	fake.EmptyTrashStub = nil
+	fake.emptyTrashReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) EmptyTrashReturnsOnCall(i int, result1 error) {
+	fake.EmptyTrashStub = nil
+	if fake.emptyTrashReturnsOnCall == nil {
+		fake.emptyTrashReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.emptyTrashReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) ResizeFilesystem(logger lager.Logger, sizeBytes int64) error {
+	fake.resizeFilesystemMutex.Lock()
+	ret, specificReturn := fake.resizeFilesystemReturnsOnCall[len(fake.resizeFilesystemArgsForCall)]
+	fake.resizeFilesystemArgsForCall = append(fake.resizeFilesystemArgsForCall, struct {
+		logger    lager.Logger
+		sizeBytes int64
+	}{logger, sizeBytes})
+	fake.recordInvocation("ResizeFilesystem", []interface{}{logger, sizeBytes})
+	fake.resizeFilesystemMutex.Unlock()
+	if fake.ResizeFilesystemStub != nil {
+		return fake.ResizeFilesystemStub(logger, sizeBytes)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.resizeFilesystemReturns.result1
+}
+
+func (fake *FakeStoreDriver) ResizeFilesystemCallCount() int {
+	fake.resizeFilesystemMutex.RLock()
+	defer fake.resizeFilesystemMutex.RUnlock()
+	return len(fake.resizeFilesystemArgsForCall)
+}
+
+func (fake *FakeStoreDriver) ResizeFilesystemArgsForCall(i int) (lager.Logger, int64) {
+	fake.resizeFilesystemMutex.RLock()
+	defer fake.resizeFilesystemMutex.RUnlock()
+	return fake.resizeFilesystemArgsForCall[i].logger, fake.resizeFilesystemArgsForCall[i].sizeBytes
+}
+
+func (fake *FakeStoreDriver) ResizeFilesystemReturns(result1 error) {
+	fake.ResizeFilesystemStub = nil
+	fake.resizeFilesystemReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) ResizeFilesystemReturnsOnCall(i int, result1 error) {
+	fake.ResizeFilesystemStub = nil
+	if fake.resizeFilesystemReturnsOnCall == nil {
+		fake.resizeFilesystemReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.resizeFilesystemReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreDriver) AllocatedBytes(logger lager.Logger) (int64, error) {
+	fake.allocatedBytesMutex.Lock()
+	ret, specificReturn := fake.allocatedBytesReturnsOnCall[len(fake.allocatedBytesArgsForCall)]
+	fake.allocatedBytesArgsForCall = append(fake.allocatedBytesArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.recordInvocation("AllocatedBytes", []interface{}{logger})
+	fake.allocatedBytesMutex.Unlock()
+	if fake.AllocatedBytesStub != nil {
+		return fake.AllocatedBytesStub(logger)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.allocatedBytesReturns.result1, fake.allocatedBytesReturns.result2
+}
+
+func (fake *FakeStoreDriver) AllocatedBytesCallCount() int {
+	fake.allocatedBytesMutex.RLock()
+	defer fake.allocatedBytesMutex.RUnlock()
+	return len(fake.allocatedBytesArgsForCall)
+}
+
+func (fake *FakeStoreDriver) AllocatedBytesArgsForCall(i int) lager.Logger {
+	fake.allocatedBytesMutex.RLock()
+	defer fake.allocatedBytesMutex.RUnlock()
+	return fake.allocatedBytesArgsForCall[i].logger
+}
+
+func (fake *FakeStoreDriver) AllocatedBytesReturns(result1 int64, result2 error) {
+	fake.AllocatedBytesStub = nil
+	fake.allocatedBytesReturns = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStoreDriver) AllocatedBytesReturnsOnCall(i int, result1 int64, result2 error) {
+	fake.AllocatedBytesStub = nil
+	if fake.allocatedBytesReturnsOnCall == nil {
+		fake.allocatedBytesReturnsOnCall = make(map[int]struct {
+			result1 int64
+			result2 error
+		})
+	}
+	fake.allocatedBytesReturnsOnCall[i] = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStoreDriver) Marshal(logger lager.Logger) ([]byte, error) {
+	fake.marshalMutex.Lock()
+	ret, specificReturn := fake.marshalReturnsOnCall[len(fake.marshalArgsForCall)]
+	fake.marshalArgsForCall = append(fake.marshalArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.recordInvocation("Marshal", []interface{}{logger})
+	fake.marshalMutex.Unlock()
+	if fake.MarshalStub != nil {
+		return fake.MarshalStub(logger)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.marshalReturns.result1, fake.marshalReturns.result2
+}
+
+func (fake *FakeStoreDriver) MarshalCallCount() int {
+	fake.marshalMutex.RLock()
+	defer fake.marshalMutex.RUnlock()
+	return len(fake.marshalArgsForCall)
+}
+
+func (fake *FakeStoreDriver) MarshalArgsForCall(i int) lager.Logger {
+	fake.marshalMutex.RLock()
+	defer fake.marshalMutex.RUnlock()
+	return fake.marshalArgsForCall[i].logger
+}
+
+func (fake *FakeStoreDriver) MarshalReturns(result1 []byte, result2 error) {
+	fake.MarshalStub = nil
+	fake.marshalReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStoreDriver) MarshalReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.MarshalStub = nil
+	if fake.marshalReturnsOnCall == nil {
+		fake.marshalReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.marshalReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeStoreDriver) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -211,6 +520,16 @@ func (fake *FakeStoreDriver) Invocations() map[string][][]interface{} {
 	defer fake.validateFileSystemMutex.RUnlock()
 	fake.initFilesystemMutex.RLock()
 	defer fake.initFilesystemMutex.RUnlock()
+	fake.initQuotaGroupsMutex.RLock()
+	defer fake.initQuotaGroupsMutex.RUnlock()
+	fake.emptyTrashMutex.RLock()
+	defer fake.emptyTrashMutex.RUnlock()
+	fake.resizeFilesystemMutex.RLock()
+	defer fake.resizeFilesystemMutex.RUnlock()
+	fake.allocatedBytesMutex.RLock()
+	defer fake.allocatedBytesMutex.RUnlock()
+	fake.marshalMutex.RLock()
+	defer fake.marshalMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -9,11 +9,10 @@ import (
 )
 
 type FakeStoreNamespacer struct {
-	ApplyMappingsStub        func(uidMappings, gidMappings []groot.IDMappingSpec) error
+	ApplyMappingsStub        func(mappings groot.IDMappings) error
 	applyMappingsMutex       sync.RWMutex
 	applyMappingsArgsForCall []struct {
-		uidMappings []groot.IDMappingSpec
-		gidMappings []groot.IDMappingSpec
+		mappings groot.IDMappings
 	}
 	applyMappingsReturns struct {
 		result1 error
@@ -21,31 +20,43 @@ type FakeStoreNamespacer struct {
 	applyMappingsReturnsOnCall map[int]struct {
 		result1 error
 	}
+	ReadStub        func() (groot.IDMappings, error)
+	readMutex       sync.RWMutex
+	readArgsForCall []struct{}
+	readReturns     struct {
+		result1 groot.IDMappings
+		result2 error
+	}
+	readReturnsOnCall map[int]struct {
+		result1 groot.IDMappings
+		result2 error
+	}
+	RemapStub        func(uidMappings, gidMappings []groot.IDMappingSpec) error
+	remapMutex       sync.RWMutex
+	remapArgsForCall []struct {
+		uidMappings []groot.IDMappingSpec
+		gidMappings []groot.IDMappingSpec
+	}
+	remapReturns struct {
+		result1 error
+	}
+	remapReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeStoreNamespacer) ApplyMappings(uidMappings []groot.IDMappingSpec, gidMappings []groot.IDMappingSpec) error {
-	var uidMappingsCopy []groot.IDMappingSpec
-	if uidMappings != nil {
-		uidMappingsCopy = make([]groot.IDMappingSpec, len(uidMappings))
-		copy(uidMappingsCopy, uidMappings)
-	}
-	var gidMappingsCopy []groot.IDMappingSpec
-	if gidMappings != nil {
-		gidMappingsCopy = make([]groot.IDMappingSpec, len(gidMappings))
-		copy(gidMappingsCopy, gidMappings)
-	}
+func (fake *FakeStoreNamespacer) ApplyMappings(mappings groot.IDMappings) error {
 	fake.applyMappingsMutex.Lock()
 	ret, specificReturn := fake.applyMappingsReturnsOnCall[len(fake.applyMappingsArgsForCall)]
 	fake.applyMappingsArgsForCall = append(fake.applyMappingsArgsForCall, struct {
-		uidMappings []groot.IDMappingSpec
-		gidMappings []groot.IDMappingSpec
-	}{uidMappingsCopy, gidMappingsCopy})
-	fake.recordInvocation("ApplyMappings", []interface{}{uidMappingsCopy, gidMappingsCopy})
+		mappings groot.IDMappings
+	}{mappings})
+	fake.recordInvocation("ApplyMappings", []interface{}{mappings})
 	fake.applyMappingsMutex.Unlock()
 	if fake.ApplyMappingsStub != nil {
-		return fake.ApplyMappingsStub(uidMappings, gidMappings)
+		return fake.ApplyMappingsStub(mappings)
 	}
 	if specificReturn {
 		return ret.result1
@@ -59,10 +70,10 @@ func (fake *FakeStoreNamespacer) ApplyMappingsCallCount() int {
 	return len(fake.applyMappingsArgsForCall)
 }
 
-func (fake *FakeStoreNamespacer) ApplyMappingsArgsForCall(i int) ([]groot.IDMappingSpec, []groot.IDMappingSpec) {
+func (fake *FakeStoreNamespacer) ApplyMappingsArgsForCall(i int) groot.IDMappings {
 	fake.applyMappingsMutex.RLock()
 	defer fake.applyMappingsMutex.RUnlock()
-	return fake.applyMappingsArgsForCall[i].uidMappings, fake.applyMappingsArgsForCall[i].gidMappings
+	return fake.applyMappingsArgsForCall[i].mappings
 }
 
 func (fake *FakeStoreNamespacer) ApplyMappingsReturns(result1 error) {
@@ -84,11 +95,117 @@ func (fake *FakeStoreNamespacer) ApplyMappingsReturnsOnCall(i int, result1 error
 	}{result1}
 }
 
+func (fake *FakeStoreNamespacer) Read() (groot.IDMappings, error) {
+	fake.readMutex.Lock()
+	ret, specificReturn := fake.readReturnsOnCall[len(fake.readArgsForCall)]
+	fake.readArgsForCall = append(fake.readArgsForCall, struct{}{})
+	fake.recordInvocation("Read", []interface{}{})
+	fake.readMutex.Unlock()
+	if fake.ReadStub != nil {
+		return fake.ReadStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.readReturns.result1, fake.readReturns.result2
+}
+
+func (fake *FakeStoreNamespacer) ReadCallCount() int {
+	fake.readMutex.RLock()
+	defer fake.readMutex.RUnlock()
+	return len(fake.readArgsForCall)
+}
+
+func (fake *FakeStoreNamespacer) ReadReturns(result1 groot.IDMappings, result2 error) {
+	fake.ReadStub = nil
+	fake.readReturns = struct {
+		result1 groot.IDMappings
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStoreNamespacer) ReadReturnsOnCall(i int, result1 groot.IDMappings, result2 error) {
+	fake.ReadStub = nil
+	if fake.readReturnsOnCall == nil {
+		fake.readReturnsOnCall = make(map[int]struct {
+			result1 groot.IDMappings
+			result2 error
+		})
+	}
+	fake.readReturnsOnCall[i] = struct {
+		result1 groot.IDMappings
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStoreNamespacer) Remap(uidMappings []groot.IDMappingSpec, gidMappings []groot.IDMappingSpec) error {
+	var uidMappingsCopy []groot.IDMappingSpec
+	if uidMappings != nil {
+		uidMappingsCopy = make([]groot.IDMappingSpec, len(uidMappings))
+		copy(uidMappingsCopy, uidMappings)
+	}
+	var gidMappingsCopy []groot.IDMappingSpec
+	if gidMappings != nil {
+		gidMappingsCopy = make([]groot.IDMappingSpec, len(gidMappings))
+		copy(gidMappingsCopy, gidMappings)
+	}
+	fake.remapMutex.Lock()
+	ret, specificReturn := fake.remapReturnsOnCall[len(fake.remapArgsForCall)]
+	fake.remapArgsForCall = append(fake.remapArgsForCall, struct {
+		uidMappings []groot.IDMappingSpec
+		gidMappings []groot.IDMappingSpec
+	}{uidMappingsCopy, gidMappingsCopy})
+	fake.recordInvocation("Remap", []interface{}{uidMappingsCopy, gidMappingsCopy})
+	fake.remapMutex.Unlock()
+	if fake.RemapStub != nil {
+		return fake.RemapStub(uidMappings, gidMappings)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.remapReturns.result1
+}
+
+func (fake *FakeStoreNamespacer) RemapCallCount() int {
+	fake.remapMutex.RLock()
+	defer fake.remapMutex.RUnlock()
+	return len(fake.remapArgsForCall)
+}
+
+func (fake *FakeStoreNamespacer) RemapArgsForCall(i int) ([]groot.IDMappingSpec, []groot.IDMappingSpec) {
+	fake.remapMutex.RLock()
+	defer fake.remapMutex.RUnlock()
+	return fake.remapArgsForCall[i].uidMappings, fake.remapArgsForCall[i].gidMappings
+}
+
+func (fake *FakeStoreNamespacer) RemapReturns(result1 error) {
+	fake.RemapStub = nil
+	fake.remapReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStoreNamespacer) RemapReturnsOnCall(i int, result1 error) {
+	fake.RemapStub = nil
+	if fake.remapReturnsOnCall == nil {
+		fake.remapReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.remapReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeStoreNamespacer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.applyMappingsMutex.RLock()
 	defer fake.applyMappingsMutex.RUnlock()
+	fake.readMutex.RLock()
+	defer fake.readMutex.RUnlock()
+	fake.remapMutex.RLock()
+	defer fake.remapMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
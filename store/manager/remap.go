@@ -0,0 +1,354 @@
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	RemapJournalFilename = "remap-journal.json"
+	remapBatchSize       = 1024
+)
+
+// remapJournal tracks the progress of a remap so that it can be resumed after
+// a crash. Ownership changes are applied in batches: every batch is written
+// to the journal with the absolute owner of each entry before any of them is
+// changed, so replaying a batch is always safe.
+type remapJournal struct {
+	OldUIDMappings []groot.IDMappingSpec
+	OldGIDMappings []groot.IDMappingSpec
+	NewUIDMappings []groot.IDMappingSpec
+	NewGIDMappings []groot.IDMappingSpec
+	// OverflowUID and OverflowGID are the store's, remapping keeps them
+	OverflowUID int
+	OverflowGID int
+
+	CompletedVolumes []string
+	CurrentVolume    string
+	Processed        int
+	PendingBatch     []remapEntry
+}
+
+type remapEntry struct {
+	Path string
+	UID  int
+	GID  int
+	Mode os.FileMode
+}
+
+type inode struct {
+	Dev uint64
+	Ino uint64
+}
+
+type RemapSpec struct {
+	UIDMappings []groot.IDMappingSpec
+	GIDMappings []groot.IDMappingSpec
+}
+
+// RemapStore shifts the ownership of every volume, and of the store itself,
+// from the current store mappings to the given ones and then replaces the
+// store mappings. Running it again after a failure resumes from the journal.
+func (m *Manager) RemapStore(logger lager.Logger, spec RemapSpec, locksmith groot.Locksmith) error {
+	logger = logger.Session("store-manager-remap-store", lager.Data{"storePath": m.storePath, "spec": spec})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if err := groot.ValidateIDMappings(spec.UIDMappings); err != nil {
+		return errorspkg.Errorf("invalid uid mappings: %s", err)
+	}
+
+	if err := groot.ValidateIDMappings(spec.GIDMappings); err != nil {
+		return errorspkg.Errorf("invalid gid mappings: %s", err)
+	}
+
+	fileLock, err := locksmith.Lock(groot.GlobalLockKey)
+	if err != nil {
+		logger.Error("locking-failed", err)
+		return errorspkg.Wrap(err, "failed to lock store")
+	}
+	defer locksmith.Unlock(fileLock)
+
+	images, err := m.images()
+	if err != nil {
+		return err
+	}
+	if len(images) > 0 {
+		return errorspkg.Errorf("store has %d images, they must be deleted before remapping the store", len(images))
+	}
+
+	journal, err := m.loadRemapJournal(logger, spec)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := m.volumes()
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range volumes {
+		if containsString(journal.CompletedVolumes, volume) {
+			continue
+		}
+
+		volumePath := filepath.Join(m.storePath, store.VolumesDirName, volume)
+		if err := m.remapTree(logger, journal, volume, volumePath, nil, nil); err != nil {
+			logger.Error("remapping-volume-failed", err, lager.Data{"volume": volume})
+			return errorspkg.Wrapf(err, "remapping volume %s", volume)
+		}
+	}
+
+	skipDirs := []string{
+		filepath.Join(m.storePath, store.VolumesDirName),
+		filepath.Join(m.storePath, store.ImageDirName),
+	}
+	// the trash holds read-only subvolumes waiting to be destroyed, and the
+	// locks and temporary files belong to the processes using them, so only
+	// the directories themselves are remapped
+	shallowDirs := []string{
+		filepath.Join(m.storePath, store.TrashDirName),
+		filepath.Join(m.storePath, store.LocksDirName),
+		filepath.Join(m.storePath, store.TempDirName),
+	}
+	if err := m.remapTree(logger, journal, "", m.storePath, skipDirs, shallowDirs); err != nil {
+		logger.Error("remapping-store-failed", err)
+		return errorspkg.Wrap(err, "remapping store")
+	}
+
+	if err := m.storeNamespacer.Remap(spec.UIDMappings, spec.GIDMappings); err != nil {
+		logger.Error("rewriting-namespace-failed", err)
+		return errorspkg.Wrap(err, "rewriting namespace")
+	}
+
+	if err := os.Remove(m.remapJournalPath()); err != nil {
+		return errorspkg.Wrap(err, "removing remap journal")
+	}
+
+	return nil
+}
+
+func (m *Manager) loadRemapJournal(logger lager.Logger, spec RemapSpec) (*remapJournal, error) {
+	contents, err := ioutil.ReadFile(m.remapJournalPath())
+	if err == nil {
+		var journal remapJournal
+		if err := json.Unmarshal(contents, &journal); err != nil {
+			return nil, errorspkg.Wrap(err, "reading remap journal")
+		}
+
+		if !reflect.DeepEqual(journal.NewUIDMappings, spec.UIDMappings) || !reflect.DeepEqual(journal.NewGIDMappings, spec.GIDMappings) {
+			return nil, errorspkg.New("a remap to different mappings is in progress, it must be resumed with the same mappings")
+		}
+
+		logger.Info("resuming-remap", lager.Data{"completedVolumes": len(journal.CompletedVolumes), "currentVolume": journal.CurrentVolume})
+		return &journal, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, errorspkg.Wrap(err, "reading remap journal")
+	}
+
+	currentMappings, err := m.storeNamespacer.Read()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading store mappings")
+	}
+
+	journal := &remapJournal{
+		OldUIDMappings:   currentMappings.UIDMappings,
+		OldGIDMappings:   currentMappings.GIDMappings,
+		NewUIDMappings:   spec.UIDMappings,
+		NewGIDMappings:   spec.GIDMappings,
+		OverflowUID:      currentMappings.OverflowUID,
+		OverflowGID:      currentMappings.OverflowGID,
+		CompletedVolumes: []string{},
+	}
+
+	return journal, m.writeRemapJournal(journal)
+}
+
+// remapTree shifts the ownership of everything under path. The walk order is
+// stable, so the number of processed entries is enough to resume it. Volumes
+// are read-only once complete, so they are made writable for the duration.
+func (m *Manager) remapTree(logger lager.Logger, journal *remapJournal, volume, path string, skipDirs, shallowDirs []string) error {
+	if volume != "" {
+		if err := m.volumeDriver.SetVolumeReadOnly(logger, volume, false); err != nil {
+			return errorspkg.Wrap(err, "making volume writable")
+		}
+	}
+
+	if journal.CurrentVolume != volume {
+		journal.CurrentVolume = volume
+		journal.Processed = 0
+	} else if err := applyRemapBatch(journal.PendingBatch); err != nil {
+		return errorspkg.Wrap(err, "replaying remap journal")
+	}
+
+	oldUIDs := groot.NewIDMappingTable(journal.OldUIDMappings, journal.OverflowUID)
+	oldGIDs := groot.NewIDMappingTable(journal.OldGIDMappings, journal.OverflowGID)
+	newUIDs := groot.NewIDMappingTable(journal.NewUIDMappings, journal.OverflowUID)
+	newGIDs := groot.NewIDMappingTable(journal.NewGIDMappings, journal.OverflowGID)
+
+	alreadyProcessed := journal.Processed
+	walked := 0
+	batch := []remapEntry{}
+	// the links of a file share its owner, shifting it once per link would
+	// shift it again once the first batch with it is applied
+	visitedInodes := map[inode]bool{}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		journal.Processed = walked
+		journal.PendingBatch = batch
+		if err := m.writeRemapJournal(journal); err != nil {
+			return err
+		}
+
+		if err := applyRemapBatch(batch); err != nil {
+			return err
+		}
+		batch = []remapEntry{}
+		return nil
+	}
+
+	err := filepath.Walk(path, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && containsString(skipDirs, entryPath) {
+			return filepath.SkipDir
+		}
+
+		// the journal is rewritten while the store is walked
+		if strings.HasPrefix(info.Name(), RemapJournalFilename) {
+			return nil
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+		if !info.IsDir() && stat.Nlink > 1 {
+			key := inode{Dev: uint64(stat.Dev), Ino: stat.Ino}
+			if visitedInodes[key] {
+				return nil
+			}
+			visitedInodes[key] = true
+		}
+
+		walked++
+		if walked <= alreadyProcessed {
+			return nil
+		}
+
+		uid := shiftID(int(stat.Uid), oldUIDs, newUIDs)
+		gid := shiftID(int(stat.Gid), oldGIDs, newGIDs)
+		if uid != int(stat.Uid) || gid != int(stat.Gid) {
+			batch = append(batch, remapEntry{Path: entryPath, UID: uid, GID: gid, Mode: info.Mode()})
+		}
+
+		if len(batch) >= remapBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if info.IsDir() && containsString(shallowDirs, entryPath) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if volume != "" {
+		if !base_image_puller.IsIncompleteVolume(volume) {
+			if err := m.volumeDriver.SetVolumeReadOnly(logger, volume, true); err != nil {
+				return errorspkg.Wrap(err, "making volume read-only")
+			}
+		}
+		journal.CompletedVolumes = append(journal.CompletedVolumes, volume)
+	}
+	journal.CurrentVolume = ""
+	journal.Processed = 0
+	journal.PendingBatch = nil
+	logger.Debug("remapped-tree", lager.Data{"path": path, "entries": walked})
+
+	return m.writeRemapJournal(journal)
+}
+
+// shiftID translates a host ID through the old mappings into the namespace
+// and back out through the new ones. IDs the old mappings do not cover are
+// left untouched, the ones the new mappings do not cover are given the
+// overflow ID.
+func shiftID(hostID int, oldMappings, newMappings groot.IDMappingTable) int {
+	namespaceID, ok := oldMappings.NamespaceID(hostID)
+	if !ok {
+		return hostID
+	}
+
+	return newMappings.HostID(namespaceID)
+}
+
+func applyRemapBatch(batch []remapEntry) error {
+	for _, entry := range batch {
+		if err := os.Lchown(entry.Path, entry.UID, entry.GID); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errorspkg.Wrapf(err, "changing owner of %s", entry.Path)
+		}
+
+		// chown clears the setuid and setgid bits
+		if entry.Mode&os.ModeSymlink == 0 && entry.Mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			if err := os.Chmod(entry.Path, entry.Mode); err != nil {
+				return errorspkg.Wrapf(err, "restoring mode of %s", entry.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) writeRemapJournal(journal *remapJournal) error {
+	contents, err := json.Marshal(journal)
+	if err != nil {
+		return errorspkg.Wrap(err, "encoding remap journal")
+	}
+
+	if err := store.WriteFileAtomically(m.remapJournalPath(), contents, 0644); err != nil {
+		return errorspkg.Wrap(err, "writing remap journal")
+	}
+
+	return nil
+}
+
+func (m *Manager) remapJournalPath() string {
+	return filepath.Join(m.storePath, store.MetaDirName, RemapJournalFilename)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"code.cloudfoundry.org/commandrunner"
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/base_image_puller/unpacker"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store/filesystems/btrfs"
+	"github.com/SUSE/groot-btrfs/store/filesystems/spec"
+	"github.com/SUSE/groot-btrfs/store/image_cloner"
+	"github.com/containers/storage/pkg/reexec"
+	errorspkg "github.com/pkg/errors"
+	"github.com/tscolari/lagregator"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	var fail = func(logger lager.Logger, message string, err error) {
+		logger.Error(message, err)
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	reexec.Register("init-store-in-userns", func() {
+		cli.ErrWriter = os.Stdout
+		logger := lager.NewLogger("init-store-in-userns")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
+
+		if len(os.Args) != 3 {
+			fail(logger, "parsing-command", errorspkg.New("driver spec or init spec not specified"))
+		}
+
+		ctrlPipeR := os.NewFile(3, "/ctrl/pipe")
+		buffer := make([]byte, 1)
+		logger.Debug("waiting-for-control-pipe")
+		if _, err := ctrlPipeR.Read(buffer); err != nil {
+			fail(logger, "reading-control-pipe", err)
+		}
+		logger.Debug("got-back-from-control-pipe")
+
+		var driverSpec spec.DriverSpec
+		if err := json.Unmarshal([]byte(os.Args[1]), &driverSpec); err != nil {
+			fail(logger, "unmarshalling-driver-spec", err)
+		}
+
+		var initSpec InitSpec
+		if err := json.Unmarshal([]byte(os.Args[2]), &initSpec); err != nil {
+			fail(logger, "unmarshalling-init-spec", err)
+		}
+
+		driver, err := specToDriver(driverSpec)
+		if err != nil {
+			fail(logger, "creating-store-driver", err)
+		}
+		manager := New(driverSpec.StorePath, groot.NewStoreNamespacer(driverSpec.StorePath), driver, driver, driver)
+
+		// inside the namespace the store owner is the namespace root, which the
+		// mappings translate back to the mapped owner on the host
+		if err := manager.initStoreStructure(logger, initSpec, 0, 0); err != nil {
+			fail(logger, "initializing-store", err)
+		}
+	})
+}
+
+// InitStoreInUserNamespace initializes a store without requiring root. The
+// store path must already sit on a btrfs filesystem mounted with
+// user_subvol_rm_allowed, and the root mappings must point at the caller. The
+// store structure is then created from a user namespace built from the
+// mappings, so that the store ends up owned by the mapped root.
+func (m *Manager) InitStoreInUserNamespace(logger lager.Logger, spec InitSpec, idMapper unpacker.IDMapper, runner commandrunner.CommandRunner) error {
+	logger = logger.Session("store-manager-init-store-in-userns", lager.Data{"storePath": m.storePath, "spec": spec})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if spec.StoreSizeBytes > 0 {
+		return errorspkg.Errorf("store %s can only be created with a store size by Root user", m.storePath)
+	}
+
+	if err := m.storeDriver.ValidateFileSystem(logger, m.validationPath(logger, spec)); err != nil {
+		logger.Error("validating-store-path-failed", err)
+		return errorspkg.Wrap(err, "validating store path filesystem")
+	}
+
+	ownerUID, ownerGID := m.findStoreOwner(spec.UIDMappings, spec.GIDMappings)
+	if ownerUID != os.Getuid() || ownerGID != os.Getgid() {
+		err := errorspkg.Errorf("store owner %d:%d must be the current user %d:%d", ownerUID, ownerGID, os.Getuid(), os.Getgid())
+		logger.Error("validating-store-owner-failed", err)
+		return err
+	}
+
+	if len(spec.UIDMappings)+len(spec.GIDMappings) == 0 {
+		if err := m.initStoreStructure(logger, spec, ownerUID, ownerGID); err != nil {
+			return err
+		}
+
+		return m.initQuotaGroups(logger, spec)
+	}
+
+	driverJSON, err := m.storeDriver.Marshal(logger)
+	if err != nil {
+		return errorspkg.Wrap(err, "marshaling store driver")
+	}
+
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return errorspkg.Wrap(err, "marshaling init spec")
+	}
+
+	ctrlPipeR, ctrlPipeW, err := os.Pipe()
+	if err != nil {
+		return errorspkg.Wrap(err, "creating control pipe")
+	}
+	defer ctrlPipeR.Close()
+	defer ctrlPipeW.Close()
+
+	outputBuffer := bytes.NewBuffer([]byte{})
+	cmd := reexec.Command("init-store-in-userns", string(driverJSON), string(specJSON))
+	cmd.Stderr = lagregator.NewRelogger(logger)
+	cmd.Stdout = outputBuffer
+	cmd.ExtraFiles = []*os.File{ctrlPipeR}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER,
+	}
+
+	logger.Debug("starting-init-store-reexec", lager.Data{"args": cmd.Args})
+	if err := runner.Start(cmd); err != nil {
+		return errorspkg.Wrap(err, "reexecing init store")
+	}
+
+	if err := idMapper.MapUIDs(logger, cmd.Process.Pid, spec.UIDMappings); err != nil {
+		abortReexec(logger, runner, cmd, ctrlPipeW)
+		return errorspkg.Wrap(err, "mapping uids")
+	}
+
+	if err := idMapper.MapGIDs(logger, cmd.Process.Pid, spec.GIDMappings); err != nil {
+		abortReexec(logger, runner, cmd, ctrlPipeW)
+		return errorspkg.Wrap(err, "mapping gids")
+	}
+
+	if _, err := ctrlPipeW.Write([]byte{0}); err != nil {
+		abortReexec(logger, runner, cmd, ctrlPipeW)
+		return errorspkg.Wrap(err, "writing to control pipe")
+	}
+
+	if err := runner.Wait(cmd); err != nil {
+		logger.Error("init-store-reexec-failed", err, lager.Data{"output": outputBuffer.String()})
+		return errorspkg.Errorf("initializing store in user namespace: %s", bytes.TrimSpace(outputBuffer.Bytes()))
+	}
+
+	return m.initQuotaGroups(logger, spec)
+}
+
+// abortReexec stops a child that is still waiting on the control pipe. Closing
+// the pipe makes it exit without initializing anything, it is then waited on
+// so that it doesn't linger.
+func abortReexec(logger lager.Logger, runner commandrunner.CommandRunner, cmd *exec.Cmd, ctrlPipeW *os.File) {
+	_ = ctrlPipeW.Close()
+	if err := runner.Wait(cmd); err != nil {
+		logger.Debug("aborted-reexec-exited", lager.Data{"error": err.Error()})
+	}
+}
+
+type storeDrivers interface {
+	base_image_puller.VolumeDriver
+	image_cloner.ImageDriver
+	StoreDriver
+}
+
+func specToDriver(spec spec.DriverSpec) (storeDrivers, error) {
+	switch spec.Type {
+	case "btrfs":
+		return btrfs.NewDriver(
+			spec.FsBinaryPath,
+			spec.MkfsBinaryPath,
+			spec.SuidBinaryPath,
+			spec.StorePath).WithCompression(spec.Compression), nil
+	default:
+		return nil, errorspkg.Errorf("invalid filesystem spec: %s not recognized", spec.Type)
+	}
+}
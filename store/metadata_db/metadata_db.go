@@ -0,0 +1,692 @@
+package metadata_db // import "github.com/SUSE/groot-btrfs/store/metadata_db"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/dependency_manager"
+	"github.com/SUSE/groot-btrfs/store/usage_tracker"
+	errorspkg "github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	FileName = "metadata.db"
+
+	// DependenciesDirName and UsageDirName are where the dependencies and
+	// the usage were kept, one JSON file each, before the database.
+	DependenciesDirName = "dependencies"
+	UsageDirName        = "usage"
+
+	openTimeout = time.Minute
+)
+
+var (
+	imagesBucket          = []byte("images")
+	layersBucket          = []byte("layers")
+	referencesBucket      = []byte("references")
+	layerReferencesBucket = []byte("layer-references")
+	quarantinedBucket     = []byte("quarantined")
+	metaBucket            = []byte("meta")
+
+	jsonImportedKey = []byte("json-imported")
+
+	buckets = [][]byte{imagesBucket, layersBucket, referencesBucket, layerReferencesBucket, quarantinedBucket, metaBucket}
+)
+
+//go:generate counterfeiter . VolumeDriver
+
+type VolumeDriver interface {
+	Volumes(logger lager.Logger) ([]string, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+}
+
+type Image struct {
+	ID        string    `json:"id"`
+	ChainIDs  []string  `json:"chain_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Layer struct {
+	ChainID    string    `json:"chain_id"`
+	Size       int64     `json:"size"`
+	LastUsed   time.Time `json:"last_used"`
+	BaseImage  string    `json:"base_image"`
+	References []string  `json:"references"`
+}
+
+// MetadataDB keeps the images, layers and references of the store in a
+// single bolt database, so that every change to them is one transaction and
+// the images using a layer can be looked up without scanning the store.
+//
+// The database is opened read-only and kept open until Close. Bolt locks the
+// whole file while it is open, shared when it is opened read-only and
+// exclusively otherwise, so every update reopens it writable and closes it
+// again once it is committed. Commands close it before long operations that
+// don't need it, such as pulling.
+type MetadataDB struct {
+	path         string
+	metaPath     string
+	volumeDriver VolumeDriver
+
+	db *bolt.DB
+}
+
+// Open opens the database in metaPath, creating it and importing the
+// dependency and usage files found next to it the first time. As every
+// command opens it, that takes no store migration. It is left open, see
+// Close.
+func Open(logger lager.Logger, metaPath string, volumeDriver VolumeDriver) (*MetadataDB, error) {
+	logger = logger.Session("opening-metadata-db", lager.Data{"metaPath": metaPath})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	d := &MetadataDB{
+		path:         filepath.Join(metaPath, FileName),
+		metaPath:     metaPath,
+		volumeDriver: volumeDriver,
+	}
+
+	if _, err := os.Stat(d.path); err == nil {
+		initialized := false
+		if err := d.view(func(tx *bolt.Tx) error {
+			initialized = isInitialized(tx)
+			return nil
+		}); err != nil {
+			_ = d.Close()
+			return nil, err
+		}
+
+		if initialized {
+			return d, nil
+		}
+	}
+
+	err := d.update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errorspkg.Wrapf(err, "creating bucket `%s`", name)
+			}
+		}
+
+		if tx.Bucket(metaBucket).Get(jsonImportedKey) != nil {
+			return nil
+		}
+
+		if err := d.importJSON(logger, tx); err != nil {
+			return errorspkg.Wrap(err, "importing metadata files")
+		}
+
+		return tx.Bucket(metaBucket).Put(jsonImportedKey, []byte(time.Now().Format(time.RFC3339Nano)))
+	})
+	if err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func isInitialized(tx *bolt.Tx) bool {
+	for _, name := range buckets {
+		if tx.Bucket(name) == nil {
+			return false
+		}
+	}
+
+	return tx.Bucket(metaBucket).Get(jsonImportedKey) != nil
+}
+
+// Register replaces the chain IDs id depends on. Ids in the image reference
+// format are recorded as images too, keeping when they were first created.
+func (d *MetadataDB) Register(id string, chainIDs []string) error {
+	return d.update(func(tx *bolt.Tx) error {
+		return register(tx, id, chainIDs, time.Now())
+	})
+}
+
+// Deregister drops the dependencies of id, or the record of their quarantine.
+// It fails with an error whose cause satisfies os.IsNotExist when nothing is
+// registered for id.
+func (d *MetadataDB) Deregister(id string) error {
+	return d.update(func(tx *bolt.Tx) error {
+		references := tx.Bucket(referencesBucket)
+		chainIDs, err := decodeChainIDs(references.Get([]byte(id)))
+		if err != nil {
+			return errorspkg.Wrapf(err, "decoding dependencies of `%s`", id)
+		}
+
+		quarantined := tx.Bucket(quarantinedBucket)
+		if chainIDs == nil && quarantined.Get([]byte(id)) == nil {
+			return errorspkg.Wrapf(os.ErrNotExist, "deregistering `%s`", id)
+		}
+		if err := quarantined.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		if err := unindex(tx, id, chainIDs); err != nil {
+			return err
+		}
+
+		if imageID, ok := imageIDFromReference(id); ok {
+			if err := tx.Bucket(imagesBucket).Delete([]byte(imageID)); err != nil {
+				return err
+			}
+		}
+
+		return references.Delete([]byte(id))
+	})
+}
+
+func (d *MetadataDB) Dependencies(id string) ([]string, error) {
+	var chainIDs []string
+	err := d.view(func(tx *bolt.Tx) error {
+		var err error
+		chainIDs, err = decodeChainIDs(tx.Bucket(referencesBucket).Get([]byte(id)))
+		return err
+	})
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "reading dependencies of `%s`", id)
+	}
+	if chainIDs == nil {
+		return nil, errorspkg.Errorf("image `%s` not found", id)
+	}
+
+	return chainIDs, nil
+}
+
+// Quarantined returns the ids whose dependency files were corrupt when they
+// were imported, with where the files were moved to. Nothing is registered
+// for them, so their images keep every volume from being collected until
+// they are deleted.
+func (d *MetadataDB) Quarantined() (map[string]string, error) {
+	quarantined := map[string]string{}
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(quarantinedBucket).ForEach(func(key, value []byte) error {
+			quarantined[string(key)] = string(value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading quarantined dependencies")
+	}
+
+	return quarantined, nil
+}
+
+// IDs lists the ids that have dependencies registered.
+func (d *MetadataDB) IDs() ([]string, error) {
+	ids := []string{}
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(referencesBucket).ForEach(func(key, _ []byte) error {
+			ids = append(ids, string(key))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading dependencies")
+	}
+
+	return ids, nil
+}
+
+// References returns the ids that depend on the layer chainID.
+func (d *MetadataDB) References(chainID string) ([]string, error) {
+	ids := []string{}
+	err := d.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(layerReferencesBucket).Bucket([]byte(chainID))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, _ []byte) error {
+			ids = append(ids, string(key))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "reading references of `%s`", chainID)
+	}
+
+	return ids, nil
+}
+
+// Images returns every image, sorted by id.
+func (d *MetadataDB) Images() ([]Image, error) {
+	images := []Image{}
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(key, value []byte) error {
+			var image Image
+			if err := json.Unmarshal(value, &image); err != nil {
+				return errorspkg.Wrapf(err, "decoding image `%s`", key)
+			}
+			images = append(images, image)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading images")
+	}
+
+	return images, nil
+}
+
+// Layers returns every layer that has been used or is referenced, sorted by
+// chain ID.
+func (d *MetadataDB) Layers() ([]Layer, error) {
+	layers := []Layer{}
+	err := d.view(func(tx *bolt.Tx) error {
+		chainIDs := map[string]bool{}
+		collect := func(key, _ []byte) error {
+			chainIDs[string(key)] = true
+			return nil
+		}
+		if err := tx.Bucket(layersBucket).ForEach(collect); err != nil {
+			return err
+		}
+		if err := tx.Bucket(layerReferencesBucket).ForEach(collect); err != nil {
+			return err
+		}
+
+		for chainID := range chainIDs {
+			layer, err := getLayer(tx, chainID)
+			if err != nil {
+				return err
+			}
+
+			layer.References = []string{}
+			if bucket := tx.Bucket(layerReferencesBucket).Bucket([]byte(chainID)); bucket != nil {
+				if err := bucket.ForEach(func(key, _ []byte) error {
+					layer.References = append(layer.References, string(key))
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+
+			layers = append(layers, layer)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading layers")
+	}
+
+	sort.Slice(layers, func(i, j int) bool { return layers[i].ChainID < layers[j].ChainID })
+	return layers, nil
+}
+
+// RegisterImage registers the chain IDs the image id depends on and stamps
+// the layers with the current time and baseImage, in one transaction. The
+// size of a layer is looked up the first time it is used, as layers don't
+// change once pulled.
+func (d *MetadataDB) RegisterImage(logger lager.Logger, id string, chainIDs []string, baseImage string) error {
+	logger = logger.Session("registering-image", lager.Data{"id": id, "chainIDs": chainIDs, "baseImage": baseImage})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	now := time.Now()
+	return d.update(func(tx *bolt.Tx) error {
+		if err := register(tx, id, chainIDs, now); err != nil {
+			return err
+		}
+
+		return d.recordUsage(logger, tx, chainIDs, baseImage, now)
+	})
+}
+
+func (d *MetadataDB) recordUsage(logger lager.Logger, tx *bolt.Tx, chainIDs []string, baseImage string, now time.Time) error {
+	for _, chainID := range chainIDs {
+		layer, err := getLayer(tx, chainID)
+		if err != nil {
+			return err
+		}
+
+		if layer.Size == 0 {
+			layer.Size = d.layerSize(logger, chainID)
+		}
+		layer.LastUsed = now
+		layer.BaseImage = baseImage
+
+		if err := putLayer(tx, layer); err != nil {
+			return errorspkg.Wrapf(err, "writing usage of `%s`", chainID)
+		}
+	}
+
+	return nil
+}
+
+// Usage returns the usage of every layer that has been used since it was
+// created.
+func (d *MetadataDB) Usage(logger lager.Logger) (map[string]groot.VolumeUsage, error) {
+	logger = logger.Session("reading-usage")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	usage := map[string]groot.VolumeUsage{}
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(layersBucket).ForEach(func(key, value []byte) error {
+			var layer Layer
+			if err := json.Unmarshal(value, &layer); err != nil {
+				logger.Error("decoding-layer-failed", err, lager.Data{"chainID": string(key)})
+				return nil
+			}
+
+			if !layer.LastUsed.IsZero() {
+				usage[string(key)] = groot.VolumeUsage{LastUsed: layer.LastUsed, BaseImage: layer.BaseImage}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading usage")
+	}
+
+	return usage, nil
+}
+
+// Forget drops a layer that no longer exists.
+func (d *MetadataDB) Forget(chainID string) error {
+	err := d.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(layersBucket).Delete([]byte(chainID)); err != nil {
+			return err
+		}
+
+		err := tx.Bucket(layerReferencesBucket).DeleteBucket([]byte(chainID))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return errorspkg.Wrapf(err, "forgetting `%s`", chainID)
+	}
+
+	return nil
+}
+
+func (d *MetadataDB) importJSON(logger lager.Logger, tx *bolt.Tx) error {
+	logger = logger.Session("importing-metadata-files")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	dependenciesPath := filepath.Join(d.metaPath, DependenciesDirName)
+	dependencyManager := dependency_manager.NewDependencyManager(dependenciesPath)
+	entries, err := ioutil.ReadDir(dependenciesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errorspkg.Wrap(err, "reading dependencies")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		id := strings.Replace(strings.TrimSuffix(entry.Name(), ".json"), "__", "/", -1)
+		chainIDs, err := dependencyManager.Dependencies(id)
+		if store.IsCorruptMetadata(err) {
+			// recorded with the rest of the quarantine below
+			logger.Error("corrupt-dependencies", err, lager.Data{"id": id})
+			continue
+		}
+		if err != nil {
+			return errorspkg.Wrapf(err, "importing dependencies of `%s`", id)
+		}
+
+		if err := register(tx, id, chainIDs, entry.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	if err := importQuarantine(tx, filepath.Join(dependenciesPath, store.QuarantineDirName)); err != nil {
+		return err
+	}
+
+	usage, err := usage_tracker.NewUsageTracker(filepath.Join(d.metaPath, UsageDirName)).Usage(logger)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := d.volumeDriver.Volumes(logger)
+	if err != nil {
+		return errorspkg.Wrap(err, "listing volumes")
+	}
+
+	for _, chainID := range volumes {
+		if strings.HasPrefix(chainID, "gc.") || base_image_puller.IsIncompleteVolume(chainID) {
+			continue
+		}
+
+		layer := Layer{
+			ChainID:   chainID,
+			Size:      d.layerSize(logger, chainID),
+			LastUsed:  usage[chainID].LastUsed,
+			BaseImage: usage[chainID].BaseImage,
+		}
+		if err := putLayer(tx, layer); err != nil {
+			return errorspkg.Wrapf(err, "importing layer `%s`", chainID)
+		}
+	}
+
+	return nil
+}
+
+// importQuarantine records the dependency files that were found corrupt,
+// including by imports that failed afterwards, unless their id was
+// registered again since.
+func importQuarantine(tx *bolt.Tx, quarantinePath string) error {
+	entries, err := ioutil.ReadDir(quarantinePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errorspkg.Wrap(err, "reading quarantined dependencies")
+	}
+
+	for _, entry := range entries {
+		// quarantined files are named <file>.<unix nano>
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		id := strings.Replace(strings.TrimSuffix(name, ".json"), "__", "/", -1)
+		if tx.Bucket(referencesBucket).Get([]byte(id)) != nil {
+			continue
+		}
+
+		if err := tx.Bucket(quarantinedBucket).Put([]byte(id), []byte(filepath.Join(quarantinePath, entry.Name()))); err != nil {
+			return errorspkg.Wrapf(err, "recording quarantined dependencies of `%s`", id)
+		}
+	}
+
+	return nil
+}
+
+// layerSize is best effort, a missing size only makes the layer look free.
+func (d *MetadataDB) layerSize(logger lager.Logger, chainID string) int64 {
+	meta, err := d.volumeDriver.VolumeMeta(logger, chainID)
+	if err != nil {
+		logger.Error("reading-volume-meta-failed", err, lager.Data{"chainID": chainID})
+		return 0
+	}
+
+	return meta.Size
+}
+
+// Close closes the database. It is opened again by the next call that needs
+// it.
+func (d *MetadataDB) Close() error {
+	if d.db == nil {
+		return nil
+	}
+
+	err := d.db.Close()
+	d.db = nil
+	return errorspkg.Wrap(err, "closing metadata database")
+}
+
+// update closes the database once the transaction is committed, so that the
+// exclusive lock is not held while the command goes on, e.g. emptying the
+// trash after collecting, and blocks everybody else registering images.
+func (d *MetadataDB) update(fn func(tx *bolt.Tx) error) error {
+	db, err := d.open(false)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Update(fn); err != nil {
+		_ = d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+func (d *MetadataDB) view(fn func(tx *bolt.Tx) error) error {
+	db, err := d.open(true)
+	if err != nil {
+		return err
+	}
+
+	return db.View(fn)
+}
+
+// open returns the open database, reopening it writable when it was opened
+// read-only and readOnly is not set.
+func (d *MetadataDB) open(readOnly bool) (*bolt.DB, error) {
+	if d.db != nil && (readOnly || !d.db.IsReadOnly()) {
+		return d.db, nil
+	}
+
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(d.path, 0644, &bolt.Options{Timeout: openTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "opening metadata database")
+	}
+
+	d.db = db
+	return db, nil
+}
+
+func register(tx *bolt.Tx, id string, chainIDs []string, createdAt time.Time) error {
+	references := tx.Bucket(referencesBucket)
+	previous, err := decodeChainIDs(references.Get([]byte(id)))
+	if err != nil {
+		return errorspkg.Wrapf(err, "decoding dependencies of `%s`", id)
+	}
+	if err := unindex(tx, id, previous); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(chainIDs)
+	if err != nil {
+		return err
+	}
+	if err := references.Put([]byte(id), data); err != nil {
+		return errorspkg.Wrapf(err, "registering `%s`", id)
+	}
+	if err := tx.Bucket(quarantinedBucket).Delete([]byte(id)); err != nil {
+		return errorspkg.Wrapf(err, "registering `%s`", id)
+	}
+
+	for _, chainID := range chainIDs {
+		bucket, err := tx.Bucket(layerReferencesBucket).CreateBucketIfNotExists([]byte(chainID))
+		if err != nil {
+			return errorspkg.Wrapf(err, "indexing `%s`", chainID)
+		}
+		if err := bucket.Put([]byte(id), []byte{}); err != nil {
+			return errorspkg.Wrapf(err, "indexing `%s`", chainID)
+		}
+	}
+
+	imageID, ok := imageIDFromReference(id)
+	if !ok {
+		return nil
+	}
+
+	images := tx.Bucket(imagesBucket)
+	image := Image{ID: imageID, CreatedAt: createdAt}
+	if value := images.Get([]byte(imageID)); value != nil {
+		if err := json.Unmarshal(value, &image); err != nil {
+			return errorspkg.Wrapf(err, "decoding image `%s`", imageID)
+		}
+	}
+	image.ChainIDs = chainIDs
+
+	data, err = json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	return images.Put([]byte(imageID), data)
+}
+
+func unindex(tx *bolt.Tx, id string, chainIDs []string) error {
+	for _, chainID := range chainIDs {
+		bucket := tx.Bucket(layerReferencesBucket).Bucket([]byte(chainID))
+		if bucket == nil {
+			continue
+		}
+
+		if err := bucket.Delete([]byte(id)); err != nil {
+			return errorspkg.Wrapf(err, "unindexing `%s`", chainID)
+		}
+	}
+
+	return nil
+}
+
+func getLayer(tx *bolt.Tx, chainID string) (Layer, error) {
+	layer := Layer{ChainID: chainID}
+	value := tx.Bucket(layersBucket).Get([]byte(chainID))
+	if value == nil {
+		return layer, nil
+	}
+
+	if err := json.Unmarshal(value, &layer); err != nil {
+		return Layer{}, errorspkg.Wrapf(err, "decoding layer `%s`", chainID)
+	}
+	return layer, nil
+}
+
+func putLayer(tx *bolt.Tx, layer Layer) error {
+	layer.References = nil
+	data, err := json.Marshal(layer)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(layersBucket).Put([]byte(layer.ChainID), data)
+}
+
+func decodeChainIDs(value []byte) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	chainIDs := []string{}
+	if err := json.Unmarshal(value, &chainIDs); err != nil {
+		return nil, err
+	}
+	return chainIDs, nil
+}
+
+func imageIDFromReference(id string) (string, bool) {
+	prefix := fmt.Sprintf(groot.ImageReferenceFormat, "")
+	if !strings.HasPrefix(id, prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(id, prefix), true
+}
@@ -0,0 +1,13 @@
+package metadata_db_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestMetadataDB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MetadataDB Suite")
+}
@@ -0,0 +1,360 @@
+package metadata_db_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/store/dependency_manager"
+	"github.com/SUSE/groot-btrfs/store/metadata_db"
+	"github.com/SUSE/groot-btrfs/store/metadata_db/metadata_dbfakes"
+	"github.com/SUSE/groot-btrfs/store/usage_tracker"
+	errorspkg "github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MetadataDB", func() {
+	var (
+		metaPath         string
+		logger           lager.Logger
+		fakeVolumeDriver *metadata_dbfakes.FakeVolumeDriver
+		db               *metadata_db.MetadataDB
+		openErr          error
+	)
+
+	BeforeEach(func() {
+		var err error
+		metaPath, err = ioutil.TempDir("", "meta")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("metadata-db")
+		fakeVolumeDriver = new(metadata_dbfakes.FakeVolumeDriver)
+		fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{Size: 1024}, nil)
+	})
+
+	JustBeforeEach(func() {
+		db, openErr = metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			Expect(db.Close()).To(Succeed())
+		}
+		Expect(os.RemoveAll(metaPath)).To(Succeed())
+	})
+
+	It("keeps the database in the meta directory", func() {
+		Expect(openErr).NotTo(HaveOccurred())
+		Expect(filepath.Join(metaPath, metadata_db.FileName)).To(BeAnExistingFile())
+	})
+
+	Describe("Close", func() {
+		It("lets others open the database", func() {
+			Expect(db.Register("image:my-image", []string{"layer-1"})).To(Succeed())
+			Expect(db.Close()).To(Succeed())
+
+			other, err := metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other.Register("image:other-image", []string{"layer-1"})).To(Succeed())
+			Expect(other.Close()).To(Succeed())
+
+			Expect(db.IDs()).To(ConsistOf("image:my-image", "image:other-image"))
+		})
+	})
+
+	Context("when the database is updated", func() {
+		It("lets others update it once the update is committed", func() {
+			Expect(db.Register("image:my-image", []string{"layer-1"})).To(Succeed())
+
+			other, err := metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other.Register("image:other-image", []string{"layer-1"})).To(Succeed())
+			Expect(other.Close()).To(Succeed())
+
+			Expect(db.IDs()).To(ConsistOf("image:my-image", "image:other-image"))
+		})
+	})
+
+	Context("when the database was opened before", func() {
+		JustBeforeEach(func() {
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("opens it read-only until it is updated", func() {
+			var err error
+			db, err = metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+			Expect(err).NotTo(HaveOccurred())
+
+			reader, err := metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.IDs()).To(BeEmpty())
+			Expect(reader.Close()).To(Succeed())
+		})
+	})
+
+	Describe("Register", func() {
+		It("registers the dependencies", func() {
+			Expect(db.Register("image:my-image", []string{"layer-1", "layer-2"})).To(Succeed())
+
+			chainIDs, err := db.Dependencies("image:my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chainIDs).To(Equal([]string{"layer-1", "layer-2"}))
+			Expect(db.IDs()).To(Equal([]string{"image:my-image"}))
+		})
+
+		It("records images", func() {
+			before := time.Now()
+			Expect(db.Register("image:my-image", []string{"layer-1"})).To(Succeed())
+			Expect(db.Register("pin:docker:///ubuntu", []string{"layer-1"})).To(Succeed())
+
+			images, err := db.Images()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images).To(HaveLen(1))
+			Expect(images[0].ID).To(Equal("my-image"))
+			Expect(images[0].ChainIDs).To(Equal([]string{"layer-1"}))
+			Expect(images[0].CreatedAt).To(BeTemporally(">=", before))
+		})
+
+		It("indexes which ids use a layer", func() {
+			Expect(db.Register("image:my-image", []string{"layer-1", "layer-2"})).To(Succeed())
+			Expect(db.Register("pin:docker:///ubuntu", []string{"layer-1"})).To(Succeed())
+
+			Expect(db.References("layer-1")).To(ConsistOf("image:my-image", "pin:docker:///ubuntu"))
+			Expect(db.References("layer-2")).To(ConsistOf("image:my-image"))
+			Expect(db.References("layer-3")).To(BeEmpty())
+		})
+
+		Context("when the id is already registered", func() {
+			It("replaces its dependencies and keeps when the image was created", func() {
+				Expect(db.Register("image:my-image", []string{"layer-1", "layer-2"})).To(Succeed())
+				images, err := db.Images()
+				Expect(err).NotTo(HaveOccurred())
+				createdAt := images[0].CreatedAt
+
+				Expect(db.Register("image:my-image", []string{"layer-1"})).To(Succeed())
+
+				Expect(db.Dependencies("image:my-image")).To(Equal([]string{"layer-1"}))
+				Expect(db.References("layer-2")).To(BeEmpty())
+
+				images, err = db.Images()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(images[0].CreatedAt).To(BeTemporally("==", createdAt))
+			})
+		})
+	})
+
+	Describe("Deregister", func() {
+		It("drops the dependencies, the image and its references", func() {
+			Expect(db.Register("image:my-image", []string{"layer-1"})).To(Succeed())
+			Expect(db.Deregister("image:my-image")).To(Succeed())
+
+			_, err := db.Dependencies("image:my-image")
+			Expect(err).To(MatchError(ContainSubstring("image `image:my-image` not found")))
+			Expect(db.IDs()).To(BeEmpty())
+			Expect(db.Images()).To(BeEmpty())
+			Expect(db.References("layer-1")).To(BeEmpty())
+		})
+
+		Context("when the id is not registered", func() {
+			It("returns a not exist error", func() {
+				err := db.Deregister("image:not-here")
+				Expect(os.IsNotExist(errorspkg.Cause(err))).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("RegisterImage", func() {
+		It("registers the dependencies of the image", func() {
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1", "layer-2"}, "docker:///ubuntu")).To(Succeed())
+
+			chainIDs, err := db.Dependencies("image:my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chainIDs).To(Equal([]string{"layer-1", "layer-2"}))
+			Expect(db.References("layer-2")).To(Equal([]string{"image:my-image"}))
+		})
+
+		It("records when each layer was used, for which base image and its size", func() {
+			before := time.Now()
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1", "layer-2"}, "docker:///ubuntu")).To(Succeed())
+
+			usage, err := db.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(2))
+			Expect(usage["layer-1"].BaseImage).To(Equal("docker:///ubuntu"))
+			Expect(usage["layer-2"].LastUsed).To(BeTemporally(">=", before))
+
+			layers, err := db.Layers()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layers).To(HaveLen(2))
+			Expect(layers[0].ChainID).To(Equal("layer-1"))
+			Expect(layers[0].Size).To(Equal(int64(1024)))
+		})
+
+		It("only looks the size up once", func() {
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1"}, "docker:///ubuntu")).To(Succeed())
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1"}, "docker:///debian")).To(Succeed())
+
+			Expect(fakeVolumeDriver.VolumeMetaCallCount()).To(Equal(1))
+			usage, err := db.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage["layer-1"].BaseImage).To(Equal("docker:///debian"))
+		})
+
+		Context("when the volume metadata can't be read", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumeMetaReturns(base_image_puller.VolumeMeta{}, errors.New("no meta"))
+			})
+
+			It("still records the usage", func() {
+				Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1"}, "docker:///ubuntu")).To(Succeed())
+
+				usage, err := db.Usage(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage).To(HaveKey("layer-1"))
+			})
+		})
+	})
+
+	Describe("Layers", func() {
+		It("includes the ids that use each layer", func() {
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1"}, "docker:///ubuntu")).To(Succeed())
+
+			layers, err := db.Layers()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layers).To(HaveLen(1))
+			Expect(layers[0].References).To(Equal([]string{"image:my-image"}))
+			Expect(layers[0].BaseImage).To(Equal("docker:///ubuntu"))
+		})
+	})
+
+	Describe("Forget", func() {
+		It("drops the layer", func() {
+			Expect(db.RegisterImage(logger, "image:my-image", []string{"layer-1", "layer-2"}, "docker:///ubuntu")).To(Succeed())
+			Expect(db.Forget("layer-1")).To(Succeed())
+
+			usage, err := db.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(1))
+			Expect(usage).To(HaveKey("layer-2"))
+		})
+
+		It("doesn't fail when the layer is not known", func() {
+			Expect(db.Forget("layer-1")).To(Succeed())
+		})
+	})
+
+	Context("when the store has metadata files from before the database", func() {
+		var lastUsed time.Time
+
+		BeforeEach(func() {
+			dependencyManager := dependency_manager.NewDependencyManager(filepath.Join(metaPath, metadata_db.DependenciesDirName))
+			Expect(os.MkdirAll(filepath.Join(metaPath, metadata_db.DependenciesDirName), 0755)).To(Succeed())
+			Expect(dependencyManager.Register("image:my-image", []string{"layer-1", "layer-2"})).To(Succeed())
+			Expect(dependencyManager.Register("pin:docker:///ubuntu", []string{"layer-1"})).To(Succeed())
+
+			usageTracker := usage_tracker.NewUsageTracker(filepath.Join(metaPath, metadata_db.UsageDirName))
+			Expect(usageTracker.RecordUsage(logger, []string{"layer-1"}, "docker:///ubuntu")).To(Succeed())
+			usage, err := usageTracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			lastUsed = usage["layer-1"].LastUsed
+
+			fakeVolumeDriver.VolumesReturns([]string{"layer-1", "layer-2", "gc.layer-3", "layer-4-incomplete-123"}, nil)
+		})
+
+		It("imports the dependencies", func() {
+			Expect(db.IDs()).To(ConsistOf("image:my-image", "pin:docker:///ubuntu"))
+			Expect(db.Dependencies("image:my-image")).To(Equal([]string{"layer-1", "layer-2"}))
+			Expect(db.References("layer-1")).To(ConsistOf("image:my-image", "pin:docker:///ubuntu"))
+
+			images, err := db.Images()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images).To(HaveLen(1))
+			Expect(images[0].ID).To(Equal("my-image"))
+		})
+
+		It("imports the usage and the sizes of the volumes", func() {
+			usage, err := db.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(1))
+			Expect(usage["layer-1"].LastUsed).To(BeTemporally("==", lastUsed))
+			Expect(usage["layer-1"].BaseImage).To(Equal("docker:///ubuntu"))
+
+			layers, err := db.Layers()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layers).To(HaveLen(2))
+			Expect(layers[1].ChainID).To(Equal("layer-2"))
+			Expect(layers[1].Size).To(Equal(int64(1024)))
+		})
+
+		It("only imports them the first time", func() {
+			Expect(db.Deregister("pin:docker:///ubuntu")).To(Succeed())
+			Expect(db.Close()).To(Succeed())
+
+			var err error
+			db, err = metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.IDs()).To(ConsistOf("image:my-image"))
+			Expect(fakeVolumeDriver.VolumesCallCount()).To(Equal(1))
+		})
+
+		Context("when a dependency file is corrupt", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(metaPath, metadata_db.DependenciesDirName, "image:corrupt.json"), []byte("[\"lay"), 0644)).To(Succeed())
+			})
+
+			It("imports the others and records the quarantine", func() {
+				Expect(openErr).NotTo(HaveOccurred())
+				Expect(db.IDs()).To(ConsistOf("image:my-image", "pin:docker:///ubuntu"))
+
+				quarantined, err := db.Quarantined()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quarantined).To(HaveLen(1))
+				Expect(quarantined["image:corrupt"]).To(HavePrefix(filepath.Join(metaPath, metadata_db.DependenciesDirName, "quarantine", "image:corrupt.json.")))
+				Expect(quarantined["image:corrupt"]).To(BeAnExistingFile())
+			})
+
+			It("drops the record when the image is deregistered", func() {
+				Expect(db.Deregister("image:corrupt")).To(Succeed())
+				Expect(db.Quarantined()).To(BeEmpty())
+			})
+
+			It("drops the record when the image is registered again", func() {
+				Expect(db.Register("image:corrupt", []string{"layer-1"})).To(Succeed())
+				Expect(db.Quarantined()).To(BeEmpty())
+			})
+		})
+
+		Context("when a dependency file can't be read", func() {
+			BeforeEach(func() {
+				Expect(os.Symlink("nowhere", filepath.Join(metaPath, metadata_db.DependenciesDirName, "image:unreadable.json"))).To(Succeed())
+			})
+
+			It("fails the import", func() {
+				Expect(openErr).To(MatchError(ContainSubstring("image:unreadable")))
+			})
+		})
+
+		Context("when listing the volumes fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.VolumesReturns(nil, errors.New("no volumes"))
+			})
+
+			It("fails and imports them on the next open", func() {
+				Expect(openErr).To(MatchError(ContainSubstring("no volumes")))
+				fakeVolumeDriver.VolumesReturns([]string{"layer-1"}, nil)
+
+				var err error
+				db, err = metadata_db.Open(logger, metaPath, fakeVolumeDriver)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(db.IDs()).To(ConsistOf("image:my-image", "pin:docker:///ubuntu"))
+			})
+		})
+	})
+})
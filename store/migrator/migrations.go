@@ -0,0 +1,124 @@
+package migrator
+
+import (
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/base_image_puller"
+	"github.com/SUSE/groot-btrfs/store/filesystems"
+	errorspkg "github.com/pkg/errors"
+)
+
+//go:generate counterfeiter . VolumeDriver
+
+type VolumeDriver interface {
+	Volumes(logger lager.Logger) ([]string, error)
+	VolumePath(logger lager.Logger, id string) (string, error)
+	VolumeSize(logger lager.Logger, id string) (int64, error)
+	VolumeMeta(logger lager.Logger, id string) (base_image_puller.VolumeMeta, error)
+	WriteVolumeMeta(logger lager.Logger, id string, data base_image_puller.VolumeMeta) error
+	IsVolumeReadOnly(logger lager.Logger, id string) (bool, error)
+	SetVolumeReadOnly(logger lager.Logger, id string, readOnly bool) error
+	AssignStoreQgroups(logger lager.Logger) error
+}
+
+// Migrations is the registry of every change to the store layout, in the
+// order they apply. Append to it and bump store.CurrentVersion together.
+func Migrations(volumeDriver VolumeDriver) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "generate the size metadata of volumes that miss it",
+			Migrate: func(logger lager.Logger) error {
+				return GenerateVolumeSizeMetadata(logger, volumeDriver)
+			},
+		},
+		{
+			Version:     2,
+			Description: "make the volumes pulled before they were made read-only so",
+			Migrate: func(logger lager.Logger) error {
+				return MakeVolumesReadOnly(logger, volumeDriver)
+			},
+		},
+		{
+			Version:     3,
+			Description: "account the volumes and images created before the store qgroups to them",
+			Migrate: func(logger lager.Logger) error {
+				return volumeDriver.AssignStoreQgroups(logger)
+			},
+		},
+	}
+}
+
+// GenerateVolumeSizeMetadata writes the size metadata of the volumes pulled
+// before it was recorded, computing it from their contents.
+func GenerateVolumeSizeMetadata(logger lager.Logger, volumeDriver VolumeDriver) error {
+	logger = logger.Session("generating-volume-size-metadata")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	volumes, err := volumeDriver.Volumes(logger)
+	if err != nil {
+		return err
+	}
+
+	for _, volumeID := range volumes {
+		_, err := volumeDriver.VolumeSize(logger, volumeID)
+		if !os.IsNotExist(errorspkg.Cause(err)) {
+			continue
+		}
+		logger.Info("volume-meta-missing", lager.Data{"volumeID": volumeID})
+
+		volumePath, err := volumeDriver.VolumePath(logger, volumeID)
+		if err != nil {
+			return err
+		}
+
+		size, err := filesystems.CalculatePathSize(logger, volumePath)
+		if err != nil {
+			return err
+		}
+
+		if err := volumeDriver.WriteVolumeMeta(logger, volumeID, base_image_puller.VolumeMeta{Size: size}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MakeVolumesReadOnly makes the complete volumes read-only, as pulls do since
+// chain volumes are shared. Incomplete volumes and the ones being collected
+// are left alone.
+func MakeVolumesReadOnly(logger lager.Logger, volumeDriver VolumeDriver) error {
+	logger = logger.Session("making-volumes-read-only")
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	volumes, err := volumeDriver.Volumes(logger)
+	if err != nil {
+		return err
+	}
+
+	for _, volumeID := range volumes {
+		if strings.HasPrefix(volumeID, "gc.") || base_image_puller.IsIncompleteVolume(volumeID) {
+			continue
+		}
+
+		readOnly, err := volumeDriver.IsVolumeReadOnly(logger, volumeID)
+		if err != nil {
+			return errorspkg.Wrapf(err, "checking volume `%s`", volumeID)
+		}
+		if readOnly {
+			continue
+		}
+
+		logger.Info("making-volume-read-only", lager.Data{"volumeID": volumeID})
+		if err := volumeDriver.SetVolumeReadOnly(logger, volumeID, true); err != nil {
+			return errorspkg.Wrapf(err, "making volume `%s` read-only", volumeID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package migrator_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/migrator"
+	"github.com/SUSE/groot-btrfs/store/migrator/migratorfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrations", func() {
+	var (
+		storePath        string
+		fakeVolumeDriver *migratorfakes.FakeVolumeDriver
+	)
+
+	BeforeEach(func() {
+		var err error
+		storePath, err = ioutil.TempDir("", "migrations")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+
+		fakeVolumeDriver = new(migratorfakes.FakeVolumeDriver)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(storePath)).To(Succeed())
+	})
+
+	It("registers one migration per version up to the current one", func() {
+		migrations := migrator.Migrations(fakeVolumeDriver)
+		Expect(migrations).To(HaveLen(store.CurrentVersion))
+		for i, migration := range migrations {
+			Expect(migration.Version).To(Equal(i + 1))
+			Expect(migration.Description).NotTo(BeEmpty())
+		}
+	})
+
+	Describe("GenerateVolumeSizeMetadata", func() {
+		var volumePath string
+
+		BeforeEach(func() {
+			var err error
+			volumePath, err = ioutil.TempDir("", "volume")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath, "file"), make([]byte, 4096), 0644)).To(Succeed())
+
+			fakeVolumeDriver.VolumesReturns([]string{"with-meta", "without-meta"}, nil)
+			fakeVolumeDriver.VolumeSizeStub = func(_ lager.Logger, id string) (int64, error) {
+				if id == "without-meta" {
+					return 0, os.ErrNotExist
+				}
+				return 100, nil
+			}
+			fakeVolumeDriver.VolumePathReturns(volumePath, nil)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(volumePath)).To(Succeed())
+		})
+
+		It("writes the size of the volumes that miss it", func() {
+			Expect(migrator.GenerateVolumeSizeMetadata(lagertest.NewTestLogger("migrations"), fakeVolumeDriver)).To(Succeed())
+
+			Expect(fakeVolumeDriver.WriteVolumeMetaCallCount()).To(Equal(1))
+			_, id, meta := fakeVolumeDriver.WriteVolumeMetaArgsForCall(0)
+			Expect(id).To(Equal("without-meta"))
+			Expect(meta.Size).To(BeNumerically(">=", 4096))
+		})
+	})
+
+	Describe("MakeVolumesReadOnly", func() {
+		BeforeEach(func() {
+			fakeVolumeDriver.VolumesReturns([]string{"writable", "read-only", "gc.writable", "chain-incomplete-1-2"}, nil)
+			fakeVolumeDriver.IsVolumeReadOnlyStub = func(_ lager.Logger, id string) (bool, error) {
+				return id == "read-only", nil
+			}
+		})
+
+		It("makes the complete volumes that are still writable read-only", func() {
+			Expect(migrator.MakeVolumesReadOnly(lagertest.NewTestLogger("migrations"), fakeVolumeDriver)).To(Succeed())
+
+			Expect(fakeVolumeDriver.SetVolumeReadOnlyCallCount()).To(Equal(1))
+			_, id, readOnly := fakeVolumeDriver.SetVolumeReadOnlyArgsForCall(0)
+			Expect(id).To(Equal("writable"))
+			Expect(readOnly).To(BeTrue())
+		})
+
+		Context("when making a volume read-only fails", func() {
+			BeforeEach(func() {
+				fakeVolumeDriver.SetVolumeReadOnlyReturns(errors.New("read-only filesystem"))
+			})
+
+			It("returns the error", func() {
+				err := migrator.MakeVolumesReadOnly(lagertest.NewTestLogger("migrations"), fakeVolumeDriver)
+				Expect(err).To(MatchError(ContainSubstring("making volume `writable` read-only: read-only filesystem")))
+			})
+		})
+	})
+})
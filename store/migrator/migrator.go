@@ -0,0 +1,116 @@
+package migrator // import "github.com/SUSE/groot-btrfs/store/migrator"
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+)
+
+// Migration brings a store from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(logger lager.Logger) error
+}
+
+type Migrator struct {
+	storePath  string
+	locksmith  groot.Locksmith
+	migrations []Migration
+}
+
+// NewMigrator takes the migrations in the order they apply, one per version
+// from 1 up. The store is migrated up to the version of the last one, which
+// Migrations keeps at store.CurrentVersion.
+func NewMigrator(storePath string, locksmith groot.Locksmith, migrations []Migration) *Migrator {
+	return &Migrator{
+		storePath:  storePath,
+		locksmith:  locksmith,
+		migrations: migrations,
+	}
+}
+
+// Pending returns the migrations the store still needs, in the order they
+// have to run. Stores without a recorded version need all of them.
+func (m *Migrator) Pending(logger lager.Logger) ([]Migration, error) {
+	if err := m.validateRegistry(); err != nil {
+		return nil, err
+	}
+
+	version, err := store.ReadVersion(m.storePath)
+	if os.IsNotExist(errorspkg.Cause(err)) {
+		logger.Debug("store-version-missing")
+		version, err = store.UnversionedStore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if version > len(m.migrations) {
+		return nil, errorspkg.Errorf("store version %d is newer than the supported version %d", version, len(m.migrations))
+	}
+
+	return m.migrations[version:], nil
+}
+
+// Migrate runs the pending migrations while holding the global lock, and
+// records the version after each of them, so that a failed migration is the
+// first one to run next time. Up to date stores are not locked, as every
+// create checks them.
+func (m *Migrator) Migrate(logger lager.Logger) ([]Migration, error) {
+	logger = logger.Session("migrating-store", lager.Data{"storePath": m.storePath})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	pending, err := m.Pending(logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return []Migration{}, nil
+	}
+
+	lockFile, err := m.locksmith.Lock(groot.GlobalLockKey)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "locking the store")
+	}
+	defer func() {
+		if err := m.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("failed-to-unlock", err)
+		}
+	}()
+
+	// somebody else may have migrated the store while we waited for the lock
+	pending, err = m.Pending(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, migration := range pending {
+		logger.Info("running-migration", lager.Data{"version": migration.Version, "description": migration.Description})
+		if err := migration.Migrate(logger); err != nil {
+			return applied, errorspkg.Wrapf(err, "migrating store to version %d", migration.Version)
+		}
+
+		if err := store.WriteVersion(m.storePath, migration.Version); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) validateRegistry() error {
+	for i, migration := range m.migrations {
+		if migration.Version != i+1 {
+			return errorspkg.Errorf("migration to version %d is registered in position %d", migration.Version, i+1)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package migrator_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/groot/grootfakes"
+	"github.com/SUSE/groot-btrfs/store"
+	"github.com/SUSE/groot-btrfs/store/migrator"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrator", func() {
+	var (
+		storePath     string
+		logger        lager.Logger
+		fakeLocksmith *grootfakes.FakeLocksmith
+		migrations    []migrator.Migration
+		ran           []int
+		storeMigrator *migrator.Migrator
+	)
+
+	migration := func(version int, err error) migrator.Migration {
+		return migrator.Migration{
+			Version:     version,
+			Description: "a migration",
+			Migrate: func(lager.Logger) error {
+				ran = append(ran, version)
+				return err
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		storePath, err = ioutil.TempDir("", "migrator")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("migrator")
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+		ran = []int{}
+		migrations = []migrator.Migration{migration(1, nil), migration(2, nil)}
+	})
+
+	JustBeforeEach(func() {
+		storeMigrator = migrator.NewMigrator(storePath, fakeLocksmith, migrations)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(storePath)).To(Succeed())
+	})
+
+	Describe("Pending", func() {
+		It("returns the migrations after the store version", func() {
+			Expect(store.WriteVersion(storePath, 1)).To(Succeed())
+
+			pending, err := storeMigrator.Pending(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pending).To(HaveLen(1))
+			Expect(pending[0].Version).To(Equal(2))
+		})
+
+		Context("when the store has no version", func() {
+			It("returns every migration", func() {
+				Expect(storeMigrator.Pending(logger)).To(HaveLen(2))
+			})
+		})
+
+		Context("when the store is newer than this build", func() {
+			BeforeEach(func() {
+				Expect(store.WriteVersion(storePath, 3)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				_, err := storeMigrator.Pending(logger)
+				Expect(err).To(MatchError(ContainSubstring("is newer than the supported version")))
+			})
+		})
+
+		Context("when the migrations are out of order", func() {
+			BeforeEach(func() {
+				migrations = []migrator.Migration{migration(2, nil), migration(1, nil)}
+			})
+
+			It("returns an error", func() {
+				_, err := storeMigrator.Pending(logger)
+				Expect(err).To(MatchError(ContainSubstring("migration to version 2 is registered in position 1")))
+			})
+		})
+	})
+
+	Describe("Migrate", func() {
+		BeforeEach(func() {
+			Expect(store.WriteVersion(storePath, store.UnversionedStore)).To(Succeed())
+		})
+
+		It("runs the pending migrations in order and records the version", func() {
+			applied, err := storeMigrator.Migrate(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(ran).To(Equal([]int{1, 2}))
+			Expect(store.ReadVersion(storePath)).To(Equal(2))
+		})
+
+		It("holds the global lock", func() {
+			_, err := storeMigrator.Migrate(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+		})
+
+		It("does nothing when the store is up to date", func() {
+			Expect(store.WriteVersion(storePath, 2)).To(Succeed())
+
+			applied, err := storeMigrator.Migrate(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+			Expect(ran).To(BeEmpty())
+			Expect(fakeLocksmith.LockCallCount()).To(BeZero())
+		})
+
+		Context("when the store is migrated while waiting for the lock", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockStub = func(string) (*os.File, error) {
+					return nil, store.WriteVersion(storePath, 2)
+				}
+			})
+
+			It("runs nothing", func() {
+				applied, err := storeMigrator.Migrate(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(applied).To(BeEmpty())
+				Expect(ran).To(BeEmpty())
+			})
+		})
+
+		Context("when a migration fails", func() {
+			BeforeEach(func() {
+				migrations = []migrator.Migration{migration(1, nil), migration(2, errors.New("disk on fire"))}
+			})
+
+			It("keeps the version of the last migration that succeeded", func() {
+				applied, err := storeMigrator.Migrate(logger)
+				Expect(err).To(MatchError(ContainSubstring("migrating store to version 2: disk on fire")))
+				Expect(applied).To(HaveLen(1))
+				Expect(store.ReadVersion(storePath)).To(Equal(1))
+			})
+		})
+
+		Context("when locking fails", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockReturns(nil, errors.New("locked out"))
+			})
+
+			It("doesn't run any migration", func() {
+				_, err := storeMigrator.Migrate(logger)
+				Expect(err).To(MatchError(ContainSubstring("locked out")))
+				Expect(ran).To(BeEmpty())
+			})
+		})
+	})
+})
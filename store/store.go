@@ -7,6 +7,7 @@ const (
 	LocksDirName     = "locks"
 	MetaDirName      = "meta"
 	TempDirName      = "tmp"
+	TrashDirName     = "trash"
 	DefaultStorePath = "/var/lib/grootfs"
 )
 
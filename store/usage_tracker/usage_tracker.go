@@ -0,0 +1,109 @@
+package usage_tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/SUSE/groot-btrfs/groot"
+	"github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+)
+
+// UsageTracker keeps one file per volume with when it was last used to create
+// an image. Files are replaced whole, so that concurrent creates sharing a
+// volume can't interleave their writes.
+type UsageTracker struct {
+	usagePath string
+}
+
+func NewUsageTracker(usagePath string) *UsageTracker {
+	return &UsageTracker{
+		usagePath: usagePath,
+	}
+}
+
+func (t *UsageTracker) RecordUsage(logger lager.Logger, chainIDs []string, baseImage string) error {
+	logger = logger.Session("recording-usage", lager.Data{"chainIDs": chainIDs, "baseImage": baseImage})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if err := os.MkdirAll(t.usagePath, 0755); err != nil {
+		return errorspkg.Wrap(err, "creating usage directory")
+	}
+
+	data, err := json.Marshal(groot.VolumeUsage{LastUsed: time.Now(), BaseImage: baseImage})
+	if err != nil {
+		return err
+	}
+
+	for _, chainID := range chainIDs {
+		if err := store.WriteFileAtomically(t.filePath(chainID), data, 0644); err != nil {
+			return errorspkg.Wrapf(err, "writing usage of `%s`", chainID)
+		}
+	}
+
+	return nil
+}
+
+// Usage returns the usage of every volume that has been used since it was
+// created. Unreadable entries are skipped and corrupt ones are moved to
+// quarantine.
+func (t *UsageTracker) Usage(logger lager.Logger) (map[string]groot.VolumeUsage, error) {
+	logger = logger.Session("reading-usage")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	usage := map[string]groot.VolumeUsage{}
+
+	entries, err := ioutil.ReadDir(t.usagePath)
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading usage")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(t.usagePath, entry.Name()))
+		if err != nil {
+			logger.Error("reading-usage-file-failed", err, lager.Data{"file": entry.Name()})
+			continue
+		}
+
+		var volumeUsage groot.VolumeUsage
+		if err := json.Unmarshal(contents, &volumeUsage); err != nil {
+			err = store.QuarantineCorruptFile(filepath.Join(t.usagePath, entry.Name()), err)
+			logger.Error("parsing-usage-file-failed", err, lager.Data{"file": entry.Name()})
+			continue
+		}
+
+		escapedID := strings.TrimSuffix(entry.Name(), ".json")
+		usage[strings.Replace(escapedID, "__", "/", -1)] = volumeUsage
+	}
+
+	return usage, nil
+}
+
+// Forget drops the usage of a volume that no longer exists.
+func (t *UsageTracker) Forget(chainID string) error {
+	if err := os.Remove(t.filePath(chainID)); err != nil && !os.IsNotExist(err) {
+		return errorspkg.Wrapf(err, "forgetting usage of `%s`", chainID)
+	}
+
+	return nil
+}
+
+func (t *UsageTracker) filePath(chainID string) string {
+	escapedID := strings.Replace(chainID, "/", "__", -1)
+	return filepath.Join(t.usagePath, fmt.Sprintf("%s.json", escapedID))
+}
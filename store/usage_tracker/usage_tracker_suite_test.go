@@ -0,0 +1,13 @@
+package usage_tracker_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestUsageTracker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "UsageTracker Suite")
+}
@@ -0,0 +1,110 @@
+package usage_tracker_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/SUSE/groot-btrfs/store/usage_tracker"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UsageTracker", func() {
+	var (
+		storePath string
+		usagePath string
+		logger    lager.Logger
+		tracker   *usage_tracker.UsageTracker
+	)
+
+	BeforeEach(func() {
+		var err error
+		storePath, err = ioutil.TempDir("", "usage")
+		Expect(err).NotTo(HaveOccurred())
+		usagePath = filepath.Join(storePath, "usage")
+
+		logger = lagertest.NewTestLogger("usage-tracker")
+		tracker = usage_tracker.NewUsageTracker(usagePath)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(storePath)).To(Succeed())
+	})
+
+	Describe("RecordUsage", func() {
+		It("records when each volume was used and for which base image", func() {
+			before := time.Now()
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1", "sha256:vol-2"}, "docker:///ubuntu")).To(Succeed())
+
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(2))
+			Expect(usage["sha256:vol-1"].BaseImage).To(Equal("docker:///ubuntu"))
+			Expect(usage["sha256:vol-2"].LastUsed).To(BeTemporally(">=", before))
+		})
+
+		It("replaces the previous usage", func() {
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1"}, "docker:///ubuntu")).To(Succeed())
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1"}, "docker:///debian")).To(Succeed())
+
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage["sha256:vol-1"].BaseImage).To(Equal("docker:///debian"))
+		})
+
+		It("handles ids with slashes", func() {
+			Expect(tracker.RecordUsage(logger, []string{"some/volume"}, "docker:///ubuntu")).To(Succeed())
+
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveKey("some/volume"))
+		})
+
+		It("leaves no temporary files behind", func() {
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1"}, "docker:///ubuntu")).To(Succeed())
+
+			entries, err := ioutil.ReadDir(usagePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Describe("Usage", func() {
+		It("is empty when nothing was recorded yet", func() {
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(BeEmpty())
+		})
+
+		It("skips corrupt entries", func() {
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1"}, "docker:///ubuntu")).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(usagePath, "sha256:vol-2.json"), []byte("{"), 0644)).To(Succeed())
+
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(1))
+			Expect(usage).To(HaveKey("sha256:vol-1"))
+		})
+	})
+
+	Describe("Forget", func() {
+		It("drops the usage of the volume", func() {
+			Expect(tracker.RecordUsage(logger, []string{"sha256:vol-1", "sha256:vol-2"}, "docker:///ubuntu")).To(Succeed())
+			Expect(tracker.Forget("sha256:vol-1")).To(Succeed())
+
+			usage, err := tracker.Usage(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(HaveLen(1))
+			Expect(usage).To(HaveKey("sha256:vol-2"))
+		})
+
+		It("doesn't fail when there is nothing to forget", func() {
+			Expect(tracker.Forget("sha256:vol-1")).To(Succeed())
+		})
+	})
+})
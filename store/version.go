@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	// VersionFileName is the file in the meta directory that records the
+	// on-disk layout of the store.
+	VersionFileName = "version"
+
+	// UnversionedStore is the layout of stores initialized before the version
+	// was recorded. They need every migration.
+	UnversionedStore = 0
+
+	// CurrentVersion is the layout this build reads and writes. It goes up by
+	// one with every migration registered in store/migrator.
+	CurrentVersion = 3
+)
+
+// ReadVersion returns the layout version of the store. The error satisfies
+// os.IsNotExist, once unwrapped, when no version was recorded.
+func ReadVersion(storePath string) (int, error) {
+	contents, err := ioutil.ReadFile(versionFilePath(storePath))
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "reading store version")
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || version < 0 {
+		return 0, errorspkg.Errorf("invalid store version `%s`", strings.TrimSpace(string(contents)))
+	}
+
+	return version, nil
+}
+
+func WriteVersion(storePath string, version int) error {
+	if err := WriteFileAtomically(versionFilePath(storePath), []byte(fmt.Sprintf("%d\n", version)), 0644); err != nil {
+		return errorspkg.Wrap(err, "writing store version")
+	}
+
+	return nil
+}
+
+func versionFilePath(storePath string) string {
+	return filepath.Join(storePath, MetaDirName, VersionFileName)
+}
@@ -0,0 +1,52 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SUSE/groot-btrfs/store"
+	errorspkg "github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store version", func() {
+	var storePath string
+
+	BeforeEach(func() {
+		var err error
+		storePath, err = ioutil.TempDir("", "store-version")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(storePath, store.MetaDirName), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(storePath)).To(Succeed())
+	})
+
+	It("reads back the version it wrote", func() {
+		Expect(store.WriteVersion(storePath, 3)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(storePath, store.MetaDirName, store.VersionFileName))).To(Equal([]byte("3\n")))
+		Expect(store.ReadVersion(storePath)).To(Equal(3))
+	})
+
+	Context("when no version was recorded", func() {
+		It("returns a not exist error", func() {
+			_, err := store.ReadVersion(storePath)
+			Expect(os.IsNotExist(errorspkg.Cause(err))).To(BeTrue())
+		})
+	})
+
+	Context("when the version is not a number", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(storePath, store.MetaDirName, store.VersionFileName), []byte("two"), 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			_, err := store.ReadVersion(storePath)
+			Expect(err).To(MatchError("invalid store version `two`"))
+		})
+	})
+})